@@ -3,225 +3,126 @@ package main
 import (
 	"database/sql"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	jwtware "github.com/gofiber/jwt/v2"
 	_ "github.com/jackc/pgx/v5/stdlib"
-	"github.com/joho/godotenv"
 	"github.com/wichananm65/pet-shop-backend/internal/address"
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
 	"github.com/wichananm65/pet-shop-backend/internal/banner"
 	"github.com/wichananm65/pet-shop-backend/internal/cart"
 	"github.com/wichananm65/pet-shop-backend/internal/category"
+	"github.com/wichananm65/pet-shop-backend/internal/config"
 	"github.com/wichananm65/pet-shop-backend/internal/favorite"
+	"github.com/wichananm65/pet-shop-backend/internal/idempotency"
+	"github.com/wichananm65/pet-shop-backend/internal/imaging"
+	"github.com/wichananm65/pet-shop-backend/internal/loginguard"
+	"github.com/wichananm65/pet-shop-backend/internal/mailer"
+	"github.com/wichananm65/pet-shop-backend/internal/migrate"
 	"github.com/wichananm65/pet-shop-backend/internal/order"
+	"github.com/wichananm65/pet-shop-backend/internal/privacy"
 	"github.com/wichananm65/pet-shop-backend/internal/product"
 	"github.com/wichananm65/pet-shop-backend/internal/recommended"
+	"github.com/wichananm65/pet-shop-backend/internal/review"
+	"github.com/wichananm65/pet-shop-backend/internal/session"
 	shoppingmall "github.com/wichananm65/pet-shop-backend/internal/shopping-mall"
+	"github.com/wichananm65/pet-shop-backend/internal/storage"
+	"github.com/wichananm65/pet-shop-backend/internal/twofactor"
+	"github.com/wichananm65/pet-shop-backend/internal/upload"
 	"github.com/wichananm65/pet-shop-backend/internal/user"
 )
 
 func main() {
-	_ = godotenv.Load()
-	app := fiber.New()
-	setupCORS(app)
-
-	db := mustOpenDB()
-	defer db.Close()
-
-	// ensure bytea columns exist for storing images
-	if _, err := db.Exec(`ALTER TABLE products ADD COLUMN IF NOT EXISTS product_img_data bytea, ADD COLUMN IF NOT EXISTS product_img_sec_data bytea`); err != nil {
-		panic(err)
-	}
-
-	// ensure banner table exists; seed with public/banner images when empty
-	// ensure user avatar column exists
-	if _, err := db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_pic TEXT`); err != nil {
-		panic(err)
+	// `app migrate up|down|status` manages the schema and
+	// `app set-role <email> <role>` grants roles, e.g. the first admin;
+	// they only need the database settings
+	commands := map[string]func(*sql.DB, []string) error{
+		"migrate":  runMigrate,
+		"set-role": runSetRole,
 	}
-	// add mainAddressId to users if missing
-	if _, err := db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS "mainAddressId" INT`); err != nil {
-		panic(err)
-	}
-	// ensure cart product id array exists for storing user cart (legacy)
-	if _, err := db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS "cartProductId" integer[]`); err != nil {
-		panic(err)
-	}
-	// new JSONB map column for product->quantity. If existing column named cart is integer[]
-	// convert it to jsonb preserving quantities (treat duplicate entries as qty 1).
-	if _, err := db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS cart jsonb NOT NULL DEFAULT '{}'`); err != nil {
-		panic(err)
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			runCommand(os.Args[1], run, os.Args[2:])
+			return
+		}
 	}
 
-	// orders table storing cart map and price breakdown
-	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS orders (
-        "orderID" SERIAL PRIMARY KEY,
-        "userID" INT NOT NULL,
-        cart jsonb NOT NULL DEFAULT '{}',
-        quantity INT NOT NULL DEFAULT 0,
-        "totalPrice" numeric NOT NULL DEFAULT 0,
-        "shippingPrice" numeric NOT NULL DEFAULT 0,
-        "grandPrice" numeric NOT NULL DEFAULT 0,
-        status TEXT,
-        "createdAt" TEXT,
-        "updatedAt" TEXT
-    )`); err != nil {
-		panic(err)
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		os.Exit(1)
 	}
-	// make sure the column exists in case table was created before cart added
-	if _, err := db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS cart jsonb NOT NULL DEFAULT '{}'`); err != nil {
+	app := fiber.New(fiber.Config{BodyLimit: cfg.Upload.BodyLimit(), ProxyHeader: cfg.ProxyHeader})
+	setupCORS(app, cfg.CORSOrigins)
+
+	db := mustOpenDB(cfg)
+	defer db.Close()
+
+	// bring the schema up to date before serving; the advisory lock makes
+	// replicas starting together wait for each other
+	migrator, err := migrate.New(db)
+	if err != nil {
 		panic(err)
 	}
-	// rename any lowercase columns from older schema versions
-	if _, err := db.Exec(`ALTER TABLE orders RENAME COLUMN IF EXISTS totalprice TO "totalPrice"`); err != nil {
-		// ignore
-	}
-	if _, err := db.Exec(`ALTER TABLE orders RENAME COLUMN IF EXISTS shippingprice TO "shippingPrice"`); err != nil {
-		// ignore
-	}
-	if _, err := db.Exec(`ALTER TABLE orders RENAME COLUMN IF EXISTS grandprice TO "grandPrice"`); err != nil {
-		// ignore
-	}
-	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS banner (banner_id SERIAL PRIMARY KEY, banner_img TEXT, banner_link TEXT, banner_alt TEXT, ord INT)`); err != nil {
+	applied, err := migrator.Up()
+	if err != nil {
 		panic(err)
 	}
-	var bannerCount int
-	if err := db.QueryRow(`SELECT COUNT(*) FROM banner`).Scan(&bannerCount); err == nil {
-		if bannerCount == 0 {
-			// seed a few banners that match files in frontend `public/banner`
-			seed := []struct{ img, link, alt string }{
-				{"/banner/04a429f3667447618ad41d1ddc3941295098953b.jpg", "", ""},
-				{"/banner/3a2c4a01b382255d010fdce9b9c5942f82297af9.jpg", "", ""},
-				{"/banner/8b1361654080c673a9ff07dd0f7ea6d51422c8b1 (1).jpg", "", ""},
-			}
-			for i, s := range seed {
-				if _, err := db.Exec(`INSERT INTO banner (banner_img, banner_link, banner_alt, ord) VALUES ($1,$2,$3,$4)`, s.img, s.link, s.alt, len(seed)-i); err != nil {
-					// ignore seed errors
-					continue
-				}
-			}
-		}
+	for _, m := range applied {
+		fmt.Printf("applied migration %04d_%s\n", m.Version, m.Name)
 	}
 
-	// ensure category table exists; seed with public/Category images when empty
-	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS category ("categoryID" SERIAL PRIMARY KEY, "categoryName" TEXT, "categoryNameTH" TEXT, "categoryImg" TEXT, ord INT)`); err != nil {
-		panic(err)
-	}
-	// make sure new TH column exists if table pre‑dated change
-	if _, err := db.Exec(`ALTER TABLE category ADD COLUMN IF NOT EXISTS "categoryNameTH" TEXT`); err != nil {
+	// every upload goes through the blob storage selected by STORAGE_BACKEND
+	blobs, signer, err := storage.New(cfg.Storage.BlobConfig(), db)
+	if err != nil {
 		panic(err)
 	}
 
-	// address table for storing user addresses (camelCase column names to match project convention)
-	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS address (
-		"addressID" SERIAL PRIMARY KEY,
-		"userID" INT NOT NULL,
-		"addressDesc" TEXT,
-		"phone" TEXT,
-		"addressName" TEXT,
-		"createdAt" TEXT,
-		"updatedAt" TEXT
-	)`); err != nil {
+	// create user repo/service/handler so we can share the user service with the
+	// new `favorite` handler (keeps favorite responsibilities isolated).
+	userRepo := user.NewPostgresRepository(db)
+	mail, err := mailer.New(cfg.Mail.MailerConfig())
+	if err != nil {
 		panic(err)
 	}
-	// if previous installations used snake_case column names, rename them to camelCase
-	if _, err := db.Exec(`DO $$
-    BEGIN
-        IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='address' AND column_name='address_id') THEN
-            ALTER TABLE address RENAME COLUMN address_id TO "addressID";
-        END IF;
-        IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='address' AND column_name='user_id') THEN
-            ALTER TABLE address RENAME COLUMN user_id TO "userID";
-        END IF;
-        IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='address' AND column_name='address_desc') THEN
-            ALTER TABLE address RENAME COLUMN address_desc TO "addressDesc";
-        END IF;
-        IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='address' AND column_name='address_name') THEN
-            ALTER TABLE address RENAME COLUMN address_name TO "addressName";
-        END IF;
-        IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='address' AND column_name='created_at') THEN
-            ALTER TABLE address RENAME COLUMN created_at TO "createdAt";
-        END IF;
-        IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='address' AND column_name='updated_at') THEN
-            ALTER TABLE address RENAME COLUMN updated_at TO "updatedAt";
-        END IF;
-    END
-    $$;`); err != nil {
-		// non-fatal - we can ignore failures here
-		fmt.Printf("warning: could not rename address columns: %v\n", err)
-	}
-	// ensure userID column exists after possible renames; add if missing to avoid SQL errors
-	if _, err := db.Exec(`ALTER TABLE address ADD COLUMN IF NOT EXISTS "userID" INT NOT NULL DEFAULT 0`); err != nil {
-		fmt.Printf("warning: could not add missing userID column: %v\n", err)
-	}
-	var categoryCount int
-	if err := db.QueryRow(`SELECT COUNT(*) FROM category`).Scan(&categoryCount); err == nil {
-		if categoryCount == 0 {
-			seed := []struct{ name, nameTH, img string }{
-				{"Animal food", "อาหารสัตว์", "/Category/Animal _food.png"},
-				{"Pet supplies", "ของใช้สัตว์เลี้ยง", "/Category/pet_supplies.png"},
-				{"Clothes and accessories", "เสื้อผ้าและเครื่องแต่งกาย", "/Category/Clothes_and_accessories.png"},
-				{"Cleaning equipment", "อุปกรณ์ทำความสะอาด", "/Category/Cleaning_equipment.png"},
-				{"Sand and bathroom", "ทรายและห้องน้ำ", "/Category/sand_and_bathroom.png"},
-				{"Hygiene care", "ปกป้องสุขภาพ", "/Category/Hygiene_care.png"},
-				{"Cat snacks", "ขนมแมว", "/Category/Cat_snacks.png"},
-				{"Cat exercise", "อุปกรณ์ออกกำลังกายแมว", "/Category/Cat_exercise.png"},
-			}
-			for i, s := range seed {
-				if _, err := db.Exec(`INSERT INTO category ("categoryName", "categoryNameTH", "categoryImg", ord) VALUES ($1,$2,$3,$4)`, s.name, s.nameTH, s.img, len(seed)-i); err != nil {
-					continue
-				}
+	userService := user.NewService(userRepo).
+		WithMailer(mail).
+		WithPasswordReset(cfg.Auth.PasswordResetURL, cfg.Auth.PasswordResetTTL).
+		WithEmailVerification(cfg.Auth.EmailVerifyURL, cfg.Auth.EmailVerifyTTL)
+	sessions := session.NewService(session.NewPostgresRepository(db), cfg.JWTSecret).
+		WithTTLs(cfg.Auth.AccessTokenTTL, cfg.Auth.RefreshTokenTTL)
+	loginGuard := loginguard.New(loginguard.NewPostgresRepository(db), cfg.Auth.Login.Policy())
+	twoFactorRoles, _ := cfg.Auth.TwoFactor.Roles() // checked by config.Load
+	twoFactor := twofactor.NewService(twofactor.NewPostgresRepository(db), cfg.Auth.TwoFactor.Issuer, cfg.JWTSecret).
+		WithRequiredRoles(twoFactorRoles...)
+	userHandler := user.NewHandler(userService).
+		WithStorage(blobs).
+		WithSessions(sessions).
+		WithLoginGuard(loginGuard).
+		WithTwoFactor(twoFactor)
+
+	// forget sign-in counters nobody has touched for a while
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := loginGuard.Prune(); err != nil {
+				fmt.Printf("warning: could not prune sign-in attempts: %v\n", err)
 			}
 		}
-	}
-
-	// ensure v2 product table exists and mirror any legacy data
-	if _, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS product (
-			product_id SERIAL PRIMARY KEY,
-			product_name TEXT,
-			product_name_en TEXT,
-			category TEXT,
-			product_price INT,
-			score INT,
-			product_desc TEXT,
-			product_desc_en TEXT,
-			product_pic TEXT,
-			product_pic_second TEXT,
-			created_at TIMESTAMP,
-			updated_at TIMESTAMP
-		);
-	`); err != nil {
-		fmt.Printf("warning: could not create product table: %v\n", err)
-	}
-	// copy any existing rows from legacy table into v2 if not already present
-	if _, err := db.Exec(`
-		INSERT INTO product (product_id, product_name, category, product_price, score, product_desc, product_pic, created_at, updated_at)
-		SELECT "productID", "productName", category, "productPrice", score, "productDesc", "productImg", "createdAt", "updatedAt"
-		FROM products p
-		WHERE NOT EXISTS (SELECT 1 FROM product WHERE product_id = p."productID")
-	`); err != nil {
-		// non-fatal
-	}
-
-	// create user repo/service/handler so we can share the user service with the
-	// new `favorite` handler (keeps favorite responsibilities isolated).
-	userRepo := user.NewPostgresRepository(db)
-	userService := user.NewService(userRepo)
-	userHandler := user.NewHandler(userService)
+	}()
 
 	// build product service/handler early so we can reuse service elsewhere
 	productRepo := product.NewPostgresRepository(db)
-	productService := product.NewService(productRepo)
-	productHandler := product.NewHandler(productService)
-
-	jwtSecret := os.Getenv("JWT_SECRET")
+	productService := product.NewService(productRepo).WithStorage(blobs)
+	productHandler := product.NewHandler(productService).WithDevReset(cfg.Features.ResetProducts)
 
 	userHandler.RegisterPublicRoutes(app)
 
@@ -242,18 +143,52 @@ func main() {
 	shoppingMallHandler.RegisterPublicRoutes(app)
 
 	// order handler (will register protected routes later)
-	// it needs access to product service for enriching carts
-	orderHandler := order.NewHandler(order.NewService(order.NewPostgresRepository(db)), userService, productService)
+	// it needs access to product service for pricing and item snapshots
+	orderService := order.NewService(order.NewPostgresRepository(db), productService)
+	idempotencyKeys := idempotency.NewPostgresRepository(db)
+	orderHandler := order.NewHandler(orderService, userService).
+		WithIdempotency(idempotencyKeys)
+	if cfg.Auth.RequireVerifiedEmail {
+		orderHandler.WithCheckoutPolicy(userService.RequireVerifiedEmail)
+	}
+
+	// cancel unpaid orders whose stock reservation has expired
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if n, err := orderService.ExpireReservations(now); err != nil {
+				fmt.Printf("warning: could not expire stock reservations: %v\n", err)
+			} else if n > 0 {
+				fmt.Printf("cancelled %d unpaid orders with expired stock reservations\n", n)
+			}
+		}
+	}()
+
+	// drop idempotency keys that are past their TTL or lease
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if _, err := idempotencyKeys.Prune(now); err != nil {
+				fmt.Printf("warning: could not prune idempotency keys: %v\n", err)
+			}
+		}
+	}()
+
+	// product reviews: listing is public, posting and voting need a login
+	reviewHandler := review.NewHandler(review.NewService(review.NewPostgresRepository(db))).WithStorage(blobs)
+	reviewHandler.RegisterPublicRoutes(app)
 
 	// register product public routes after specific endpoints to avoid route param collision
 	productHandler.RegisterPublicRoutes(app)
 
-	// make uploaded files public
-	app.Static("/uploads", "./uploads")
+	// make uploaded files public under /uploads and serve signed downloads
+	storage.NewHandler(blobs, signer).RegisterPublicRoutes(app)
 
 	// dev endpoint: import existing filesystem images into DB (public, gated by ALLOW_RESET_PRODUCTS)
 	app.Post("/dev/import-product-images", func(c *fiber.Ctx) error {
-		if os.Getenv("ALLOW_RESET_PRODUCTS") != "1" {
+		if !cfg.Features.ResetProducts {
 			return c.Status(fiber.StatusForbidden).SendString("not allowed")
 		}
 		rows, err := db.Query(`SELECT productid, productimg FROM products`)
@@ -269,15 +204,14 @@ func main() {
 			if err := rows.Scan(&id, &path); err != nil {
 				continue
 			}
-			if !path.Valid || path.String == "" || !strings.HasPrefix(path.String, "/uploads") {
+			if !path.Valid || !strings.HasPrefix(path.String, "/uploads/") {
 				continue
 			}
-			p := "." + path.String
-			b, err := os.ReadFile(p)
+			obj, err := blobs.Get(strings.TrimPrefix(path.String, "/uploads/"))
 			if err != nil {
 				continue
 			}
-			if _, err := db.Exec(`UPDATE products SET product_img_data = $1 WHERE productid = $2`, b, id); err != nil {
+			if _, err := db.Exec(`UPDATE products SET product_img_data = $1 WHERE productid = $2`, obj.Data, id); err != nil {
 				continue
 			}
 			count++
@@ -287,7 +221,7 @@ func main() {
 
 	// dev endpoint: drop, recreate and reseed `category` table (gated by ALLOW_RESET_CATEGORIES)
 	app.Post("/dev/reset-categories", func(c *fiber.Ctx) error {
-		if os.Getenv("ALLOW_RESET_CATEGORIES") != "1" {
+		if !cfg.Features.ResetCategories {
 			return c.Status(fiber.StatusForbidden).SendString("not allowed")
 		}
 
@@ -319,28 +253,53 @@ func main() {
 		return c.JSON(fiber.Map{"inserted": inserted})
 	})
 
-	// public endpoint to serve product image bytes or fallback to file/redirect
+	// public endpoint to serve product image bytes or fallback to file/redirect;
+	// ?size=thumb|medium|large picks a rendition (default large)
 	app.Get("/api/v1/product/:id<[0-9]+>/image", func(c *fiber.Ctx) error {
 		idStr := c.Params("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).SendString("invalid id")
 		}
+		size, err := imaging.ParseRendition(c.Query("size"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		// images uploaded before renditions existed only have product_img_data
+		col := map[imaging.Rendition]string{
+			imaging.Thumb:  "COALESCE(product_img_thumb, product_img_data)",
+			imaging.Medium: "COALESCE(product_img_medium, product_img_data)",
+			imaging.Large:  "product_img_data",
+		}[size]
 
 		var imgData []byte
-		var path sql.NullString
-		err = db.QueryRow(`SELECT product_img_data, productimg FROM products WHERE productid = $1`, id).Scan(&imgData, &path)
+		var imgType, path sql.NullString
+		err = db.QueryRow(`SELECT `+col+`, product_img_type, productimg FROM products WHERE productid = $1`, id).Scan(&imgData, &imgType, &path)
 		if err != nil {
 			return c.Status(fiber.StatusNotFound).SendString("not found")
 		}
 
 		if len(imgData) > 0 {
-			ct := http.DetectContentType(imgData)
+			ct := imgType.String
+			if !imgType.Valid {
+				ct = http.DetectContentType(imgData)
+			}
 			c.Set("Content-Type", ct)
 			return c.Send(imgData)
 		}
 
 		if path.Valid && path.String != "" {
+			if key, ok := strings.CutPrefix(path.String, "/uploads/"); ok {
+				obj, err := blobs.Get(imaging.RenditionPath(key, size))
+				if err == storage.ErrNotFound {
+					obj, err = blobs.Get(key)
+				}
+				if err != nil {
+					return c.Status(fiber.StatusNotFound).SendString("image not available")
+				}
+				c.Set("Content-Type", obj.ContentType)
+				return c.Send(obj.Data)
+			}
 			if strings.HasPrefix(path.String, "/") {
 				return c.SendFile("." + path.String)
 			}
@@ -351,7 +310,7 @@ func main() {
 
 	app.Use(checkMiddleware)
 	app.Use(jwtware.New(jwtware.Config{
-		SigningKey: []byte(jwtSecret),
+		SigningKey: []byte(cfg.JWTSecret),
 		// allow unauthenticated GET requests for numeric product details and images
 		Filter: func(c *fiber.Ctx) bool {
 			// allow unauthenticated GETs for numeric product id paths
@@ -389,6 +348,27 @@ func main() {
 	addressHandler := address.NewHandler(addressService)
 	addressHandler.RegisterProtectedRoutes(app)
 
+	// PDPA data export and account deletion; admins deleting a user erase
+	// their data the same way
+	privacyService := privacy.NewService(privacy.NewPostgresRepository(db), userService, addressService, orderService, favoriteService).
+		WithStorage(blobs).
+		WithGrace(cfg.Privacy.DeletionGrace)
+	userHandler.WithEraser(privacyService.Erase)
+	privacy.NewHandler(privacyService, userService).WithSessions(sessions).WithLoginGuard(loginGuard).RegisterProtectedRoutes(app)
+
+	// erase the accounts whose deletion grace period is over
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if n, err := privacyService.Purge(); err != nil {
+				fmt.Printf("warning: could not erase deleted accounts: %v\n", err)
+			} else if n > 0 {
+				fmt.Printf("erased %d deleted accounts\n", n)
+			}
+		}
+	}()
+
 	// cart endpoints
 	cartRepo := cart.NewPostgresRepository(db)
 	cartService := cart.NewService(cartRepo)
@@ -396,9 +376,10 @@ func main() {
 	cartHandler.RegisterProtectedRoutes(app)
 
 	productHandler.RegisterProtectedRoutes(app)
+	reviewHandler.RegisterProtectedRoutes(app)
 
-	// protected endpoint to upload and persist image bytes into Postgres
-	app.Post("/api/v1/product/:id<[0-9]+>/image", func(c *fiber.Ctx) error {
+	// protected endpoint to upload a product's main image into blob storage
+	app.Post("/api/v1/product/:id<[0-9]+>/image", auth.Require(auth.ManageProducts), func(c *fiber.Ctx) error {
 		idStr := c.Params("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
@@ -409,46 +390,49 @@ func main() {
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).SendString("file is required")
 		}
-		f, err := file.Open()
+		f, err := upload.Read(file, upload.Images)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+			return c.Status(uploadErrorStatus(err)).SendString(err.Error())
 		}
-		defer f.Close()
-		b, err := io.ReadAll(f)
+		set, err := imaging.Process(f.Data)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+			return c.Status(uploadErrorStatus(err)).SendString(err.Error())
 		}
 
-		if _, err := db.Exec(`UPDATE products SET product_img_data = $1 WHERE productid = $2`, b, id); err != nil {
+		key := fmt.Sprintf("products/%d/%s", id, upload.Name(set.Large.Data, set.Large.Ext()))
+		if err := storage.PutRenditions(blobs, key, set); err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+		// clear the bytea copies so the image is served from storage
+		if _, err := db.Exec(`UPDATE products SET productimg = $1, product_img_data = NULL, product_img_medium = NULL, product_img_thumb = NULL, product_img_type = NULL WHERE productid = $2`,
+			"/uploads/"+key, id); err != nil {
 			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 		}
 		return c.SendString("ok")
 	})
 
 	// upload endpoint remains protected
-	app.Post("/upload", uploadFile)
+	app.Post("/upload", uploadFile(blobs, upload.Policy{MaxBytes: cfg.Upload.MaxBytes, Types: upload.Files.Types}))
 
-	app.Listen(":8080")
+	app.Listen(cfg.ListenAddr)
 }
 
-func setupCORS(app *fiber.App) {
+func setupCORS(app *fiber.App, origins []string) {
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
+		AllowOrigins: strings.Join(origins, ","),
 		AllowMethods: "GET,POST,HEAD,PUT,DELETE,PATCH",
-		AllowHeaders: "Origin, Content-Type, Accept",
+		AllowHeaders: "Origin, Content-Type, Accept, Idempotency-Key",
 	}))
 }
 
-func mustOpenDB() *sql.DB {
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		panic("DATABASE_URL is not set")
-	}
-
-	db, err := sql.Open("pgx", dbURL)
+func mustOpenDB(cfg config.Config) *sql.DB {
+	db, err := sql.Open("pgx", cfg.DatabaseURL)
 	if err != nil {
 		panic(err)
 	}
+	db.SetMaxOpenConns(cfg.DB.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.DB.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DB.ConnMaxLifetime)
 
 	if err := db.Ping(); err != nil {
 		panic(err)
@@ -457,17 +441,138 @@ func mustOpenDB() *sql.DB {
 	return db
 }
 
-func uploadFile(c *fiber.Ctx) error {
-	file, err := c.FormFile("file")
+// runCommand runs a subcommand with a database opened from the DB
+// settings alone and exits non-zero when it fails.
+func runCommand(name string, run func(*sql.DB, []string) error, args []string) {
+	cfg, err := config.LoadDB()
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		os.Exit(1)
 	}
+	db := mustOpenDB(cfg)
+	err = run(db, args)
+	db.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
 
-	if err := c.SaveFile(file, "./uploads/"+file.Filename); err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+// runMigrate implements `app migrate up|down [steps]|status`. down reverts
+// one migration unless a step count is given.
+func runMigrate(db *sql.DB, args []string) error {
+	migrator, err := migrate.New(db)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("usage: app migrate up|down [steps]|status")
+	}
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid step count %q", args[1])
+			}
+		}
+		reverted, err := migrator.Down(steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		entries, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+		for _, e := range entries {
+			appliedAt := "-"
+			if !e.AppliedAt.IsZero() {
+				appliedAt = e.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", e.Version, e.Name, e.State, appliedAt)
+		}
+		return w.Flush()
 	}
+	return fmt.Errorf("unknown command %q, expected up, down or status", args[0])
+}
 
-	return c.SendString("File uploaded successfully: " + file.Filename)
+// runSetRole implements `app set-role <email> <role>`, which is how the
+// first admin is created. It refuses to run on a schema with pending
+// migrations, which it may not match.
+func runSetRole(db *sql.DB, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: app set-role <email> customer|staff|admin")
+	}
+	migrator, err := migrate.New(db)
+	if err != nil {
+		return err
+	}
+	entries, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	pending := 0
+	for _, e := range entries {
+		if e.State == migrate.Pending {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d migrations are pending; run `app migrate up` first", pending)
+	}
+	repo := user.NewPostgresRepository(db)
+	u, err := repo.GetByEmail(args[0])
+	if err != nil {
+		return err
+	}
+	updated, err := user.NewService(repo).SetRole(u.ID, args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is now %s\n", updated.Email, updated.Role)
+	return nil
+}
+
+func uploadFile(blobs storage.Blob, policy upload.Policy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		file, err := c.FormFile("file")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		// the client's filename and content type are never trusted
+		f, err := upload.Read(file, policy)
+		if err != nil {
+			return c.Status(uploadErrorStatus(err)).SendString(err.Error())
+		}
+		key := "files/" + f.Name()
+		if err := blobs.Put(key, f.Data, f.ContentType); err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+
+		return c.SendString("File uploaded successfully: /uploads/" + key)
+	}
+}
+
+// uploadErrorStatus maps errors of upload.Read and imaging.Process to a
+// response status.
+func uploadErrorStatus(err error) int {
+	switch err {
+	case upload.ErrTooLarge, imaging.ErrTooLarge:
+		return fiber.StatusRequestEntityTooLarge
+	case upload.ErrUnsupportedType, upload.ErrArchive, upload.ErrScript, imaging.ErrUnsupportedFormat:
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
 }
 
 func checkMiddleware(c *fiber.Ctx) error {
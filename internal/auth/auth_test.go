@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestParseRole(t *testing.T) {
+	if r, err := ParseRole(""); err != nil || r != RoleCustomer {
+		t.Fatalf("expected an empty role to be a customer, got %q %v", r, err)
+	}
+	if r, err := ParseRole("staff"); err != nil || r != RoleStaff {
+		t.Fatalf("expected staff, got %q %v", r, err)
+	}
+	if _, err := ParseRole("root"); err != ErrUnknownRole {
+		t.Fatalf("expected ErrUnknownRole, got %v", err)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	cases := []struct {
+		name   string
+		claims jwt.MapClaims
+		want   int
+	}{
+		{"no token", nil, fiber.StatusUnauthorized},
+		{"token without role", jwt.MapClaims{"user_id": 1}, fiber.StatusForbidden},
+		{"customer", jwt.MapClaims{"user_id": 1, RoleClaim: "customer"}, fiber.StatusForbidden},
+		{"unknown role", jwt.MapClaims{"user_id": 1, RoleClaim: "root"}, fiber.StatusForbidden},
+		{"staff", jwt.MapClaims{"user_id": 1, RoleClaim: "staff"}, fiber.StatusForbidden},
+		{"admin", jwt.MapClaims{"user_id": 1, RoleClaim: "admin"}, fiber.StatusOK},
+	}
+	for _, tc := range cases {
+		app := fiber.New()
+		app.Use(func(c *fiber.Ctx) error {
+			if tc.claims != nil {
+				c.Locals("user", &jwt.Token{Claims: tc.claims})
+			}
+			return c.Next()
+		})
+		app.Get("/users", Require(ManageUsers), func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+		res, err := app.Test(httptest.NewRequest("GET", "/users", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, res.StatusCode)
+		}
+	}
+	if !RoleStaff.Can(ManageOrders) || RoleCustomer.Can(ManageProducts) {
+		t.Fatal("unexpected grants")
+	}
+}
@@ -1,9 +1,11 @@
 package cart
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/wichananm65/pet-shop-backend/internal/product"
 	"github.com/wichananm65/pet-shop-backend/internal/user"
 )
 
@@ -25,6 +27,7 @@ func (h *Handler) RegisterProtectedRoutes(app *fiber.App) {
 
 type cartRequest struct {
 	ProductID int `json:"productID"`
+	VariantID int `json:"variantID,omitempty"`
 	Quantity  int `json:"quantity,omitempty"`
 }
 
@@ -36,6 +39,9 @@ func (h *Handler) addToCart(c *fiber.Ctx) error {
 	if payload.ProductID <= 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid productID"})
 	}
+	if payload.VariantID < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid variantID"})
+	}
 	// allow negative quantities; zero will simply return current cart
 	// (service handles qty==0 case)
 	userID, err := user.GetUserIDFromCtx(c)
@@ -43,9 +49,17 @@ func (h *Handler) addToCart(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
 	}
 
-	items, err := h.service.AddToCart(userID, payload.ProductID, payload.Quantity)
+	items, err := h.service.AddToCart(userID, payload.ProductID, payload.VariantID, payload.Quantity)
 	if err != nil {
+		var shortage *product.InsufficientStockError
+		if errors.As(err, &shortage) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": shortage.Error(), "shortages": shortage.Shortages})
+		}
 		switch err {
+		case product.ErrNotFound:
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "product not found"})
+		case product.ErrVariantNotFound:
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "variant not found"})
 		case user.ErrNotFound:
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "user not found"})
 		default:
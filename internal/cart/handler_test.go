@@ -9,6 +9,7 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/wichananm65/pet-shop-backend/internal/product"
 	"github.com/wichananm65/pet-shop-backend/internal/user"
 )
 
@@ -143,3 +144,51 @@ func TestCartRoutes_Basic(t *testing.T) {
 		t.Fatalf("expected empty cart after clear, got %s", string(b9))
 	}
 }
+
+func TestAddToCart_RespectsStock(t *testing.T) {
+	repo := NewInMemoryRepository([]user.User{{ID: 42}})
+	repo.Stock = map[product.StockKey]int{{ProductID: 3}: 2, {ProductID: 3, VariantID: 5}: 1}
+	app := makeAppWithCartHandler(NewHandler(NewService(repo)))
+
+	req := httptest.NewRequest("POST", "/api/v1/product/cart", strings.NewReader(`{"productID":3,"quantity":2}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", "42")
+	res, _ := app.Test(req)
+	if res.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200 while within stock, got %d", res.StatusCode)
+	}
+
+	// one more unit than available is rejected and the cart is unchanged
+	req2 := httptest.NewRequest("POST", "/api/v1/product/cart", strings.NewReader(`{"productID":3,"quantity":1}`))
+	req2.Header.Set("Content-Type", "application/json")
+	req2.Header.Set("X-User-ID", "42")
+	res2, _ := app.Test(req2)
+	if res2.StatusCode != fiber.StatusConflict {
+		t.Fatalf("expected 409 when exceeding stock, got %d", res2.StatusCode)
+	}
+	b, _ := io.ReadAll(res2.Body)
+	if !strings.Contains(string(b), `"available":2`) {
+		t.Fatalf("expected available stock in response, got %s", string(b))
+	}
+	items, _ := repo.GetCart(42)
+	if len(items) != 1 || items[0].Quantity != 2 {
+		t.Fatalf("cart should still hold 2 units, got %+v", items)
+	}
+
+	// a variant is its own cart line with its own stock
+	req3 := httptest.NewRequest("POST", "/api/v1/product/cart", strings.NewReader(`{"productID":3,"variantID":5,"quantity":1}`))
+	req3.Header.Set("Content-Type", "application/json")
+	req3.Header.Set("X-User-ID", "42")
+	res3, _ := app.Test(req3)
+	if res3.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200 adding a variant, got %d", res3.StatusCode)
+	}
+	b3, _ := io.ReadAll(res3.Body)
+	if !strings.Contains(string(b3), `"variantID":5`) {
+		t.Fatalf("expected variant line in cart, got %s", string(b3))
+	}
+	items, _ = repo.GetCart(42)
+	if len(items) != 2 {
+		t.Fatalf("expected product and variant as separate lines, got %+v", items)
+	}
+}
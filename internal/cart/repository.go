@@ -4,6 +4,7 @@ import (
 	"errors"
 	"sync"
 
+	"github.com/wichananm65/pet-shop-backend/internal/product"
 	"github.com/wichananm65/pet-shop-backend/internal/user"
 )
 
@@ -12,57 +13,79 @@ var (
 )
 
 // CartItem describes a product along with its quantity in the cart.
-// It reuses FavoriteProduct fields for the product details.
+// It reuses FavoriteProduct fields for the product details; VariantID, SKU
+// and Options identify the chosen variant, in which case ProductPrice and
+// ProductImg are the variant's.
 type CartItem struct {
 	user.FavoriteProduct
-	Quantity int `json:"quantity"`
+	VariantID int               `json:"variantID,omitempty"`
+	SKU       *string           `json:"sku,omitempty"`
+	Options   map[string]string `json:"options,omitempty"`
+	Quantity  int               `json:"quantity"`
 }
 
 // Repository provides access to cart operations.
-// quantities are stored so duplicates are allowed and incremented.
+// quantities are stored so duplicates are allowed and incremented. A cart
+// line is a product, or one of its variants when variantID is non-zero.
+// Adding more units than the line has in stock fails with
+// *product.InsufficientStockError.
 type Repository interface {
-	AddToCart(userID int, productID int, qty int, updatedAt string) ([]CartItem, error)
+	AddToCart(userID int, productID int, variantID int, qty int, updatedAt string) ([]CartItem, error)
 	GetCart(userID int) ([]CartItem, error)
 	ClearCart(userID int, updatedAt string) error
 }
 
-// InMemoryRepository is used for tests and local scenarios.
+// InMemoryRepository is used for tests and local scenarios. Stock, when
+// non-nil, holds the available units per product or variant and is checked
+// on add.
 type InMemoryRepository struct {
 	mu    sync.RWMutex
 	users []user.User
+	carts map[int]map[product.StockKey]int
+	Stock map[product.StockKey]int
 }
 
 func NewInMemoryRepository(seed []user.User) *InMemoryRepository {
-	r := &InMemoryRepository{users: make([]user.User, 0, len(seed))}
+	r := &InMemoryRepository{
+		users: make([]user.User, 0, len(seed)),
+		carts: map[int]map[product.StockKey]int{},
+	}
 	for _, u := range seed {
 		r.users = append(r.users, u)
+		lines := map[product.StockKey]int{}
+		for pid, q := range u.Cart {
+			lines[product.StockKey{ProductID: pid}] = q
+		}
+		r.carts[u.ID] = lines
 	}
 	return r
 }
 
-func (r *InMemoryRepository) AddToCart(userID int, productID int, qty int, updatedAt string) ([]CartItem, error) {
+func (r *InMemoryRepository) AddToCart(userID int, productID int, variantID int, qty int, updatedAt string) ([]CartItem, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	for i, u := range r.users {
 		if u.ID == userID {
-			if u.Cart == nil {
-				u.Cart = make(map[int]int)
+			lines := r.carts[userID]
+			key := product.StockKey{ProductID: productID, VariantID: variantID}
+			if r.Stock != nil && qty > 0 && lines[key]+qty > r.Stock[key] {
+				return nil, &product.InsufficientStockError{Shortages: []product.StockShortage{{
+					ProductID: productID,
+					VariantID: variantID,
+					Requested: lines[key] + qty,
+					Available: r.Stock[key],
+				}}}
 			}
-			u.Cart[productID] += qty
+			lines[key] += qty
 			// remove entry if quantity drops to zero or below
-			if u.Cart[productID] <= 0 {
-				delete(u.Cart, productID)
+			if lines[key] <= 0 {
+				delete(lines, key)
 			}
 			if updatedAt != "" {
 				u.UpdatedAt = updatedAt
 			}
 			r.users[i] = u
-			// build response slice
-			items := make([]CartItem, 0, len(u.Cart))
-			for pid, q := range u.Cart {
-				items = append(items, CartItem{FavoriteProduct: user.FavoriteProduct{ProductID: pid}, Quantity: q})
-			}
-			return items, nil
+			return cartItems(lines), nil
 		}
 	}
 	return nil, ErrNotFound
@@ -73,23 +96,27 @@ func (r *InMemoryRepository) GetCart(userID int) ([]CartItem, error) {
 	defer r.mu.RUnlock()
 	for _, u := range r.users {
 		if u.ID == userID {
-			out := make([]CartItem, 0, len(u.Cart))
-			for pid, q := range u.Cart {
-				out = append(out, CartItem{FavoriteProduct: user.FavoriteProduct{ProductID: pid}, Quantity: q})
-			}
-			return out, nil
+			return cartItems(r.carts[userID]), nil
 		}
 	}
 	return nil, ErrNotFound
 }
 
+func cartItems(lines map[product.StockKey]int) []CartItem {
+	out := make([]CartItem, 0, len(lines))
+	for k, q := range lines {
+		out = append(out, CartItem{FavoriteProduct: user.FavoriteProduct{ProductID: k.ProductID}, VariantID: k.VariantID, Quantity: q})
+	}
+	return out
+}
+
 // ClearCart empties a user's cart.
 func (r *InMemoryRepository) ClearCart(userID int, updatedAt string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	for i, u := range r.users {
 		if u.ID == userID {
-			u.Cart = make(map[int]int)
+			r.carts[userID] = map[product.StockKey]int{}
 			if updatedAt != "" {
 				u.UpdatedAt = updatedAt
 			}
@@ -2,8 +2,9 @@ package cart
 
 import (
 	"database/sql"
+	"encoding/json"
 
-	"github.com/wichananm65/pet-shop-backend/internal/user"
+	"github.com/wichananm65/pet-shop-backend/internal/product"
 )
 
 type PostgresRepository struct {
@@ -12,29 +13,44 @@ type PostgresRepository struct {
 
 const (
 	getCartQuery = `
-        SELECT p.productid, p.productname, p.productnameth, p.productdesc, p.productdescth, p.productprice, p.productimg, p.score, c.quantity
+        SELECT p.productid, p.productname, p.productnameth, p.productdesc, p.productdescth,
+               COALESCE(v.price, p.productprice), COALESCE(v.img, p.productimg), p.score,
+               c.variantid, v.sku, v.options, c.quantity
         FROM cart c
         JOIN products p ON c.productid = p.productid
+        LEFT JOIN product_variants v ON v.variantid = c.variantid AND c.variantid <> 0
         WHERE c.userid = $1
         ORDER BY c.createdat
     `
 	insertCartItemQuery = `
-        INSERT INTO cart (userid, productid, quantity, createdat, updatedat)
-        VALUES ($1, $2, $3, $4, $4)
-        ON CONFLICT (userid, productid)
+        INSERT INTO cart (userid, productid, variantid, quantity, createdat, updatedat)
+        VALUES ($1, $2, $3, $4, $5, $5)
+        ON CONFLICT (userid, productid, variantid)
         DO UPDATE SET quantity = cart.quantity + EXCLUDED.quantity, updatedat = EXCLUDED.updatedat
         RETURNING cartid
     `
 	updateCartQuantityQuery = `
         UPDATE cart SET quantity = $1, updatedat = $2
-        WHERE userid = $3 AND productid = $4
+        WHERE userid = $3 AND productid = $4 AND variantid = $5
     `
 	deleteCartItemQuery = `
-        DELETE FROM cart WHERE userid = $1 AND productid = $2
+        DELETE FROM cart WHERE userid = $1 AND productid = $2 AND variantid = $3
     `
 	clearCartQuery = `
         DELETE FROM cart WHERE userid = $1
     `
+	cartStockQuery = `
+        SELECT p.stock, COALESCE(c.quantity, 0)
+        FROM products p
+        LEFT JOIN cart c ON c.productid = p.productid AND c.variantid = 0 AND c.userid = $1
+        WHERE p.productid = $2
+    `
+	cartVariantStockQuery = `
+        SELECT v.stock, COALESCE(c.quantity, 0)
+        FROM product_variants v
+        LEFT JOIN cart c ON c.variantid = v.variantid AND c.userid = $1
+        WHERE v.variantid = $2 AND v.productid = $3
+    `
 )
 
 func NewPostgresRepository(db *sql.DB) *PostgresRepository {
@@ -47,21 +63,24 @@ func (r *PostgresRepository) ClearCart(userID int, updatedAt string) error {
 	return err
 }
 
-func (r *PostgresRepository) AddToCart(userID int, productID int, qty int, updatedAt string) ([]CartItem, error) {
+func (r *PostgresRepository) AddToCart(userID int, productID int, variantID int, qty int, updatedAt string) ([]CartItem, error) {
 	if qty == 0 {
 		return r.GetCart(userID)
 	}
 
 	if qty > 0 {
+		if err := r.checkStock(userID, productID, variantID, qty); err != nil {
+			return nil, err
+		}
 		// Add or update quantity
-		_, err := r.db.Exec(insertCartItemQuery, userID, productID, qty, updatedAt)
+		_, err := r.db.Exec(insertCartItemQuery, userID, productID, variantID, qty, updatedAt)
 		if err != nil {
 			return nil, err
 		}
 	} else {
 		// Reduce quantity
 		var currentQty int
-		err := r.db.QueryRow(`SELECT quantity FROM cart WHERE userid = $1 AND productid = $2`, userID, productID).Scan(&currentQty)
+		err := r.db.QueryRow(`SELECT quantity FROM cart WHERE userid = $1 AND productid = $2 AND variantid = $3`, userID, productID, variantID).Scan(&currentQty)
 		if err != nil && err != sql.ErrNoRows {
 			return nil, err
 		}
@@ -69,13 +88,13 @@ func (r *PostgresRepository) AddToCart(userID int, productID int, qty int, updat
 		newQty := currentQty + qty
 		if newQty <= 0 {
 			// Remove item if quantity drops to zero or below
-			_, err = r.db.Exec(deleteCartItemQuery, userID, productID)
+			_, err = r.db.Exec(deleteCartItemQuery, userID, productID, variantID)
 			if err != nil {
 				return nil, err
 			}
 		} else {
 			// Update quantity
-			_, err = r.db.Exec(updateCartQuantityQuery, newQty, updatedAt, userID, productID)
+			_, err = r.db.Exec(updateCartQuantityQuery, newQty, updatedAt, userID, productID, variantID)
 			if err != nil {
 				return nil, err
 			}
@@ -85,6 +104,39 @@ func (r *PostgresRepository) AddToCart(userID int, productID int, qty int, updat
 	return r.GetCart(userID)
 }
 
+// checkStock rejects adding qty units when the cart would then hold more
+// than the product or variant has in stock. Stock is only reserved at
+// checkout, so this is an early warning rather than a guarantee. Products
+// whose stock is NULL are not tracked and always pass.
+func (r *PostgresRepository) checkStock(userID, productID, variantID, qty int) error {
+	var stock sql.NullInt64
+	var inCart int
+	var err error
+	if variantID != 0 {
+		err = r.db.QueryRow(cartVariantStockQuery, userID, variantID, productID).Scan(&stock, &inCart)
+		if err == sql.ErrNoRows {
+			return product.ErrVariantNotFound
+		}
+	} else {
+		err = r.db.QueryRow(cartStockQuery, userID, productID).Scan(&stock, &inCart)
+		if err == sql.ErrNoRows {
+			return product.ErrNotFound
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if stock.Valid && inCart+qty > int(stock.Int64) {
+		return &product.InsufficientStockError{Shortages: []product.StockShortage{{
+			ProductID: productID,
+			VariantID: variantID,
+			Requested: inCart + qty,
+			Available: int(stock.Int64),
+		}}}
+	}
+	return nil
+}
+
 func (r *PostgresRepository) GetCart(userID int) ([]CartItem, error) {
 	rows, err := r.db.Query(getCartQuery, userID)
 	if err != nil {
@@ -94,12 +146,23 @@ func (r *PostgresRepository) GetCart(userID int) ([]CartItem, error) {
 
 	out := make([]CartItem, 0)
 	for rows.Next() {
-		var f user.FavoriteProduct
-		var quantity int
-		if err := rows.Scan(&f.ProductID, &f.ProductName, &f.ProductNameTH, &f.ProductDesc, &f.ProductDescTH, &f.ProductPrice, &f.ProductImg, &f.Score, &quantity); err != nil {
+		var (
+			item    CartItem
+			sku     sql.NullString
+			options []byte
+		)
+		f := &item.FavoriteProduct
+		if err := rows.Scan(&f.ProductID, &f.ProductName, &f.ProductNameTH, &f.ProductDesc, &f.ProductDescTH, &f.ProductPrice, &f.ProductImg, &f.Score,
+			&item.VariantID, &sku, &options, &item.Quantity); err != nil {
 			return nil, err
 		}
-		out = append(out, CartItem{FavoriteProduct: f, Quantity: quantity})
+		if sku.Valid {
+			item.SKU = &sku.String
+		}
+		if len(options) > 0 {
+			_ = json.Unmarshal(options, &item.Options)
+		}
+		out = append(out, item)
 	}
 
 	return out, nil
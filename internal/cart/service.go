@@ -9,15 +9,17 @@ func NewService(repo Repository) *Service {
 	return &Service{repo: repo}
 }
 
-func (s *Service) AddToCart(userID int, productID int, qty int) ([]CartItem, error) {
-	if userID <= 0 || productID <= 0 {
+// AddToCart changes the quantity of a product, or of one of its variants
+// when variantID is non-zero, in the user's cart.
+func (s *Service) AddToCart(userID int, productID int, variantID int, qty int) ([]CartItem, error) {
+	if userID <= 0 || productID <= 0 || variantID < 0 {
 		return nil, ErrNotFound
 	}
 	// zero qty does nothing, but we still call repo to get current cart
 	if qty == 0 {
 		return s.repo.GetCart(userID)
 	}
-	return s.repo.AddToCart(userID, productID, qty, "")
+	return s.repo.AddToCart(userID, productID, variantID, qty, "")
 }
 
 func (s *Service) GetCart(userID int) ([]CartItem, error) {
@@ -0,0 +1,494 @@
+// Package config loads the app settings into a typed Config. Values come
+// from the defaults, then an optional YAML file, then the environment
+// (including a .env file), each overriding the one before. Load validates
+// the result so the app refuses to start misconfigured.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
+	"github.com/wichananm65/pet-shop-backend/internal/imaging"
+	"github.com/wichananm65/pet-shop-backend/internal/loginguard"
+	"github.com/wichananm65/pet-shop-backend/internal/mailer"
+	"github.com/wichananm65/pet-shop-backend/internal/privacy"
+	"github.com/wichananm65/pet-shop-backend/internal/session"
+	"github.com/wichananm65/pet-shop-backend/internal/storage"
+	"github.com/wichananm65/pet-shop-backend/internal/upload"
+	"github.com/wichananm65/pet-shop-backend/internal/user"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFile is read when CONFIG_FILE is unset; it is optional.
+const DefaultFile = "config.yaml"
+
+type Config struct {
+	ListenAddr  string   `yaml:"listen_addr"`
+	DatabaseURL string   `yaml:"database_url"`
+	JWTSecret   string   `yaml:"jwt_secret"`
+	CORSOrigins []string `yaml:"cors_origins"`
+	// ProxyHeader names the header holding the client IP, such as
+	// X-Forwarded-For, when the app runs behind a reverse proxy. Leave it
+	// empty otherwise, as clients could spoof it.
+	ProxyHeader string   `yaml:"proxy_header"`
+	Auth        Auth     `yaml:"auth"`
+	DB          DB       `yaml:"db"`
+	Upload      Upload   `yaml:"upload"`
+	Features    Features `yaml:"features"`
+	Storage     Storage  `yaml:"storage"`
+	Mail        Mail     `yaml:"mail"`
+	Privacy     Privacy  `yaml:"privacy"`
+}
+
+// Privacy sets how long a deletion request waits before the account is
+// erased.
+type Privacy struct {
+	DeletionGrace time.Duration `yaml:"deletion_grace"`
+}
+
+// Auth sets how long login tokens live, where password reset and email
+// verification links point and whether checkout needs a verified email.
+type Auth struct {
+	AccessTokenTTL       time.Duration `yaml:"access_token_ttl"`
+	RefreshTokenTTL      time.Duration `yaml:"refresh_token_ttl"`
+	PasswordResetURL     string        `yaml:"password_reset_url"`
+	PasswordResetTTL     time.Duration `yaml:"password_reset_ttl"`
+	EmailVerifyURL       string        `yaml:"email_verify_url"`
+	EmailVerifyTTL       time.Duration `yaml:"email_verify_ttl"`
+	RequireVerifiedEmail bool          `yaml:"require_verified_email"`
+	Login                Login         `yaml:"login"`
+	TwoFactor            TwoFactor     `yaml:"two_factor"`
+}
+
+// TwoFactor configures TOTP sign-in. Users with one of RequiredRoles cannot
+// sign in without it; Issuer names the shop in authenticator apps.
+type TwoFactor struct {
+	Issuer        string   `yaml:"issuer"`
+	RequiredRoles []string `yaml:"required_roles"`
+}
+
+// Roles returns RequiredRoles as roles.
+func (t TwoFactor) Roles() ([]auth.Role, error) {
+	roles := make([]auth.Role, 0, len(t.RequiredRoles))
+	for _, name := range t.RequiredRoles {
+		r, err := auth.ParseRole(name)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q", err, name)
+		}
+		roles = append(roles, r)
+	}
+	return roles, nil
+}
+
+// Login limits sign-in attempts; see loginguard.Policy.
+type Login struct {
+	MaxFailures   int           `yaml:"max_failures"`
+	FailureWindow time.Duration `yaml:"failure_window"`
+	Lockout       time.Duration `yaml:"lockout"`
+	IPLimit       int           `yaml:"ip_limit"`
+	IPWindow      time.Duration `yaml:"ip_window"`
+}
+
+func defaultLogin() Login {
+	p := loginguard.DefaultPolicy()
+	return Login{
+		MaxFailures:   p.MaxFailures,
+		FailureWindow: p.FailureWindow,
+		Lockout:       p.Lockout,
+		IPLimit:       p.IPLimit,
+		IPWindow:      p.IPWindow,
+	}
+}
+
+// Policy returns the limits in the form loginguard.New takes.
+func (l Login) Policy() loginguard.Policy {
+	p := loginguard.DefaultPolicy()
+	p.MaxFailures = l.MaxFailures
+	p.FailureWindow = l.FailureWindow
+	p.Lockout = l.Lockout
+	p.IPLimit = l.IPLimit
+	p.IPWindow = l.IPWindow
+	return p
+}
+
+// DB sizes the database/sql connection pool. Zero means no limit.
+type DB struct {
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+}
+
+// Upload limits file uploads. Images are also capped by
+// imaging.MaxUploadBytes, which protects the decoder.
+type Upload struct {
+	MaxBytes int64 `yaml:"max_bytes"`
+}
+
+// BodyLimit is the request body limit that still fits the largest allowed
+// upload together with its multipart framing.
+func (u Upload) BodyLimit() int {
+	return int(max(u.MaxBytes, imaging.MaxUploadBytes)) + upload.FormOverheadBytes
+}
+
+// Features toggles the dev-only endpoints.
+type Features struct {
+	ResetProducts   bool `yaml:"reset_products"`
+	ResetCategories bool `yaml:"reset_categories"`
+}
+
+type Storage struct {
+	Backend    string `yaml:"backend"`
+	LocalDir   string `yaml:"local_dir"`
+	SigningKey string `yaml:"signing_key"`
+	S3         S3     `yaml:"s3"`
+}
+
+type S3 struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	PathStyle bool   `yaml:"path_style"`
+}
+
+// BlobConfig returns the settings in the form storage.New takes.
+func (s Storage) BlobConfig() storage.Config {
+	return storage.Config{
+		Backend:    s.Backend,
+		LocalDir:   s.LocalDir,
+		SigningKey: []byte(s.SigningKey),
+		S3: storage.S3Config{
+			Endpoint:  s.S3.Endpoint,
+			Region:    s.S3.Region,
+			Bucket:    s.S3.Bucket,
+			AccessKey: s.S3.AccessKey,
+			SecretKey: s.S3.SecretKey,
+			PathStyle: s.S3.PathStyle,
+		},
+	}
+}
+
+type Mail struct {
+	Backend string `yaml:"backend"`
+	From    string `yaml:"from"`
+	Dir     string `yaml:"dir"`
+	SMTP    SMTP   `yaml:"smtp"`
+}
+
+type SMTP struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// MailerConfig returns the settings in the form mailer.New takes.
+func (m Mail) MailerConfig() mailer.Config {
+	return mailer.Config{
+		Backend: m.Backend,
+		From:    m.From,
+		Dir:     m.Dir,
+		SMTP: mailer.SMTPConfig{
+			Host:     m.SMTP.Host,
+			Port:     m.SMTP.Port,
+			Username: m.SMTP.Username,
+			Password: m.SMTP.Password,
+		},
+	}
+}
+
+// Default returns the settings used for everything not configured.
+func Default() Config {
+	return Config{
+		ListenAddr:  ":8080",
+		CORSOrigins: []string{"*"},
+		Auth: Auth{
+			AccessTokenTTL:   session.DefaultAccessTTL,
+			RefreshTokenTTL:  session.DefaultRefreshTTL,
+			PasswordResetURL: "http://localhost:3000/reset-password",
+			PasswordResetTTL: user.DefaultResetTTL,
+			EmailVerifyURL:   "http://localhost:3000/verify-email",
+			EmailVerifyTTL:   user.DefaultVerificationTTL,
+			Login:            defaultLogin(),
+			TwoFactor:        TwoFactor{Issuer: "Pet Shop"},
+		},
+		DB: DB{
+			MaxOpenConns:    25,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+		},
+		Upload:  Upload{MaxBytes: upload.MaxBytes},
+		Storage: Storage{Backend: storage.BackendLocal, LocalDir: "./uploads"},
+		Mail:    Mail{Backend: mailer.BackendLog, From: "Pet Shop <no-reply@localhost>", Dir: "./mail"},
+		Privacy: Privacy{DeletionGrace: privacy.DefaultDeletionGrace},
+	}
+}
+
+// Load reads .env into the environment, then builds and validates the
+// Config. CONFIG_FILE names the YAML file; it defaults to DefaultFile.
+func Load() (Config, error) {
+	_ = godotenv.Load()
+	return load(os.LookupEnv)
+}
+
+// LoadDB is Load for commands that only use the database, such as `app
+// migrate`: only the DB settings are validated, so JWT_SECRET and the rest
+// may be missing.
+func LoadDB() (Config, error) {
+	_ = godotenv.Load()
+	return loadDB(os.LookupEnv)
+}
+
+func load(lookup func(string) (string, bool)) (Config, error) {
+	cfg, err := read(lookup)
+	if err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func loadDB(lookup func(string) (string, bool)) (Config, error) {
+	cfg, err := read(lookup)
+	if err != nil {
+		return Config{}, err
+	}
+	if err := errors.Join(cfg.validateDB()...); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// read builds the Config without validating it.
+func read(lookup func(string) (string, bool)) (Config, error) {
+	cfg := Default()
+
+	path, _ := lookup("CONFIG_FILE")
+	explicit := path != ""
+	if !explicit {
+		path = DefaultFile
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return Config{}, fmt.Errorf("%s: %w", path, err)
+		}
+	case !errors.Is(err, os.ErrNotExist) || explicit:
+		return Config{}, err
+	}
+
+	if err := applyEnv(&cfg, lookup); err != nil {
+		return Config{}, err
+	}
+	// signed URLs use the JWT secret unless they get their own key
+	if cfg.Storage.SigningKey == "" {
+		cfg.Storage.SigningKey = cfg.JWTSecret
+	}
+	return cfg, nil
+}
+
+func applyEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	var errs []error
+	str := func(name string, dst *string) {
+		if v, ok := lookup(name); ok {
+			*dst = v
+		}
+	}
+	num := func(name string, dst *int) {
+		if v, ok := lookup(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a number", name, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	flag := func(name string, dst *bool) {
+		if v, ok := lookup(name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a boolean", name, v))
+				return
+			}
+			*dst = b
+		}
+	}
+	duration := func(name string, dst *time.Duration) {
+		if v, ok := lookup(name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a duration", name, v))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	str("LISTEN_ADDR", &cfg.ListenAddr)
+	str("DATABASE_URL", &cfg.DatabaseURL)
+	str("JWT_SECRET", &cfg.JWTSecret)
+	str("PROXY_HEADER", &cfg.ProxyHeader)
+	if v, ok := lookup("CORS_ORIGINS"); ok {
+		cfg.CORSOrigins = splitList(v)
+	}
+
+	duration("ACCESS_TOKEN_TTL", &cfg.Auth.AccessTokenTTL)
+	duration("REFRESH_TOKEN_TTL", &cfg.Auth.RefreshTokenTTL)
+	str("PASSWORD_RESET_URL", &cfg.Auth.PasswordResetURL)
+	duration("PASSWORD_RESET_TTL", &cfg.Auth.PasswordResetTTL)
+	str("EMAIL_VERIFY_URL", &cfg.Auth.EmailVerifyURL)
+	duration("EMAIL_VERIFY_TTL", &cfg.Auth.EmailVerifyTTL)
+	flag("REQUIRE_VERIFIED_EMAIL", &cfg.Auth.RequireVerifiedEmail)
+	num("LOGIN_MAX_FAILURES", &cfg.Auth.Login.MaxFailures)
+	duration("LOGIN_FAILURE_WINDOW", &cfg.Auth.Login.FailureWindow)
+	duration("LOGIN_LOCKOUT", &cfg.Auth.Login.Lockout)
+	num("LOGIN_IP_LIMIT", &cfg.Auth.Login.IPLimit)
+	duration("LOGIN_IP_WINDOW", &cfg.Auth.Login.IPWindow)
+	str("TWO_FACTOR_ISSUER", &cfg.Auth.TwoFactor.Issuer)
+	if v, ok := lookup("TWO_FACTOR_REQUIRED_ROLES"); ok {
+		cfg.Auth.TwoFactor.RequiredRoles = splitList(v)
+	}
+
+	num("DB_MAX_OPEN_CONNS", &cfg.DB.MaxOpenConns)
+	num("DB_MAX_IDLE_CONNS", &cfg.DB.MaxIdleConns)
+	duration("DB_CONN_MAX_LIFETIME", &cfg.DB.ConnMaxLifetime)
+	if v, ok := lookup("UPLOAD_MAX_BYTES"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("UPLOAD_MAX_BYTES: %q is not a number", v))
+		} else {
+			cfg.Upload.MaxBytes = n
+		}
+	}
+
+	flag("ALLOW_RESET_PRODUCTS", &cfg.Features.ResetProducts)
+	flag("ALLOW_RESET_CATEGORIES", &cfg.Features.ResetCategories)
+
+	str("STORAGE_BACKEND", &cfg.Storage.Backend)
+	str("STORAGE_LOCAL_DIR", &cfg.Storage.LocalDir)
+	str("STORAGE_SIGNING_KEY", &cfg.Storage.SigningKey)
+	str("S3_ENDPOINT", &cfg.Storage.S3.Endpoint)
+	str("S3_REGION", &cfg.Storage.S3.Region)
+	str("S3_BUCKET", &cfg.Storage.S3.Bucket)
+	str("S3_ACCESS_KEY", &cfg.Storage.S3.AccessKey)
+	str("S3_SECRET_KEY", &cfg.Storage.S3.SecretKey)
+	flag("S3_PATH_STYLE", &cfg.Storage.S3.PathStyle)
+
+	str("MAIL_BACKEND", &cfg.Mail.Backend)
+	str("MAIL_FROM", &cfg.Mail.From)
+	str("MAIL_DIR", &cfg.Mail.Dir)
+	str("SMTP_HOST", &cfg.Mail.SMTP.Host)
+	num("SMTP_PORT", &cfg.Mail.SMTP.Port)
+	str("SMTP_USERNAME", &cfg.Mail.SMTP.Username)
+	str("SMTP_PASSWORD", &cfg.Mail.SMTP.Password)
+
+	duration("ACCOUNT_DELETION_GRACE", &cfg.Privacy.DeletionGrace)
+
+	return errors.Join(errs...)
+}
+
+// splitList splits a comma-separated env value, dropping empty items.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Validate reports every invalid or missing setting at once.
+func (c Config) Validate() error {
+	errs := c.validateDB()
+	if c.ListenAddr == "" {
+		errs = append(errs, errors.New("listen address is required"))
+	}
+	if strings.TrimSpace(c.JWTSecret) == "" {
+		errs = append(errs, errors.New("JWT_SECRET is required"))
+	}
+	if len(c.CORSOrigins) == 0 {
+		errs = append(errs, errors.New("at least one CORS origin is required"))
+	}
+	if c.Auth.AccessTokenTTL <= 0 || c.Auth.RefreshTokenTTL <= 0 {
+		errs = append(errs, errors.New("ACCESS_TOKEN_TTL and REFRESH_TOKEN_TTL must be positive"))
+	} else if c.Auth.AccessTokenTTL >= c.Auth.RefreshTokenTTL {
+		errs = append(errs, errors.New("ACCESS_TOKEN_TTL must be shorter than REFRESH_TOKEN_TTL"))
+	}
+	if c.Auth.PasswordResetTTL <= 0 || c.Auth.EmailVerifyTTL <= 0 {
+		errs = append(errs, errors.New("PASSWORD_RESET_TTL and EMAIL_VERIFY_TTL must be positive"))
+	}
+	for _, link := range []struct{ name, url string }{
+		{"PASSWORD_RESET_URL", c.Auth.PasswordResetURL},
+		{"EMAIL_VERIFY_URL", c.Auth.EmailVerifyURL},
+	} {
+		if u, err := url.Parse(link.url); err != nil || !u.IsAbs() {
+			errs = append(errs, fmt.Errorf("%s: %q is not an absolute URL", link.name, link.url))
+		}
+	}
+	if l := c.Auth.Login; l.MaxFailures <= 0 || l.FailureWindow <= 0 || l.Lockout <= 0 || l.IPLimit <= 0 || l.IPWindow <= 0 {
+		errs = append(errs, errors.New("LOGIN_* limits must be positive"))
+	}
+	if c.Auth.TwoFactor.Issuer == "" {
+		errs = append(errs, errors.New("TWO_FACTOR_ISSUER is required"))
+	}
+	if _, err := c.Auth.TwoFactor.Roles(); err != nil {
+		errs = append(errs, fmt.Errorf("TWO_FACTOR_REQUIRED_ROLES: %w", err))
+	}
+	if c.Privacy.DeletionGrace < 0 {
+		errs = append(errs, errors.New("ACCOUNT_DELETION_GRACE must not be negative"))
+	}
+	if c.Upload.MaxBytes <= 0 {
+		errs = append(errs, errors.New("UPLOAD_MAX_BYTES must be positive"))
+	}
+	switch c.Storage.Backend {
+	case "", storage.BackendLocal, storage.BackendPostgres:
+	case storage.BackendS3:
+		if c.Storage.S3.Endpoint == "" || c.Storage.S3.Bucket == "" {
+			errs = append(errs, errors.New("S3_ENDPOINT and S3_BUCKET are required by the s3 storage backend"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown storage backend %q", c.Storage.Backend))
+	}
+	switch c.Mail.Backend {
+	case "", mailer.BackendLog, mailer.BackendFile:
+	case mailer.BackendSMTP:
+		if c.Mail.SMTP.Host == "" || c.Mail.From == "" {
+			errs = append(errs, errors.New("SMTP_HOST and MAIL_FROM are required by the smtp mail backend"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown mail backend %q", c.Mail.Backend))
+	}
+	return errors.Join(errs...)
+}
+
+// validateDB checks the settings needed to open the database.
+func (c Config) validateDB() []error {
+	var errs []error
+	if c.DatabaseURL == "" {
+		errs = append(errs, errors.New("DATABASE_URL is required"))
+	}
+	if c.DB.MaxOpenConns < 0 || c.DB.MaxIdleConns < 0 || c.DB.ConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("database pool settings must not be negative"))
+	}
+	if c.DB.MaxOpenConns > 0 && c.DB.MaxIdleConns > c.DB.MaxOpenConns {
+		errs = append(errs, errors.New("DB_MAX_IDLE_CONNS must not exceed DB_MAX_OPEN_CONNS"))
+	}
+	return errs
+}
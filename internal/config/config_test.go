@@ -0,0 +1,147 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func TestLoad_EnvOverridesYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	yaml := `
+listen_addr: ":9000"
+jwt_secret: from-yaml
+cors_origins: ["https://shop.example"]
+db:
+  max_open_conns: 10
+  conn_max_lifetime: 5m
+features:
+  reset_products: true
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := load(env(map[string]string{
+		"CONFIG_FILE":       path,
+		"DATABASE_URL":      "postgres://localhost/shop",
+		"JWT_SECRET":        "from-env",
+		"DB_MAX_IDLE_CONNS": "2",
+		"CORS_ORIGINS":      "https://a.example, https://b.example",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ListenAddr != ":9000" || cfg.JWTSecret != "from-env" || cfg.DB.MaxOpenConns != 10 || cfg.DB.MaxIdleConns != 2 {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if cfg.DB.ConnMaxLifetime != 5*time.Minute || !cfg.Features.ResetProducts || cfg.Features.ResetCategories {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if strings.Join(cfg.CORSOrigins, "|") != "https://a.example|https://b.example" {
+		t.Fatalf("unexpected CORS origins %q", cfg.CORSOrigins)
+	}
+	if cfg.Storage.SigningKey != "from-env" {
+		t.Fatalf("expected the signing key to fall back to the JWT secret, got %q", cfg.Storage.SigningKey)
+	}
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	if _, err := load(env(map[string]string{"CONFIG_FILE": "missing.yaml", "DATABASE_URL": "x", "JWT_SECRET": "y"})); err == nil {
+		t.Fatal("expected an error for a config file that cannot be read")
+	}
+
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	cfg, err := load(env(map[string]string{
+		"DATABASE_URL": "postgres://localhost/shop",
+		"JWT_SECRET":   "secret",
+	}))
+	if err != nil {
+		t.Fatalf("expected the default config file to be optional, got %v", err)
+	}
+	if cfg.ListenAddr != ":8080" || len(cfg.CORSOrigins) != 1 || cfg.Upload.BodyLimit() <= int(cfg.Upload.MaxBytes) {
+		t.Fatalf("unexpected defaults %+v", cfg)
+	}
+}
+
+func TestLoad_Validation(t *testing.T) {
+	cases := []struct {
+		name string
+		vars map[string]string
+		want string
+	}{
+		{"empty JWT secret", map[string]string{"JWT_SECRET": "  "}, "JWT_SECRET"},
+		{"missing database", map[string]string{"DATABASE_URL": ""}, "DATABASE_URL"},
+		{"bad number", map[string]string{"DB_MAX_OPEN_CONNS": "many"}, "DB_MAX_OPEN_CONNS"},
+		{"bad toggle", map[string]string{"ALLOW_RESET_PRODUCTS": "yes please"}, "ALLOW_RESET_PRODUCTS"},
+		{"idle above open", map[string]string{"DB_MAX_OPEN_CONNS": "2", "DB_MAX_IDLE_CONNS": "3"}, "DB_MAX_IDLE_CONNS"},
+		{"no upload limit", map[string]string{"UPLOAD_MAX_BYTES": "0"}, "UPLOAD_MAX_BYTES"},
+		{"unknown backend", map[string]string{"STORAGE_BACKEND": "ftp"}, "storage backend"},
+		{"bad duration", map[string]string{"ACCESS_TOKEN_TTL": "soon"}, "ACCESS_TOKEN_TTL"},
+		{"access outlives refresh", map[string]string{"ACCESS_TOKEN_TTL": "48h", "REFRESH_TOKEN_TTL": "24h"}, "ACCESS_TOKEN_TTL"},
+		{"relative reset URL", map[string]string{"PASSWORD_RESET_URL": "/reset"}, "PASSWORD_RESET_URL"},
+		{"unknown mail backend", map[string]string{"MAIL_BACKEND": "pigeon"}, "mail backend"},
+		{"smtp without host", map[string]string{"MAIL_BACKEND": "smtp"}, "SMTP_HOST"},
+		{"no login limit", map[string]string{"LOGIN_MAX_FAILURES": "0"}, "LOGIN_"},
+		{"negative deletion grace", map[string]string{"ACCOUNT_DELETION_GRACE": "-1h"}, "ACCOUNT_DELETION_GRACE"},
+		{"unknown 2FA role", map[string]string{"TWO_FACTOR_REQUIRED_ROLES": "staff, root"}, "TWO_FACTOR_REQUIRED_ROLES"},
+		{"no CORS origins", map[string]string{"CORS_ORIGINS": " , "}, "CORS"},
+	}
+	for _, tc := range cases {
+		vars := map[string]string{
+			"CONFIG_FILE":  filepath.Join(t.TempDir(), "empty.yaml"),
+			"DATABASE_URL": "postgres://localhost/shop",
+			"JWT_SECRET":   "secret",
+		}
+		if err := os.WriteFile(vars["CONFIG_FILE"], nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range tc.vars {
+			vars[k] = v
+		}
+		if _, err := load(env(vars)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error mentioning %s, got %v", tc.name, tc.want, err)
+		}
+	}
+}
+
+func TestLoad_RejectsUnknownYAMLKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	if err := os.WriteFile(path, []byte("listen_adr: \":9000\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := load(env(map[string]string{"CONFIG_FILE": path, "DATABASE_URL": "x", "JWT_SECRET": "y"})); err == nil {
+		t.Fatal("expected a misspelled key to be rejected")
+	}
+}
+
+func TestLoadDB_OnlyNeedsDatabaseSettings(t *testing.T) {
+	vars := map[string]string{"CONFIG_FILE": filepath.Join(t.TempDir(), "empty.yaml"), "DATABASE_URL": "postgres://localhost/shop"}
+	if err := os.WriteFile(vars["CONFIG_FILE"], nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadDB(env(vars)); err != nil {
+		t.Fatalf("expected the DB settings to be enough, got %v", err)
+	}
+	if _, err := load(env(vars)); err == nil || !strings.Contains(err.Error(), "JWT_SECRET") {
+		t.Fatalf("expected Load to still require JWT_SECRET, got %v", err)
+	}
+	vars["DATABASE_URL"] = ""
+	if _, err := loadDB(env(vars)); err == nil || !strings.Contains(err.Error(), "DATABASE_URL") {
+		t.Fatalf("expected DATABASE_URL to be required, got %v", err)
+	}
+}
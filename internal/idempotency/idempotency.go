@@ -0,0 +1,46 @@
+package idempotency
+
+import (
+	"errors"
+	"time"
+)
+
+// HeaderName is the request header clients use to make a write retry-safe.
+const HeaderName = "Idempotency-Key"
+
+// TTL is how long a stored response is replayed before the key may be reused.
+const TTL = 24 * time.Hour
+
+// Lease is how long an in-flight request holds its key. A request that died
+// without completing or releasing it, e.g. in a crash, would otherwise block
+// retries until TTL; after Lease a retry takes the key over.
+const Lease = time.Minute
+
+var (
+	ErrNotFound = errors.New("idempotency key not found")
+)
+
+// Record is one idempotency key together with the fingerprint of the request
+// that first used it and, once the request finished, its response.
+// StatusCode is zero while the original request is still in flight.
+type Record struct {
+	UserID       int
+	Scope        string
+	Key          string
+	RequestHash  string
+	StatusCode   int
+	ContentType  string
+	ResponseBody []byte
+	CreatedAt    time.Time
+}
+
+// Completed reports whether the original request has produced a response.
+func (r Record) Completed() bool {
+	return r.StatusCode != 0
+}
+
+// live reports whether the record still holds its key at now.
+func (r Record) live(now time.Time) bool {
+	age := now.Sub(r.CreatedAt)
+	return age < TTL && (r.Completed() || age < Lease)
+}
@@ -0,0 +1,91 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/wichananm65/pet-shop-backend/internal/user"
+)
+
+// maxKeyLength bounds the header value we are willing to store.
+const maxKeyLength = 255
+
+// New returns middleware that makes the wrapped route safe to retry. When a
+// request carries an Idempotency-Key header the first response is stored and
+// replayed for retries with the same key and body; reusing the key with a
+// different body, or while the first request is still running, yields 409.
+// A first request running for longer than Lease no longer blocks retries.
+// Requests without the header pass straight through.
+func New(repo Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(HeaderName)
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > maxKeyLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Idempotency-Key is too long"})
+		}
+		userID, err := user.GetUserIDFromCtx(c)
+		if err != nil {
+			// let the route report the missing authentication itself
+			return c.Next()
+		}
+
+		scope := c.Method() + " " + c.Route().Path
+		hash := requestHash(c)
+		existing, reserved, err := repo.Reserve(Record{
+			UserID:      userID,
+			Scope:       scope,
+			Key:         key,
+			RequestHash: hash,
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+		}
+		if !reserved {
+			return replay(c, existing, hash)
+		}
+
+		if err := c.Next(); err != nil {
+			_ = repo.Release(userID, scope, key, existing.CreatedAt)
+			return err
+		}
+		status := c.Response().StatusCode()
+		if status >= fiber.StatusInternalServerError {
+			// server failures are not final; allow the client to retry
+			_ = repo.Release(userID, scope, key, existing.CreatedAt)
+			return nil
+		}
+		if err := repo.Complete(userID, scope, key, status, string(c.Response().Header.ContentType()), c.Response().Body()); err != nil {
+			fmt.Printf("warning: could not store idempotent response for key %q: %v\n", key, err)
+		}
+		return nil
+	}
+}
+
+func replay(c *fiber.Ctx, rec Record, hash string) error {
+	if rec.RequestHash != hash {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": "Idempotency-Key was already used with a different request"})
+	}
+	if !rec.Completed() {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": "a request with this Idempotency-Key is still being processed"})
+	}
+	if rec.ContentType != "" {
+		c.Set(fiber.HeaderContentType, rec.ContentType)
+	}
+	c.Set("Idempotent-Replayed", "true")
+	return c.Status(rec.StatusCode).Send(rec.ResponseBody)
+}
+
+// requestHash fingerprints the parts of the request that define its meaning.
+func requestHash(c *fiber.Ctx) string {
+	h := sha256.New()
+	h.Write([]byte(c.Method()))
+	h.Write([]byte{0})
+	h.Write([]byte(c.Path()))
+	h.Write([]byte{0})
+	h.Write(c.Body())
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,160 @@
+package idempotency
+
+import (
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func makeAppWithIdempotency(repo Repository, calls *int) *fiber.App {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		if v := c.Get("X-User-ID"); v != "" {
+			id, err := strconv.Atoi(v)
+			if err == nil {
+				claims := jwt.MapClaims{"user_id": id}
+				tok := &jwt.Token{Claims: claims}
+				c.Locals("user", tok)
+			}
+		}
+		return c.Next()
+	})
+	app.Post("/api/v1/orders", New(repo), func(c *fiber.Ctx) error {
+		*calls++
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"orderID": *calls})
+	})
+	return app
+}
+
+func postOrder(t *testing.T, app *fiber.App, key, body string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", "42")
+	if key != "" {
+		req.Header.Set(HeaderName, key)
+	}
+	res, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(res.Body)
+	return res.StatusCode, string(b)
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	calls := 0
+	app := makeAppWithIdempotency(NewInMemoryRepository(), &calls)
+
+	status, body := postOrder(t, app, "abc", `{"cart":{"1":1}}`)
+	if status != 200 || !strings.Contains(body, `"orderID":1`) {
+		t.Fatalf("unexpected first response %d %s", status, body)
+	}
+
+	// retry with the same key and body replays the stored response
+	status, body = postOrder(t, app, "abc", `{"cart":{"1":1}}`)
+	if status != 200 || !strings.Contains(body, `"orderID":1`) {
+		t.Fatalf("expected replayed response, got %d %s", status, body)
+	}
+	if calls != 1 {
+		t.Fatalf("handler should run once, ran %d times", calls)
+	}
+
+	// same key with a different body is a conflict
+	status, _ = postOrder(t, app, "abc", `{"cart":{"2":1}}`)
+	if status != fiber.StatusConflict {
+		t.Fatalf("expected 409 for reused key, got %d", status)
+	}
+
+	// requests without a key are not deduplicated
+	postOrder(t, app, "", `{"cart":{"1":1}}`)
+	postOrder(t, app, "", `{"cart":{"1":1}}`)
+	if calls != 3 {
+		t.Fatalf("expected keyless requests to run, handler ran %d times", calls)
+	}
+}
+
+func TestIdempotencyMiddleware_InFlightConflict(t *testing.T) {
+	repo := NewInMemoryRepository()
+	calls := 0
+	app := makeAppWithIdempotency(repo, &calls)
+
+	// simulate a request that reserved the key but has not finished yet
+	req := httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(`{}`))
+	ctxApp := fiber.New()
+	var hash string
+	ctxApp.Post("/api/v1/orders", func(c *fiber.Ctx) error {
+		hash = requestHash(c)
+		return nil
+	})
+	ctxApp.Test(req, -1)
+	repo.Reserve(Record{UserID: 42, Scope: "POST /api/v1/orders", Key: "k1", RequestHash: hash})
+
+	status, _ := postOrder(t, app, "k1", `{}`)
+	if status != fiber.StatusConflict || calls != 0 {
+		t.Fatalf("expected 409 while in flight, got %d (calls=%d)", status, calls)
+	}
+}
+
+func TestIdempotencyMiddleware_ExpiredLeaseIsTakenOver(t *testing.T) {
+	repo := NewInMemoryRepository()
+	calls := 0
+	app := makeAppWithIdempotency(repo, &calls)
+
+	// a request that reserved the key and never finished
+	stale := time.Now().Add(-Lease - time.Second)
+	repo.Reserve(Record{UserID: 42, Scope: "POST /api/v1/orders", Key: "k1", RequestHash: "crashed", CreatedAt: stale})
+
+	status, _ := postOrder(t, app, "k1", `{"cart":{"1":1}}`)
+	if status != fiber.StatusOK || calls != 1 {
+		t.Fatalf("expected the retry to take over the key, got %d (calls=%d)", status, calls)
+	}
+	status, _ = postOrder(t, app, "k1", `{"cart":{"1":1}}`)
+	if status != fiber.StatusOK || calls != 1 {
+		t.Fatalf("expected the retry's response to be replayed, got %d (calls=%d)", status, calls)
+	}
+}
+
+func TestInMemoryRepository_ReleaseMatchesOwner(t *testing.T) {
+	repo := NewInMemoryRepository()
+	stale := time.Now().Add(-Lease - time.Second)
+	first, _, _ := repo.Reserve(Record{UserID: 42, Scope: "POST /api/v1/orders", Key: "k1", RequestHash: "h", CreatedAt: stale})
+	retry, reserved, _ := repo.Reserve(Record{UserID: 42, Scope: "POST /api/v1/orders", Key: "k1", RequestHash: "h"})
+	if !reserved {
+		t.Fatal("expected the retry to take over the expired lease")
+	}
+
+	// the first request gives up late; the retry still holds the key
+	repo.Release(42, "POST /api/v1/orders", "k1", first.CreatedAt)
+	if _, reserved, _ := repo.Reserve(Record{UserID: 42, Scope: "POST /api/v1/orders", Key: "k1", RequestHash: "h"}); reserved {
+		t.Fatal("expected the first request not to release the retry's reservation")
+	}
+	repo.Release(42, "POST /api/v1/orders", "k1", retry.CreatedAt)
+	if _, reserved, _ := repo.Reserve(Record{UserID: 42, Scope: "POST /api/v1/orders", Key: "k1", RequestHash: "h"}); !reserved {
+		t.Fatal("expected the retry to release its own reservation")
+	}
+}
+
+func TestInMemoryRepository_Prune(t *testing.T) {
+	repo := NewInMemoryRepository()
+	now := time.Now()
+	repo.Reserve(Record{UserID: 1, Scope: "s", Key: "in-flight", CreatedAt: now})
+	repo.Reserve(Record{UserID: 1, Scope: "s", Key: "abandoned", CreatedAt: now.Add(-Lease - time.Second)})
+	repo.Reserve(Record{UserID: 1, Scope: "s", Key: "done", CreatedAt: now.Add(-time.Hour)})
+	repo.Complete(1, "s", "done", 200, "application/json", nil)
+	repo.Reserve(Record{UserID: 1, Scope: "s", Key: "old", CreatedAt: now.Add(-TTL - time.Second)})
+	repo.Complete(1, "s", "old", 200, "application/json", nil)
+
+	if n, err := repo.Prune(now); err != nil || n != 2 {
+		t.Fatalf("expected the abandoned and old keys to be pruned, got %d, %v", n, err)
+	}
+	if _, reserved, _ := repo.Reserve(Record{UserID: 1, Scope: "s", Key: "done"}); reserved {
+		t.Fatal("expected the completed key to be kept until TTL")
+	}
+}
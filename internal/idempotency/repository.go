@@ -0,0 +1,94 @@
+package idempotency
+
+import (
+	"sync"
+	"time"
+)
+
+// Repository persists idempotency records. Keys are namespaced by user and
+// scope (method + route) so the same key on different endpoints is unrelated.
+type Repository interface {
+	// Reserve stores rec if no live record exists for its key and reports
+	// reserved=true. Otherwise it returns the existing record untouched.
+	// Completed records live for TTL, in-flight ones for Lease.
+	Reserve(rec Record) (existing Record, reserved bool, err error)
+	// Complete attaches the response to a reserved record that has none
+	// yet; once a retry took over an expired lease, the first of the two
+	// requests to finish is the one replayed.
+	Complete(userID int, scope, key string, statusCode int, contentType string, body []byte) error
+	// Release drops the in-flight reservation made at reservedAt so the
+	// request can be retried. A reservation a retry has since taken over
+	// belongs to that retry and is left alone.
+	Release(userID int, scope, key string, reservedAt time.Time) error
+	// Prune deletes the records that are no longer live at now and
+	// reports how many there were.
+	Prune(now time.Time) (int64, error)
+}
+
+type recordKey struct {
+	userID int
+	scope  string
+	key    string
+}
+
+// InMemoryRepository is used for tests and local scenarios.
+type InMemoryRepository struct {
+	mu      sync.Mutex
+	records map[recordKey]Record
+}
+
+func NewInMemoryRepository() *InMemoryRepository {
+	return &InMemoryRepository{records: map[recordKey]Record{}}
+}
+
+func (r *InMemoryRepository) Reserve(rec Record) (Record, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := recordKey{rec.UserID, rec.Scope, rec.Key}
+	if existing, ok := r.records[k]; ok && existing.live(time.Now()) {
+		return existing, false, nil
+	}
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = time.Now().UTC()
+	}
+	r.records[k] = rec
+	return rec, true, nil
+}
+
+func (r *InMemoryRepository) Complete(userID int, scope, key string, statusCode int, contentType string, body []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := recordKey{userID, scope, key}
+	rec, ok := r.records[k]
+	if !ok || rec.Completed() {
+		return ErrNotFound
+	}
+	rec.StatusCode = statusCode
+	rec.ContentType = contentType
+	rec.ResponseBody = append([]byte(nil), body...)
+	r.records[k] = rec
+	return nil
+}
+
+func (r *InMemoryRepository) Release(userID int, scope, key string, reservedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := recordKey{userID, scope, key}
+	if rec, ok := r.records[k]; ok && !rec.Completed() && rec.CreatedAt.Equal(reservedAt) {
+		delete(r.records, k)
+	}
+	return nil
+}
+
+func (r *InMemoryRepository) Prune(now time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for k, rec := range r.records {
+		if !rec.live(now) {
+			delete(r.records, k)
+			n++
+		}
+	}
+	return n, nil
+}
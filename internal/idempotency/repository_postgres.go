@@ -0,0 +1,121 @@
+package idempotency
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PostgresRepository stores idempotency records in the idempotency_keys table.
+type PostgresRepository struct {
+	db *sql.DB
+}
+
+const (
+	// removes a completed record older than TTL or an in-flight one whose
+	// lease is over
+	deleteExpiredKeyQuery = `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND scope = $2 AND key = $3
+		  AND (created_at < $4 OR (status_code IS NULL AND created_at < $5))
+	`
+	reserveKeyQuery = `
+		INSERT INTO idempotency_keys (user_id, scope, key, request_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, scope, key) DO NOTHING
+	`
+	getKeyQuery = `
+		SELECT request_hash, status_code, content_type, response_body, created_at
+		FROM idempotency_keys
+		WHERE user_id = $1 AND scope = $2 AND key = $3
+	`
+	completeKeyQuery = `
+		UPDATE idempotency_keys
+		SET status_code = $4, content_type = $5, response_body = $6
+		WHERE user_id = $1 AND scope = $2 AND key = $3 AND status_code IS NULL
+	`
+	// created_at identifies the reservation, so a request whose lease
+	// expired cannot drop the reservation of the retry that took over
+	releaseKeyQuery = `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND scope = $2 AND key = $3 AND status_code IS NULL AND created_at = $4
+	`
+	pruneKeysQuery = `
+		DELETE FROM idempotency_keys
+		WHERE created_at < $1 OR (status_code IS NULL AND created_at < $2)
+	`
+)
+
+func NewPostgresRepository(db *sql.DB) *PostgresRepository {
+	return &PostgresRepository{db: db}
+}
+
+// Reserve relies on the primary key to decide which of several concurrent
+// requests with the same key wins the reservation. CreatedAt is kept to
+// the microsecond precision of the column so Release can match it.
+func (r *PostgresRepository) Reserve(rec Record) (Record, bool, error) {
+	now := time.Now().UTC()
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = now
+	}
+	rec.CreatedAt = rec.CreatedAt.Truncate(time.Microsecond)
+	if _, err := r.db.Exec(deleteExpiredKeyQuery, rec.UserID, rec.Scope, rec.Key, now.Add(-TTL), now.Add(-Lease)); err != nil {
+		return Record{}, false, err
+	}
+	result, err := r.db.Exec(reserveKeyQuery, rec.UserID, rec.Scope, rec.Key, rec.RequestHash, rec.CreatedAt)
+	if err != nil {
+		return Record{}, false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return Record{}, false, err
+	}
+	if affected == 1 {
+		return rec, true, nil
+	}
+
+	existing := Record{UserID: rec.UserID, Scope: rec.Scope, Key: rec.Key}
+	var (
+		status      sql.NullInt64
+		contentType sql.NullString
+	)
+	err = r.db.QueryRow(getKeyQuery, rec.UserID, rec.Scope, rec.Key).Scan(
+		&existing.RequestHash, &status, &contentType, &existing.ResponseBody, &existing.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Record{}, false, ErrNotFound
+		}
+		return Record{}, false, err
+	}
+	existing.StatusCode = int(status.Int64)
+	existing.ContentType = contentType.String
+	return existing, false, nil
+}
+
+func (r *PostgresRepository) Complete(userID int, scope, key string, statusCode int, contentType string, body []byte) error {
+	result, err := r.db.Exec(completeKeyQuery, userID, scope, key, statusCode, contentType, body)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresRepository) Release(userID int, scope, key string, reservedAt time.Time) error {
+	_, err := r.db.Exec(releaseKeyQuery, userID, scope, key, reservedAt)
+	return err
+}
+
+func (r *PostgresRepository) Prune(now time.Time) (int64, error) {
+	result, err := r.db.Exec(pruneKeysQuery, now.Add(-TTL), now.Add(-Lease))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
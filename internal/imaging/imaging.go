@@ -0,0 +1,197 @@
+// Package imaging turns uploaded images into fixed-size renditions. Uploads
+// are decoded, checked against size limits, auto-rotated according to their
+// EXIF orientation and re-encoded, which drops EXIF and any other metadata.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif" // register GIF decoding
+	"image/jpeg"
+	"image/png"
+	"path"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // register WebP decoding
+)
+
+// Rendition names a fixed output size.
+type Rendition string
+
+const (
+	Thumb  Rendition = "thumb"
+	Medium Rendition = "medium"
+	Large  Rendition = "large"
+)
+
+// Sizes is the bounding box, in pixels, of each rendition's longest side.
+// Images smaller than a box are never upscaled.
+var Sizes = map[Rendition]int{
+	Thumb:  200,
+	Medium: 600,
+	Large:  1200,
+}
+
+// Upload limits. MaxPixels is checked from the image header before the
+// pixels are decoded so oversized images are rejected cheaply.
+const (
+	MaxUploadBytes = 10 << 20
+	MaxPixels      = 40_000_000
+	jpegQuality    = 85
+)
+
+var (
+	ErrTooLarge          = errors.New("image must be at most 10 MB and 40 megapixels")
+	ErrUnsupportedFormat = errors.New("image must be a JPEG, PNG, GIF or WebP file")
+	ErrInvalidRendition  = errors.New("size must be one of thumb, medium, large")
+)
+
+var acceptedFormats = map[string]bool{"jpeg": true, "png": true, "gif": true, "webp": true}
+
+// Encoded is one re-encoded rendition.
+type Encoded struct {
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// Ext returns the file extension matching the encoded format.
+func (e Encoded) Ext() string {
+	if e.ContentType == "image/png" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// Set holds every rendition of one upload. All renditions share a format:
+// JPEG for opaque images, PNG when the image has transparency.
+type Set struct {
+	Thumb  Encoded
+	Medium Encoded
+	Large  Encoded
+}
+
+// Get returns the requested rendition.
+func (s Set) Get(r Rendition) Encoded {
+	switch r {
+	case Thumb:
+		return s.Thumb
+	case Medium:
+		return s.Medium
+	}
+	return s.Large
+}
+
+// ContentType is the content type shared by all renditions.
+func (s Set) ContentType() string {
+	return s.Large.ContentType
+}
+
+// ParseRendition parses the size query parameter of image routes. An empty
+// value selects Large.
+func ParseRendition(s string) (Rendition, error) {
+	switch r := Rendition(strings.ToLower(s)); r {
+	case "":
+		return Large, nil
+	case Thumb, Medium, Large:
+		return r, nil
+	}
+	return "", ErrInvalidRendition
+}
+
+// RenditionPath returns the file path of a rendition stored next to the
+// large one, e.g. /uploads/a/b.jpg -> /uploads/a/b_thumb.jpg.
+func RenditionPath(p string, r Rendition) string {
+	if r == Large || r == "" {
+		return p
+	}
+	ext := path.Ext(p)
+	return strings.TrimSuffix(p, ext) + "_" + string(r) + ext
+}
+
+// Process validates and decodes an upload and produces all renditions.
+func Process(data []byte) (Set, error) {
+	if len(data) > MaxUploadBytes {
+		return Set{}, ErrTooLarge
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || !acceptedFormats[format] {
+		return Set{}, ErrUnsupportedFormat
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return Set{}, ErrTooLarge
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Set{}, ErrUnsupportedFormat
+	}
+
+	// orient after the first downscale so the pixel shuffling runs on the
+	// smaller image; the boxes are square so the result is the same
+	large := fit(src, Sizes[Large])
+	if format == "jpeg" {
+		large = orient(large, jpegOrientation(data))
+	}
+	medium := fit(large, Sizes[Medium])
+	thumb := fit(medium, Sizes[Thumb])
+
+	encode := pngEncode
+	if large.Opaque() {
+		encode = jpegEncode
+	}
+	var set Set
+	for _, r := range []struct {
+		dst *Encoded
+		img *image.NRGBA
+	}{{&set.Large, large}, {&set.Medium, medium}, {&set.Thumb, thumb}} {
+		enc, err := encode(r.img)
+		if err != nil {
+			return Set{}, err
+		}
+		*r.dst = enc
+	}
+	return set, nil
+}
+
+// fit scales src down to fit a box x box square, keeping the aspect ratio.
+// The result is always a fresh NRGBA image so alpha is preserved.
+func fit(src image.Image, box int) *image.NRGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > box || h > box {
+		if w >= h {
+			h = max(1, h*box/w)
+			w = box
+		} else {
+			w = max(1, w*box/h)
+			h = box
+		}
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	if w == b.Dx() && h == b.Dy() {
+		draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	} else {
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	}
+	return dst
+}
+
+func jpegEncode(img *image.NRGBA) (Encoded, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return Encoded{}, err
+	}
+	return Encoded{Data: buf.Bytes(), ContentType: "image/jpeg", Width: img.Rect.Dx(), Height: img.Rect.Dy()}, nil
+}
+
+func pngEncode(img *image.NRGBA) (Encoded, error) {
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := enc.Encode(&buf, img); err != nil {
+		return Encoded{}, err
+	}
+	return Encoded{Data: buf.Bytes(), ContentType: "image/png", Width: img.Rect.Dx(), Height: img.Rect.Dy()}, nil
+}
@@ -0,0 +1,133 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func filled(w, h int, c color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// withOrientation inserts an APP1 segment carrying the given EXIF
+// orientation right after the SOI marker of a JPEG.
+func withOrientation(jpg []byte, orientation byte) []byte {
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // header, IFD0 at 8
+		0x00, 0x01, // one entry
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, orientation, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, // no next IFD
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	size := len(payload) + 2
+	seg := append([]byte{0xFF, 0xE1, byte(size >> 8), byte(size)}, payload...)
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, seg...)
+	return append(out, jpg[2:]...)
+}
+
+func TestProcess_Renditions(t *testing.T) {
+	set, err := Process(encodePNG(t, filled(2400, 1200, color.NRGBA{200, 10, 10, 255})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[Rendition][2]int{Large: {1200, 600}, Medium: {600, 300}, Thumb: {200, 100}}
+	for r, dims := range want {
+		enc := set.Get(r)
+		if enc.ContentType != "image/jpeg" {
+			t.Fatalf("%s: expected opaque image to be re-encoded as JPEG, got %s", r, enc.ContentType)
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(enc.Data))
+		if err != nil {
+			t.Fatalf("%s: %v", r, err)
+		}
+		if cfg.Width != dims[0] || cfg.Height != dims[1] || enc.Width != dims[0] || enc.Height != dims[1] {
+			t.Fatalf("%s: expected %dx%d, got %dx%d", r, dims[0], dims[1], cfg.Width, cfg.Height)
+		}
+	}
+}
+
+func TestProcess_KeepsTransparencyAndDoesNotUpscale(t *testing.T) {
+	set, err := Process(encodePNG(t, filled(150, 80, color.NRGBA{0, 0, 0, 0})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.ContentType() != "image/png" {
+		t.Fatalf("expected PNG for a transparent image, got %s", set.ContentType())
+	}
+	if set.Large.Width != 150 || set.Large.Height != 80 || set.Thumb.Width != 150 {
+		t.Fatalf("small images must not be upscaled: large %dx%d thumb %dx%d", set.Large.Width, set.Large.Height, set.Thumb.Width, set.Thumb.Height)
+	}
+}
+
+func TestProcess_AppliesAndStripsEXIFOrientation(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, filled(40, 20, color.White), nil); err != nil {
+		t.Fatal(err)
+	}
+	src := withOrientation(buf.Bytes(), 6)
+	if o := jpegOrientation(src); o != 6 {
+		t.Fatalf("expected orientation 6, got %d", o)
+	}
+
+	set, err := Process(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Large.Width != 20 || set.Large.Height != 40 {
+		t.Fatalf("expected the image rotated to 20x40, got %dx%d", set.Large.Width, set.Large.Height)
+	}
+	if bytes.Contains(set.Large.Data, []byte("Exif")) {
+		t.Fatal("expected EXIF to be stripped")
+	}
+}
+
+func TestProcess_Rejects(t *testing.T) {
+	if _, err := Process([]byte("<svg xmlns='http://www.w3.org/2000/svg'/>")); err != ErrUnsupportedFormat {
+		t.Fatalf("expected ErrUnsupportedFormat, got %v", err)
+	}
+	if _, err := Process(make([]byte, MaxUploadBytes+1)); err != ErrTooLarge {
+		t.Fatalf("expected ErrTooLarge for oversized upload, got %v", err)
+	}
+	// a tiny file whose header claims a huge canvas
+	huge := encodePNG(t, image.NewNRGBA(image.Rect(0, 0, 1, 1)))
+	huge[16], huge[17], huge[18], huge[19] = 0x00, 0x00, 0xFF, 0xFF // width
+	huge[20], huge[21], huge[22], huge[23] = 0x00, 0x00, 0xFF, 0xFF // height
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+	if _, err := Process(huge); err != ErrTooLarge {
+		t.Fatalf("expected ErrTooLarge for a decompression bomb, got %v", err)
+	}
+}
+
+func TestRenditionPath(t *testing.T) {
+	if got := RenditionPath("/uploads/avatars/15_abc.jpg", Thumb); got != "/uploads/avatars/15_abc_thumb.jpg" {
+		t.Fatalf("unexpected path %s", got)
+	}
+	if got := RenditionPath("/uploads/avatars/15_abc.jpg", Large); got != "/uploads/avatars/15_abc.jpg" {
+		t.Fatalf("unexpected path %s", got)
+	}
+	if _, err := ParseRendition("huge"); err != ErrInvalidRendition {
+		t.Fatalf("expected ErrInvalidRendition, got %v", err)
+	}
+}
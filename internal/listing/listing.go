@@ -0,0 +1,230 @@
+// Package listing holds the query model shared by product listing endpoints:
+// filters, sort order and opaque cursor pagination.
+package listing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Sort orders supported by listings. Every order breaks ties on the id so
+// cursors are stable.
+const (
+	SortNewest    = "newest"
+	SortPriceAsc  = "price_asc"
+	SortPriceDesc = "price_desc"
+	SortScore     = "score"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("sort must be one of newest, price_asc, price_desc, score")
+)
+
+// Query describes one page of a filtered, sorted listing. Nil pointers and
+// empty slices mean "no filter".
+type Query struct {
+	MinPrice    *int
+	MaxPrice    *int
+	CategoryIDs []int
+	MinScore    *int
+	InStock     bool
+	Sort        string
+	Limit       int
+	Cursor      *Cursor
+}
+
+// Keys are the sortable values of one listed item.
+type Keys struct {
+	ID    int
+	Price int
+	Score int
+}
+
+// Cursor marks the last item of a page. It is handed to clients as an opaque
+// string and only valid for the sort order it was created with.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value int    `json:"v,omitempty"`
+	ID    int    `json:"id"`
+}
+
+// Page is a listing response.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// Encode returns the opaque form of the cursor.
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor parses a cursor produced by Encode.
+func DecodeCursor(s string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || !validSort(c.Sort) || c.ID <= 0 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+func validSort(s string) bool {
+	switch s {
+	case SortNewest, SortPriceAsc, SortPriceDesc, SortScore:
+		return true
+	}
+	return false
+}
+
+// FromRequest reads the listing query string: minPrice, maxPrice, category
+// (comma separated ids), minScore, inStock, sort, limit and cursor.
+func FromRequest(c *fiber.Ctx, defaultLimit int) (Query, error) {
+	q := Query{Sort: SortNewest, Limit: defaultLimit}
+	var err error
+	if q.MinPrice, err = optionalInt(c, "minPrice"); err != nil {
+		return Query{}, err
+	}
+	if q.MaxPrice, err = optionalInt(c, "maxPrice"); err != nil {
+		return Query{}, err
+	}
+	if q.MinScore, err = optionalInt(c, "minScore"); err != nil {
+		return Query{}, err
+	}
+	if v := c.Query("category"); v != "" {
+		for _, part := range strings.Split(v, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || id <= 0 {
+				return Query{}, errors.New("category must be a comma separated list of ids")
+			}
+			q.CategoryIDs = append(q.CategoryIDs, id)
+		}
+	}
+	if v := c.Query("inStock"); v != "" {
+		if q.InStock, err = strconv.ParseBool(v); err != nil {
+			return Query{}, errors.New("inStock must be true or false")
+		}
+	}
+	if v := c.Query("sort"); v != "" {
+		if !validSort(v) {
+			return Query{}, ErrInvalidSort
+		}
+		q.Sort = v
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return Query{}, errors.New("limit must be a positive number")
+		}
+		q.Limit = n
+	}
+	if v := c.Query("cursor"); v != "" {
+		cur, err := DecodeCursor(v)
+		if err != nil {
+			return Query{}, err
+		}
+		q.Cursor = &cur
+	}
+	return q.Normalize()
+}
+
+func optionalInt(c *fiber.Ctx, name string) (*int, error) {
+	v := c.Query(name)
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, errors.New(name + " must be a number")
+	}
+	return &n, nil
+}
+
+// Normalize applies defaults and checks that the cursor belongs to the
+// requested sort order.
+func (q Query) Normalize() (Query, error) {
+	if q.Sort == "" {
+		q.Sort = SortNewest
+	}
+	if !validSort(q.Sort) {
+		return q, ErrInvalidSort
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	if q.Cursor != nil && q.Cursor.Sort != q.Sort {
+		return q, ErrInvalidCursor
+	}
+	return q, nil
+}
+
+// sortValue returns the value the query sorts on besides the id.
+func (q Query) sortValue(k Keys) int {
+	switch q.Sort {
+	case SortPriceAsc, SortPriceDesc:
+		return k.Price
+	case SortScore:
+		return k.Score
+	}
+	return 0
+}
+
+// Less reports whether a is listed before b.
+func (q Query) Less(a, b Keys) bool {
+	va, vb := q.sortValue(a), q.sortValue(b)
+	switch q.Sort {
+	case SortPriceAsc:
+		if va != vb {
+			return va < vb
+		}
+		return a.ID < b.ID
+	case SortPriceDesc, SortScore:
+		if va != vb {
+			return va > vb
+		}
+		return a.ID > b.ID
+	}
+	return a.ID > b.ID
+}
+
+// After reports whether an item comes after the query cursor, i.e. belongs
+// on the requested page. Without a cursor every item does.
+func (q Query) After(k Keys) bool {
+	if q.Cursor == nil {
+		return true
+	}
+	return q.Less(Keys{ID: q.Cursor.ID, Price: q.Cursor.Value, Score: q.Cursor.Value}, k)
+}
+
+// Paginate trims a result fetched with Limit+1 rows to the page size and sets
+// NextCursor when more items follow.
+func Paginate[T any](items []T, q Query, keys func(T) Keys) Page[T] {
+	page := Page[T]{Items: items}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+	if len(items) > q.Limit {
+		page.Items = items[:q.Limit]
+		last := keys(page.Items[q.Limit-1])
+		page.NextCursor = Cursor{Sort: q.Sort, Value: q.sortValue(last), ID: last.ID}.Encode()
+	}
+	return page
+}
@@ -0,0 +1,120 @@
+package listing
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	c := Cursor{Sort: SortPriceAsc, Value: 120, ID: 7}
+	got, err := DecodeCursor(c.Encode())
+	if err != nil || got != c {
+		t.Fatalf("round trip failed: %+v %v", got, err)
+	}
+	for _, bad := range []string{"not-base64!", "e30", Cursor{Sort: "bogus", ID: 1}.Encode()} {
+		if _, err := DecodeCursor(bad); err != ErrInvalidCursor {
+			t.Errorf("expected ErrInvalidCursor for %q, got %v", bad, err)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	minPrice, minScore := 100, 3
+	q := Query{
+		MinPrice:    &minPrice,
+		MinScore:    &minScore,
+		CategoryIDs: []int{2},
+		InStock:     true,
+		Sort:        SortPriceAsc,
+		Limit:       10,
+		Cursor:      &Cursor{Sort: SortPriceAsc, Value: 150, ID: 4},
+	}
+	sql, args := q.Build("SELECT p.id FROM t p", Columns{ID: "p.id", Price: "p.price", Score: "p.score", Stock: "p.stock", Category: "p.cat"})
+	want := "SELECT p.id FROM t p WHERE COALESCE(p.price, 0) >= $1 AND COALESCE(p.score, 0) >= $2 AND p.cat = ANY($3::int[]) AND (p.stock IS NULL OR p.stock > 0)" +
+		" AND (COALESCE(p.price, 0), p.id) > ($4, $5) ORDER BY COALESCE(p.price, 0) ASC, p.id ASC LIMIT $6"
+	if sql != want {
+		t.Fatalf("unexpected sql:\n%s\nwant:\n%s", sql, want)
+	}
+	if len(args) != 6 || args[5] != 11 {
+		t.Fatalf("unexpected args %v", args)
+	}
+
+	sql, args = Query{Sort: SortNewest, Limit: 5}.Build("SELECT p.id FROM t p", Columns{ID: "p.id", Price: "p.price", Score: "p.score"})
+	if sql != "SELECT p.id FROM t p ORDER BY p.id DESC LIMIT $1" || len(args) != 1 {
+		t.Fatalf("unexpected unfiltered sql %q %v", sql, args)
+	}
+}
+
+func TestPaginateWithLessAndAfter(t *testing.T) {
+	items := []Keys{{ID: 1, Price: 50}, {ID: 2, Price: 10}, {ID: 3, Price: 50}, {ID: 4, Price: 30}}
+	q, _ := Query{Sort: SortPriceAsc, Limit: 2}.Normalize()
+
+	var seen []int
+	for page := 0; page < 3; page++ {
+		rows := make([]Keys, 0)
+		for _, k := range items {
+			if q.After(k) {
+				rows = append(rows, k)
+			}
+		}
+		for i := 0; i < len(rows); i++ {
+			for j := i + 1; j < len(rows); j++ {
+				if q.Less(rows[j], rows[i]) {
+					rows[i], rows[j] = rows[j], rows[i]
+				}
+			}
+		}
+		if len(rows) > q.Limit+1 {
+			rows = rows[:q.Limit+1]
+		}
+		p := Paginate(rows, q, func(k Keys) Keys { return k })
+		for _, k := range p.Items {
+			seen = append(seen, k.ID)
+		}
+		if p.NextCursor == "" {
+			break
+		}
+		cur, err := DecodeCursor(p.NextCursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.Cursor = &cur
+	}
+	if got := fmt.Sprint(seen); got != "[2 4 1 3]" {
+		t.Fatalf("expected price order [2 4 1 3] across pages, got %s", got)
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		q, err := FromRequest(c, 50)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		if q.Limit != 50 && c.Query("limit") == "" {
+			t.Errorf("expected default limit, got %d", q.Limit)
+		}
+		return c.SendString("ok")
+	})
+
+	for query, want := range map[string]int{
+		"": 200,
+		"minPrice=10&maxPrice=200&category=1,2&minScore=3&inStock=true&sort=score&limit=500": 200,
+		"minPrice=abc":   400,
+		"category=1,x":   400,
+		"sort=cheapest":  400,
+		"limit=0":        400,
+		"inStock=maybe":  400,
+		"cursor=garbage": 400,
+		"sort=score&cursor=" + Cursor{Sort: SortPriceAsc, ID: 1}.Encode(): 400,
+	} {
+		res, _ := app.Test(httptest.NewRequest("GET", "/?"+query, nil))
+		if res.StatusCode != want {
+			t.Errorf("%q: expected %d got %d", query, want, res.StatusCode)
+		}
+	}
+}
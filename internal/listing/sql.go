@@ -0,0 +1,88 @@
+package listing
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Columns maps the listing fields onto SQL expressions of the queried table.
+// Category must evaluate to the item's category id.
+type Columns struct {
+	ID       string
+	Price    string
+	Score    string
+	Stock    string
+	Category string
+}
+
+// Build appends the filter, keyset and ordering clauses to a
+// "SELECT ... FROM ..." statement without a WHERE clause and returns the
+// full statement with its arguments. It selects Limit+1 rows so Paginate can
+// tell whether another page follows.
+func (q Query) Build(selectFrom string, cols Columns) (string, []any) {
+	var (
+		conds []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	price := "COALESCE(" + cols.Price + ", 0)"
+	score := "COALESCE(" + cols.Score + ", 0)"
+
+	if q.MinPrice != nil {
+		conds = append(conds, price+" >= "+arg(*q.MinPrice))
+	}
+	if q.MaxPrice != nil {
+		conds = append(conds, price+" <= "+arg(*q.MaxPrice))
+	}
+	if q.MinScore != nil {
+		conds = append(conds, score+" >= "+arg(*q.MinScore))
+	}
+	if len(q.CategoryIDs) > 0 {
+		conds = append(conds, cols.Category+" = ANY("+arg(pq.Array(q.CategoryIDs))+"::int[])")
+	}
+	if q.InStock {
+		// a NULL stock is not tracked and counts as in stock
+		conds = append(conds, "("+cols.Stock+" IS NULL OR "+cols.Stock+" > 0)")
+	}
+
+	var order string
+	switch q.Sort {
+	case SortPriceAsc:
+		order = price + " ASC, " + cols.ID + " ASC"
+	case SortPriceDesc:
+		order = price + " DESC, " + cols.ID + " DESC"
+	case SortScore:
+		order = score + " DESC, " + cols.ID + " DESC"
+	default:
+		order = cols.ID + " DESC"
+	}
+	if c := q.Cursor; c != nil {
+		switch q.Sort {
+		case SortPriceAsc:
+			conds = append(conds, fmt.Sprintf("(%s, %s) > (%s, %s)", price, cols.ID, arg(c.Value), arg(c.ID)))
+		case SortPriceDesc:
+			conds = append(conds, fmt.Sprintf("(%s, %s) < (%s, %s)", price, cols.ID, arg(c.Value), arg(c.ID)))
+		case SortScore:
+			conds = append(conds, fmt.Sprintf("(%s, %s) < (%s, %s)", score, cols.ID, arg(c.Value), arg(c.ID)))
+		default:
+			conds = append(conds, cols.ID+" < "+arg(c.ID))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(selectFrom)
+	if len(conds) > 0 {
+		b.WriteString(" WHERE ")
+		b.WriteString(strings.Join(conds, " AND "))
+	}
+	b.WriteString(" ORDER BY ")
+	b.WriteString(order)
+	b.WriteString(" LIMIT ")
+	b.WriteString(arg(q.Limit + 1))
+	return b.String(), args
+}
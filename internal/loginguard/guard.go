@@ -0,0 +1,177 @@
+// Package loginguard slows down password guessing. It counts failed sign-ins
+// per email, backs off exponentially after a few of them and locks the email
+// out for a while after many; every sign-in attempt also counts against a
+// per-IP limit. Lockouts are recorded for auditing.
+package loginguard
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Policy sets the limits. Failures of an email are counted within
+// FailureWindow of the first one; after BackoffAfter failures each further
+// attempt has to wait BackoffBase, doubling per failure up to BackoffMax,
+// and after MaxFailures the email is locked for Lockout. An IP may make
+// IPLimit attempts per IPWindow.
+type Policy struct {
+	MaxFailures   int
+	FailureWindow time.Duration
+	Lockout       time.Duration
+	BackoffAfter  int
+	BackoffBase   time.Duration
+	BackoffMax    time.Duration
+	IPLimit       int
+	IPWindow      time.Duration
+}
+
+// DefaultPolicy returns the limits used unless configured otherwise.
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxFailures:   10,
+		FailureWindow: 15 * time.Minute,
+		Lockout:       15 * time.Minute,
+		BackoffAfter:  3,
+		BackoffBase:   time.Second,
+		BackoffMax:    time.Minute,
+		IPLimit:       100,
+		IPWindow:      15 * time.Minute,
+	}
+}
+
+// LimitError is returned when an attempt is refused; RetryAfter is how long
+// the client has to wait.
+type LimitError struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s, retry in %s", e.Reason, e.RetryAfter.Round(time.Second))
+}
+
+type Guard struct {
+	repo   Repository
+	policy Policy
+	now    func() time.Time
+}
+
+func New(repo Repository, policy Policy) *Guard {
+	return &Guard{repo: repo, policy: policy, now: time.Now}
+}
+
+func emailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// emailDigest identifies email in logs without writing the address: the
+// first bytes of the SHA-256 of its normalized form, which staff can
+// compute for an address they are asked about.
+func emailDigest(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:8])
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check is called before the password is verified. It counts the attempt
+// against ip and returns a *LimitError when ip is over its limit or email
+// is locked out or still backing off. Emails are tracked whether or not an
+// account exists, so the answer does not reveal which ones do.
+func (g *Guard) Check(email, ip string) error {
+	now := g.now()
+	if err := g.checkIP(ip, "too many sign-in attempts", now); err != nil {
+		return err
+	}
+
+	a, err := g.repo.Get(emailKey(email))
+	if err != nil {
+		return err
+	}
+	if a.LockedUntil != nil && now.Before(*a.LockedUntil) {
+		return &LimitError{Reason: "account temporarily locked", RetryAfter: a.LockedUntil.Sub(now)}
+	}
+	if a.WindowStart.Before(now.Add(-g.policy.FailureWindow)) {
+		// earlier failures are out of the window and no longer count
+		return nil
+	}
+	if wait := g.backoff(a.Count); wait > 0 && now.Before(a.LastAt.Add(wait)) {
+		return &LimitError{Reason: "too many failed sign-ins", RetryAfter: a.LastAt.Add(wait).Sub(now)}
+	}
+	return nil
+}
+
+// CheckIP counts a request other than a sign-in, such as asking for a
+// password reset mail, against the per-IP limit and returns a *LimitError
+// when ip is over it.
+func (g *Guard) CheckIP(ip string) error {
+	return g.checkIP(ip, "too many requests", g.now())
+}
+
+func (g *Guard) checkIP(ip, reason string, now time.Time) error {
+	hits, err := g.repo.Increment(ipKey(ip), now, now.Add(-g.policy.IPWindow))
+	if err != nil {
+		return err
+	}
+	if g.policy.IPLimit > 0 && hits.Count > g.policy.IPLimit {
+		return &LimitError{Reason: reason, RetryAfter: hits.WindowStart.Add(g.policy.IPWindow).Sub(now)}
+	}
+	return nil
+}
+
+// backoff is the wait required after failures consecutive failures.
+func (g *Guard) backoff(failures int) time.Duration {
+	if g.policy.BackoffAfter <= 0 || failures < g.policy.BackoffAfter {
+		return 0
+	}
+	wait := g.policy.BackoffBase
+	for i := g.policy.BackoffAfter; i < failures && wait < g.policy.BackoffMax; i++ {
+		wait *= 2
+	}
+	return min(wait, g.policy.BackoffMax)
+}
+
+// Failure records a wrong password for email and locks it out once it
+// reaches MaxFailures.
+func (g *Guard) Failure(email, ip string) error {
+	now := g.now()
+	key := emailKey(email)
+	a, err := g.repo.Increment(key, now, now.Add(-g.policy.FailureWindow))
+	if err != nil {
+		return err
+	}
+	if g.policy.MaxFailures <= 0 || a.Count < g.policy.MaxFailures {
+		return nil
+	}
+
+	until := now.Add(g.policy.Lockout)
+	if err := g.repo.Lock(key, until); err != nil {
+		return err
+	}
+	log.Printf("sign-in locked for email %s until %s after %d failures (last from %s)", emailDigest(email), until.Format(time.RFC3339), a.Count, ip)
+	return g.repo.RecordLockout(Lockout{
+		Email:       strings.ToLower(strings.TrimSpace(email)),
+		IP:          ip,
+		Failures:    a.Count,
+		LockedUntil: until,
+		CreatedAt:   now,
+	})
+}
+
+// Success clears the failures of email after a correct password.
+func (g *Guard) Success(email string) error {
+	return g.repo.Reset(emailKey(email))
+}
+
+// Prune drops counters that have been idle for longer than any window, so
+// the table does not grow with every IP and email ever tried.
+func (g *Guard) Prune() (int64, error) {
+	idle := max(g.policy.FailureWindow, g.policy.IPWindow, g.policy.Lockout)
+	return g.repo.Prune(g.now().Add(-idle))
+}
@@ -0,0 +1,130 @@
+package loginguard
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newGuard(p Policy) (*Guard, *clock, *InMemoryRepository) {
+	repo := NewInMemoryRepository()
+	c := &clock{t: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	g := New(repo, p)
+	g.now = c.now
+	return g, c, repo
+}
+
+func retryAfter(t *testing.T, err error) time.Duration {
+	t.Helper()
+	var limit *LimitError
+	if !errors.As(err, &limit) {
+		t.Fatalf("expected a *LimitError, got %v", err)
+	}
+	return limit.RetryAfter
+}
+
+func TestGuard_BackoffAndLockout(t *testing.T) {
+	p := DefaultPolicy()
+	p.MaxFailures = 5
+	g, c, repo := newGuard(p)
+
+	for i := 1; i <= 2; i++ {
+		if err := g.Check("a@example.com", "1.2.3.4"); err != nil {
+			t.Fatalf("attempt %d: expected no backoff yet, got %v", i, err)
+		}
+		g.Failure("a@example.com", "1.2.3.4")
+	}
+	g.Failure("a@example.com", "1.2.3.4")
+	if wait := retryAfter(t, g.Check("A@example.com ", "1.2.3.4")); wait != time.Second {
+		t.Fatalf("expected a 1s backoff after 3 failures, got %s", wait)
+	}
+	c.advance(time.Second)
+	g.Failure("a@example.com", "1.2.3.4")
+	if wait := retryAfter(t, g.Check("a@example.com", "1.2.3.4")); wait != 2*time.Second {
+		t.Fatalf("expected the backoff to double, got %s", wait)
+	}
+
+	c.advance(2 * time.Second)
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	g.Failure("a@example.com", "5.6.7.8")
+	log.SetOutput(os.Stderr)
+	if !strings.Contains(logged.String(), emailDigest("a@example.com")) || strings.Contains(logged.String(), "a@example.com") {
+		t.Fatalf("expected the lockout log to name the email only by digest, got %q", logged.String())
+	}
+	if wait := retryAfter(t, g.Check("a@example.com", "9.9.9.9")); wait != p.Lockout {
+		t.Fatalf("expected a %s lockout from any IP, got %s", p.Lockout, wait)
+	}
+	if l := repo.Lockouts(); len(l) != 1 || l[0].Email != "a@example.com" || l[0].IP != "5.6.7.8" || l[0].Failures != 5 {
+		t.Fatalf("expected one audited lockout, got %+v", l)
+	}
+	if err := g.Check("b@example.com", "9.9.9.9"); err != nil {
+		t.Fatalf("expected other emails to be unaffected, got %v", err)
+	}
+
+	c.advance(p.Lockout)
+	if err := g.Check("a@example.com", "1.2.3.4"); err != nil {
+		t.Fatalf("expected the lockout to expire, got %v", err)
+	}
+}
+
+func TestGuard_SuccessAndWindowResetFailures(t *testing.T) {
+	g, c, _ := newGuard(DefaultPolicy())
+	for i := 0; i < 3; i++ {
+		g.Failure("a@example.com", "1.2.3.4")
+	}
+	g.Success("a@example.com")
+	if err := g.Check("a@example.com", "1.2.3.4"); err != nil {
+		t.Fatalf("expected a successful sign-in to clear failures, got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		g.Failure("a@example.com", "1.2.3.4")
+	}
+	c.advance(DefaultPolicy().FailureWindow + time.Second)
+	if err := g.Check("a@example.com", "1.2.3.4"); err != nil {
+		t.Fatalf("expected failures outside the window not to count, got %v", err)
+	}
+}
+
+func TestGuard_IPLimit(t *testing.T) {
+	p := DefaultPolicy()
+	p.IPLimit = 3
+	g, c, _ := newGuard(p)
+	for i := 0; i < 3; i++ {
+		if err := g.Check("user"+string(rune('a'+i))+"@example.com", "1.2.3.4"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if wait := retryAfter(t, g.Check("other@example.com", "1.2.3.4")); wait != p.IPWindow {
+		t.Fatalf("expected the IP to wait out its window, got %s", wait)
+	}
+	if err := g.Check("other@example.com", "4.3.2.1"); err != nil {
+		t.Fatalf("expected other IPs to be unaffected, got %v", err)
+	}
+	if err := g.CheckIP("1.2.3.4"); err == nil {
+		t.Fatal("expected other requests to count against the same IP limit")
+	}
+	c.advance(p.IPWindow + time.Second)
+	if err := g.Check("other@example.com", "1.2.3.4"); err != nil {
+		t.Fatalf("expected a new window for the IP, got %v", err)
+	}
+}
+
+func TestGuard_Prune(t *testing.T) {
+	g, c, _ := newGuard(DefaultPolicy())
+	g.Check("a@example.com", "1.2.3.4")
+	c.advance(time.Hour)
+	if n, err := g.Prune(); err != nil || n != 1 {
+		t.Fatalf("expected the idle IP counter to be pruned, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,116 @@
+package loginguard
+
+import (
+	"sync"
+	"time"
+)
+
+// Attempt counts events under a key such as "email:jane@example.com" or
+// "ip:203.0.113.7" since WindowStart.
+type Attempt struct {
+	Key         string
+	Count       int
+	WindowStart time.Time
+	LastAt      time.Time
+	LockedUntil *time.Time
+}
+
+// Lockout is the audit record of an email being locked out.
+type Lockout struct {
+	Email       string
+	IP          string
+	Failures    int
+	LockedUntil time.Time
+	CreatedAt   time.Time
+}
+
+type Repository interface {
+	// Get returns the zero Attempt for unknown keys.
+	Get(key string) (Attempt, error)
+	// Increment counts an event at now. A window that started before
+	// windowStart is over, so the count restarts at 1.
+	Increment(key string, now, windowStart time.Time) (Attempt, error)
+	// Lock locks key until the given time and restarts its count.
+	Lock(key string, until time.Time) error
+	Reset(key string) error
+	RecordLockout(l Lockout) error
+	// Prune deletes keys idle since before and not locked beyond it.
+	Prune(before time.Time) (int64, error)
+}
+
+// InMemoryRepository is used for tests and local scenarios.
+type InMemoryRepository struct {
+	mu       sync.Mutex
+	attempts map[string]Attempt
+	lockouts []Lockout
+}
+
+func NewInMemoryRepository() *InMemoryRepository {
+	return &InMemoryRepository{attempts: map[string]Attempt{}}
+}
+
+func (r *InMemoryRepository) Get(key string) (Attempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.attempts[key], nil
+}
+
+func (r *InMemoryRepository) Increment(key string, now, windowStart time.Time) (Attempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.attempts[key]
+	if !ok || a.WindowStart.Before(windowStart) {
+		a.Key = key
+		a.Count = 0
+		a.WindowStart = now
+	}
+	a.Count++
+	a.LastAt = now
+	r.attempts[key] = a
+	return a, nil
+}
+
+func (r *InMemoryRepository) Lock(key string, until time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a := r.attempts[key]
+	a.Key = key
+	a.Count = 0
+	a.LockedUntil = &until
+	r.attempts[key] = a
+	return nil
+}
+
+func (r *InMemoryRepository) Reset(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.attempts, key)
+	return nil
+}
+
+func (r *InMemoryRepository) RecordLockout(l Lockout) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lockouts = append(r.lockouts, l)
+	return nil
+}
+
+// Lockouts returns the recorded lockouts, oldest first.
+func (r *InMemoryRepository) Lockouts() []Lockout {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Lockout(nil), r.lockouts...)
+}
+
+func (r *InMemoryRepository) Prune(before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for key, a := range r.attempts {
+		if a.LastAt.Before(before) && (a.LockedUntil == nil || a.LockedUntil.Before(before)) {
+			delete(r.attempts, key)
+			n++
+		}
+	}
+	return n, nil
+}
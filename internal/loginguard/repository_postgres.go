@@ -0,0 +1,95 @@
+package loginguard
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PostgresRepository keeps counters in the login_attempts table and the
+// audit trail in login_lockouts.
+type PostgresRepository struct {
+	db *sql.DB
+}
+
+const (
+	getAttemptQuery = `
+		SELECT key, count, window_start, last_at, locked_until
+		FROM login_attempts
+		WHERE key = $1
+	`
+	// a single upsert, so concurrent attempts cannot lose counts
+	incrementAttemptQuery = `
+		INSERT INTO login_attempts (key, count, window_start, last_at)
+		VALUES ($1, 1, $2, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			count = CASE WHEN login_attempts.window_start < $3 THEN 1 ELSE login_attempts.count + 1 END,
+			window_start = CASE WHEN login_attempts.window_start < $3 THEN $2 ELSE login_attempts.window_start END,
+			last_at = $2
+		RETURNING key, count, window_start, last_at, locked_until
+	`
+	lockAttemptQuery = `
+		INSERT INTO login_attempts (key, count, window_start, last_at, locked_until)
+		VALUES ($1, 0, now(), now(), $2)
+		ON CONFLICT (key) DO UPDATE SET count = 0, locked_until = $2
+	`
+	resetAttemptQuery  = `DELETE FROM login_attempts WHERE key = $1`
+	insertLockoutQuery = `
+		INSERT INTO login_lockouts (email, ip, failures, locked_until, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	pruneAttemptsQuery = `
+		DELETE FROM login_attempts
+		WHERE last_at < $1 AND (locked_until IS NULL OR locked_until < $1)
+	`
+)
+
+func NewPostgresRepository(db *sql.DB) *PostgresRepository {
+	return &PostgresRepository{db: db}
+}
+
+func (r *PostgresRepository) Get(key string) (Attempt, error) {
+	a, err := scanAttempt(r.db.QueryRow(getAttemptQuery, key))
+	if err == sql.ErrNoRows {
+		return Attempt{}, nil
+	}
+	return a, err
+}
+
+func (r *PostgresRepository) Increment(key string, now, windowStart time.Time) (Attempt, error) {
+	return scanAttempt(r.db.QueryRow(incrementAttemptQuery, key, now, windowStart))
+}
+
+func (r *PostgresRepository) Lock(key string, until time.Time) error {
+	_, err := r.db.Exec(lockAttemptQuery, key, until)
+	return err
+}
+
+func (r *PostgresRepository) Reset(key string) error {
+	_, err := r.db.Exec(resetAttemptQuery, key)
+	return err
+}
+
+func (r *PostgresRepository) RecordLockout(l Lockout) error {
+	_, err := r.db.Exec(insertLockoutQuery, l.Email, l.IP, l.Failures, l.LockedUntil, l.CreatedAt)
+	return err
+}
+
+func (r *PostgresRepository) Prune(before time.Time) (int64, error) {
+	result, err := r.db.Exec(pruneAttemptsQuery, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func scanAttempt(row *sql.Row) (Attempt, error) {
+	var a Attempt
+	var locked sql.NullTime
+	if err := row.Scan(&a.Key, &a.Count, &a.WindowStart, &a.LastAt, &locked); err != nil {
+		return Attempt{}, err
+	}
+	if locked.Valid {
+		a.LockedUntil = &locked.Time
+	}
+	return a, nil
+}
@@ -0,0 +1,73 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// File writes every message as an .eml file below a directory, where it can
+// be opened with any mail client.
+type File struct {
+	dir  string
+	from string
+	now  func() time.Time
+}
+
+func NewFile(dir, from string) *File {
+	return &File{dir: dir, from: from, now: time.Now}
+}
+
+func (f *File) Send(m Message) error {
+	now := f.now()
+	data, err := format(f.from, m, now)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return err
+	}
+	// the random suffix keeps messages sent in the same instant apart
+	out, err := os.CreateTemp(f.dir, now.UTC().Format("20060102T150405.000000000")+"-*.eml")
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(data); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Log writes every message to a writer, stdout by default.
+type Log struct {
+	mu   sync.Mutex
+	w    io.Writer
+	from string
+}
+
+func NewLog(w io.Writer, from string) *Log {
+	if w == nil {
+		w = os.Stdout
+	}
+	return &Log{w: w, from: from}
+}
+
+func (l *Log) Send(m Message) error {
+	data, err := format(l.from, m, time.Now())
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = fmt.Fprintf(l.w, "----- mail -----\n%s\n----- end mail -----\n", data)
+	return err
+}
+
+var (
+	_ Mailer = (*SMTP)(nil)
+	_ Mailer = (*File)(nil)
+	_ Mailer = (*Log)(nil)
+)
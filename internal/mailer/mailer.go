@@ -0,0 +1,79 @@
+// Package mailer sends the app's transactional email behind the Mailer
+// interface. SMTP delivers for real; the file and log backends keep mail on
+// the machine for local development and tests.
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+)
+
+// Backends selectable through Config.Backend.
+const (
+	BackendSMTP = "smtp"
+	BackendFile = "file"
+	BackendLog  = "log"
+)
+
+var ErrInvalidHeader = errors.New("mail header contains a line break")
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(m Message) error
+}
+
+// Config selects and configures a backend. From is the sender of every
+// message; Dir is where the file backend writes.
+type Config struct {
+	Backend string
+	From    string
+	Dir     string
+	SMTP    SMTPConfig
+}
+
+// New returns the backend selected by cfg.Backend, defaulting to log.
+func New(cfg Config) (Mailer, error) {
+	switch cfg.Backend {
+	case "", BackendLog:
+		return NewLog(nil, cfg.From), nil
+	case BackendFile:
+		dir := cfg.Dir
+		if dir == "" {
+			dir = "./mail"
+		}
+		return NewFile(dir, cfg.From), nil
+	case BackendSMTP:
+		return NewSMTP(cfg.SMTP, cfg.From)
+	}
+	return nil, fmt.Errorf("unknown mail backend %q", cfg.Backend)
+}
+
+// format renders m as an RFC 5322 message. Header values with line breaks
+// are refused so user input cannot add headers or recipients.
+func format(from string, m Message, now time.Time) ([]byte, error) {
+	for _, v := range []string{from, m.To, m.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes(), nil
+}
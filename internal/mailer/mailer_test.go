@@ -0,0 +1,59 @@
+package mailer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewLog(&buf, "shop@example.com")
+	if err := m.Send(Message{To: "a@example.com", Subject: "Hello", Body: "line one\nline two"}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{"From: shop@example.com\r\n", "To: a@example.com\r\n", "Subject: Hello\r\n", "\r\n\r\nline one\r\nline two"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in %q", want, out)
+		}
+	}
+
+	if err := m.Send(Message{To: "a@example.com\r\nBcc: b@example.com", Subject: "x"}); err != ErrInvalidHeader {
+		t.Fatalf("expected ErrInvalidHeader for an injected header, got %v", err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := NewFile(dir, "shop@example.com")
+	for i := 0; i < 2; i++ {
+		if err := m.Send(Message{To: "a@example.com", Subject: "Hi", Body: "body"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("expected two .eml files, got %v (%v)", files, err)
+	}
+	data, _ := os.ReadFile(files[0])
+	if !strings.HasSuffix(string(data), "\r\n\r\nbody") {
+		t.Fatalf("unexpected message %q", data)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{Backend: "pigeon"}); err == nil {
+		t.Fatal("expected an unknown backend to be rejected")
+	}
+	if _, err := New(Config{Backend: BackendSMTP, From: "shop@example.com"}); err == nil {
+		t.Fatal("expected the smtp backend to require a host")
+	}
+	if m, err := New(Config{}); err != nil {
+		t.Fatal(err)
+	} else if _, ok := m.(*Log); !ok {
+		t.Fatalf("expected the log backend by default, got %T", m)
+	}
+}
@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"errors"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// SMTP delivers through a mail server. net/smtp upgrades the connection
+// with STARTTLS when the server offers it.
+type SMTP struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTP authenticates with PLAIN when a username is configured; the port
+// defaults to 587.
+func NewSMTP(cfg SMTPConfig, from string) (*SMTP, error) {
+	if cfg.Host == "" || from == "" {
+		return nil, errors.New("the smtp mail backend needs a host and a sender")
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = 587
+	}
+	s := &SMTP{addr: net.JoinHostPort(cfg.Host, strconv.Itoa(port)), from: from}
+	if cfg.Username != "" {
+		s.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	return s, nil
+}
+
+func (s *SMTP) Send(m Message) error {
+	data, err := format(s.from, m, time.Now())
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{m.To}, data)
+}
@@ -0,0 +1,328 @@
+// Package migrate applies the versioned SQL migrations embedded from sql/.
+// Every migration is a pair of files NNNN_name.up.sql and
+// NNNN_name.down.sql. Applied versions are recorded in schema_migrations
+// together with the checksum of their up file, and a Postgres advisory lock
+// keeps replicas that start at the same time from migrating concurrently.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+var (
+	ErrChecksumMismatch = errors.New("applied migration was modified")
+	ErrMissing          = errors.New("applied migration has no file")
+)
+
+// lockID is the pg_advisory_lock key held while migrating.
+const lockID = 7316385620164915
+
+const (
+	createTableQuery = `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`
+	listAppliedQuery   = `SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version`
+	insertAppliedQuery = `INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES ($1, $2, $3, now())`
+	deleteAppliedQuery = `DELETE FROM schema_migrations WHERE version = $1`
+)
+
+// Migration is one version of the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// Checksum is the hex SHA-256 of Up.
+	Checksum string
+}
+
+// State describes a migration in Status.
+type State string
+
+const (
+	Pending  State = "pending"
+	Applied  State = "applied"
+	Modified State = "modified" // applied, but the up file changed since
+	Missing  State = "missing"  // applied, but this build has no such file
+)
+
+// Entry is one line of Status.
+type Entry struct {
+	Version   int
+	Name      string
+	State     State
+	AppliedAt time.Time
+}
+
+type applied struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New returns a Migrator for the migrations embedded in this package.
+func New(db *sql.DB) (*Migrator, error) {
+	sub, err := fs.Sub(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := Load(sub)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithMigrations(db, migrations), nil
+}
+
+// NewWithMigrations returns a Migrator for the given migrations, which must
+// be sorted by version as Load returns them.
+func NewWithMigrations(db *sql.DB, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Load reads the migrations in the root of fsys. Every version needs both an
+// up and a down file, and versions must be unique.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		m := fileName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migrate: unexpected file %q", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		data, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migrate: version %d is used by %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(data)
+			sum := sha256.Sum256(data)
+			mig.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			mig.Down = string(data)
+		}
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migrate: %04d_%s needs both an up and a down file", mig.Version, mig.Name)
+		}
+		out = append(out, *mig)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Up applies every pending migration in version order and returns the
+// applied ones. It refuses to run when an applied migration was modified.
+// Applied versions without a file are ignored so that a replica still on
+// an older build can start after a newer one migrated.
+func (m *Migrator) Up() ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(func(ctx context.Context, conn *sql.Conn) error {
+		seen, err := listApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			a, ok := seen[mig.Version]
+			if ok {
+				if a.checksum != mig.Checksum {
+					return fmt.Errorf("%w: %04d_%s", ErrChecksumMismatch, mig.Version, mig.Name)
+				}
+				continue
+			}
+			if err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, insertAppliedQuery, mig.Version, mig.Name, mig.Checksum)
+				return err
+			}); err != nil {
+				return fmt.Errorf("migrate: %04d_%s up: %w", mig.Version, mig.Name, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns
+// the reverted ones.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	if steps < 1 {
+		return nil, nil
+	}
+	var done []Migration
+	err := m.withLock(func(ctx context.Context, conn *sql.Conn) error {
+		seen, err := listApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int, 0, len(seen))
+		for v := range seen {
+			versions = append(versions, v)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+		if steps < len(versions) {
+			versions = versions[:steps]
+		}
+
+		for _, v := range versions {
+			mig, ok := m.find(v)
+			if !ok {
+				return fmt.Errorf("%w: %04d_%s", ErrMissing, v, seen[v].name)
+			}
+			if seen[v].checksum != mig.Checksum {
+				return fmt.Errorf("%w: %04d_%s", ErrChecksumMismatch, mig.Version, mig.Name)
+			}
+			if err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, deleteAppliedQuery, mig.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("migrate: %04d_%s down: %w", mig.Version, mig.Name, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status lists every known migration, applied or not, in version order.
+func (m *Migrator) Status() ([]Entry, error) {
+	var out []Entry
+	err := m.withLock(func(ctx context.Context, conn *sql.Conn) error {
+		seen, err := listApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			e := Entry{Version: mig.Version, Name: mig.Name, State: Pending}
+			if a, ok := seen[mig.Version]; ok {
+				e.State, e.AppliedAt = Applied, a.appliedAt
+				if a.checksum != mig.Checksum {
+					e.State = Modified
+				}
+				delete(seen, mig.Version)
+			}
+			out = append(out, e)
+		}
+		for v, a := range seen {
+			out = append(out, Entry{Version: v, Name: a.name, State: Missing, AppliedAt: a.appliedAt})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+		return nil
+	})
+	return out, err
+}
+
+func (m *Migrator) find(version int) (Migration, bool) {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return mig, true
+		}
+	}
+	return Migration{}, false
+}
+
+// withLock runs fn on a single connection holding the advisory lock; the
+// lock belongs to the session, so every statement must use that connection.
+func (m *Migrator) withLock(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, lockID)
+	}()
+	if _, err := conn.ExecContext(ctx, createTableQuery); err != nil {
+		return err
+	}
+	return fn(ctx, conn)
+}
+
+func listApplied(ctx context.Context, conn *sql.Conn) (map[int]applied, error) {
+	rows, err := conn.QueryContext(ctx, listAppliedQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int]applied)
+	for rows.Next() {
+		var (
+			version int
+			a       applied
+		)
+		if err := rows.Scan(&version, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		out[version] = a
+	}
+	return out, rows.Err()
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
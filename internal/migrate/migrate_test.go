@@ -0,0 +1,192 @@
+package migrate
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"0002_second.up.sql":   {Data: []byte("CREATE TABLE b (id INT);")},
+		"0002_second.down.sql": {Data: []byte("DROP TABLE b;")},
+		"0001_first.up.sql":    {Data: []byte("CREATE TABLE a (id INT);")},
+		"0001_first.down.sql":  {Data: []byte("DROP TABLE a;")},
+	}
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(testFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[1].Name != "second" {
+		t.Fatalf("expected the migrations in version order, got %+v", migrations)
+	}
+	if migrations[0].Checksum == "" || migrations[0].Checksum == migrations[1].Checksum {
+		t.Fatalf("expected distinct checksums, got %q and %q", migrations[0].Checksum, migrations[1].Checksum)
+	}
+
+	fsys := testFS()
+	delete(fsys, "0002_second.down.sql")
+	if _, err := Load(fsys); err == nil {
+		t.Fatal("expected an error for a migration without a down file")
+	}
+	fsys = testFS()
+	fsys["0002_other.up.sql"] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+	if _, err := Load(fsys); err == nil {
+		t.Fatal("expected an error for a duplicate version")
+	}
+	fsys = testFS()
+	fsys["notes.txt"] = &fstest.MapFile{Data: []byte("x")}
+	if _, err := Load(fsys); err == nil {
+		t.Fatal("expected an error for a stray file")
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	m, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, mig := range m.migrations {
+		if mig.Version != i+1 {
+			t.Fatalf("expected contiguous versions, %04d_%s is at position %d", mig.Version, mig.Name, i+1)
+		}
+	}
+}
+
+var appliedColumns = []string{"version", "name", "checksum", "applied_at"}
+
+func expectLock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_lock($1)`)).WithArgs(lockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_unlock($1)`)).WithArgs(lockID).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestUp_AppliesPendingInTransactions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock error: %v", err)
+	}
+	defer db.Close()
+	migrations, _ := Load(testFS())
+	m := NewWithMigrations(db, migrations)
+
+	expectLock(mock)
+	mock.ExpectQuery("FROM schema_migrations").WillReturnRows(sqlmock.NewRows(appliedColumns).
+		AddRow(1, "first", migrations[0].Checksum, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE b")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(2, "second", migrations[1].Checksum).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	done, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 1 || done[0].Version != 2 {
+		t.Fatalf("expected only 0002 to be applied, got %+v", done)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestUp_RefusesModifiedMigration(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock error: %v", err)
+	}
+	defer db.Close()
+	migrations, _ := Load(testFS())
+	m := NewWithMigrations(db, migrations)
+
+	expectLock(mock)
+	mock.ExpectQuery("FROM schema_migrations").WillReturnRows(sqlmock.NewRows(appliedColumns).
+		AddRow(1, "first", "stale", time.Now()))
+	expectUnlock(mock)
+
+	if _, err := m.Up(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestDown_RevertsNewestAndRollsBackOnError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock error: %v", err)
+	}
+	defer db.Close()
+	migrations, _ := Load(testFS())
+	m := NewWithMigrations(db, migrations)
+	applied := sqlmock.NewRows(appliedColumns).
+		AddRow(1, "first", migrations[0].Checksum, time.Now()).
+		AddRow(2, "second", migrations[1].Checksum, time.Now())
+
+	expectLock(mock)
+	mock.ExpectQuery("FROM schema_migrations").WillReturnRows(applied)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DROP TABLE b")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM schema_migrations").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DROP TABLE a")).WillReturnError(errors.New("table is in use"))
+	mock.ExpectRollback()
+	expectUnlock(mock)
+
+	done, err := m.Down(5)
+	if err == nil {
+		t.Fatal("expected the failing down migration to be reported")
+	}
+	if len(done) != 1 || done[0].Version != 2 {
+		t.Fatalf("expected only 0002 to be reverted, got %+v", done)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock error: %v", err)
+	}
+	defer db.Close()
+	migrations, _ := Load(testFS())
+	m := NewWithMigrations(db, migrations)
+
+	expectLock(mock)
+	mock.ExpectQuery("FROM schema_migrations").WillReturnRows(sqlmock.NewRows(appliedColumns).
+		AddRow(1, "first", "stale", time.Now()).
+		AddRow(3, "from_newer_build", "x", time.Now()))
+	expectUnlock(mock)
+
+	entries, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []State{Modified, Pending, Missing}
+	if len(entries) != len(want) {
+		t.Fatalf("expected %d entries, got %+v", len(want), entries)
+	}
+	for i, e := range entries {
+		if e.State != want[i] {
+			t.Errorf("entry %d: expected %s, got %s", i, want[i], e.State)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS address;
+DROP TABLE IF EXISTS category;
+DROP TABLE IF EXISTS banner;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS "Favorite";
+DROP TABLE IF EXISTS cart;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS products;
@@ -0,0 +1,143 @@
+-- Core tables of the shop. Installations that predate migrations already
+-- have them, so every statement is written to be a no-op there.
+
+CREATE TABLE IF NOT EXISTS products (
+    productid SERIAL PRIMARY KEY,
+    productname TEXT,
+    productnameth TEXT,
+    productprice INT,
+    productdesc TEXT,
+    productdescth TEXT,
+    productimg TEXT,
+    score INT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+ALTER TABLE products ADD COLUMN IF NOT EXISTS productimgsec TEXT;
+-- bytea copies of the product images
+ALTER TABLE products ADD COLUMN IF NOT EXISTS product_img_data bytea, ADD COLUMN IF NOT EXISTS product_img_sec_data bytea;
+
+CREATE TABLE IF NOT EXISTS users (
+    userid SERIAL PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    firstname TEXT,
+    lastname TEXT,
+    phone TEXT,
+    gender TEXT,
+    mainaddressid INT,
+    avatarpic TEXT,
+    createdat TEXT,
+    updatedat TEXT
+);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_pic TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS "mainAddressId" INT;
+-- cart product id array (legacy) and the product->quantity map
+ALTER TABLE users ADD COLUMN IF NOT EXISTS "cartProductId" integer[];
+ALTER TABLE users ADD COLUMN IF NOT EXISTS cart jsonb NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS cart (
+    cartid SERIAL PRIMARY KEY,
+    userid INT NOT NULL,
+    productid INT NOT NULL,
+    quantity INT NOT NULL DEFAULT 1,
+    createdat TEXT,
+    updatedat TEXT,
+    UNIQUE (userid, productid)
+);
+
+CREATE TABLE IF NOT EXISTS "Favorite" (
+    userid INT NOT NULL,
+    productid INT NOT NULL,
+    PRIMARY KEY (userid, productid)
+);
+
+-- orders storing the cart map and price breakdown
+CREATE TABLE IF NOT EXISTS orders (
+    "orderID" SERIAL PRIMARY KEY,
+    "userID" INT NOT NULL,
+    cart jsonb NOT NULL DEFAULT '{}',
+    quantity INT NOT NULL DEFAULT 0,
+    "totalPrice" numeric NOT NULL DEFAULT 0,
+    "shippingPrice" numeric NOT NULL DEFAULT 0,
+    "grandPrice" numeric NOT NULL DEFAULT 0,
+    status TEXT,
+    "createdAt" TEXT,
+    "updatedAt" TEXT
+);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS cart jsonb NOT NULL DEFAULT '{}';
+-- rename lowercase columns from older schema versions
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'orders' AND column_name = 'totalprice') THEN
+        ALTER TABLE orders RENAME COLUMN totalprice TO "totalPrice";
+    END IF;
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'orders' AND column_name = 'shippingprice') THEN
+        ALTER TABLE orders RENAME COLUMN shippingprice TO "shippingPrice";
+    END IF;
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'orders' AND column_name = 'grandprice') THEN
+        ALTER TABLE orders RENAME COLUMN grandprice TO "grandPrice";
+    END IF;
+END $$;
+
+-- banners, seeded with images from the frontend's public/banner
+CREATE TABLE IF NOT EXISTS banner (banner_id SERIAL PRIMARY KEY, banner_img TEXT, banner_link TEXT, banner_alt TEXT, ord INT);
+INSERT INTO banner (banner_img, banner_link, banner_alt, ord)
+SELECT v.img, '', '', v.ord
+FROM (VALUES
+    ('/banner/04a429f3667447618ad41d1ddc3941295098953b.jpg', 3),
+    ('/banner/3a2c4a01b382255d010fdce9b9c5942f82297af9.jpg', 2),
+    ('/banner/8b1361654080c673a9ff07dd0f7ea6d51422c8b1 (1).jpg', 1)
+) AS v(img, ord)
+WHERE NOT EXISTS (SELECT 1 FROM banner);
+
+-- categories, seeded with images from the frontend's public/Category
+CREATE TABLE IF NOT EXISTS category ("categoryID" SERIAL PRIMARY KEY, "categoryName" TEXT, "categoryNameTH" TEXT, "categoryImg" TEXT, ord INT);
+ALTER TABLE category ADD COLUMN IF NOT EXISTS "categoryNameTH" TEXT;
+INSERT INTO category ("categoryName", "categoryNameTH", "categoryImg", ord)
+SELECT v.name, v.name_th, v.img, v.ord
+FROM (VALUES
+    ('Animal food', 'อาหารสัตว์', '/Category/Animal _food.png', 8),
+    ('Pet supplies', 'ของใช้สัตว์เลี้ยง', '/Category/pet_supplies.png', 7),
+    ('Clothes and accessories', 'เสื้อผ้าและเครื่องแต่งกาย', '/Category/Clothes_and_accessories.png', 6),
+    ('Cleaning equipment', 'อุปกรณ์ทำความสะอาด', '/Category/Cleaning_equipment.png', 5),
+    ('Sand and bathroom', 'ทรายและห้องน้ำ', '/Category/sand_and_bathroom.png', 4),
+    ('Hygiene care', 'ปกป้องสุขภาพ', '/Category/Hygiene_care.png', 3),
+    ('Cat snacks', 'ขนมแมว', '/Category/Cat_snacks.png', 2),
+    ('Cat exercise', 'อุปกรณ์ออกกำลังกายแมว', '/Category/Cat_exercise.png', 1)
+) AS v(name, name_th, img, ord)
+WHERE NOT EXISTS (SELECT 1 FROM category);
+
+-- user addresses (camelCase column names to match project convention)
+CREATE TABLE IF NOT EXISTS address (
+    "addressID" SERIAL PRIMARY KEY,
+    "userID" INT NOT NULL,
+    "addressDesc" TEXT,
+    "phone" TEXT,
+    "addressName" TEXT,
+    "createdAt" TEXT,
+    "updatedAt" TEXT
+);
+-- installations that used snake_case column names get them renamed
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'address' AND column_name = 'address_id') THEN
+        ALTER TABLE address RENAME COLUMN address_id TO "addressID";
+    END IF;
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'address' AND column_name = 'user_id') THEN
+        ALTER TABLE address RENAME COLUMN user_id TO "userID";
+    END IF;
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'address' AND column_name = 'address_desc') THEN
+        ALTER TABLE address RENAME COLUMN address_desc TO "addressDesc";
+    END IF;
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'address' AND column_name = 'address_name') THEN
+        ALTER TABLE address RENAME COLUMN address_name TO "addressName";
+    END IF;
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'address' AND column_name = 'created_at') THEN
+        ALTER TABLE address RENAME COLUMN created_at TO "createdAt";
+    END IF;
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'address' AND column_name = 'updated_at') THEN
+        ALTER TABLE address RENAME COLUMN updated_at TO "updatedAt";
+    END IF;
+END $$;
+ALTER TABLE address ADD COLUMN IF NOT EXISTS "userID" INT NOT NULL DEFAULT 0;
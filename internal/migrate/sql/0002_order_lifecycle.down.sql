@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS order_status_history;
+DROP TABLE IF EXISTS order_items;
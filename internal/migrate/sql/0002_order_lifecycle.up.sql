@@ -0,0 +1,60 @@
+-- order_items keeps an immutable snapshot of each purchased product so
+-- order history does not follow later price changes or deletions
+CREATE TABLE IF NOT EXISTS order_items (
+    "orderItemID" SERIAL PRIMARY KEY,
+    "orderID" INT NOT NULL REFERENCES orders("orderID") ON DELETE CASCADE,
+    "productID" INT NOT NULL,
+    "productName" TEXT,
+    "productNameTH" TEXT,
+    "productImg" TEXT,
+    "unitPrice" numeric NOT NULL DEFAULT 0,
+    quantity INT NOT NULL DEFAULT 0,
+    "lineTotal" numeric NOT NULL DEFAULT 0,
+    "createdAt" TEXT
+);
+CREATE INDEX IF NOT EXISTS order_items_order_idx ON order_items ("orderID");
+
+-- backfill snapshots for orders placed before order_items existed, using
+-- the best data still available (current product rows)
+INSERT INTO order_items ("orderID", "productID", "productName", "productNameTH", "productImg", "unitPrice", quantity, "lineTotal", "createdAt")
+SELECT o."orderID", c.key::int, p.productname, p.productnameth, p.productimg,
+       COALESCE(p.productprice, 0), c.value::int, COALESCE(p.productprice, 0) * c.value::int, o."createdAt"
+FROM orders o
+CROSS JOIN LATERAL jsonb_each_text(o.cart) c
+LEFT JOIN products p ON p.productid = c.key::int
+WHERE c.key ~ '^[0-9]+$'
+  AND NOT EXISTS (SELECT 1 FROM order_items i WHERE i."orderID" = o."orderID");
+
+-- order_status_history records every lifecycle transition of an order
+CREATE TABLE IF NOT EXISTS order_status_history (
+    "historyID" SERIAL PRIMARY KEY,
+    "orderID" INT NOT NULL REFERENCES orders("orderID") ON DELETE CASCADE,
+    "fromStatus" TEXT,
+    "toStatus" TEXT NOT NULL,
+    "changedBy" INT,
+    note TEXT,
+    "createdAt" TEXT
+);
+CREATE INDEX IF NOT EXISTS order_status_history_order_idx ON order_status_history ("orderID");
+
+-- orders created before the lifecycle existed have no status; start them
+-- at pending_payment with a matching initial history entry
+UPDATE orders SET status = 'pending_payment' WHERE status IS NULL OR status = '';
+INSERT INTO order_status_history ("orderID", "fromStatus", "toStatus", "changedBy", note, "createdAt")
+SELECT o."orderID", NULL, o.status, o."userID", 'order created', o."createdAt"
+FROM orders o
+WHERE NOT EXISTS (SELECT 1 FROM order_status_history h WHERE h."orderID" = o."orderID");
+
+-- idempotency_keys stores the first response for each Idempotency-Key so
+-- retried order/payment requests are replayed instead of re-executed
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id INT NOT NULL,
+    scope TEXT NOT NULL,
+    key TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    status_code INT,
+    content_type TEXT,
+    response_body bytea,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, scope, key)
+);
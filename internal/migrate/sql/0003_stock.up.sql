@@ -0,0 +1,19 @@
+-- per-product stock; reserved at checkout and released on cancel/expiry.
+-- Rollout: products that exist when this runs get a NULL stock, meaning it
+-- is not tracked, so they keep selling as before. Staff start tracking a
+-- product by setting its stock (PUT /api/v1/product/:id/stock); products
+-- created afterwards are tracked from 0.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS stock INT;
+ALTER TABLE products ALTER COLUMN stock SET DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS stock_reservations (
+    "reservationID" SERIAL PRIMARY KEY,
+    "orderID" INT NOT NULL REFERENCES orders("orderID") ON DELETE CASCADE,
+    "productID" INT NOT NULL,
+    quantity INT NOT NULL,
+    status TEXT NOT NULL,
+    "expiresAt" TIMESTAMPTZ NOT NULL,
+    "createdAt" TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS stock_reservations_order_idx ON stock_reservations ("orderID");
+CREATE INDEX IF NOT EXISTS stock_reservations_expiry_idx ON stock_reservations ("expiresAt") WHERE status = 'reserved';
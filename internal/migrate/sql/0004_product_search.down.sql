@@ -0,0 +1,6 @@
+-- pg_trgm stays installed; other objects in the database may use it
+DROP INDEX IF EXISTS products_productdescth_trgm_idx;
+DROP INDEX IF EXISTS products_productdesc_trgm_idx;
+DROP INDEX IF EXISTS products_productnameth_trgm_idx;
+DROP INDEX IF EXISTS products_productname_trgm_idx;
+DROP INDEX IF EXISTS products_search_idx;
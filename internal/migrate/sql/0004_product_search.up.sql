@@ -0,0 +1,10 @@
+-- search indexes: full-text for space-separated words and trigram for
+-- substring/fuzzy matching, which is what makes Thai searchable
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS products_search_idx ON products USING gin (
+    to_tsvector('simple', coalesce(productname, '') || ' ' || coalesce(productnameth, '') || ' ' || coalesce(productdesc, '') || ' ' || coalesce(productdescth, ''))
+);
+CREATE INDEX IF NOT EXISTS products_productname_trgm_idx ON products USING gin (productname gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS products_productnameth_trgm_idx ON products USING gin (productnameth gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS products_productdesc_trgm_idx ON products USING gin (productdesc gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS products_productdescth_trgm_idx ON products USING gin (productdescth gin_trgm_ops);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS products_score_idx;
+DROP INDEX IF EXISTS products_price_idx;
+ALTER TABLE products DROP COLUMN IF EXISTS category;
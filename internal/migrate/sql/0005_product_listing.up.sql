@@ -0,0 +1,5 @@
+-- listing filters use the category name on products; price and score
+-- indexes back the keyset pagination of the price/score sort orders
+ALTER TABLE products ADD COLUMN IF NOT EXISTS category TEXT;
+CREATE INDEX IF NOT EXISTS products_price_idx ON products ((COALESCE(productprice, 0)), productid);
+CREATE INDEX IF NOT EXISTS products_score_idx ON products ((COALESCE(score, 0)), productid);
@@ -0,0 +1,8 @@
+-- fails when a user has several variants of one product in the cart;
+-- clear those lines first
+DROP INDEX IF EXISTS cart_user_product_variant_key;
+ALTER TABLE cart DROP COLUMN IF EXISTS variantid;
+ALTER TABLE cart ADD CONSTRAINT cart_userid_productid_key UNIQUE (userid, productid);
+ALTER TABLE stock_reservations DROP COLUMN IF EXISTS "variantID";
+ALTER TABLE order_items DROP COLUMN IF EXISTS options, DROP COLUMN IF EXISTS sku, DROP COLUMN IF EXISTS "variantID";
+DROP TABLE IF EXISTS product_variants;
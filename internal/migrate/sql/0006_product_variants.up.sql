@@ -0,0 +1,37 @@
+-- product_variants holds size/flavor/weight options with their own
+-- SKU, price and stock
+CREATE TABLE IF NOT EXISTS product_variants (
+    variantid SERIAL PRIMARY KEY,
+    productid INT NOT NULL REFERENCES products(productid) ON DELETE CASCADE,
+    sku TEXT NOT NULL UNIQUE,
+    options jsonb NOT NULL DEFAULT '{}',
+    price INT NOT NULL DEFAULT 0,
+    stock INT NOT NULL DEFAULT 0,
+    img TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS product_variants_product_idx ON product_variants (productid);
+
+-- variant columns on order items and reservations; variantID 0 means the
+-- base product
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS "variantID" INT NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS sku TEXT, ADD COLUMN IF NOT EXISTS options jsonb;
+ALTER TABLE stock_reservations ADD COLUMN IF NOT EXISTS "variantID" INT NOT NULL DEFAULT 0;
+
+-- cart lines may point at a variant; the unique key moves from
+-- (userid, productid) to (userid, productid, variantid)
+ALTER TABLE cart ADD COLUMN IF NOT EXISTS variantid INT NOT NULL DEFAULT 0;
+CREATE UNIQUE INDEX IF NOT EXISTS cart_user_product_variant_key ON cart (userid, productid, variantid);
+DO $$
+DECLARE con record;
+BEGIN
+    FOR con IN
+        SELECT c.conname FROM pg_constraint c
+        WHERE c.conrelid = 'cart'::regclass AND c.contype = 'u'
+          AND (SELECT array_agg(a.attname::text ORDER BY a.attname)
+               FROM pg_attribute a
+               WHERE a.attrelid = c.conrelid AND a.attnum = ANY(c.conkey)) = ARRAY['productid', 'userid']
+    LOOP
+        EXECUTE format('ALTER TABLE cart DROP CONSTRAINT %I', con.conname);
+    END LOOP;
+END $$;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS review_helpful_votes;
+DROP TABLE IF EXISTS reviews;
+ALTER TABLE products DROP COLUMN IF EXISTS rating_count, DROP COLUMN IF EXISTS rating_avg;
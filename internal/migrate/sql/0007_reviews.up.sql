@@ -0,0 +1,23 @@
+-- reviews by customers with a delivered order; products cache the
+-- aggregate and derive score from it
+ALTER TABLE products ADD COLUMN IF NOT EXISTS rating_avg NUMERIC(3,2) NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS rating_count INT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS reviews (
+    review_id SERIAL PRIMARY KEY,
+    product_id INT NOT NULL REFERENCES products(productid) ON DELETE CASCADE,
+    user_id INT NOT NULL,
+    rating INT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    body TEXT NOT NULL DEFAULT '',
+    photos TEXT[] NOT NULL DEFAULT '{}',
+    helpful_count INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (product_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS reviews_product_helpful_idx ON reviews (product_id, helpful_count DESC, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS review_helpful_votes (
+    review_id INT NOT NULL REFERENCES reviews(review_id) ON DELETE CASCADE,
+    user_id INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (review_id, user_id)
+);
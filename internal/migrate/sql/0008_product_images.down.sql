@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS product_images;
+ALTER TABLE products DROP COLUMN IF EXISTS product_img_type, DROP COLUMN IF EXISTS product_img_thumb, DROP COLUMN IF EXISTS product_img_medium;
@@ -0,0 +1,21 @@
+-- renditions of the uploaded product image; product_img_data holds the
+-- large one and product_img_type the format shared by all three
+ALTER TABLE products ADD COLUMN IF NOT EXISTS product_img_medium bytea, ADD COLUMN IF NOT EXISTS product_img_thumb bytea, ADD COLUMN IF NOT EXISTS product_img_type TEXT;
+
+-- product_images is the ordered product gallery; the partial unique
+-- index allows a single primary image per product
+CREATE TABLE IF NOT EXISTS product_images (
+    image_id SERIAL PRIMARY KEY,
+    product_id INT NOT NULL REFERENCES products(productid) ON DELETE CASCADE,
+    position INT NOT NULL,
+    alt_en TEXT NOT NULL DEFAULT '',
+    alt_th TEXT NOT NULL DEFAULT '',
+    is_primary BOOLEAN NOT NULL DEFAULT false,
+    content_type TEXT NOT NULL,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+-- smaller renditions of gallery images; data holds the large one
+ALTER TABLE product_images ADD COLUMN IF NOT EXISTS medium_data BYTEA, ADD COLUMN IF NOT EXISTS thumb_data BYTEA;
+CREATE INDEX IF NOT EXISTS product_images_product_idx ON product_images (product_id, position);
+CREATE UNIQUE INDEX IF NOT EXISTS product_images_primary_key ON product_images (product_id) WHERE is_primary;
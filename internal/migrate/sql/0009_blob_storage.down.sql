@@ -0,0 +1,4 @@
+-- gallery images stored only as blobs have no data left and are removed
+DROP TABLE IF EXISTS storage_blobs;
+DELETE FROM product_images WHERE data IS NULL;
+ALTER TABLE product_images DROP COLUMN IF EXISTS storage_key, ALTER COLUMN data SET NOT NULL;
@@ -0,0 +1,11 @@
+-- new gallery images live in blob storage under storage_key; the bytea
+-- columns only hold images uploaded before that
+ALTER TABLE product_images ADD COLUMN IF NOT EXISTS storage_key TEXT, ALTER COLUMN data DROP NOT NULL;
+
+-- objects of the postgres storage backend
+CREATE TABLE IF NOT EXISTS storage_blobs (
+    key TEXT PRIMARY KEY,
+    content_type TEXT NOT NULL,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,4 @@
+-- restores the old table; rows copied into products stay there
+ALTER TABLE IF EXISTS product_premerge RENAME TO product;
+ALTER TABLE products DROP COLUMN IF EXISTS productdescen;
+ALTER TABLE products DROP COLUMN IF EXISTS productnameen;
@@ -0,0 +1,28 @@
+-- one-time merge of the old v2 `product` table into products: rows only
+-- present in product are copied with their ids, matching rows fill the
+-- fields products lacks, and product is kept as product_premerge. The
+-- English texts get their own columns; productnameth stays Thai.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS productnameen TEXT;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS productdescen TEXT;
+
+DO $$
+BEGIN
+    IF to_regclass('public.product') IS NULL THEN
+        RETURN;
+    END IF;
+    INSERT INTO products (productid, productname, productnameen, category, productprice, score, productdesc, productdescen,
+        productimg, productimgsec, created_at, updated_at)
+    SELECT v.product_id, v.product_name, v.product_name_en, v.category, v.product_price, v.score, v.product_desc, v.product_desc_en,
+        v.product_pic, v.product_pic_second, COALESCE(v.created_at, now()), COALESCE(v.updated_at, now())
+    FROM product v
+    WHERE NOT EXISTS (SELECT 1 FROM products p WHERE p.productid = v.product_id);
+    UPDATE products p
+    SET productnameen = COALESCE(p.productnameen, v.product_name_en),
+        productdescen = COALESCE(p.productdescen, v.product_desc_en),
+        category = COALESCE(p.category, v.category),
+        productimgsec = COALESCE(p.productimgsec, v.product_pic_second)
+    FROM product v
+    WHERE v.product_id = p.productid;
+    PERFORM setval(pg_get_serial_sequence('products', 'productid'), GREATEST((SELECT MAX(productid) FROM products), 1));
+    ALTER TABLE product RENAME TO product_premerge;
+END $$;
@@ -0,0 +1,4 @@
+-- roles gate the catalog, order and user management routes; everyone
+-- starts as a customer
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'customer'
+    CONSTRAINT users_role_check CHECK (role IN ('customer', 'staff', 'admin'));
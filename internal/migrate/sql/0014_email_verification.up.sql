@@ -0,0 +1,18 @@
+-- accounts start unverified; existing ones too, since nobody proved they
+-- own their address
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+-- sign-in and sign-up look emails up case-insensitively
+CREATE INDEX IF NOT EXISTS idx_users_email_lower ON users (lower(email));
+
+-- email is the address the link was sent to, so a link stops working once
+-- the user changes their email
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    email TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    used_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_email_verification_tokens_user ON email_verification_tokens (user_id, created_at);
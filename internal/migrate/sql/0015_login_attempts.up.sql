@@ -0,0 +1,20 @@
+-- sign-in counters keyed by "email:<email>" or "ip:<address>"
+CREATE TABLE IF NOT EXISTS login_attempts (
+    key TEXT PRIMARY KEY,
+    count INT NOT NULL,
+    window_start TIMESTAMPTZ NOT NULL,
+    last_at TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_last_at ON login_attempts (last_at);
+
+-- audit trail of lockouts; kept when the counters are pruned
+CREATE TABLE IF NOT EXISTS login_lockouts (
+    id BIGSERIAL PRIMARY KEY,
+    email TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    failures INT NOT NULL,
+    locked_until TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_login_lockouts_email ON login_lockouts (email, created_at);
@@ -0,0 +1,4 @@
+-- accounts whose owner asked for deletion are erased once delete_after has
+-- passed; until then the request can be cancelled
+ALTER TABLE users ADD COLUMN IF NOT EXISTS delete_after TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_users_delete_after ON users (delete_after) WHERE delete_after IS NOT NULL;
@@ -1,11 +1,15 @@
 package order
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
+	"github.com/wichananm65/pet-shop-backend/internal/idempotency"
 	"github.com/wichananm65/pet-shop-backend/internal/product"
 	"github.com/wichananm65/pet-shop-backend/internal/user"
 )
@@ -14,26 +18,53 @@ import (
 // It also needs the user service to update user order lists.
 
 type Handler struct {
-	service        *Service
-	userService    user.ServiceInterface
-	productService product.ServiceInterface
+	service     *Service
+	userService user.ServiceInterface
+	// idempotent guards order-creating routes against client retries; it
+	// defaults to a pass-through until WithIdempotency is called.
+	idempotent fiber.Handler
+	// checkoutPolicy, when set, has to pass before a user places an order
+	checkoutPolicy func(userID int) error
 }
 
-func NewHandler(s *Service, us user.ServiceInterface, ps product.ServiceInterface) *Handler {
-	return &Handler{service: s, userService: us, productService: ps}
+func NewHandler(s *Service, us user.ServiceInterface) *Handler {
+	return &Handler{service: s, userService: us, idempotent: func(c *fiber.Ctx) error { return c.Next() }}
+}
+
+// WithIdempotency enables Idempotency-Key support on the routes that create
+// orders, backed by the given repository.
+func (h *Handler) WithIdempotency(repo idempotency.Repository) *Handler {
+	h.idempotent = idempotency.New(repo)
+	return h
+}
+
+// WithCheckoutPolicy makes order creation and checkout call policy with the
+// user's ID first; an error is answered with 403 and its message, e.g. to
+// require a verified email.
+func (h *Handler) WithCheckoutPolicy(policy func(userID int) error) *Handler {
+	h.checkoutPolicy = policy
+	return h
 }
 
 func (h *Handler) RegisterProtectedRoutes(app *fiber.App) {
-	app.Post("/api/v1/orders", h.createOrder)
+	app.Post("/api/v1/orders", h.idempotent, h.createOrder)
+	app.Post("/api/v1/checkout", h.idempotent, h.checkout)
 	app.Get("/api/v1/orders", h.getOrders)
+	app.Get("/api/v1/orders/:id<[0-9]+>", h.getOrder)
+	app.Post("/api/v1/orders/:id<[0-9]+>/cancel", h.cancelOrder)
+	app.Get("/api/v1/orders/:id<[0-9]+>/history", h.getOrderHistory)
+	// staff move orders of any customer through the lifecycle
+	app.Put("/api/v1/orders/:id<[0-9]+>/status", auth.Require(auth.ManageOrders), h.updateStatus)
 }
 
+// createOrderRequest carries the cart to order. The price fields are
+// optional; when sent they must match the server-side calculation.
 type createOrderRequest struct {
 	Cart          map[string]int `json:"cart"`
-	Quantity      int            `json:"quantity"`
-	TotalPrice    float64        `json:"totalPrice"`
-	ShippingPrice float64        `json:"shippingPrice"`
-	GrandPrice    float64        `json:"grandPrice"`
+	Quantity      *int           `json:"quantity"`
+	TotalPrice    *float64       `json:"totalPrice"`
+	ShippingPrice *float64       `json:"shippingPrice"`
+	GrandPrice    *float64       `json:"grandPrice"`
 }
 
 func (h *Handler) createOrder(c *fiber.Ctx) error {
@@ -44,31 +75,30 @@ func (h *Handler) createOrder(c *fiber.Ctx) error {
 	if len(payload.Cart) == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "cart cannot be empty"})
 	}
-	if payload.Quantity <= 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "quantity must be positive"})
-	}
-	if payload.TotalPrice < 0 || payload.ShippingPrice < 0 || payload.GrandPrice < 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "prices must be non-negative"})
-	}
 
 	userID, err := user.GetUserIDFromCtx(c)
 	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
 	}
+	if err := h.checkCheckoutPolicy(userID); err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"message": err.Error()})
+	}
 
 	order := Order{
-		Cart:          payload.Cart,
+		Cart:      payload.Cart,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		UpdatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	claimed := &ClaimedTotals{
 		Quantity:      payload.Quantity,
 		TotalPrice:    payload.TotalPrice,
 		ShippingPrice: payload.ShippingPrice,
 		GrandPrice:    payload.GrandPrice,
-		CreatedAt:     time.Now().UTC().Format(time.RFC3339),
-		UpdatedAt:     time.Now().UTC().Format(time.RFC3339),
 	}
 
-	created, err := h.service.Create(order, userID)
+	created, err := h.service.Create(order, userID, claimed)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+		return writeOrderError(c, err)
 	}
 
 	// append orderID to user's order list via userService
@@ -78,7 +108,69 @@ func (h *Handler) createOrder(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(created)
 }
 
+// checkout converts the caller's cart into an order in one step, replacing
+// the read-cart / post-order / clear-cart sequence on the client.
+func (h *Handler) checkout(c *fiber.Ctx) error {
+	userID, err := user.GetUserIDFromCtx(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
+	}
+	if err := h.checkCheckoutPolicy(userID); err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"message": err.Error()})
+	}
+
+	created, err := h.service.Checkout(userID)
+	if err != nil {
+		return writeOrderError(c, err)
+	}
+
+	if _, err2 := h.userService.AppendOrderID(userID, created.OrderID); err2 != nil {
+		fmt.Printf("warning: could not append orderID to user %d: %v\n", userID, err2)
+	}
+	return c.Status(fiber.StatusCreated).JSON(created)
+}
+
+func (h *Handler) checkCheckoutPolicy(userID int) error {
+	if h.checkoutPolicy == nil {
+		return nil
+	}
+	return h.checkoutPolicy(userID)
+}
+
+// writeOrderError maps service errors onto HTTP responses. Pricing problems
+// are returned with enough structure for the client to show what changed.
+func writeOrderError(c *fiber.Ctx, err error) error {
+	var mismatch *PriceMismatchError
+	var unknown *UnknownProductError
+	var transition *TransitionError
+	var shortage *product.InsufficientStockError
+	switch {
+	case errors.As(err, &mismatch):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": mismatch.Error(), "differences": mismatch.Differences})
+	case errors.As(err, &unknown):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": unknown.Error(), "productIds": unknown.ProductIDs})
+	case errors.As(err, &shortage):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": shortage.Error(), "shortages": shortage.Shortages})
+	case errors.Is(err, ErrEmptyCart), errors.Is(err, ErrInvalidCartItem):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	case errors.Is(err, ErrNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "order not found"})
+	case errors.Is(err, ErrStatusConflict), errors.Is(err, ErrNotCancellable):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": err.Error()})
+	case errors.As(err, &transition):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": transition.Error(), "from": transition.From, "to": transition.To})
+	case errors.Is(err, ErrUnknownStatus):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	case errors.Is(err, ErrInvalidUser):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+	}
+}
+
 // getOrders returns all orders belonging to the currently authenticated user.
+// Each order carries the item snapshots captured at checkout, so prices and
+// names reflect what the customer actually paid.
 func (h *Handler) getOrders(c *fiber.Ctx) error {
 	userID, err := user.GetUserIDFromCtx(c)
 	if err != nil {
@@ -89,40 +181,110 @@ func (h *Handler) getOrders(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
 	}
+	return c.JSON(orders)
+}
+
+// getOrder returns a single order of the current user.
+func (h *Handler) getOrder(c *fiber.Ctx) error {
+	userID, err := user.GetUserIDFromCtx(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
+	}
+	ord, err := h.ownedOrder(c, userID)
+	if err != nil {
+		return writeOrderError(c, err)
+	}
+	return c.JSON(ord)
+}
 
-	// enrich cart entries with product details if service available
-	if h.productService != nil && len(orders) > 0 {
-		// collect unique product IDs from all carts
-		idSet := map[int]struct{}{}
-		for _, ord := range orders {
-			for pidStr := range ord.Cart {
-				if id, err := strconv.Atoi(pidStr); err == nil {
-					idSet[id] = struct{}{}
-				}
-			}
-		}
-		ids := make([]int, 0, len(idSet))
-		for id := range idSet {
-			ids = append(ids, id)
-		}
-		if len(ids) > 0 {
-			prods, err2 := h.productService.ListV1ByIDs(ids)
-			if err2 == nil {
-				prodMap := map[string]product.ProductV1{}
-				for _, p := range prods {
-					prodMap[strconv.Itoa(p.ProductID)] = p
-				}
-				for i := range orders {
-					orders[i].CartProducts = map[string]product.ProductV1{}
-					for pidStr := range orders[i].Cart {
-						if p, ok := prodMap[pidStr]; ok {
-							orders[i].CartProducts[pidStr] = p
-						}
-					}
-				}
-			}
+type cancelOrderRequest struct {
+	Reason string `json:"reason"`
+}
+
+// cancelOrder lets a customer cancel one of their orders before shipment.
+func (h *Handler) cancelOrder(c *fiber.Ctx) error {
+	userID, err := user.GetUserIDFromCtx(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
+	}
+	payload := new(cancelOrderRequest)
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(payload); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
 		}
 	}
+	ord, err := h.ownedOrder(c, userID)
+	if err != nil {
+		return writeOrderError(c, err)
+	}
 
-	return c.JSON(orders)
+	cancelled, err := h.service.Cancel(ord.OrderID, userID, strings.TrimSpace(payload.Reason))
+	if err != nil {
+		return writeOrderError(c, err)
+	}
+	return c.JSON(cancelled)
+}
+
+type updateStatusRequest struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+// updateStatus moves any order to a new status on behalf of a staff member,
+// who is recorded as the actor in the status history.
+func (h *Handler) updateStatus(c *fiber.Ctx) error {
+	staffID, err := user.GetUserIDFromCtx(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
+	}
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return writeOrderError(c, ErrNotFound)
+	}
+	payload := new(updateStatusRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+
+	updated, err := h.service.UpdateStatus(id, strings.TrimSpace(payload.Status), staffID, strings.TrimSpace(payload.Note))
+	if err != nil {
+		return writeOrderError(c, err)
+	}
+	return c.JSON(updated)
+}
+
+// getOrderHistory returns the status timeline of one of the current user's
+// orders. Orders belonging to other users are reported as not found.
+func (h *Handler) getOrderHistory(c *fiber.Ctx) error {
+	userID, err := user.GetUserIDFromCtx(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
+	}
+	ord, err := h.ownedOrder(c, userID)
+	if err != nil {
+		return writeOrderError(c, err)
+	}
+
+	history, err := h.service.History(ord.OrderID)
+	if err != nil {
+		return writeOrderError(c, err)
+	}
+	return c.JSON(fiber.Map{"orderID": ord.OrderID, "status": currentStatus(ord), "history": history})
+}
+
+// ownedOrder loads the order named by the :id route parameter and checks
+// that it belongs to userID.
+func (h *Handler) ownedOrder(c *fiber.Ctx, userID int) (Order, error) {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return Order{}, ErrNotFound
+	}
+	ord, err := h.service.GetByID(id)
+	if err != nil {
+		return Order{}, err
+	}
+	if ord.UserID != userID {
+		return Order{}, ErrNotFound
+	}
+	return ord, nil
 }
@@ -3,26 +3,28 @@ package order
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
 	"github.com/wichananm65/pet-shop-backend/internal/product"
 	"github.com/wichananm65/pet-shop-backend/internal/user"
 )
 
-// dummy repo implementing Repository
-type dummyRepo struct{}
-
-// implement ListByIDs for retrieving orders in tests
-func (r *dummyRepo) ListByIDs(ids []int) ([]Order, error) {
-	orders := make([]Order, 0, len(ids))
-	for _, id := range ids {
-		orders = append(orders, Order{OrderID: id, Cart: map[string]int{"1": 1}, Quantity: 1, TotalPrice: 10, ShippingPrice: 2, GrandPrice: 12})
-	}
-	return orders, nil
+// newTestRepo returns an in-memory repository holding order 123 of user 42,
+// whose item snapshot still carries the name the product had at checkout.
+func newTestRepo() *InMemoryRepository {
+	return NewInMemoryRepository([]Order{{
+		OrderID: 123, UserID: 42, Cart: map[string]int{"1": 1}, Quantity: 1, TotalPrice: 10, ShippingPrice: 2, GrandPrice: 12,
+		Status: StatusPendingPayment,
+		Items:  []OrderItem{{OrderItemID: 1, OrderID: 123, ProductID: 1, ProductName: ptrString("old name"), UnitPrice: 10, Quantity: 1, LineTotal: 10}},
+	}})
 }
 
 // dummy user service with AppendOrderID stub
@@ -49,6 +51,15 @@ func (d *dummyProductService) ListV1ByIDs(ids []int) ([]product.ProductV1, error
 	}
 	return out, nil
 }
+
+// ListVariantsByIDs returns a variant of product 1 priced at 15 for every id.
+func (d *dummyProductService) ListVariantsByIDs(ids []int) ([]product.Variant, error) {
+	out := make([]product.Variant, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, product.Variant{VariantID: id, ProductID: 1, SKU: fmt.Sprintf("SKU-%d", id), Options: map[string]string{"size": "M"}, Price: 15})
+	}
+	return out, nil
+}
 func (d *dummyProductService) Create(p product.Product) (product.Product, error) { return p, nil }
 func (d *dummyProductService) Update(id int, p product.Product) (product.Product, error) {
 	return p, nil
@@ -96,30 +107,31 @@ func (d *dummyUserService) AppendOrderID(userID int, orderID int) (user.User, er
 // Ensure dummyUserService implements user.ServiceInterface
 var _ user.ServiceInterface = (*dummyUserService)(nil)
 
-func (r *dummyRepo) Create(ord Order) (Order, error) {
-	ord.OrderID = 123
-	return ord, nil
-}
-
 // makeAppWithAuth returns an app wired with the order handler plus a
 // tiny piece of middleware that emulates JWT parsing by reading an
-// "X-User-ID" header and populating c.Locals("user").  This mirrors the
+// "X-User-ID" and "X-User-Role" headers and populating c.Locals("user").  This mirrors the
 // pattern used in other package tests such as cart/handler_test.go.
 func makeAppWithAuth() *fiber.App {
+	return makeAppWithRepo(newTestRepo())
+}
+
+func makeAppWithRepo(repo Repository) *fiber.App {
+	return makeAppWithHandler(NewHandler(NewService(repo, &dummyProductService{}), &dummyUserService{}))
+}
+
+func makeAppWithHandler(h *Handler) *fiber.App {
 	a := fiber.New()
 	a.Use(func(c *fiber.Ctx) error {
 		if v := c.Get("X-User-ID"); v != "" {
 			// simple conversion, ignore error for test simplicity
 			var id int
 			fmt.Sscanf(v, "%d", &id)
-			claims := jwt.MapClaims{"user_id": id}
+			claims := jwt.MapClaims{"user_id": id, auth.RoleClaim: c.Get("X-User-Role")}
 			tok := &jwt.Token{Claims: claims}
 			c.Locals("user", tok)
 		}
 		return c.Next()
 	})
-	prdService := &dummyProductService{}
-	h := NewHandler(NewService(&dummyRepo{}), &dummyUserService{}, prdService)
 	h.RegisterProtectedRoutes(a)
 	return a
 }
@@ -130,9 +142,9 @@ func TestCreateOrder_Success(t *testing.T) {
 	reqBody := map[string]interface{}{
 		"cart":          map[string]int{"1": 2},
 		"quantity":      2,
-		"totalPrice":    100.0,
-		"shippingPrice": 10.0,
-		"grandPrice":    110.0,
+		"totalPrice":    20.0,
+		"shippingPrice": 50.0,
+		"grandPrice":    70.0,
 	}
 	b, _ := json.Marshal(reqBody)
 
@@ -150,11 +162,78 @@ func TestCreateOrder_Success(t *testing.T) {
 
 	var ord Order
 	json.NewDecoder(res.Body).Decode(&ord)
-	if ord.OrderID != 123 {
-		t.Errorf("expected orderID 123, got %d", ord.OrderID)
+	if ord.OrderID != 124 {
+		t.Errorf("expected orderID 124, got %d", ord.OrderID)
+	}
+	if len(ord.Items) != 1 || ord.Items[0].ProductID != 1 || ord.Items[0].UnitPrice != 10 || ord.Items[0].Quantity != 2 {
+		t.Errorf("expected one item snapshot on created order, got %+v", ord.Items)
+	}
+	if ord.Items[0].ProductName == nil || *ord.Items[0].ProductName != "p" {
+		t.Errorf("expected product name snapshot, got %+v", ord.Items[0])
+	}
+}
+
+func TestCreateOrder_ComputesTotalsWhenOmitted(t *testing.T) {
+	a := makeAppWithAuth()
+
+	req := httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(`{"cart":{"1":3,"2":1}}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", "42")
+
+	res, err := a.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("expected 200 got %d", res.StatusCode)
+	}
+	var ord Order
+	json.NewDecoder(res.Body).Decode(&ord)
+	if ord.Quantity != 4 || ord.TotalPrice != 40 || ord.ShippingPrice != ShippingFlatRate || ord.GrandPrice != 40+ShippingFlatRate {
+		t.Errorf("unexpected server totals: %+v", ord)
+	}
+}
+
+func TestCreateOrder_RejectsMismatchedTotals(t *testing.T) {
+	a := makeAppWithAuth()
+
+	req := httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(`{"cart":{"1":2},"quantity":2,"totalPrice":0,"shippingPrice":0,"grandPrice":0}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", "42")
+
+	res, err := a.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != fiber.StatusConflict {
+		t.Fatalf("expected 409 got %d", res.StatusCode)
 	}
-	if len(ord.CartProducts) != 0 {
-		t.Errorf("expected no cartProducts on created order, got %+v", ord.CartProducts)
+	var body struct {
+		Differences []PriceDifference `json:"differences"`
+	}
+	json.NewDecoder(res.Body).Decode(&body)
+	fields := map[string]bool{}
+	for _, d := range body.Differences {
+		fields[d.Field] = true
+	}
+	if len(body.Differences) != 3 || !fields["totalPrice"] || !fields["shippingPrice"] || !fields["grandPrice"] {
+		t.Errorf("unexpected differences: %+v", body.Differences)
+	}
+}
+
+func TestCreateOrder_InvalidCartEntry(t *testing.T) {
+	a := makeAppWithAuth()
+
+	req := httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(`{"cart":{"1":-2}}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", "42")
+
+	res, err := a.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 got %d", res.StatusCode)
 	}
 }
 
@@ -177,7 +256,410 @@ func TestGetOrders_Success(t *testing.T) {
 	if len(orders) != 1 || orders[0].OrderID != 123 {
 		t.Errorf("expected one order with ID 123, got %+v", orders)
 	}
-	if qp, ok := orders[0].CartProducts["1"]; !ok || qp.ProductID != 1 {
-		t.Errorf("cart products not populated: %+v", orders[0].CartProducts)
+	// the stored snapshot is returned as-is, not re-joined with live products
+	if len(orders[0].Items) != 1 || orders[0].Items[0].ProductName == nil || *orders[0].Items[0].ProductName != "old name" {
+		t.Errorf("item snapshots not returned: %+v", orders[0].Items)
+	}
+}
+
+func TestGetOrderHistory_OwnershipChecked(t *testing.T) {
+	a := makeAppWithAuth()
+
+	req := httptest.NewRequest("GET", "/api/v1/orders/123/history", nil)
+	req.Header.Set("X-User-ID", "42")
+	res, err := a.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("expected 200 got %d", res.StatusCode)
+	}
+	var body struct {
+		Status  string         `json:"status"`
+		History []StatusChange `json:"history"`
+	}
+	json.NewDecoder(res.Body).Decode(&body)
+	if body.Status != StatusPendingPayment || body.History == nil {
+		t.Errorf("unexpected history response: %+v", body)
+	}
+
+	req2 := httptest.NewRequest("GET", "/api/v1/orders/123/history", nil)
+	req2.Header.Set("X-User-ID", "7")
+	res2, err := a.Test(req2, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res2.StatusCode != fiber.StatusNotFound {
+		t.Fatalf("expected 404 for another user's order, got %d", res2.StatusCode)
+	}
+}
+
+func TestServiceUpdateStatus_EnforcesLifecycle(t *testing.T) {
+	repo := newTestRepo()
+	svc := NewService(repo, &dummyProductService{})
+
+	_, err := svc.UpdateStatus(123, StatusShipped, 1, "")
+	var te *TransitionError
+	if !errors.As(err, &te) || te.From != StatusPendingPayment || te.To != StatusShipped {
+		t.Fatalf("expected transition error, got %v", err)
+	}
+	if _, err := svc.UpdateStatus(123, StatusPaid, 1, "payment received"); err != nil {
+		t.Fatalf("pending_payment -> paid should be allowed: %v", err)
+	}
+	history, _ := repo.ListStatusHistory(123)
+	if len(history) != 1 || history[0].ToStatus != StatusPaid || *history[0].FromStatus != StatusPendingPayment {
+		t.Fatalf("status change not recorded: %+v", history)
+	}
+	if _, err := svc.UpdateStatus(123, "lost", 1, ""); err != ErrUnknownStatus {
+		t.Fatalf("expected ErrUnknownStatus, got %v", err)
+	}
+}
+
+func TestUpdateStatus_StaffOnly(t *testing.T) {
+	repo := newTestRepo()
+	a := makeAppWithRepo(repo)
+
+	update := func(userID, role, body string) int {
+		req := httptest.NewRequest("PUT", "/api/v1/orders/123/status", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User-ID", userID)
+		req.Header.Set("X-User-Role", role)
+		res, err := a.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode
+	}
+
+	// the owner is a customer and may not mark their own order as paid
+	if code := update("42", "customer", `{"status":"paid"}`); code != fiber.StatusForbidden {
+		t.Fatalf("expected 403 for a customer, got %d", code)
+	}
+	if code := update("5", "staff", `{"status":"shipped"}`); code != fiber.StatusConflict {
+		t.Fatalf("expected 409 for a transition the lifecycle forbids, got %d", code)
+	}
+	if code := update("5", "staff", `{"status":"paid","note":"bank transfer"}`); code != fiber.StatusOK {
+		t.Fatalf("expected 200 for staff, got %d", code)
+	}
+	history, _ := repo.ListStatusHistory(123)
+	if len(history) != 1 || history[0].ChangedBy != 5 || history[0].Note != "bank transfer" {
+		t.Fatalf("expected the staff member to be recorded, got %+v", history)
+	}
+}
+
+func TestCheckout(t *testing.T) {
+	repo := newTestRepo()
+	repo.Carts[42] = map[string]int{"1": 2, "2": 1}
+	a := makeAppWithRepo(repo)
+
+	req := httptest.NewRequest("POST", "/api/v1/checkout", nil)
+	req.Header.Set("X-User-ID", "42")
+	res, err := a.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != fiber.StatusCreated {
+		t.Fatalf("expected 201 got %d", res.StatusCode)
+	}
+	var ord Order
+	json.NewDecoder(res.Body).Decode(&ord)
+	if ord.OrderID != 124 || ord.Quantity != 3 || ord.GrandPrice != 30+ShippingFlatRate || len(ord.Items) != 2 {
+		t.Errorf("unexpected checkout order: %+v", ord)
+	}
+	if ord.Status != StatusPendingPayment {
+		t.Errorf("expected status %s, got %q", StatusPendingPayment, ord.Status)
+	}
+
+	req2 := httptest.NewRequest("POST", "/api/v1/checkout", nil)
+	req2.Header.Set("X-User-ID", "7")
+	res2, err := a.Test(req2, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res2.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 for empty cart, got %d", res2.StatusCode)
+	}
+	if len(repo.Carts[42]) != 0 {
+		t.Errorf("cart should be cleared after checkout, got %+v", repo.Carts[42])
+	}
+}
+
+func TestCheckout_PolicyBlocksOrders(t *testing.T) {
+	repo := newTestRepo()
+	repo.Carts[42] = map[string]int{"1": 2}
+	unverified := errors.New("email address is not verified")
+	h := NewHandler(NewService(repo, &dummyProductService{}), &dummyUserService{}).
+		WithCheckoutPolicy(func(userID int) error {
+			if userID == 42 {
+				return unverified
+			}
+			return nil
+		})
+	a := makeAppWithHandler(h)
+
+	for _, path := range []string{"/api/v1/checkout", "/api/v1/orders"} {
+		req := httptest.NewRequest("POST", path, strings.NewReader(`{"cart":{"1":1}}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User-ID", "42")
+		res, err := a.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != fiber.StatusForbidden {
+			t.Fatalf("%s: expected 403 when the policy fails, got %d", path, res.StatusCode)
+		}
+	}
+	if len(repo.Carts[42]) != 1 {
+		t.Errorf("a blocked checkout must keep the cart, got %+v", repo.Carts[42])
+	}
+
+	req := httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(`{"cart":{"1":1}}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", "7")
+	res, err := a.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200 when the policy passes, got %d", res.StatusCode)
+	}
+}
+
+func TestGetOrder_OwnershipChecked(t *testing.T) {
+	a := makeAppWithAuth()
+
+	req := httptest.NewRequest("GET", "/api/v1/orders/123", nil)
+	req.Header.Set("X-User-ID", "42")
+	res, err := a.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("expected 200 got %d", res.StatusCode)
+	}
+	var ord Order
+	json.NewDecoder(res.Body).Decode(&ord)
+	if ord.OrderID != 123 || len(ord.Items) != 1 {
+		t.Errorf("unexpected order: %+v", ord)
+	}
+
+	for _, path := range []string{"/api/v1/orders/123", "/api/v1/orders/999"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-User-ID", "7")
+		res, err := a.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != fiber.StatusNotFound {
+			t.Fatalf("expected 404 for %s, got %d", path, res.StatusCode)
+		}
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	repo := newTestRepo()
+	a := makeAppWithRepo(repo)
+
+	// another user cannot cancel the order
+	req := httptest.NewRequest("POST", "/api/v1/orders/123/cancel", strings.NewReader(`{"reason":"changed my mind"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", "7")
+	res, _ := a.Test(req, -1)
+	if res.StatusCode != fiber.StatusNotFound {
+		t.Fatalf("expected 404 for foreign order, got %d", res.StatusCode)
+	}
+
+	req = httptest.NewRequest("POST", "/api/v1/orders/123/cancel", strings.NewReader(`{"reason":"changed my mind"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", "42")
+	res, _ = a.Test(req, -1)
+	if res.StatusCode != 200 {
+		t.Fatalf("expected 200 got %d", res.StatusCode)
+	}
+	var ord Order
+	json.NewDecoder(res.Body).Decode(&ord)
+	if ord.Status != StatusCancelled {
+		t.Errorf("expected cancelled order, got %q", ord.Status)
+	}
+	history, _ := repo.ListStatusHistory(123)
+	if len(history) == 0 || history[len(history)-1].Note != "changed my mind" {
+		t.Errorf("cancel reason not recorded: %+v", history)
+	}
+
+	// cancelling again is rejected
+	req = httptest.NewRequest("POST", "/api/v1/orders/123/cancel", nil)
+	req.Header.Set("X-User-ID", "42")
+	res, _ = a.Test(req, -1)
+	if res.StatusCode != fiber.StatusConflict {
+		t.Fatalf("expected 409 for already cancelled order, got %d", res.StatusCode)
+	}
+}
+
+func TestCancelOrder_NotAfterShipment(t *testing.T) {
+	repo := newTestRepo()
+	svc := NewService(repo, &dummyProductService{})
+	for _, st := range []string{StatusPaid, StatusPacking, StatusShipped} {
+		if _, err := svc.UpdateStatus(123, st, 1, ""); err != nil {
+			t.Fatalf("advance to %s: %v", st, err)
+		}
+	}
+	if _, err := svc.Cancel(123, 42, ""); err != ErrNotCancellable {
+		t.Fatalf("expected ErrNotCancellable, got %v", err)
+	}
+}
+
+func TestCheckout_ReservesAndReleasesStock(t *testing.T) {
+	repo := newTestRepo()
+	repo.Stock = map[product.StockKey]int{{ProductID: 1}: 3, {ProductID: 2}: 0}
+	a := makeAppWithRepo(repo)
+
+	// product 2 is sold out, so the whole checkout is rejected
+	repo.Carts[42] = map[string]int{"1": 2, "2": 1}
+	req := httptest.NewRequest("POST", "/api/v1/checkout", nil)
+	req.Header.Set("X-User-ID", "42")
+	res, _ := a.Test(req, -1)
+	if res.StatusCode != fiber.StatusConflict {
+		t.Fatalf("expected 409 for sold out product, got %d", res.StatusCode)
+	}
+	var body struct {
+		Shortages []product.StockShortage `json:"shortages"`
+	}
+	json.NewDecoder(res.Body).Decode(&body)
+	if len(body.Shortages) != 1 || body.Shortages[0].ProductID != 2 || body.Shortages[0].Available != 0 {
+		t.Fatalf("unexpected shortages: %+v", body.Shortages)
+	}
+	if repo.Stock[product.StockKey{ProductID: 1}] != 3 || len(repo.Carts[42]) != 2 {
+		t.Fatalf("failed checkout must not touch stock or cart: stock=%v cart=%v", repo.Stock, repo.Carts[42])
+	}
+
+	repo.Carts[42] = map[string]int{"1": 2}
+	req = httptest.NewRequest("POST", "/api/v1/checkout", nil)
+	req.Header.Set("X-User-ID", "42")
+	res, _ = a.Test(req, -1)
+	if res.StatusCode != fiber.StatusCreated {
+		t.Fatalf("expected 201 got %d", res.StatusCode)
+	}
+	var ord Order
+	json.NewDecoder(res.Body).Decode(&ord)
+	if left := repo.Stock[product.StockKey{ProductID: 1}]; left != 1 {
+		t.Fatalf("expected 1 unit left after reservation, got %d", left)
+	}
+
+	req = httptest.NewRequest("POST", fmt.Sprintf("/api/v1/orders/%d/cancel", ord.OrderID), nil)
+	req.Header.Set("X-User-ID", "42")
+	res, _ = a.Test(req, -1)
+	if res.StatusCode != 200 {
+		t.Fatalf("expected 200 for cancel, got %d", res.StatusCode)
+	}
+	if left := repo.Stock[product.StockKey{ProductID: 1}]; left != 3 {
+		t.Fatalf("expected cancel to restore stock to 3, got %d", left)
+	}
+}
+
+func TestExpireReservations(t *testing.T) {
+	repo := NewInMemoryRepository(nil)
+	repo.Stock = map[product.StockKey]int{{ProductID: 1}: 5}
+	svc := NewService(repo, &dummyProductService{})
+
+	unpaid, err := svc.Create(Order{Cart: map[string]int{"1": 2}}, 42, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paid, err := svc.Create(Order{Cart: map[string]int{"1": 1}}, 42, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.UpdateStatus(paid.OrderID, StatusPaid, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := svc.ExpireReservations(time.Now()); n != 0 {
+		t.Fatalf("nothing should expire yet, cancelled %d", n)
+	}
+	n, err := svc.ExpireReservations(time.Now().Add(ReservationTTL + time.Minute))
+	if err != nil || n != 1 {
+		t.Fatalf("expected one expired order, got %d (%v)", n, err)
+	}
+	if ord, _ := repo.GetByID(unpaid.OrderID); ord.Status != StatusCancelled {
+		t.Errorf("expected unpaid order to be cancelled, got %q", ord.Status)
+	}
+	if ord, _ := repo.GetByID(paid.OrderID); ord.Status != StatusPaid {
+		t.Errorf("paid order must keep its status, got %q", ord.Status)
+	}
+	if left := repo.Stock[product.StockKey{ProductID: 1}]; left != 4 {
+		t.Errorf("expected expired units back in stock (4), got %d", left)
+	}
+}
+
+func TestUpdateStatus_RefundRestocksOnlyBeforeShipment(t *testing.T) {
+	repo := NewInMemoryRepository(nil)
+	repo.Stock = map[product.StockKey]int{{ProductID: 1}: 5}
+	svc := NewService(repo, &dummyProductService{})
+
+	packed, err := svc.Create(Order{Cart: map[string]int{"1": 2}}, 42, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shipped, err := svc.Create(Order{Cart: map[string]int{"1": 1}}, 42, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, to := range []string{StatusPaid, StatusPacking, StatusRefunded} {
+		if _, err := svc.UpdateStatus(packed.OrderID, to, 1, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if left := repo.Stock[product.StockKey{ProductID: 1}]; left != 4 {
+		t.Fatalf("expected a refund before shipment to restock (4), got %d", left)
+	}
+
+	// shipped goods come back, if at all, through a manual restock
+	for _, to := range []string{StatusPaid, StatusPacking, StatusShipped, StatusRefunded} {
+		if _, err := svc.UpdateStatus(shipped.OrderID, to, 1, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if left := repo.Stock[product.StockKey{ProductID: 1}]; left != 4 {
+		t.Fatalf("expected a refund after shipment to leave stock alone (4), got %d", left)
+	}
+}
+
+func TestCreateOrder_WithVariant(t *testing.T) {
+	repo := NewInMemoryRepository(nil)
+	repo.Stock = map[product.StockKey]int{{ProductID: 1, VariantID: 7}: 1}
+	svc := NewService(repo, &dummyProductService{})
+
+	// product 2 has no stock in this repo, so the whole order is rejected
+	if _, err := svc.Create(Order{Cart: map[string]int{"1:7": 1, "2": 1}}, 42, nil); err == nil {
+		t.Fatal("expected insufficient stock error")
+	} else if _, ok := err.(*product.InsufficientStockError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	ord, err := svc.Create(Order{Cart: map[string]int{"1:7": 1}}, 42, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ord.Items) != 1 || ord.Items[0].VariantID != 7 || ord.Items[0].UnitPrice != 15 {
+		t.Fatalf("expected variant price snapshot, got %+v", ord.Items)
+	}
+	if ord.Items[0].SKU == nil || *ord.Items[0].SKU != "SKU-7" || ord.Items[0].Options["size"] != "M" {
+		t.Errorf("expected sku and options in snapshot, got %+v", ord.Items[0])
+	}
+	if ord.TotalPrice != 15 || ord.ShippingPrice != ShippingFlatRate {
+		t.Errorf("unexpected totals %+v", ord)
+	}
+	if left := repo.Stock[product.StockKey{ProductID: 1, VariantID: 7}]; left != 0 {
+		t.Errorf("expected variant stock to be reserved, %d left", left)
+	}
+
+	// a variant of another product is rejected
+	if _, err := svc.Create(Order{Cart: map[string]int{"2:7": 1}}, 42, nil); err == nil {
+		t.Fatal("expected error for variant of another product")
+	} else if unknown, ok := err.(*UnknownProductError); !ok || len(unknown.VariantIDs) != 1 {
+		t.Fatalf("expected UnknownProductError for variant, got %v", err)
+	}
+	if _, err := svc.Create(Order{Cart: map[string]int{"1:x": 1}}, 42, nil); err != ErrInvalidCartItem {
+		t.Fatalf("expected ErrInvalidCartItem, got %v", err)
 	}
 }
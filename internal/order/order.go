@@ -1,18 +1,34 @@
 package order
 
-import "github.com/wichananm65/pet-shop-backend/internal/product"
-
 // Order represents a purchase made by a user.
 type Order struct {
-	OrderID       int                          `json:"orderID"`
-	UserID        int                          `json:"userID,omitempty"`
-	Cart          map[string]int               `json:"cart"`
-	CartProducts  map[string]product.ProductV1 `json:"cartProducts,omitempty"`
-	Quantity      int                          `json:"quantity"`
-	TotalPrice    float64                      `json:"totalPrice"`
-	ShippingPrice float64                      `json:"shippingPrice"`
-	GrandPrice    float64                      `json:"grandPrice"`
-	Status        string                       `json:"status"`
-	CreatedAt     string                       `json:"createdAt"`
-	UpdatedAt     string                       `json:"updatedAt"`
+	OrderID       int            `json:"orderID"`
+	UserID        int            `json:"userID,omitempty"`
+	Cart          map[string]int `json:"cart"`
+	Items         []OrderItem    `json:"items"`
+	Quantity      int            `json:"quantity"`
+	TotalPrice    float64        `json:"totalPrice"`
+	ShippingPrice float64        `json:"shippingPrice"`
+	GrandPrice    float64        `json:"grandPrice"`
+	Status        string         `json:"status"`
+	CreatedAt     string         `json:"createdAt"`
+	UpdatedAt     string         `json:"updatedAt"`
+}
+
+// OrderItem is an immutable snapshot of one purchased product, captured at
+// checkout so later price changes or product deletion do not alter history.
+// VariantID, SKU and Options are set when a specific variant was bought.
+type OrderItem struct {
+	OrderItemID   int               `json:"orderItemID"`
+	OrderID       int               `json:"orderID"`
+	ProductID     int               `json:"productID"`
+	VariantID     int               `json:"variantID,omitempty"`
+	SKU           *string           `json:"sku,omitempty"`
+	Options       map[string]string `json:"options,omitempty"`
+	ProductName   *string           `json:"productName,omitempty"`
+	ProductNameTH *string           `json:"productNameTH,omitempty"`
+	ProductImg    *string           `json:"productImg,omitempty"`
+	UnitPrice     float64           `json:"unitPrice"`
+	Quantity      int               `json:"quantity"`
+	LineTotal     float64           `json:"lineTotal"`
 }
@@ -0,0 +1,249 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wichananm65/pet-shop-backend/internal/product"
+)
+
+// Shipping rule: orders below FreeShippingThreshold pay a flat
+// ShippingFlatRate, orders at or above it ship for free (prices in baht).
+const (
+	ShippingFlatRate      = 50
+	FreeShippingThreshold = 1000
+)
+
+var (
+	ErrInvalidUser     = errors.New("invalid user")
+	ErrEmptyCart       = errors.New("empty cart")
+	ErrInvalidCartItem = errors.New(`cart entries must be a positive quantity keyed by "productID" or "productID:variantID"`)
+)
+
+// Totals is the price breakdown of an order as computed by the server.
+type Totals struct {
+	Quantity      int     `json:"quantity"`
+	TotalPrice    float64 `json:"totalPrice"`
+	ShippingPrice float64 `json:"shippingPrice"`
+	GrandPrice    float64 `json:"grandPrice"`
+}
+
+// ClaimedTotals carries the totals a client sent along with an order. Nil
+// fields were not sent and are simply filled in from server pricing.
+type ClaimedTotals struct {
+	Quantity      *int
+	TotalPrice    *float64
+	ShippingPrice *float64
+	GrandPrice    *float64
+}
+
+// PriceDifference describes one field where the client's value disagrees
+// with the server-side calculation.
+type PriceDifference struct {
+	Field  string  `json:"field"`
+	Client float64 `json:"client"`
+	Server float64 `json:"server"`
+}
+
+// PriceMismatchError is returned when client supplied totals do not match
+// the server-side pricing of the cart.
+type PriceMismatchError struct {
+	Differences []PriceDifference `json:"differences"`
+}
+
+func (e *PriceMismatchError) Error() string {
+	fields := make([]string, 0, len(e.Differences))
+	for _, d := range e.Differences {
+		fields = append(fields, d.Field)
+	}
+	return "order totals do not match server pricing: " + strings.Join(fields, ", ")
+}
+
+// UnknownProductError is returned when the cart references products that do
+// not exist or have no price, or variants that do not belong to the product.
+type UnknownProductError struct {
+	ProductIDs []int `json:"productIds"`
+	VariantIDs []int `json:"variantIds,omitempty"`
+}
+
+func (e *UnknownProductError) Error() string {
+	ids := make([]string, 0, len(e.ProductIDs)+len(e.VariantIDs))
+	for _, id := range e.ProductIDs {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	for _, id := range e.VariantIDs {
+		ids = append(ids, "variant "+strconv.Itoa(id))
+	}
+	return fmt.Sprintf("unknown products in cart: %s", strings.Join(ids, ", "))
+}
+
+// ShippingFor applies the shipping rule to an item subtotal.
+func ShippingFor(subtotal float64) float64 {
+	if subtotal <= 0 || subtotal >= FreeShippingThreshold {
+		return 0
+	}
+	return ShippingFlatRate
+}
+
+// CartKey builds the order cart key for a product, or for one of its
+// variants when variantID is non-zero ("12" or "12:3").
+func CartKey(productID, variantID int) string {
+	if variantID == 0 {
+		return strconv.Itoa(productID)
+	}
+	return strconv.Itoa(productID) + ":" + strconv.Itoa(variantID)
+}
+
+// parseCart converts the string-keyed cart map into quantities per product
+// or product variant.
+func parseCart(cart map[string]int) (map[product.StockKey]int, error) {
+	if len(cart) == 0 {
+		return nil, ErrEmptyCart
+	}
+	out := make(map[product.StockKey]int, len(cart))
+	for key, qty := range cart {
+		var k product.StockKey
+		productPart, variantPart, hasVariant := strings.Cut(key, ":")
+		id, err := strconv.Atoi(productPart)
+		if err != nil || id <= 0 || qty <= 0 {
+			return nil, ErrInvalidCartItem
+		}
+		k.ProductID = id
+		if hasVariant {
+			vid, err := strconv.Atoi(variantPart)
+			if err != nil || vid <= 0 {
+				return nil, ErrInvalidCartItem
+			}
+			k.VariantID = vid
+		}
+		out[k] += qty
+	}
+	return out, nil
+}
+
+// priceCart looks up current product data and computes the totals for the
+// given cart along with a snapshot line item per product. Quantity is
+// derived from the cart rather than trusted.
+func priceCart(ps product.ServiceInterface, cart map[string]int) (Totals, []OrderItem, error) {
+	quantities, err := parseCart(cart)
+	if err != nil {
+		return Totals{}, nil, err
+	}
+	keys := make([]product.StockKey, 0, len(quantities))
+	productIDs := make([]int, 0, len(quantities))
+	variantIDs := make([]int, 0)
+	seen := map[int]bool{}
+	for k := range quantities {
+		keys = append(keys, k)
+		if !seen[k.ProductID] {
+			seen[k.ProductID] = true
+			productIDs = append(productIDs, k.ProductID)
+		}
+		if k.VariantID != 0 {
+			variantIDs = append(variantIDs, k.VariantID)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ProductID != keys[j].ProductID {
+			return keys[i].ProductID < keys[j].ProductID
+		}
+		return keys[i].VariantID < keys[j].VariantID
+	})
+	sort.Ints(productIDs)
+	sort.Ints(variantIDs)
+
+	products, err := ps.ListV1ByIDs(productIDs)
+	if err != nil {
+		return Totals{}, nil, err
+	}
+	byID := make(map[int]product.ProductV1, len(products))
+	for _, p := range products {
+		byID[p.ProductID] = p
+	}
+	variants := map[int]product.Variant{}
+	if len(variantIDs) > 0 {
+		list, err := ps.ListVariantsByIDs(variantIDs)
+		if err != nil {
+			return Totals{}, nil, err
+		}
+		for _, v := range list {
+			variants[v.VariantID] = v
+		}
+	}
+
+	var t Totals
+	items := make([]OrderItem, 0, len(keys))
+	missing := &UnknownProductError{ProductIDs: []int{}}
+	for _, k := range keys {
+		p, ok := byID[k.ProductID]
+		if !ok {
+			missing.ProductIDs = append(missing.ProductIDs, k.ProductID)
+			continue
+		}
+		item := OrderItem{
+			ProductID:     k.ProductID,
+			ProductName:   p.ProductName,
+			ProductNameTH: p.ProductNameTH,
+			ProductImg:    p.ProductImg,
+			Quantity:      quantities[k],
+		}
+		if k.VariantID != 0 {
+			v, ok := variants[k.VariantID]
+			if !ok || v.ProductID != k.ProductID {
+				missing.VariantIDs = append(missing.VariantIDs, k.VariantID)
+				continue
+			}
+			sku := v.SKU
+			item.VariantID = v.VariantID
+			item.SKU = &sku
+			item.Options = v.Options
+			item.UnitPrice = float64(v.Price)
+			if v.Img != nil {
+				item.ProductImg = v.Img
+			}
+		} else if p.ProductPrice != nil {
+			item.UnitPrice = float64(*p.ProductPrice)
+		} else {
+			missing.ProductIDs = append(missing.ProductIDs, k.ProductID)
+			continue
+		}
+		item.LineTotal = item.UnitPrice * float64(item.Quantity)
+		items = append(items, item)
+		t.Quantity += item.Quantity
+		t.TotalPrice += item.LineTotal
+	}
+	if len(missing.ProductIDs) > 0 || len(missing.VariantIDs) > 0 {
+		return Totals{}, nil, missing
+	}
+	t.ShippingPrice = ShippingFor(t.TotalPrice)
+	t.GrandPrice = t.TotalPrice + t.ShippingPrice
+	return t, items, nil
+}
+
+// compareTotals returns a PriceMismatchError listing every claimed field that
+// differs from the computed totals, or nil when they agree.
+func compareTotals(claimed *ClaimedTotals, computed Totals) error {
+	if claimed == nil {
+		return nil
+	}
+	diffs := make([]PriceDifference, 0)
+	if claimed.Quantity != nil && *claimed.Quantity != computed.Quantity {
+		diffs = append(diffs, PriceDifference{Field: "quantity", Client: float64(*claimed.Quantity), Server: float64(computed.Quantity)})
+	}
+	check := func(field string, client *float64, server float64) {
+		if client != nil && math.Abs(*client-server) > 0.005 {
+			diffs = append(diffs, PriceDifference{Field: field, Client: *client, Server: server})
+		}
+	}
+	check("totalPrice", claimed.TotalPrice, computed.TotalPrice)
+	check("shippingPrice", claimed.ShippingPrice, computed.ShippingPrice)
+	check("grandPrice", claimed.GrandPrice, computed.GrandPrice)
+	if len(diffs) > 0 {
+		return &PriceMismatchError{Differences: diffs}
+	}
+	return nil
+}
@@ -1,8 +1,248 @@
 package order
 
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wichananm65/pet-shop-backend/internal/product"
+)
+
 // Repository defines persistence operations for orders.
 type Repository interface {
+	// Create stores the order, its items and the initial status history
+	// entry atomically.
 	Create(ord Order, userID int) (Order, error)
+	// Checkout atomically reads the user's cart, builds the order with the
+	// price callback, stores it and clears the cart. It returns ErrEmptyCart
+	// when there is nothing to check out.
+	Checkout(userID int, price func(cart map[string]int) (Order, error)) (Order, error)
+	GetByID(id int) (Order, error)
 	ListByIDs(ids []int) ([]Order, error)
 	ListByUserID(userID int) ([]Order, error)
+	// UpdateStatus moves an order from one status to another and records the
+	// change. It returns ErrStatusConflict when the stored status is no
+	// longer `from`.
+	UpdateStatus(orderID int, from, to string, change StatusChange) (Order, error)
+	ListStatusHistory(orderID int) ([]StatusChange, error)
+	// ExpiredReservations lists orders awaiting payment whose stock
+	// reservation expired before now.
+	ExpiredReservations(now time.Time) ([]int, error)
+}
+
+// InMemoryRepository is used for tests and local scenarios. Carts holds the
+// server-side cart of each user for Checkout. Stock, when non-nil, holds the
+// available units per product and is reserved and released like the
+// products and product_variants tables; a nil Stock disables stock tracking.
+type InMemoryRepository struct {
+	mu           sync.RWMutex
+	orders       []Order
+	history      map[int][]StatusChange
+	reservations map[int]*memReservation
+	nextID       int
+	Carts        map[int]map[string]int
+	Stock        map[product.StockKey]int
+}
+
+type memReservation struct {
+	quantities map[product.StockKey]int
+	status     string
+	expiresAt  time.Time
+}
+
+func NewInMemoryRepository(seed []Order) *InMemoryRepository {
+	r := &InMemoryRepository{
+		orders:       make([]Order, 0, len(seed)),
+		history:      map[int][]StatusChange{},
+		reservations: map[int]*memReservation{},
+		nextID:       1,
+		Carts:        map[int]map[string]int{},
+	}
+	for _, o := range seed {
+		r.orders = append(r.orders, o)
+		if o.OrderID >= r.nextID {
+			r.nextID = o.OrderID + 1
+		}
+	}
+	return r
+}
+
+func (r *InMemoryRepository) Create(ord Order, userID int) (Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.insertLocked(ord, userID)
+}
+
+func (r *InMemoryRepository) insertLocked(ord Order, userID int) (Order, error) {
+	quantities := itemQuantities(ord.Items)
+	if r.Stock != nil {
+		if err := r.reserveLocked(quantities); err != nil {
+			return Order{}, err
+		}
+	}
+	ord.OrderID = r.nextID
+	r.nextID++
+	ord.UserID = userID
+	items := make([]OrderItem, len(ord.Items))
+	for i, it := range ord.Items {
+		it.OrderItemID = i + 1
+		it.OrderID = ord.OrderID
+		items[i] = it
+	}
+	ord.Items = items
+	r.orders = append(r.orders, ord)
+	r.history[ord.OrderID] = append(r.history[ord.OrderID], StatusChange{
+		HistoryID: len(r.history[ord.OrderID]) + 1,
+		OrderID:   ord.OrderID,
+		ToStatus:  ord.Status,
+		ChangedBy: userID,
+		Note:      "order created",
+		CreatedAt: ord.CreatedAt,
+	})
+	if r.Stock != nil {
+		r.reservations[ord.OrderID] = &memReservation{
+			quantities: quantities,
+			status:     reservationReserved,
+			expiresAt:  time.Now().UTC().Add(ReservationTTL),
+		}
+	}
+	return ord, nil
+}
+
+func (r *InMemoryRepository) reserveLocked(quantities map[product.StockKey]int) error {
+	keys := make([]product.StockKey, 0, len(quantities))
+	for k := range quantities {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ProductID != keys[j].ProductID {
+			return keys[i].ProductID < keys[j].ProductID
+		}
+		return keys[i].VariantID < keys[j].VariantID
+	})
+	shortages := make([]product.StockShortage, 0)
+	for _, k := range keys {
+		if r.Stock[k] < quantities[k] {
+			shortages = append(shortages, product.StockShortage{ProductID: k.ProductID, VariantID: k.VariantID, Requested: quantities[k], Available: r.Stock[k]})
+		}
+	}
+	if len(shortages) > 0 {
+		return &product.InsufficientStockError{Shortages: shortages}
+	}
+	for _, k := range keys {
+		r.Stock[k] -= quantities[k]
+	}
+	return nil
+}
+
+func (r *InMemoryRepository) Checkout(userID int, price func(cart map[string]int) (Order, error)) (Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cart := r.Carts[userID]
+	if len(cart) == 0 {
+		return Order{}, ErrEmptyCart
+	}
+	ord, err := price(cart)
+	if err != nil {
+		return Order{}, err
+	}
+	created, err := r.insertLocked(ord, userID)
+	if err != nil {
+		return Order{}, err
+	}
+	delete(r.Carts, userID)
+	return created, nil
+}
+
+func (r *InMemoryRepository) GetByID(id int) (Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, o := range r.orders {
+		if o.OrderID == id {
+			return o, nil
+		}
+	}
+	return Order{}, ErrNotFound
+}
+
+func (r *InMemoryRepository) ListByIDs(ids []int) ([]Order, error) {
+	out := make([]Order, 0, len(ids))
+	for _, id := range ids {
+		if o, err := r.GetByID(id); err == nil {
+			out = append(out, o)
+		}
+	}
+	return out, nil
+}
+
+func (r *InMemoryRepository) ListByUserID(userID int) ([]Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Order, 0)
+	for i := len(r.orders) - 1; i >= 0; i-- {
+		if r.orders[i].UserID == userID {
+			out = append(out, r.orders[i])
+		}
+	}
+	return out, nil
+}
+
+func (r *InMemoryRepository) UpdateStatus(orderID int, from, to string, change StatusChange) (Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.orders {
+		if r.orders[i].OrderID != orderID {
+			continue
+		}
+		if currentStatus(r.orders[i]) != from {
+			return Order{}, ErrStatusConflict
+		}
+		r.orders[i].Status = to
+		r.orders[i].UpdatedAt = change.CreatedAt
+		change.HistoryID = len(r.history[orderID]) + 1
+		change.OrderID = orderID
+		change.FromStatus = &from
+		change.ToStatus = to
+		r.history[orderID] = append(r.history[orderID], change)
+		r.settleLocked(orderID, from, to)
+		return r.orders[i], nil
+	}
+	return Order{}, ErrNotFound
+}
+
+func (r *InMemoryRepository) settleLocked(orderID int, from, to string) {
+	res, ok := r.reservations[orderID]
+	if !ok {
+		return
+	}
+	switch {
+	case to == StatusPaid && res.status == reservationReserved:
+		res.status = reservationCommitted
+	case releasesStock(from, to) && res.status != reservationReleased:
+		for k, qty := range res.quantities {
+			r.Stock[k] += qty
+		}
+		res.status = reservationReleased
+	}
+}
+
+func (r *InMemoryRepository) ExpiredReservations(now time.Time) ([]int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]int, 0)
+	for _, o := range r.orders {
+		res, ok := r.reservations[o.OrderID]
+		if ok && res.status == reservationReserved && res.expiresAt.Before(now) && currentStatus(o) == StatusPendingPayment {
+			ids = append(ids, o.OrderID)
+		}
+	}
+	return ids, nil
+}
+
+func (r *InMemoryRepository) ListStatusHistory(orderID int) ([]StatusChange, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]StatusChange, len(r.history[orderID]))
+	copy(out, r.history[orderID])
+	return out, nil
 }
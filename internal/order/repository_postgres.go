@@ -3,19 +3,133 @@ package order
 import (
 	"database/sql"
 	"encoding/json"
+	"time"
 
 	"github.com/lib/pq"
+	"github.com/wichananm65/pet-shop-backend/internal/product"
 )
 
 type PostgresRepository struct {
 	db *sql.DB
 }
 
+const (
+	insertOrderItemQuery = `
+		INSERT INTO order_items ("orderID", "productID", "variantID", sku, options, "productName", "productNameTH", "productImg", "unitPrice", quantity, "lineTotal", "createdAt")
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+		RETURNING "orderItemID"
+	`
+	insertStatusHistoryQuery = `
+		INSERT INTO order_status_history ("orderID", "fromStatus", "toStatus", "changedBy", note, "createdAt")
+		VALUES ($1,$2,$3,$4,$5,$6)
+		RETURNING "historyID"
+	`
+	listStatusHistoryQuery = `
+		SELECT "historyID", "orderID", "fromStatus", "toStatus", "changedBy", note, "createdAt"
+		FROM order_status_history
+		WHERE "orderID" = $1
+		ORDER BY "historyID"
+	`
+	listOrderItemsQuery = `
+		SELECT "orderItemID", "orderID", "productID", "variantID", sku, options, "productName", "productNameTH", "productImg", "unitPrice", quantity, "lineTotal"
+		FROM order_items
+		WHERE "orderID" = ANY($1::int[])
+		ORDER BY "orderID", "orderItemID"
+	`
+	insertReservationQuery = `
+		INSERT INTO stock_reservations ("orderID", "productID", "variantID", quantity, status, "expiresAt")
+		VALUES ($1,$2,$3,$4,$5,$6)
+	`
+	listExpiredReservationsQuery = `
+		SELECT DISTINCT r."orderID"
+		FROM stock_reservations r
+		JOIN orders o ON o."orderID" = r."orderID"
+		WHERE r.status = $1 AND r."expiresAt" < $2 AND COALESCE(NULLIF(o.status, ''), $3) = $3
+		ORDER BY r."orderID"
+	`
+)
+
 func NewPostgresRepository(db *sql.DB) *PostgresRepository {
 	return &PostgresRepository{db: db}
 }
 
+// Create inserts the order, its item snapshots and the initial status
+// history entry in a single transaction.
 func (r *PostgresRepository) Create(ord Order, userID int) (Order, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return Order{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	created, err := insertOrder(tx, ord, userID)
+	if err != nil {
+		return Order{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Order{}, err
+	}
+	return created, nil
+}
+
+// Checkout converts the user's cart rows into an order inside one
+// transaction: the cart rows are locked, priced via the supplied callback,
+// written out as an order and then deleted. A concurrent checkout of the
+// same cart blocks on the row locks and then finds the cart empty, so the
+// cart can never produce two orders.
+func (r *PostgresRepository) Checkout(userID int, price func(cart map[string]int) (Order, error)) (Order, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return Order{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	rows, err := tx.Query(`SELECT productid, variantid, quantity FROM cart WHERE userid = $1 ORDER BY productid, variantid FOR UPDATE`, userID)
+	if err != nil {
+		return Order{}, err
+	}
+	cart := map[string]int{}
+	for rows.Next() {
+		var productID, variantID, qty int
+		if err := rows.Scan(&productID, &variantID, &qty); err != nil {
+			rows.Close()
+			return Order{}, err
+		}
+		cart[CartKey(productID, variantID)] += qty
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Order{}, err
+	}
+	if len(cart) == 0 {
+		return Order{}, ErrEmptyCart
+	}
+
+	ord, err := price(cart)
+	if err != nil {
+		return Order{}, err
+	}
+	created, err := insertOrder(tx, ord, userID)
+	if err != nil {
+		return Order{}, err
+	}
+	if _, err := tx.Exec(`DELETE FROM cart WHERE userid = $1`, userID); err != nil {
+		return Order{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Order{}, err
+	}
+	return created, nil
+}
+
+// insertOrder writes the order row, its initial status history entry and
+// item snapshots using the given transaction, and reserves stock for the
+// items. A shortage aborts the whole order with InsufficientStockError.
+func insertOrder(tx *sql.Tx, ord Order, userID int) (Order, error) {
 	cartJSON, err := json.Marshal(ord.Cart)
 	if err != nil {
 		return Order{}, err
@@ -25,7 +139,7 @@ func (r *PostgresRepository) Create(ord Order, userID int) (Order, error) {
 		cartRaw []byte
 		status  sql.NullString
 	)
-	err = r.db.QueryRow(
+	err = tx.QueryRow(
 		`INSERT INTO orders ("userID", cart, quantity, "totalPrice", "shippingPrice", "grandPrice", status, "createdAt", "updatedAt")
 		 VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
 		 RETURNING "orderID", cart, quantity, "totalPrice", "shippingPrice", "grandPrice", status, "createdAt", "updatedAt"`,
@@ -45,10 +159,206 @@ func (r *PostgresRepository) Create(ord Order, userID int) (Order, error) {
 	if len(cartRaw) > 0 {
 		_ = json.Unmarshal(cartRaw, &ord.Cart)
 	}
+
+	if _, err := insertStatusChange(tx, StatusChange{
+		OrderID:   ord.OrderID,
+		ToStatus:  ord.Status,
+		ChangedBy: userID,
+		Note:      "order created",
+		CreatedAt: ord.CreatedAt,
+	}); err != nil {
+		return Order{}, err
+	}
+
+	for i := range ord.Items {
+		it := &ord.Items[i]
+		it.OrderID = ord.OrderID
+		var options []byte
+		if it.Options != nil {
+			if options, err = json.Marshal(it.Options); err != nil {
+				return Order{}, err
+			}
+		}
+		if err := tx.QueryRow(insertOrderItemQuery,
+			it.OrderID, it.ProductID, it.VariantID, it.SKU, options,
+			it.ProductName, it.ProductNameTH, it.ProductImg,
+			it.UnitPrice, it.Quantity, it.LineTotal, ord.CreatedAt,
+		).Scan(&it.OrderItemID); err != nil {
+			return Order{}, err
+		}
+	}
+
+	quantities := itemQuantities(ord.Items)
+	if err := product.ReserveStock(tx, quantities); err != nil {
+		return Order{}, err
+	}
+	expiresAt := time.Now().UTC().Add(ReservationTTL)
+	for k, qty := range quantities {
+		if _, err := tx.Exec(insertReservationQuery, ord.OrderID, k.ProductID, k.VariantID, qty, reservationReserved, expiresAt); err != nil {
+			return Order{}, err
+		}
+	}
 	ord.UserID = userID
 	return ord, nil
 }
 
+func (r *PostgresRepository) GetByID(id int) (Order, error) {
+	orders, err := r.ListByIDs([]int{id})
+	if err != nil {
+		return Order{}, err
+	}
+	if len(orders) == 0 {
+		return Order{}, ErrNotFound
+	}
+	return orders[0], nil
+}
+
+// UpdateStatus changes the order status only if it still equals `from`, so
+// two concurrent updates cannot both apply, and records the change.
+func (r *PostgresRepository) UpdateStatus(orderID int, from, to string, change StatusChange) (Order, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return Order{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	result, err := tx.Exec(
+		`UPDATE orders SET status = $1, "updatedAt" = $2
+		 WHERE "orderID" = $3 AND COALESCE(NULLIF(status, ''), $5) = $4`,
+		to, change.CreatedAt, orderID, from, StatusPendingPayment,
+	)
+	if err != nil {
+		return Order{}, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return Order{}, err
+	}
+	if affected == 0 {
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM orders WHERE "orderID" = $1)`, orderID).Scan(&exists); err != nil {
+			return Order{}, err
+		}
+		if !exists {
+			return Order{}, ErrNotFound
+		}
+		return Order{}, ErrStatusConflict
+	}
+
+	change.OrderID = orderID
+	change.FromStatus = &from
+	change.ToStatus = to
+	if _, err := insertStatusChange(tx, change); err != nil {
+		return Order{}, err
+	}
+	if err := settleReservations(tx, orderID, from, to); err != nil {
+		return Order{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Order{}, err
+	}
+	return r.GetByID(orderID)
+}
+
+// settleReservations applies a status change to the order's stock
+// reservations: payment commits them, and a cancellation or a refund before
+// shipment returns the units to stock (see releasesStock). Other transitions
+// leave stock untouched.
+func settleReservations(tx *sql.Tx, orderID int, from, to string) error {
+	switch {
+	case to == StatusPaid:
+		_, err := tx.Exec(`UPDATE stock_reservations SET status = $1 WHERE "orderID" = $2 AND status = $3`,
+			reservationCommitted, orderID, reservationReserved)
+		return err
+	case releasesStock(from, to):
+		rows, err := tx.Query(`SELECT "productID", "variantID", quantity FROM stock_reservations WHERE "orderID" = $1 AND status IN ($2, $3) FOR UPDATE`,
+			orderID, reservationReserved, reservationCommitted)
+		if err != nil {
+			return err
+		}
+		quantities := map[product.StockKey]int{}
+		for rows.Next() {
+			var k product.StockKey
+			var qty int
+			if err := rows.Scan(&k.ProductID, &k.VariantID, &qty); err != nil {
+				rows.Close()
+				return err
+			}
+			quantities[k] += qty
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if err := product.ReleaseStock(tx, quantities); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE stock_reservations SET status = $1 WHERE "orderID" = $2 AND status IN ($3, $4)`,
+			reservationReleased, orderID, reservationReserved, reservationCommitted)
+		return err
+	}
+	return nil
+}
+
+// ExpiredReservations returns the orders still awaiting payment whose stock
+// reservation ran out before now.
+func (r *PostgresRepository) ExpiredReservations(now time.Time) ([]int, error) {
+	rows, err := r.db.Query(listExpiredReservationsQuery, reservationReserved, now.UTC(), StatusPendingPayment)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *PostgresRepository) ListStatusHistory(orderID int) ([]StatusChange, error) {
+	rows, err := r.db.Query(listStatusHistoryQuery, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]StatusChange, 0)
+	for rows.Next() {
+		var (
+			ch        StatusChange
+			from      sql.NullString
+			changedBy sql.NullInt64
+			note      sql.NullString
+			createdAt sql.NullString
+		)
+		if err := rows.Scan(&ch.HistoryID, &ch.OrderID, &from, &ch.ToStatus, &changedBy, &note, &createdAt); err != nil {
+			return nil, err
+		}
+		if from.Valid {
+			ch.FromStatus = &from.String
+		}
+		ch.ChangedBy = int(changedBy.Int64)
+		ch.Note = note.String
+		ch.CreatedAt = createdAt.String
+		out = append(out, ch)
+	}
+	return out, rows.Err()
+}
+
+func insertStatusChange(tx *sql.Tx, ch StatusChange) (int, error) {
+	var id int
+	err := tx.QueryRow(insertStatusHistoryQuery,
+		ch.OrderID, ch.FromStatus, ch.ToStatus, ch.ChangedBy, ch.Note, ch.CreatedAt,
+	).Scan(&id)
+	return id, err
+}
+
 func (r *PostgresRepository) ListByIDs(ids []int) ([]Order, error) {
 	if len(ids) == 0 {
 		return []Order{}, nil
@@ -65,7 +375,11 @@ func (r *PostgresRepository) ListByIDs(ids []int) ([]Order, error) {
 		return nil, err
 	}
 	defer rows.Close()
-	return scanOrders(rows)
+	orders, err := scanOrders(rows)
+	if err != nil {
+		return nil, err
+	}
+	return orders, r.attachItems(orders)
 }
 
 func (r *PostgresRepository) ListByUserID(userID int) ([]Order, error) {
@@ -78,7 +392,55 @@ func (r *PostgresRepository) ListByUserID(userID int) ([]Order, error) {
 		return nil, err
 	}
 	defer rows.Close()
-	return scanOrders(rows)
+	orders, err := scanOrders(rows)
+	if err != nil {
+		return nil, err
+	}
+	return orders, r.attachItems(orders)
+}
+
+// attachItems loads the item snapshots for all given orders with one query.
+func (r *PostgresRepository) attachItems(orders []Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	ids := make([]int, 0, len(orders))
+	index := make(map[int]int, len(orders))
+	for i := range orders {
+		ids = append(ids, orders[i].OrderID)
+		index[orders[i].OrderID] = i
+		orders[i].Items = []OrderItem{}
+	}
+
+	rows, err := r.db.Query(listOrderItemsQuery, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			it      OrderItem
+			sku     sql.NullString
+			options []byte
+		)
+		if err := rows.Scan(
+			&it.OrderItemID, &it.OrderID, &it.ProductID, &it.VariantID, &sku, &options,
+			&it.ProductName, &it.ProductNameTH, &it.ProductImg,
+			&it.UnitPrice, &it.Quantity, &it.LineTotal,
+		); err != nil {
+			return err
+		}
+		if sku.Valid {
+			it.SKU = &sku.String
+		}
+		if len(options) > 0 {
+			_ = json.Unmarshal(options, &it.Options)
+		}
+		if i, ok := index[it.OrderID]; ok {
+			orders[i].Items = append(orders[i].Items, it)
+		}
+	}
+	return rows.Err()
 }
 
 func scanOrders(rows *sql.Rows) ([]Order, error) {
@@ -102,5 +464,5 @@ func scanOrders(rows *sql.Rows) ([]Order, error) {
 		}
 		orders = append(orders, ord)
 	}
-	return orders, nil
+	return orders, rows.Err()
 }
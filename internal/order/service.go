@@ -2,27 +2,157 @@ package order
 
 import (
 	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wichananm65/pet-shop-backend/internal/product"
 )
 
 // Service provides business logic for orders.
 type Service struct {
-	repo Repository
+	repo     Repository
+	products product.ServiceInterface
+}
+
+func NewService(r Repository, ps product.ServiceInterface) *Service {
+	return &Service{repo: r, products: ps}
 }
 
-func NewService(r Repository) *Service {
-	return &Service{repo: r}
+// Quote prices the given cart from current product data without creating
+// an order.
+func (s *Service) Quote(cart map[string]int) (Totals, error) {
+	totals, _, err := priceCart(s.products, cart)
+	return totals, err
 }
 
-func (s *Service) Create(ord Order, userID int) (Order, error) {
+// Create prices the cart server-side and persists the order together with
+// a snapshot of every purchased product. Any totals the client claimed are
+// compared against the computed ones; a mismatch is rejected with a
+// PriceMismatchError, omitted fields are filled in.
+func (s *Service) Create(ord Order, userID int, claimed *ClaimedTotals) (Order, error) {
 	if userID <= 0 {
-		return Order{}, errors.New("invalid user")
+		return Order{}, ErrInvalidUser
+	}
+	totals, items, err := priceCart(s.products, ord.Cart)
+	if err != nil {
+		return Order{}, err
 	}
-	if len(ord.Cart) == 0 {
-		return Order{}, errors.New("empty cart")
+	if err := compareTotals(claimed, totals); err != nil {
+		return Order{}, err
 	}
+	ord.Quantity = totals.Quantity
+	ord.TotalPrice = totals.TotalPrice
+	ord.ShippingPrice = totals.ShippingPrice
+	ord.GrandPrice = totals.GrandPrice
+	ord.Items = items
+	ord.Status = StatusPendingPayment
 	return s.repo.Create(ord, userID)
 }
 
+// Checkout turns the user's server-side cart into an order. Pricing happens
+// inside the repository transaction so the cart that is priced is exactly
+// the cart that gets cleared.
+func (s *Service) Checkout(userID int) (Order, error) {
+	if userID <= 0 {
+		return Order{}, ErrInvalidUser
+	}
+	return s.repo.Checkout(userID, func(cart map[string]int) (Order, error) {
+		totals, items, err := priceCart(s.products, cart)
+		if err != nil {
+			return Order{}, err
+		}
+		now := time.Now().UTC().Format(time.RFC3339)
+		return Order{
+			Cart:          cart,
+			Items:         items,
+			Quantity:      totals.Quantity,
+			TotalPrice:    totals.TotalPrice,
+			ShippingPrice: totals.ShippingPrice,
+			GrandPrice:    totals.GrandPrice,
+			Status:        StatusPendingPayment,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}, nil
+	})
+}
+
+// GetByID returns a single order.
+func (s *Service) GetByID(orderID int) (Order, error) {
+	return s.repo.GetByID(orderID)
+}
+
+// UpdateStatus moves an order to a new lifecycle status on behalf of
+// actorID, rejecting transitions the lifecycle does not allow.
+func (s *Service) UpdateStatus(orderID int, to string, actorID int, note string) (Order, error) {
+	if !ValidStatus(to) {
+		return Order{}, ErrUnknownStatus
+	}
+	ord, err := s.repo.GetByID(orderID)
+	if err != nil {
+		return Order{}, err
+	}
+	from := currentStatus(ord)
+	if !CanTransition(from, to) {
+		return Order{}, &TransitionError{From: from, To: to}
+	}
+	return s.repo.UpdateStatus(orderID, from, to, StatusChange{
+		ChangedBy: actorID,
+		Note:      note,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// Cancel cancels an order before it has shipped and records the reason in
+// the status history.
+func (s *Service) Cancel(orderID int, actorID int, reason string) (Order, error) {
+	ord, err := s.repo.GetByID(orderID)
+	if err != nil {
+		return Order{}, err
+	}
+	from := currentStatus(ord)
+	if !CanCancel(from) {
+		return Order{}, ErrNotCancellable
+	}
+	if reason == "" {
+		reason = "cancelled by customer"
+	}
+	return s.repo.UpdateStatus(orderID, from, StatusCancelled, StatusChange{
+		ChangedBy: actorID,
+		Note:      reason,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// ExpireReservations cancels orders whose payment window has passed so their
+// reserved stock becomes available again. Orders that were paid or changed
+// in the meantime are skipped. It returns the number of orders cancelled.
+func (s *Service) ExpireReservations(now time.Time) (int, error) {
+	ids, err := s.repo.ExpiredReservations(now)
+	if err != nil {
+		return 0, err
+	}
+	cancelled := 0
+	for _, id := range ids {
+		_, err := s.repo.UpdateStatus(id, StatusPendingPayment, StatusCancelled, StatusChange{
+			Note:      "payment window expired",
+			CreatedAt: now.UTC().Format(time.RFC3339),
+		})
+		if errors.Is(err, ErrStatusConflict) || errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return cancelled, fmt.Errorf("expire order %d: %w", id, err)
+		}
+		cancelled++
+	}
+	return cancelled, nil
+}
+
+// History returns the status timeline of an order, oldest first.
+func (s *Service) History(orderID int) ([]StatusChange, error) {
+	return s.repo.ListStatusHistory(orderID)
+}
+
 // ListByIDs retrieves the orders corresponding to the given ids.
 func (s *Service) ListByIDs(ids []int) ([]Order, error) {
 	if ids == nil {
@@ -0,0 +1,108 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Order lifecycle states. New orders start as pending_payment and move
+// forward through fulfilment; cancelled and refunded are terminal.
+const (
+	StatusPendingPayment = "pending_payment"
+	StatusPaid           = "paid"
+	StatusPacking        = "packing"
+	StatusShipped        = "shipped"
+	StatusDelivered      = "delivered"
+	StatusCancelled      = "cancelled"
+	StatusRefunded       = "refunded"
+)
+
+var (
+	ErrNotFound       = errors.New("order not found")
+	ErrUnknownStatus  = errors.New("unknown order status")
+	ErrStatusConflict = errors.New("order status changed concurrently")
+	ErrNotCancellable = errors.New("order can no longer be cancelled")
+)
+
+// transitions lists the statuses each status may move to.
+var transitions = map[string][]string{
+	StatusPendingPayment: {StatusPaid, StatusCancelled},
+	StatusPaid:           {StatusPacking, StatusCancelled, StatusRefunded},
+	StatusPacking:        {StatusShipped, StatusCancelled, StatusRefunded},
+	StatusShipped:        {StatusDelivered, StatusRefunded},
+	StatusDelivered:      {StatusRefunded},
+	StatusCancelled:      {},
+	StatusRefunded:       {},
+}
+
+// releasesStock reports whether moving from from to to puts the order's
+// reserved units back into stock: a cancellation, or a refund before the
+// goods left the warehouse. Refunds after shipment leave stock alone; staff
+// restock returned goods by hand through PUT /api/v1/product/:id/stock.
+func releasesStock(from, to string) bool {
+	switch to {
+	case StatusCancelled:
+		return true
+	case StatusRefunded:
+		return from != StatusShipped && from != StatusDelivered
+	}
+	return false
+}
+
+// TransitionError is returned when a status change is not allowed by the
+// lifecycle.
+type TransitionError struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot change order status from %s to %s", e.From, e.To)
+}
+
+// StatusChange is one entry in an order's status timeline. FromStatus is
+// nil for the entry recorded when the order was created.
+type StatusChange struct {
+	HistoryID  int     `json:"historyID"`
+	OrderID    int     `json:"orderID"`
+	FromStatus *string `json:"fromStatus"`
+	ToStatus   string  `json:"toStatus"`
+	ChangedBy  int     `json:"changedBy"`
+	Note       string  `json:"note,omitempty"`
+	CreatedAt  string  `json:"createdAt"`
+}
+
+// ValidStatus reports whether s is one of the lifecycle states.
+func ValidStatus(s string) bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// CanTransition reports whether an order may move from one status to another.
+func CanTransition(from, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// CanCancel reports whether a customer may still cancel an order in the
+// given status, i.e. it has not been shipped yet.
+func CanCancel(status string) bool {
+	switch status {
+	case StatusPendingPayment, StatusPaid, StatusPacking:
+		return true
+	}
+	return false
+}
+
+// currentStatus normalizes the status of orders created before the
+// lifecycle existed, which have no status recorded.
+func currentStatus(ord Order) string {
+	if ord.Status == "" {
+		return StatusPendingPayment
+	}
+	return ord.Status
+}
@@ -0,0 +1,28 @@
+package order
+
+import (
+	"time"
+
+	"github.com/wichananm65/pet-shop-backend/internal/product"
+)
+
+// ReservationTTL is how long stock stays reserved for an order awaiting
+// payment before the order is cancelled and the stock released.
+const ReservationTTL = 30 * time.Minute
+
+// Stock reservation states. A reservation is held while the order awaits
+// payment, committed once it is paid and released when it is cancelled.
+const (
+	reservationReserved  = "reserved"
+	reservationCommitted = "committed"
+	reservationReleased  = "released"
+)
+
+// itemQuantities sums the purchased units per product or variant.
+func itemQuantities(items []OrderItem) map[product.StockKey]int {
+	out := make(map[product.StockKey]int, len(items))
+	for _, it := range items {
+		out[product.StockKey{ProductID: it.ProductID, VariantID: it.VariantID}] += it.Quantity
+	}
+	return out
+}
@@ -0,0 +1,178 @@
+package privacy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/wichananm65/pet-shop-backend/internal/loginguard"
+	"github.com/wichananm65/pet-shop-backend/internal/session"
+	"github.com/wichananm65/pet-shop-backend/internal/user"
+)
+
+type Handler struct {
+	service  *Service
+	users    *user.Service
+	sessions *session.Service
+	guard    *loginguard.Guard
+}
+
+// NewHandler checks passwords with users before scheduling a deletion.
+func NewHandler(s *Service, users *user.Service) *Handler {
+	return &Handler{service: s, users: users}
+}
+
+// WithSessions signs users out everywhere once they ask for deletion.
+func (h *Handler) WithSessions(s *session.Service) *Handler {
+	h.sessions = s
+	return h
+}
+
+// WithLoginGuard checks the password under the sign-in limits of the
+// user's email, so it cannot be guessed through this route either.
+func (h *Handler) WithLoginGuard(g *loginguard.Guard) *Handler {
+	h.guard = g
+	return h
+}
+
+func (h *Handler) RegisterProtectedRoutes(app *fiber.App) {
+	app.Get("/api/v1/profile/export", h.export)
+	app.Get("/api/v1/profile/deletion", h.getDeletion)
+	app.Post("/api/v1/profile/deletion", h.requestDeletion)
+	app.Delete("/api/v1/profile/deletion", h.cancelDeletion)
+}
+
+// export sends a ZIP bundle, or only its data.json with ?format=json.
+func (h *Handler) export(c *fiber.Ctx) error {
+	userID, err := user.GetUserIDFromCtx(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
+	}
+	format := c.Query("format", "zip")
+	if format != "zip" && format != "json" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "format must be zip or json"})
+	}
+	bundle, err := h.service.Export(userID)
+	if err != nil {
+		return writeError(c, err)
+	}
+
+	c.Set(fiber.HeaderCacheControl, "private, no-store")
+	if format == "json" {
+		return c.JSON(bundle)
+	}
+	var buf bytes.Buffer
+	if err := bundle.WriteZIP(&buf); err != nil {
+		return writeError(c, err)
+	}
+	name := fmt.Sprintf("pet-shop-data-%d-%s.zip", userID, bundle.ExportedAt.Format("20060102"))
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+name+`"`)
+	return c.Send(buf.Bytes())
+}
+
+func (h *Handler) getDeletion(c *fiber.Ctx) error {
+	userID, err := user.GetUserIDFromCtx(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
+	}
+	d, err := h.service.Deletion(userID)
+	if err != nil {
+		return writeError(c, err)
+	}
+	return c.JSON(d)
+}
+
+type deletionRequest struct {
+	Password string `json:"password"`
+}
+
+// requestDeletion schedules the erasure of the signed-in user's account
+// after they re-enter their password, which is checked under the sign-in
+// limits like a sign-in.
+func (h *Handler) requestDeletion(c *fiber.Ctx) error {
+	userID, err := user.GetUserIDFromCtx(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
+	}
+	payload := new(deletionRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	u, err := h.users.GetByID(userID)
+	if err != nil {
+		return writeError(c, err)
+	}
+	if h.guard != nil {
+		if err := h.guard.Check(u.Email, c.IP()); err != nil {
+			return writeLimitError(c, err)
+		}
+	}
+	err = h.users.CheckPassword(userID, payload.Password)
+	if h.guard != nil {
+		switch err {
+		case nil:
+			if err := h.guard.Success(u.Email); err != nil {
+				log.Printf("could not clear failed sign-ins: %v", err)
+			}
+		case user.ErrWrongPassword:
+			if err := h.guard.Failure(u.Email, c.IP()); err != nil {
+				log.Printf("could not record failed sign-in: %v", err)
+			}
+		}
+	}
+	if err != nil {
+		return writeError(c, err)
+	}
+	at, err := h.service.RequestDeletion(userID)
+	if err != nil {
+		return writeError(c, err)
+	}
+	if h.sessions != nil {
+		if err := h.sessions.RevokeAll(userID); err != nil {
+			return writeError(c, err)
+		}
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message":     "Account deletion scheduled; to cancel it, sign in and send DELETE /api/v1/profile/deletion before deleteAfter",
+		"deleteAfter": at,
+	})
+}
+
+func (h *Handler) cancelDeletion(c *fiber.Ctx) error {
+	userID, err := user.GetUserIDFromCtx(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
+	}
+	if err := h.service.CancelDeletion(userID); err != nil {
+		return writeError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func writeError(c *fiber.Ctx, err error) error {
+	switch err {
+	case user.ErrNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "user not found"})
+	case ErrNotScheduled:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": err.Error()})
+	case user.ErrWrongPassword:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"message": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+	}
+}
+
+func writeLimitError(c *fiber.Ctx, err error) error {
+	var limit *loginguard.LimitError
+	if !errors.As(err, &limit) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+	}
+	seconds := int(math.Ceil(limit.RetryAfter.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"message": limit.Reason, "retryAfter": seconds})
+}
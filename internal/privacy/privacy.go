@@ -0,0 +1,275 @@
+// Package privacy lets customers exercise their rights under the Thai PDPA:
+// downloading everything the shop keeps about them and erasing their
+// account. Erasure waits out a grace period in which the customer can
+// change their mind; orders survive it without personal data because the
+// shop has to keep them for accounting.
+package privacy
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/wichananm65/pet-shop-backend/internal/address"
+	"github.com/wichananm65/pet-shop-backend/internal/favorite"
+	"github.com/wichananm65/pet-shop-backend/internal/order"
+	"github.com/wichananm65/pet-shop-backend/internal/review"
+	"github.com/wichananm65/pet-shop-backend/internal/storage"
+	"github.com/wichananm65/pet-shop-backend/internal/user"
+)
+
+// DefaultDeletionGrace is how long a deletion request can be cancelled.
+const DefaultDeletionGrace = 30 * 24 * time.Hour
+
+var ErrNotScheduled = errors.New("account deletion is not scheduled")
+
+// The services the export reads from; *user.Service, *address.Service,
+// *order.Service and *favorite.Service implement them.
+type (
+	Profiles interface {
+		GetByID(id int) (user.User, error)
+	}
+	Addresses interface {
+		GetAddresses(userID int) ([]address.Address, error)
+	}
+	Orders interface {
+		ListByUserID(userID int) ([]order.Order, error)
+	}
+	Favorites interface {
+		GetFavorites(userID int) ([]user.FavoriteProduct, error)
+	}
+)
+
+// Bundle is everything exported for a user.
+type Bundle struct {
+	ExportedAt time.Time              `json:"exportedAt"`
+	Profile    user.User              `json:"profile"`
+	Addresses  []address.Address      `json:"addresses"`
+	Orders     []order.Order          `json:"orders"`
+	Favorites  []user.FavoriteProduct `json:"favorites"`
+	// Avatar is the file name of the avatar inside the ZIP bundle.
+	Avatar string `json:"avatar,omitempty"`
+
+	avatar *storage.Object
+}
+
+// Deletion is the state of a user's deletion request.
+type Deletion struct {
+	Scheduled   bool       `json:"scheduled"`
+	DeleteAfter *time.Time `json:"deleteAfter,omitempty"`
+}
+
+type Service struct {
+	repo      Repository
+	profiles  Profiles
+	addresses Addresses
+	orders    Orders
+	favorites Favorites
+	blobs     storage.Blob
+	grace     time.Duration
+	now       func() time.Time
+}
+
+func NewService(repo Repository, profiles Profiles, addresses Addresses, orders Orders, favorites Favorites) *Service {
+	return &Service{
+		repo:      repo,
+		profiles:  profiles,
+		addresses: addresses,
+		orders:    orders,
+		favorites: favorites,
+		grace:     DefaultDeletionGrace,
+		now:       time.Now,
+	}
+}
+
+// WithStorage adds the avatar to exports and removes it on erasure.
+func (s *Service) WithStorage(blobs storage.Blob) *Service {
+	s.blobs = blobs
+	return s
+}
+
+// WithGrace sets how long deletion requests wait before the account is
+// erased. Zero erases it on the next Purge.
+func (s *Service) WithGrace(grace time.Duration) *Service {
+	s.grace = grace
+	return s
+}
+
+// Export collects the user's data. Users without addresses or favorites
+// get empty lists.
+func (s *Service) Export(userID int) (Bundle, error) {
+	u, err := s.profiles.GetByID(userID)
+	if err != nil {
+		return Bundle{}, err
+	}
+	u.Password = ""
+	b := Bundle{ExportedAt: s.now().UTC(), Profile: u}
+
+	if b.Addresses, err = s.addresses.GetAddresses(userID); err != nil && err != address.ErrNotFound {
+		return Bundle{}, err
+	}
+	if b.Orders, err = s.orders.ListByUserID(userID); err != nil {
+		return Bundle{}, err
+	}
+	if b.Favorites, err = s.favorites.GetFavorites(userID); err != nil && err != favorite.ErrNotFound {
+		return Bundle{}, err
+	}
+	if b.Addresses == nil {
+		b.Addresses = []address.Address{}
+	}
+	if b.Orders == nil {
+		b.Orders = []order.Order{}
+	}
+	if b.Favorites == nil {
+		b.Favorites = []user.FavoriteProduct{}
+	}
+
+	if key, ok := avatarKey(u); ok && s.blobs != nil {
+		obj, err := s.blobs.Get(key)
+		switch {
+		case err == nil:
+			b.avatar = &obj
+			b.Avatar = "avatar" + path.Ext(key)
+		case err != storage.ErrNotFound:
+			return Bundle{}, err
+		}
+	}
+	return b, nil
+}
+
+// WriteZIP writes the bundle as a ZIP archive holding data.json and the
+// avatar.
+func (b Bundle) WriteZIP(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: "data.json", Method: zip.Deflate, Modified: b.ExportedAt})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(b); err != nil {
+		return err
+	}
+	if b.avatar != nil {
+		// images are compressed already
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: b.Avatar, Method: zip.Store, Modified: b.ExportedAt})
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(b.avatar.Data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// RequestDeletion schedules the user's account for erasure once the grace
+// period is over and returns when that is. Asking again keeps the original
+// date.
+func (s *Service) RequestDeletion(userID int) (time.Time, error) {
+	d, err := s.Deletion(userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if d.Scheduled {
+		return *d.DeleteAfter, nil
+	}
+	at := s.now().Add(s.grace).UTC()
+	if err := s.repo.ScheduleDeletion(userID, at); err != nil {
+		return time.Time{}, err
+	}
+	return at, nil
+}
+
+func (s *Service) CancelDeletion(userID int) error {
+	return s.repo.CancelDeletion(userID)
+}
+
+func (s *Service) Deletion(userID int) (Deletion, error) {
+	at, err := s.repo.ScheduledDeletion(userID)
+	if err != nil {
+		return Deletion{}, err
+	}
+	return Deletion{Scheduled: at != nil, DeleteAfter: at}, nil
+}
+
+// Erase deletes the user's account and personal data right away and
+// anonymizes their orders. Earlier avatars replaced without being deleted
+// cannot be found and stay in storage.
+func (s *Service) Erase(userID int) error {
+	u, err := s.profiles.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	photos, err := s.repo.Erase(userID)
+	if err != nil {
+		return err
+	}
+	if s.blobs == nil {
+		return nil
+	}
+	// the account is gone either way; a leftover file is only logged
+	if key, ok := avatarKey(u); ok {
+		if err := storage.DeleteRenditions(s.blobs, key); err != nil {
+			log.Printf("warning: could not delete avatar %s of erased user %d: %v", key, userID, err)
+		}
+	}
+	for _, photo := range photos {
+		if key, ok := reviewPhotoKey(userID, photo); ok {
+			if err := storage.DeleteRenditions(s.blobs, key); err != nil {
+				log.Printf("warning: could not delete review photo %s of erased user %d: %v", key, userID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Purge erases the accounts whose grace period is over and returns how
+// many it erased. It goes on after a failure and returns the first error.
+func (s *Service) Purge() (int, error) {
+	ids, err := s.repo.DueDeletions(s.now())
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	var firstErr error
+	for _, id := range ids {
+		err := s.Erase(id)
+		if err == nil {
+			n++
+		} else if err != user.ErrNotFound && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return n, firstErr
+}
+
+// avatarKey returns the storage key of an avatar uploaded to blob storage.
+func avatarKey(u user.User) (string, bool) {
+	if u.AvatarPic == nil {
+		return "", false
+	}
+	return uploadKey(*u.AvatarPic)
+}
+
+// reviewPhotoKey returns the storage key of a photo the user uploaded for
+// their reviews. Older reviews may cite any /uploads URL, so only keys under
+// the user's review.PhotoPrefix are treated as theirs.
+func reviewPhotoKey(userID int, url string) (string, bool) {
+	key, ok := uploadKey(url)
+	if !ok || !strings.HasPrefix(key, review.PhotoPrefix(userID)) {
+		return "", false
+	}
+	return key, true
+}
+
+// uploadKey returns the storage key behind an /uploads URL.
+func uploadKey(url string) (string, bool) {
+	key, ok := strings.CutPrefix(url, "/uploads/")
+	return key, ok && storage.ValidKey(key)
+}
@@ -0,0 +1,234 @@
+package privacy
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/wichananm65/pet-shop-backend/internal/address"
+	"github.com/wichananm65/pet-shop-backend/internal/loginguard"
+	"github.com/wichananm65/pet-shop-backend/internal/order"
+	"github.com/wichananm65/pet-shop-backend/internal/storage"
+	"github.com/wichananm65/pet-shop-backend/internal/user"
+)
+
+type stubOrders map[int][]order.Order
+
+func (s stubOrders) ListByUserID(userID int) ([]order.Order, error) { return s[userID], nil }
+
+type stubFavorites map[int][]user.FavoriteProduct
+
+func (s stubFavorites) GetFavorites(userID int) ([]user.FavoriteProduct, error) {
+	return s[userID], nil
+}
+
+type fixture struct {
+	service *Service
+	repo    *InMemoryRepository
+	users   *user.Service
+	blobs   *storage.Memory
+	userID  int
+	now     time.Time
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	users := user.NewService(user.NewInMemoryRepository(nil))
+	u, err := users.Register(newUser("p@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := storage.NewMemory(nil)
+	avatar := "/uploads/avatars/" + strconv.Itoa(u.ID) + "_ab12.jpg"
+	if err := blobs.Put(strings.TrimPrefix(avatar, "/uploads/"), []byte("jpeg bytes"), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	u.AvatarPic = &avatar
+	if _, err := users.Update(u.ID, u); err != nil {
+		t.Fatal(err)
+	}
+
+	addresses := address.NewService(address.NewInMemoryRepository(map[int][]address.Address{
+		u.ID: {{AddressID: 1, UserID: u.ID, AddressDesc: "1 Sukhumvit Rd", Phone: "0812345678", AddressName: "Home"}},
+	}))
+	orders := stubOrders{u.ID: {{OrderID: 9, UserID: u.ID, GrandPrice: 250, Status: order.StatusDelivered}}}
+	name := "Cat food"
+	favorites := stubFavorites{u.ID: {{ProductID: 3, ProductName: &name}}}
+
+	f := &fixture{repo: NewInMemoryRepository(), users: users, blobs: blobs, userID: u.ID, now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	f.service = NewService(f.repo, users, addresses, orders, favorites).WithStorage(blobs)
+	f.service.now = func() time.Time { return f.now }
+	return f
+}
+
+func newUser(email string) user.User {
+	return user.User{Email: email, Password: "secret123", FirstName: "Pim", LastName: "Test", Phone: "0812345678", Gender: "F"}
+}
+
+func TestExport_BundlesEverything(t *testing.T) {
+	f := newFixture(t)
+	b, err := f.service.Export(f.userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Profile.Email != "p@example.com" || b.Profile.Password != "" {
+		t.Fatalf("expected the profile without its password hash, got %+v", b.Profile)
+	}
+	if len(b.Addresses) != 1 || len(b.Orders) != 1 || len(b.Favorites) != 1 {
+		t.Fatalf("expected one address, order and favorite, got %+v", b)
+	}
+
+	var buf bytes.Buffer
+	if err := b.WriteZIP(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, zf := range zr.File {
+		rc, err := zf.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[zf.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	if string(files["avatar.jpg"]) != "jpeg bytes" {
+		t.Fatalf("expected the avatar in the bundle, got files %v", len(files))
+	}
+	var data struct {
+		Profile   user.User         `json:"profile"`
+		Addresses []address.Address `json:"addresses"`
+		Avatar    string            `json:"avatar"`
+	}
+	if err := json.Unmarshal(files["data.json"], &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Profile.ID != f.userID || data.Addresses[0].AddressDesc != "1 Sukhumvit Rd" || data.Avatar != "avatar.jpg" {
+		t.Fatalf("unexpected data.json %s", files["data.json"])
+	}
+}
+
+func TestDeletion_GracePeriodAndPurge(t *testing.T) {
+	f := newFixture(t)
+	f.service.WithGrace(24 * time.Hour)
+
+	at, err := f.service.RequestDeletion(f.userID)
+	if err != nil || !at.Equal(f.now.Add(24*time.Hour)) {
+		t.Fatalf("expected deletion in 24h, got %s, %v", at, err)
+	}
+	f.now = f.now.Add(time.Hour)
+	if again, _ := f.service.RequestDeletion(f.userID); !again.Equal(at) {
+		t.Fatalf("expected asking again to keep the date, got %s", again)
+	}
+	if n, err := f.service.Purge(); err != nil || n != 0 {
+		t.Fatalf("expected nothing to purge within the grace period, got %d, %v", n, err)
+	}
+
+	if err := f.service.CancelDeletion(f.userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.service.CancelDeletion(f.userID); err != ErrNotScheduled {
+		t.Fatalf("expected ErrNotScheduled, got %v", err)
+	}
+	f.now = f.now.Add(48 * time.Hour)
+	if n, _ := f.service.Purge(); n != 0 {
+		t.Fatal("expected a cancelled deletion not to be purged")
+	}
+
+	own := "reviews/" + strconv.Itoa(f.userID) + "/own.jpg"
+	shared := "reviews/" + strconv.Itoa(f.userID) + "/shared.jpg"
+	for _, key := range []string{own, shared, "files/ab12.jpg"} {
+		if err := f.blobs.Put(key, []byte("photo"), "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.repo.AddReviewPhotos(f.userID, "/uploads/"+own, "/uploads/"+shared, "/uploads/files/ab12.jpg")
+	// another user's older review cites the same photo
+	f.repo.AddReviewPhotos(f.userID+1, "/uploads/"+shared)
+	if _, err := f.service.RequestDeletion(f.userID); err != nil {
+		t.Fatal(err)
+	}
+	f.now = f.now.Add(24 * time.Hour)
+	if n, err := f.service.Purge(); err != nil || n != 1 {
+		t.Fatalf("expected the account to be purged, got %d, %v", n, err)
+	}
+	if erased := f.repo.Erased(); len(erased) != 1 || erased[0] != f.userID {
+		t.Fatalf("expected user %d to be erased, got %v", f.userID, erased)
+	}
+	if _, err := f.blobs.Get("avatars/" + strconv.Itoa(f.userID) + "_ab12.jpg"); err != storage.ErrNotFound {
+		t.Fatalf("expected the avatar to be deleted, got %v", err)
+	}
+	if _, err := f.blobs.Get(own); err != storage.ErrNotFound {
+		t.Fatalf("expected the review photo to be deleted, got %v", err)
+	}
+	if _, err := f.blobs.Get(shared); err != nil {
+		t.Fatalf("expected a photo another review cites to stay, got %v", err)
+	}
+	if _, err := f.blobs.Get("files/ab12.jpg"); err != nil {
+		t.Fatalf("expected a shared upload cited by a review to stay, got %v", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	f := newFixture(t)
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user", &jwt.Token{Claims: jwt.MapClaims{"user_id": f.userID}})
+		return c.Next()
+	})
+	policy := loginguard.DefaultPolicy()
+	policy.BackoffAfter = 2
+	guard := loginguard.New(loginguard.NewInMemoryRepository(), policy)
+	NewHandler(f.service, f.users).WithLoginGuard(guard).RegisterProtectedRoutes(app)
+
+	request := func(method, path, body string) (int, []byte, string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(res.Body)
+		return res.StatusCode, data, res.Header.Get("Content-Type")
+	}
+
+	if code, _, ct := request("GET", "/api/v1/profile/export", ""); code != fiber.StatusOK || ct != "application/zip" {
+		t.Fatalf("expected a ZIP download, got %d %q", code, ct)
+	}
+	code, data, _ := request("GET", "/api/v1/profile/export?format=json", "")
+	if code != fiber.StatusOK || !strings.Contains(string(data), `"email":"p@example.com"`) || strings.Contains(string(data), "password") {
+		t.Fatalf("expected the JSON bundle without a password, got %d %s", code, data)
+	}
+
+	if code, _, _ := request("POST", "/api/v1/profile/deletion", `{"password":"wrong"}`); code != fiber.StatusForbidden {
+		t.Fatalf("expected 403 for a wrong password, got %d", code)
+	}
+	request("POST", "/api/v1/profile/deletion", `{"password":"wrong"}`)
+	if code, _, _ := request("POST", "/api/v1/profile/deletion", `{"password":"secret123"}`); code != fiber.StatusTooManyRequests {
+		t.Fatalf("expected wrong passwords to back off like sign-ins, got %d", code)
+	}
+	guard.Success("p@example.com")
+	if code, data, _ := request("POST", "/api/v1/profile/deletion", `{"password":"secret123"}`); code != fiber.StatusAccepted || !strings.Contains(string(data), "DELETE /api/v1/profile/deletion") {
+		t.Fatalf("expected 202 naming the cancel endpoint, got %d %s", code, data)
+	}
+	if code, data, _ := request("GET", "/api/v1/profile/deletion", ""); code != fiber.StatusOK || !strings.Contains(string(data), `"scheduled":true`) {
+		t.Fatalf("expected a scheduled deletion, got %d %s", code, data)
+	}
+	if code, _, _ := request("DELETE", "/api/v1/profile/deletion", ""); code != fiber.StatusNoContent {
+		t.Fatalf("expected 204 cancelling, got %d", code)
+	}
+	if code, _, _ := request("DELETE", "/api/v1/profile/deletion", ""); code != fiber.StatusNotFound {
+		t.Fatalf("expected 404 with nothing to cancel, got %d", code)
+	}
+}
@@ -0,0 +1,112 @@
+package privacy
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Repository methods return user.ErrNotFound for unknown users.
+type Repository interface {
+	ScheduleDeletion(userID int, at time.Time) error
+	// CancelDeletion returns ErrNotScheduled when nothing is scheduled.
+	CancelDeletion(userID int) error
+	// ScheduledDeletion returns nil when nothing is scheduled.
+	ScheduledDeletion(userID int) (*time.Time, error)
+	// DueDeletions returns the users whose deletion is due at now.
+	DueDeletions(now time.Time) ([]int, error)
+	// Erase deletes the user with their personal data and anonymizes their
+	// orders, all or nothing. It returns the photo URLs of the deleted
+	// reviews that no remaining review cites, so their files can be
+	// removed from storage.
+	Erase(userID int) ([]string, error)
+}
+
+// InMemoryRepository is used for tests and local scenarios. Erase only
+// records the user and hands back the photos added with AddReviewPhotos.
+type InMemoryRepository struct {
+	mu           sync.Mutex
+	scheduled    map[int]time.Time
+	reviewPhotos map[int][]string
+	erased       []int
+}
+
+func NewInMemoryRepository() *InMemoryRepository {
+	return &InMemoryRepository{scheduled: map[int]time.Time{}, reviewPhotos: map[int][]string{}}
+}
+
+// AddReviewPhotos records photo URLs of reviews written by the user.
+func (r *InMemoryRepository) AddReviewPhotos(userID int, photos ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reviewPhotos[userID] = append(r.reviewPhotos[userID], photos...)
+}
+
+func (r *InMemoryRepository) ScheduleDeletion(userID int, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scheduled[userID] = at
+	return nil
+}
+
+func (r *InMemoryRepository) CancelDeletion(userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.scheduled[userID]; !ok {
+		return ErrNotScheduled
+	}
+	delete(r.scheduled, userID)
+	return nil
+}
+
+func (r *InMemoryRepository) ScheduledDeletion(userID int) (*time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	at, ok := r.scheduled[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &at, nil
+}
+
+func (r *InMemoryRepository) DueDeletions(now time.Time) ([]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ids []int
+	for id, at := range r.scheduled {
+		if !at.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (r *InMemoryRepository) Erase(userID int) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	photos := r.reviewPhotos[userID]
+	delete(r.scheduled, userID)
+	delete(r.reviewPhotos, userID)
+	cited := map[string]bool{}
+	for _, other := range r.reviewPhotos {
+		for _, p := range other {
+			cited[p] = true
+		}
+	}
+	var unused []string
+	for _, p := range photos {
+		if !cited[p] {
+			unused = append(unused, p)
+		}
+	}
+	r.erased = append(r.erased, userID)
+	return unused, nil
+}
+
+// Erased returns the erased users in order.
+func (r *InMemoryRepository) Erased() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int(nil), r.erased...)
+}
@@ -0,0 +1,229 @@
+package privacy
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/wichananm65/pet-shop-backend/internal/user"
+)
+
+type PostgresRepository struct {
+	db *sql.DB
+}
+
+const (
+	scheduleDeletionQuery  = `UPDATE users SET delete_after = $1 WHERE userid = $2`
+	cancelDeletionQuery    = `UPDATE users SET delete_after = NULL WHERE userid = $1 AND delete_after IS NOT NULL`
+	scheduledDeletionQuery = `SELECT delete_after FROM users WHERE userid = $1`
+	dueDeletionsQuery      = `SELECT userid FROM users WHERE delete_after <= $1 ORDER BY userid`
+
+	lockUserQuery = `SELECT email FROM users WHERE userid = $1 FOR UPDATE`
+	// orders stay for accounting with their items and totals, but no longer
+	// point at anyone; notes the user wrote, such as cancellation reasons,
+	// may be personal
+	anonymizeHistoryQuery = `UPDATE order_status_history SET "changedBy" = NULL, note = NULL WHERE "changedBy" = $1`
+	anonymizeOrdersQuery  = `UPDATE orders SET "userID" = 0 WHERE "userID" = $1`
+	withdrawVotesQuery    = `
+		UPDATE reviews SET helpful_count = helpful_count - 1
+		WHERE review_id IN (SELECT review_id FROM review_helpful_votes WHERE user_id = $1)
+	`
+	deleteReviewsQuery = `DELETE FROM reviews WHERE user_id = $1 RETURNING product_id, photos`
+	// photos of the deleted reviews that other reviews still cite
+	citedPhotosQuery = `SELECT DISTINCT p FROM reviews, unnest(photos) AS p WHERE p = ANY($1)`
+	// the same aggregate the review package maintains
+	refreshRatingQuery = `
+		UPDATE products p
+		SET rating_avg = s.avg, rating_count = s.cnt, score = ROUND(s.avg)::int, updated_at = now()
+		FROM (
+			SELECT COALESCE(ROUND(AVG(rating), 2), 0) AS avg, COUNT(*) AS cnt
+			FROM reviews
+			WHERE product_id = $1
+		) s
+		WHERE p.productid = $1
+	`
+	deleteLoginAttemptsQuery = `DELETE FROM login_attempts WHERE key = 'email:' || lower($1)`
+	deleteLockoutsQuery      = `DELETE FROM login_lockouts WHERE email = lower($1)`
+	deleteUserQuery          = `DELETE FROM users WHERE userid = $1`
+)
+
+// deleteByUserQueries remove the rows of the user in other tables.
+var deleteByUserQueries = []string{
+	`DELETE FROM review_helpful_votes WHERE user_id = $1`,
+	`DELETE FROM address WHERE "userID" = $1`,
+	`DELETE FROM cart WHERE userid = $1`,
+	`DELETE FROM "Favorite" WHERE userid = $1`,
+	`DELETE FROM refresh_tokens WHERE user_id = $1`,
+	`DELETE FROM password_reset_tokens WHERE user_id = $1`,
+	`DELETE FROM email_verification_tokens WHERE user_id = $1`,
+	`DELETE FROM user_totp WHERE user_id = $1`,
+	`DELETE FROM user_recovery_codes WHERE user_id = $1`,
+	`DELETE FROM idempotency_keys WHERE user_id = $1`,
+}
+
+func NewPostgresRepository(db *sql.DB) *PostgresRepository {
+	return &PostgresRepository{db: db}
+}
+
+func (r *PostgresRepository) ScheduleDeletion(userID int, at time.Time) error {
+	result, err := r.db.Exec(scheduleDeletionQuery, at, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return user.ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresRepository) CancelDeletion(userID int) error {
+	result, err := r.db.Exec(cancelDeletionQuery, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotScheduled
+	}
+	return nil
+}
+
+func (r *PostgresRepository) ScheduledDeletion(userID int) (*time.Time, error) {
+	var at sql.NullTime
+	err := r.db.QueryRow(scheduledDeletionQuery, userID).Scan(&at)
+	if err == sql.ErrNoRows {
+		return nil, user.ErrNotFound
+	}
+	if err != nil || !at.Valid {
+		return nil, err
+	}
+	return &at.Time, nil
+}
+
+func (r *PostgresRepository) DueDeletions(now time.Time) ([]int, error) {
+	rows, err := r.db.Query(dueDeletionsQuery, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *PostgresRepository) Erase(userID int) ([]string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var email string
+	if err := tx.QueryRow(lockUserQuery, userID).Scan(&email); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, user.ErrNotFound
+		}
+		return nil, err
+	}
+	for _, q := range []string{anonymizeHistoryQuery, anonymizeOrdersQuery, withdrawVotesQuery} {
+		if _, err := tx.Exec(q, userID); err != nil {
+			return nil, err
+		}
+	}
+	products, photos, err := deleteReviews(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, productID := range products {
+		if _, err := tx.Exec(refreshRatingQuery, productID); err != nil {
+			return nil, err
+		}
+	}
+	if photos, err = uncitedPhotos(tx, photos); err != nil {
+		return nil, err
+	}
+	for _, q := range deleteByUserQueries {
+		if _, err := tx.Exec(q, userID); err != nil {
+			return nil, err
+		}
+	}
+	for _, q := range []string{deleteLoginAttemptsQuery, deleteLockoutsQuery} {
+		if _, err := tx.Exec(q, email); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(deleteUserQuery, userID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return photos, nil
+}
+
+// deleteReviews deletes the user's reviews and returns the products they
+// were about and their photo URLs.
+func deleteReviews(tx *sql.Tx, userID int) (products []int, photos []string, err error) {
+	rows, err := tx.Query(deleteReviewsQuery, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id   int
+			urls pq.StringArray
+		)
+		if err := rows.Scan(&id, &urls); err != nil {
+			return nil, nil, err
+		}
+		products = append(products, id)
+		photos = append(photos, urls...)
+	}
+	return products, photos, rows.Err()
+}
+
+// uncitedPhotos returns the photos that no remaining review cites.
+func uncitedPhotos(tx *sql.Tx, photos []string) ([]string, error) {
+	if len(photos) == 0 {
+		return nil, nil
+	}
+	rows, err := tx.Query(citedPhotosQuery, pq.Array(photos))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cited := map[string]bool{}
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		cited[p] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var uncited []string
+	for _, p := range photos {
+		if !cited[p] {
+			uncited = append(uncited, p)
+		}
+	}
+	return uncited, nil
+}
@@ -2,21 +2,31 @@ package product
 
 import (
 	"fmt"
-	"os"
 	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
+	"github.com/wichananm65/pet-shop-backend/internal/imaging"
+	"github.com/wichananm65/pet-shop-backend/internal/listing"
+	"github.com/wichananm65/pet-shop-backend/internal/upload"
 )
 
 type Handler struct {
-	service *Service
+	service    *Service
+	allowReset bool
 }
 
 func NewHandler(service *Service) *Handler {
 	return &Handler{service: service}
 }
 
+// WithDevReset enables the dev-only /dev/reset-products endpoint.
+func (h *Handler) WithDevReset(allowed bool) *Handler {
+	h.allowReset = allowed
+	return h
+}
+
 func (h *Handler) RegisterPublicRoutes(app *fiber.App) {
 	app.Get("/products", h.getProducts)
 	app.Get("/product/:id", h.getProduct)
@@ -25,9 +35,13 @@ func (h *Handler) RegisterPublicRoutes(app *fiber.App) {
 	// Only match numeric IDs so literal paths like `/api/v1/product/favorite` are not
 	// captured by this parameterized route. Use angle-bracket regex which is
 	// supported by the router in the runtime used here and matches the image route.
+	// search must be registered ahead of the :id route, which would
+	// otherwise capture "search" as an id
+	app.Get("/api/v1/product/search", h.searchProducts)
 	app.Get("/api/v1/product/:id<[0-9]+>", h.getProductV1)
 	app.Get("/api/v1/product/category/:id<[0-9]+>", h.getProductsByCategory)
-	// dev-only endpoint to reset products — enabled when ALLOW_RESET_PRODUCTS=1
+	app.Get("/api/v1/product/:id<[0-9]+>/images/:imageId<[0-9]+>", h.getImage)
+	// dev-only endpoint to reset products — enabled by WithDevReset
 	app.Post("/dev/reset-products", h.resetProducts)
 }
 
@@ -50,25 +64,72 @@ func (h *Handler) getProductV1(c *fiber.Ctx) error {
 	return c.JSON(p)
 }
 
+// searchProducts handles GET /api/v1/product/search?q=&page=&pageSize=.
+func (h *Handler) searchProducts(c *fiber.Ctx) error {
+	res, err := h.service.Search(SearchQuery{
+		Q:        c.Query("q"),
+		Page:     c.QueryInt("page", 1),
+		PageSize: c.QueryInt("pageSize", DefaultSearchPageSize),
+	})
+	if err != nil {
+		if err == ErrInvalidSearch {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+	}
+	return c.JSON(res)
+}
+
 func (h *Handler) getProductsByCategory(c *fiber.Ctx) error {
 	param := c.Params("id")
 	id, err := strconv.Atoi(param)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).SendString("invalid category id")
 	}
-	products := h.service.ListByCategoryID(id)
-	return c.JSON(products)
+	q, err := listing.FromRequest(c, listing.DefaultLimit)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	q.CategoryIDs = []int{id}
+	return h.writePage(c, q)
+}
+
+// writePage runs a listing query and writes the page or the error.
+func (h *Handler) writePage(c *fiber.Ctx, q listing.Query) error {
+	page, err := h.service.ListPage(q)
+	if err != nil {
+		if err == listing.ErrInvalidCursor || err == listing.ErrInvalidSort {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+	}
+	return c.JSON(page)
 }
 
 func (h *Handler) RegisterProtectedRoutes(app *fiber.App) {
-	app.Post("/products", h.createProduct)
-	app.Put("/product/:id", h.updateProduct)
-	app.Delete("/product/:id", h.deleteProduct)
+	// every protected product route changes the catalog
+	staff := auth.Require(auth.ManageProducts)
+	app.Post("/products", staff, h.createProduct)
+	app.Put("/product/:id", staff, h.updateProduct)
+	app.Delete("/product/:id", staff, h.deleteProduct)
+	app.Put("/api/v1/product/:id<[0-9]+>/stock", staff, h.setStock)
+	app.Post("/api/v1/product/:id<[0-9]+>/variants", staff, h.createVariant)
+	app.Put("/api/v1/product/variants/:variantId<[0-9]+>", staff, h.updateVariant)
+	app.Delete("/api/v1/product/variants/:variantId<[0-9]+>", staff, h.deleteVariant)
+	app.Post("/api/v1/product/:id<[0-9]+>/images", staff, h.uploadImage)
+	app.Put("/api/v1/product/:id<[0-9]+>/images/order", staff, h.reorderImages)
+	app.Patch("/api/v1/product/:id<[0-9]+>/images/:imageId<[0-9]+>", staff, h.updateImage)
+	app.Delete("/api/v1/product/:id<[0-9]+>/images/:imageId<[0-9]+>", staff, h.deleteImage)
 }
 
+// getProducts lists products with optional filters (minPrice, maxPrice,
+// category, minScore, inStock), sort and cursor pagination.
 func (h *Handler) getProducts(c *fiber.Ctx) error {
-	products := h.service.List()
-	return c.JSON(products)
+	q, err := listing.FromRequest(c, listing.DefaultLimit)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	return h.writePage(c, q)
 }
 
 func (h *Handler) getProduct(c *fiber.Ctx) error {
@@ -85,9 +146,9 @@ func (h *Handler) getProduct(c *fiber.Ctx) error {
 }
 
 // resetProducts clears the product table and inserts the provided list (or a default sample list).
-// This endpoint is protected by ALLOW_RESET_PRODUCTS environment variable; set it to "1" to allow.
+// It is refused unless enabled with WithDevReset (ALLOW_RESET_PRODUCTS).
 func (h *Handler) resetProducts(c *fiber.Ctx) error {
-	if os.Getenv("ALLOW_RESET_PRODUCTS") != "1" {
+	if !h.allowReset {
 		return c.Status(fiber.StatusForbidden).SendString("reset not allowed")
 	}
 
@@ -103,7 +164,6 @@ func (h *Handler) resetProducts(c *fiber.Ctx) error {
 				Name:        "Cat Scratcher Bed",
 				Description: "Comfortable cardboard cat bed",
 				Price:       840,
-				Score:       5,
 				Category:    ptrString("Pet Supplies"),
 				Pic:         ptrString("/shopping/cat-bed.svg"),
 				CreatedAt:   &now,
@@ -113,7 +173,6 @@ func (h *Handler) resetProducts(c *fiber.Ctx) error {
 				Name:        "Double Food Bowl",
 				Description: "Wooden elevated double food bowl",
 				Price:       420,
-				Score:       5,
 				Category:    ptrString("Pet Supplies"),
 				Pic:         ptrString("/shopping/double-bowl.svg"),
 				CreatedAt:   &now,
@@ -123,7 +182,6 @@ func (h *Handler) resetProducts(c *fiber.Ctx) error {
 				Name:        "Cat Sweater",
 				Description: "Warm knitted cat sweater",
 				Price:       260,
-				Score:       4,
 				Category:    ptrString("Clothes and accessories"),
 				Pic:         ptrString("/shopping/cat-sweater.svg"),
 				CreatedAt:   &now,
@@ -133,7 +191,6 @@ func (h *Handler) resetProducts(c *fiber.Ctx) error {
 				Name:        "Cheese Cat House",
 				Description: "Cute cardboard cat house",
 				Price:       399,
-				Score:       5,
 				Category:    ptrString("Cat exercise"),
 				Pic:         ptrString("/shopping/cheese-house.svg"),
 				CreatedAt:   &now,
@@ -158,9 +215,6 @@ func validateProductPayload(p *Product) map[string]string {
 	if p.Price < 0 {
 		errs["productPrice"] = "productPrice must be >= 0"
 	}
-	if p.Score < 0 || p.Score > 5 {
-		errs["score"] = "score must be between 0 and 5"
-	}
 	if p.Category != nil {
 		valid := false
 		for _, c := range AllowedCategories {
@@ -241,3 +295,221 @@ func (h *Handler) deleteProduct(c *fiber.Ctx) error {
 	}
 	return c.SendString("Product deleted")
 }
+
+// setStock sets the number of units of a product available for sale.
+func (h *Handler) setStock(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid id"})
+	}
+	var body struct {
+		Stock *int `json:"stock"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Stock == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "stock is required"})
+	}
+
+	p, err := h.service.SetStock(id, *body.Stock)
+	if err != nil {
+		switch err {
+		case ErrInvalidStock:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+		case ErrNotFound:
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Product not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+	}
+	return c.JSON(p)
+}
+
+// createVariant adds a size/flavor/weight option with its own SKU, price and
+// stock to a product.
+func (h *Handler) createVariant(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid id"})
+	}
+	var v Variant
+	if err := c.BodyParser(&v); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	v.ProductID = id
+
+	created, err := h.service.CreateVariant(v)
+	if err != nil {
+		return writeVariantError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(created)
+}
+
+func (h *Handler) updateVariant(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("variantId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid variant id"})
+	}
+	var v Variant
+	if err := c.BodyParser(&v); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+
+	updated, err := h.service.UpdateVariant(id, v)
+	if err != nil {
+		return writeVariantError(c, err)
+	}
+	return c.JSON(updated)
+}
+
+func (h *Handler) deleteVariant(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("variantId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid variant id"})
+	}
+	if err := h.service.DeleteVariant(id); err != nil {
+		return writeVariantError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func writeVariantError(c *fiber.Ctx, err error) error {
+	switch err {
+	case ErrInvalidVariant:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	case ErrNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Product not found"})
+	case ErrVariantNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": err.Error()})
+	case ErrDuplicateSKU:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+}
+
+// getImage serves a rendition of a gallery image chosen by
+// ?size=thumb|medium|large (default large). Image ids are never reused and
+// their bytes never change, so responses may be cached indefinitely.
+func (h *Handler) getImage(c *fiber.Ctx) error {
+	productID, imageID, err := imageParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid id"})
+	}
+	size, err := imaging.ParseRendition(c.Query("size"))
+	if err != nil {
+		return writeImageError(c, err)
+	}
+	img, data, err := h.service.GetImage(productID, imageID, size)
+	if err != nil {
+		return writeImageError(c, err)
+	}
+	c.Set(fiber.HeaderContentType, img.ContentType)
+	c.Set(fiber.HeaderCacheControl, "public, max-age=31536000, immutable")
+	return c.Send(data)
+}
+
+// uploadImage adds a multipart "file" to the product gallery with optional
+// "alt", "altTH" and "primary" form fields.
+func (h *Handler) uploadImage(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid id"})
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "file is required"})
+	}
+	f, err := upload.Read(file, upload.Images)
+	if err != nil {
+		return writeImageError(c, err)
+	}
+	primary, _ := strconv.ParseBool(c.FormValue("primary"))
+
+	img, err := h.service.CreateImage(Image{
+		ProductID: id,
+		Alt:       c.FormValue("alt"),
+		AltTH:     c.FormValue("altTH"),
+		Primary:   primary,
+	}, f.Data)
+	if err != nil {
+		return writeImageError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(img)
+}
+
+func (h *Handler) updateImage(c *fiber.Ctx) error {
+	productID, imageID, err := imageParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid id"})
+	}
+	var body struct {
+		Alt     string `json:"alt"`
+		AltTH   string `json:"altTH"`
+		Primary bool   `json:"primary"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	img, err := h.service.UpdateImage(Image{
+		ImageID:   imageID,
+		ProductID: productID,
+		Alt:       body.Alt,
+		AltTH:     body.AltTH,
+		Primary:   body.Primary,
+	})
+	if err != nil {
+		return writeImageError(c, err)
+	}
+	return c.JSON(img)
+}
+
+// reorderImages takes {"imageIDs": [...]} listing the whole gallery in the
+// new display order.
+func (h *Handler) reorderImages(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid id"})
+	}
+	var body struct {
+		ImageIDs []int `json:"imageIDs"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	gallery, err := h.service.ReorderImages(id, body.ImageIDs)
+	if err != nil {
+		return writeImageError(c, err)
+	}
+	return c.JSON(gallery)
+}
+
+func (h *Handler) deleteImage(c *fiber.Ctx) error {
+	productID, imageID, err := imageParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid id"})
+	}
+	if err := h.service.DeleteImage(productID, imageID); err != nil {
+		return writeImageError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func imageParams(c *fiber.Ctx) (productID, imageID int, err error) {
+	if productID, err = strconv.Atoi(c.Params("id")); err != nil {
+		return 0, 0, err
+	}
+	imageID, err = strconv.Atoi(c.Params("imageId"))
+	return productID, imageID, err
+}
+
+func writeImageError(c *fiber.Ctx, err error) error {
+	switch err {
+	case imaging.ErrUnsupportedFormat, imaging.ErrInvalidRendition, ErrInvalidImageAlt, ErrInvalidImageOrder,
+		upload.ErrUnsupportedType, upload.ErrArchive, upload.ErrScript:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	case imaging.ErrTooLarge, upload.ErrTooLarge:
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"message": err.Error()})
+	case ErrNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Product not found"})
+	case ErrImageNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+}
@@ -1,13 +1,17 @@
 package product
 
 import (
+	"encoding/json"
 	"io"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
 	"github.com/wichananm65/pet-shop-backend/internal/favorite"
+	"github.com/wichananm65/pet-shop-backend/internal/listing"
 	"github.com/wichananm65/pet-shop-backend/internal/user"
 )
 
@@ -104,3 +108,231 @@ func TestGetProductsByCategory(t *testing.T) {
 		t.Fatalf("expected 400 for bad id, got %d", res2.StatusCode)
 	}
 }
+
+// withRole stores a token with the given role the way the JWT middleware
+// would.
+func withRole(role auth.Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("user", &jwt.Token{Claims: jwt.MapClaims{"user_id": 1, auth.RoleClaim: string(role)}})
+		return c.Next()
+	}
+}
+
+func TestProtectedRoutes_RequireStaff(t *testing.T) {
+	r := NewInMemoryRepository([]Product{{ID: 1, Name: "A", Price: 10}})
+	h := NewHandler(NewService(r))
+
+	for _, tc := range []struct {
+		role auth.Role
+		want int
+	}{
+		{auth.RoleCustomer, fiber.StatusForbidden},
+		{auth.RoleStaff, fiber.StatusOK},
+		{auth.RoleAdmin, fiber.StatusOK},
+	} {
+		app := fiber.New()
+		app.Use(withRole(tc.role))
+		h.RegisterProtectedRoutes(app)
+		req := httptest.NewRequest("PUT", "/api/v1/product/1/stock", strings.NewReader(`{"stock":5}`))
+		req.Header.Set("Content-Type", "application/json")
+		res, _ := app.Test(req)
+		if res.StatusCode != tc.want {
+			t.Errorf("%s: expected %d got %d", tc.role, tc.want, res.StatusCode)
+		}
+	}
+
+	app := fiber.New()
+	h.RegisterProtectedRoutes(app)
+	res, _ := app.Test(httptest.NewRequest("DELETE", "/product/1", nil))
+	if res.StatusCode != fiber.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", res.StatusCode)
+	}
+}
+
+func TestSetStock(t *testing.T) {
+	r := NewInMemoryRepository([]Product{{ID: 1, Name: "A", Price: 10}})
+	h := NewHandler(NewService(r))
+	app := fiber.New()
+	app.Use(withRole(auth.RoleStaff))
+	h.RegisterPublicRoutes(app)
+	h.RegisterProtectedRoutes(app)
+
+	req := httptest.NewRequest("PUT", "/api/v1/product/1/stock", strings.NewReader(`{"stock":5}`))
+	req.Header.Set("Content-Type", "application/json")
+	res, _ := app.Test(req)
+	if res.StatusCode != 200 {
+		t.Fatalf("expected 200 got %d", res.StatusCode)
+	}
+
+	res, _ = app.Test(httptest.NewRequest("GET", "/api/v1/product/1", nil))
+	body, _ := io.ReadAll(res.Body)
+	if !strings.Contains(string(body), `"stock":5`) || !strings.Contains(string(body), `"inStock":true`) {
+		t.Fatalf("expected stock in product detail, got %s", body)
+	}
+
+	req = httptest.NewRequest("PUT", "/api/v1/product/1/stock", strings.NewReader(`{"stock":-1}`))
+	req.Header.Set("Content-Type", "application/json")
+	res, _ = app.Test(req)
+	if res.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 for negative stock, got %d", res.StatusCode)
+	}
+
+	req = httptest.NewRequest("PUT", "/api/v1/product/99/stock", strings.NewReader(`{"stock":1}`))
+	req.Header.Set("Content-Type", "application/json")
+	res, _ = app.Test(req)
+	if res.StatusCode != fiber.StatusNotFound {
+		t.Fatalf("expected 404 for unknown product, got %d", res.StatusCode)
+	}
+}
+
+func TestProductVariants(t *testing.T) {
+	r := NewInMemoryRepository([]Product{{ID: 1, Name: "Dog Food", Price: 300}})
+	h := NewHandler(NewService(r))
+	app := fiber.New()
+	app.Use(withRole(auth.RoleStaff))
+	h.RegisterPublicRoutes(app)
+	h.RegisterProtectedRoutes(app)
+
+	req := httptest.NewRequest("POST", "/api/v1/product/1/variants", strings.NewReader(`{"sku":"DOG-1KG","options":{"weight":"1kg"},"price":300,"stock":4}`))
+	req.Header.Set("Content-Type", "application/json")
+	res, _ := app.Test(req)
+	if res.StatusCode != fiber.StatusCreated {
+		t.Fatalf("expected 201 got %d", res.StatusCode)
+	}
+	req = httptest.NewRequest("POST", "/api/v1/product/1/variants", strings.NewReader(`{"sku":"DOG-3KG","options":{"weight":"3kg"},"price":800}`))
+	req.Header.Set("Content-Type", "application/json")
+	app.Test(req)
+
+	res, _ = app.Test(httptest.NewRequest("GET", "/api/v1/product/1", nil))
+	body, _ := io.ReadAll(res.Body)
+	str := string(body)
+	if !strings.Contains(str, `"sku":"DOG-1KG"`) || !strings.Contains(str, `"weight":"3kg"`) || !strings.Contains(str, `"price":800`) {
+		t.Fatalf("expected variants in product detail, got %s", str)
+	}
+
+	req = httptest.NewRequest("PUT", "/api/v1/product/variants/2", strings.NewReader(`{"sku":"DOG-3KG","options":{"weight":"3kg"},"price":750,"stock":2}`))
+	req.Header.Set("Content-Type", "application/json")
+	res, _ = app.Test(req)
+	if res.StatusCode != 200 {
+		t.Fatalf("expected 200 updating variant, got %d", res.StatusCode)
+	}
+	if vs, _ := r.ListVariantsByIDs([]int{2}); len(vs) != 1 || vs[0].Price != 750 || !vs[0].InStock {
+		t.Fatalf("variant not updated: %+v", vs)
+	}
+
+	// a variant needs a sku and cannot be added to a missing product
+	req = httptest.NewRequest("POST", "/api/v1/product/1/variants", strings.NewReader(`{"price":10}`))
+	req.Header.Set("Content-Type", "application/json")
+	if res, _ = app.Test(req); res.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 without sku, got %d", res.StatusCode)
+	}
+	req = httptest.NewRequest("POST", "/api/v1/product/9/variants", strings.NewReader(`{"sku":"X","price":10}`))
+	req.Header.Set("Content-Type", "application/json")
+	if res, _ = app.Test(req); res.StatusCode != fiber.StatusNotFound {
+		t.Fatalf("expected 404 for unknown product, got %d", res.StatusCode)
+	}
+	// skus are unique across all variants
+	req = httptest.NewRequest("POST", "/api/v1/product/1/variants", strings.NewReader(`{"sku":"DOG-1KG","price":10}`))
+	req.Header.Set("Content-Type", "application/json")
+	if res, _ = app.Test(req); res.StatusCode != fiber.StatusConflict {
+		t.Fatalf("expected 409 for a duplicate sku, got %d", res.StatusCode)
+	}
+	req = httptest.NewRequest("PUT", "/api/v1/product/variants/2", strings.NewReader(`{"sku":"DOG-1KG","price":10}`))
+	req.Header.Set("Content-Type", "application/json")
+	if res, _ = app.Test(req); res.StatusCode != fiber.StatusConflict {
+		t.Fatalf("expected 409 renaming to a taken sku, got %d", res.StatusCode)
+	}
+
+	if res, _ = app.Test(httptest.NewRequest("DELETE", "/api/v1/product/variants/1", nil)); res.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("expected 204 deleting variant, got %d", res.StatusCode)
+	}
+	if res, _ = app.Test(httptest.NewRequest("DELETE", "/api/v1/product/variants/1", nil)); res.StatusCode != fiber.StatusNotFound {
+		t.Fatalf("expected 404 deleting twice, got %d", res.StatusCode)
+	}
+}
+
+func TestGetProducts_FiltersSortAndCursor(t *testing.T) {
+	seed := []Product{
+		{ID: 1, Name: "A", Price: 100, Score: 5, Stock: 1, Category: ptrString("catA")},
+		{ID: 2, Name: "B", Price: 50, Score: 3, Stock: 0, Category: ptrString("catA")},
+		{ID: 3, Name: "C", Price: 300, Score: 4, Stock: 2, Category: ptrString("catB")},
+		{ID: 4, Name: "D", Price: 200, Score: 1, Stock: 5, Category: ptrString("catA")},
+	}
+	r := NewInMemoryRepository(seed)
+	r.CategoryNames = map[int]string{100: "catA", 200: "catB"}
+	app := fiber.New()
+	NewHandler(NewService(r)).RegisterPublicRoutes(app)
+
+	get := func(query string) (int, listing.Page[Product]) {
+		res, err := app.Test(httptest.NewRequest("GET", "/products?"+query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var page listing.Page[Product]
+		json.NewDecoder(res.Body).Decode(&page)
+		return res.StatusCode, page
+	}
+	ids := func(p listing.Page[Product]) string {
+		out := make([]string, 0, len(p.Items))
+		for _, it := range p.Items {
+			out = append(out, it.Name)
+		}
+		return strings.Join(out, ",")
+	}
+
+	if _, page := get(""); ids(page) != "D,C,B,A" || page.NextCursor != "" {
+		t.Fatalf("default newest order unexpected: %s %q", ids(page), page.NextCursor)
+	}
+	if _, page := get("category=100&inStock=true&sort=price_asc"); ids(page) != "A,D" {
+		t.Fatalf("unexpected filtered result %s", ids(page))
+	}
+	if _, page := get("minPrice=60&maxPrice=250&minScore=2"); ids(page) != "A" {
+		t.Fatalf("unexpected price/score filter result %s", ids(page))
+	}
+
+	status, page := get("sort=score&limit=2")
+	if status != 200 || ids(page) != "A,C" || page.NextCursor == "" {
+		t.Fatalf("unexpected first page %d %s %q", status, ids(page), page.NextCursor)
+	}
+	if _, page = get("sort=score&limit=2&cursor=" + page.NextCursor); ids(page) != "B,D" || page.NextCursor != "" {
+		t.Fatalf("unexpected second page %s %q", ids(page), page.NextCursor)
+	}
+
+	if status, _ = get("sort=price_asc&cursor=" + listing.Cursor{Sort: listing.SortScore, ID: 1}.Encode()); status != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 for cursor of another sort, got %d", status)
+	}
+}
+
+func TestProductWrites_IgnoreScore(t *testing.T) {
+	r := NewInMemoryRepository([]Product{{ID: 1, Name: "A", Price: 10, Score: 4}})
+	h := NewHandler(NewService(r))
+	app := fiber.New()
+	app.Use(withRole(auth.RoleStaff))
+	h.RegisterProtectedRoutes(app)
+
+	send := func(method, path, body string) Product {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != fiber.StatusOK && res.StatusCode != fiber.StatusCreated {
+			t.Fatalf("%s %s: unexpected status %d", method, path, res.StatusCode)
+		}
+		var p Product
+		json.NewDecoder(res.Body).Decode(&p)
+		return p
+	}
+
+	// score is derived from reviews; a staff edit must not overwrite it
+	if p := send("PUT", "/product/1", `{"productName":"A","productPrice":12,"score":1}`); p.Score != 4 || p.Price != 12 {
+		t.Fatalf("expected the update to keep score 4, got %+v", p)
+	}
+	if p, _ := r.GetByID(1); p.Score != 4 {
+		t.Fatalf("expected the stored score to stay 4, got %d", p.Score)
+	}
+	if p := send("POST", "/products", `{"productName":"B","productPrice":5,"score":5}`); p.Score != 0 {
+		t.Fatalf("expected a new product to start without a score, got %d", p.Score)
+	}
+}
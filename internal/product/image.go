@@ -0,0 +1,73 @@
+package product
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/wichananm65/pet-shop-backend/internal/imaging"
+	"github.com/wichananm65/pet-shop-backend/internal/upload"
+)
+
+// MaxImageAltLength caps the alt text of a gallery image in either language.
+const MaxImageAltLength = 250
+
+var (
+	ErrImageNotFound     = errors.New("image not found")
+	ErrInvalidImageAlt   = errors.New("alt text must be at most 250 characters")
+	ErrInvalidImageOrder = errors.New("order must list every image of the product exactly once")
+)
+
+// Image is one entry of a product's gallery. Position is 0-based and
+// contiguous; exactly one image of a non-empty gallery is primary. Every
+// image is stored as thumb, medium and large renditions sharing ContentType;
+// StorageKey is the blob key of the large one.
+type Image struct {
+	ImageID     int    `json:"imageID"`
+	ProductID   int    `json:"productID"`
+	URL         string `json:"url"`
+	Position    int    `json:"position"`
+	Alt         string `json:"alt"`
+	AltTH       string `json:"altTH"`
+	Primary     bool   `json:"primary"`
+	ContentType string `json:"contentType"`
+	StorageKey  string `json:"-"`
+}
+
+// ImageURL is the public path an image's bytes are served from; append
+// ?size=thumb|medium|large to pick a rendition.
+func ImageURL(productID, imageID int) string {
+	return fmt.Sprintf("/api/v1/product/%d/images/%d", productID, imageID)
+}
+
+// imageKey is the blob key of a gallery image's large rendition. Keys are
+// derived from the content, so the same image added twice shares its blobs.
+func imageKey(productID int, large imaging.Encoded) string {
+	return fmt.Sprintf("products/%d/%s", productID, upload.Name(large.Data, large.Ext()))
+}
+
+func validateImageAlt(img Image) error {
+	if utf8.RuneCountInString(img.Alt) > MaxImageAltLength || utf8.RuneCountInString(img.AltTH) > MaxImageAltLength {
+		return ErrInvalidImageAlt
+	}
+	return nil
+}
+
+// sameImageSet reports whether ids names every image in the gallery exactly
+// once.
+func sameImageSet(gallery []Image, ids []int) bool {
+	if len(ids) != len(gallery) {
+		return false
+	}
+	want := make(map[int]bool, len(gallery))
+	for _, img := range gallery {
+		want[img.ImageID] = true
+	}
+	for _, id := range ids {
+		if !want[id] {
+			return false
+		}
+		delete(want, id)
+	}
+	return true
+}
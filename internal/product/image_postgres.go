@@ -0,0 +1,264 @@
+package product
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+	"github.com/wichananm65/pet-shop-backend/internal/imaging"
+)
+
+const (
+	imageColumns     = `image_id, product_id, position, alt_en, alt_th, is_primary, content_type, COALESCE(storage_key, '')`
+	listImagesQuery  = `SELECT ` + imageColumns + ` FROM product_images WHERE product_id = $1 ORDER BY position, image_id`
+	insertImageQuery = `
+		INSERT INTO product_images (product_id, position, alt_en, alt_th, is_primary, content_type, storage_key)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)
+		RETURNING image_id
+	`
+	// clearPrimaryQuery must run before another image is made primary, as
+	// product_images_primary_key allows one primary image per product.
+	clearPrimaryQuery  = `UPDATE product_images SET is_primary = false WHERE product_id = $1 AND is_primary AND image_id <> $2`
+	reorderImagesQuery = `
+		UPDATE product_images i
+		SET position = t.pos - 1
+		FROM unnest($2::int[]) WITH ORDINALITY AS t(id, pos)
+		WHERE i.image_id = t.id AND i.product_id = $1
+	`
+	compactPositionsQuery = `
+		UPDATE product_images i
+		SET position = t.rn - 1
+		FROM (
+			SELECT image_id, row_number() OVER (ORDER BY position, image_id) AS rn
+			FROM product_images
+			WHERE product_id = $1
+		) t
+		WHERE i.image_id = t.image_id
+	`
+	promoteFirstImageQuery = `
+		UPDATE product_images SET is_primary = true
+		WHERE image_id = (SELECT image_id FROM product_images WHERE product_id = $1 ORDER BY position, image_id LIMIT 1)
+	`
+)
+
+// renditionColumns maps a rendition to the column holding it for images
+// stored in the table before blob storage existed. data holds the large
+// rendition, and the only copy for images stored before renditions existed,
+// so it is the fallback for the smaller sizes.
+var renditionColumns = map[imaging.Rendition]string{
+	imaging.Thumb:  `COALESCE(thumb_data, data)`,
+	imaging.Medium: `COALESCE(medium_data, data)`,
+	imaging.Large:  `data`,
+}
+
+func (r *PostgresRepository) ListImages(productID int) ([]Image, error) {
+	return listImages(r.db, productID)
+}
+
+// CreateImage appends an image to the end of the gallery. The first image of
+// a product becomes primary regardless of img.Primary.
+func (r *PostgresRepository) CreateImage(img Image) (Image, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return Image{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := lockProduct(tx, img.ProductID); err != nil {
+		return Image{}, err
+	}
+	var count int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(position) + 1, 0), COUNT(*) FROM product_images WHERE product_id = $1`, img.ProductID).Scan(&img.Position, &count); err != nil {
+		return Image{}, err
+	}
+	if count == 0 {
+		img.Primary = true
+	}
+	if img.Primary {
+		if _, err := tx.Exec(clearPrimaryQuery, img.ProductID, 0); err != nil {
+			return Image{}, err
+		}
+	}
+	if err := tx.QueryRow(insertImageQuery, img.ProductID, img.Position, img.Alt, img.AltTH, img.Primary, img.ContentType,
+		img.StorageKey).Scan(&img.ImageID); err != nil {
+		return Image{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Image{}, err
+	}
+	img.URL = ImageURL(img.ProductID, img.ImageID)
+	return img, nil
+}
+
+// GetImage returns an image, together with the bytes of one rendition when
+// it predates blob storage.
+func (r *PostgresRepository) GetImage(productID, imageID int, size imaging.Rendition) (Image, []byte, error) {
+	col, ok := renditionColumns[size]
+	if !ok {
+		return Image{}, nil, imaging.ErrInvalidRendition
+	}
+	var data []byte
+	row := r.db.QueryRow(`SELECT `+imageColumns+`, `+col+` FROM product_images WHERE product_id = $1 AND image_id = $2`, productID, imageID)
+	img, err := scanImage(row, &data)
+	if err == sql.ErrNoRows {
+		return Image{}, nil, ErrImageNotFound
+	}
+	if err != nil {
+		return Image{}, nil, err
+	}
+	return img, data, nil
+}
+
+// UpdateImage changes the alt texts of an image and, when img.Primary is
+// set, makes it the primary image. An image cannot be demoted directly;
+// promote another image instead.
+func (r *PostgresRepository) UpdateImage(img Image) (Image, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return Image{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := lockProduct(tx, img.ProductID); err != nil {
+		return Image{}, err
+	}
+	if img.Primary {
+		if _, err := tx.Exec(clearPrimaryQuery, img.ProductID, img.ImageID); err != nil {
+			return Image{}, err
+		}
+	}
+	row := tx.QueryRow(`
+		UPDATE product_images SET alt_en = $1, alt_th = $2, is_primary = is_primary OR $3
+		WHERE product_id = $4 AND image_id = $5
+		RETURNING `+imageColumns, img.Alt, img.AltTH, img.Primary, img.ProductID, img.ImageID)
+	updated, err := scanImage(row)
+	if err == sql.ErrNoRows {
+		return Image{}, ErrImageNotFound
+	}
+	if err != nil {
+		return Image{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Image{}, err
+	}
+	return updated, nil
+}
+
+// ReorderImages sets the gallery order to imageIDs, which must name every
+// image of the product exactly once.
+func (r *PostgresRepository) ReorderImages(productID int, imageIDs []int) ([]Image, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := lockProduct(tx, productID); err != nil {
+		return nil, err
+	}
+	gallery, err := listImages(tx, productID)
+	if err != nil {
+		return nil, err
+	}
+	if !sameImageSet(gallery, imageIDs) {
+		return nil, ErrInvalidImageOrder
+	}
+	if _, err := tx.Exec(reorderImagesQuery, productID, pq.Array(imageIDs)); err != nil {
+		return nil, err
+	}
+	gallery, err = listImages(tx, productID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return gallery, nil
+}
+
+// DeleteImage removes an image, closes the gap in positions and promotes
+// the new first image when the primary one was deleted.
+func (r *PostgresRepository) DeleteImage(productID, imageID int) (Image, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return Image{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := lockProduct(tx, productID); err != nil {
+		return Image{}, err
+	}
+	deleted, err := scanImage(tx.QueryRow(`DELETE FROM product_images WHERE product_id = $1 AND image_id = $2 RETURNING `+imageColumns, productID, imageID))
+	if err == sql.ErrNoRows {
+		return Image{}, ErrImageNotFound
+	}
+	if err != nil {
+		return Image{}, err
+	}
+	if _, err := tx.Exec(compactPositionsQuery, productID); err != nil {
+		return Image{}, err
+	}
+	if deleted.Primary {
+		if _, err := tx.Exec(promoteFirstImageQuery, productID); err != nil {
+			return Image{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return Image{}, err
+	}
+	return deleted, nil
+}
+
+// lockProduct serializes gallery changes per product so positions and the
+// primary flag stay consistent.
+func lockProduct(tx *sql.Tx, productID int) error {
+	var id int
+	err := tx.QueryRow(`SELECT productid FROM products WHERE productid = $1 FOR UPDATE`, productID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func listImages(q queryer, productID int) ([]Image, error) {
+	rows, err := q.Query(listImagesQuery, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]Image, 0)
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, img)
+	}
+	return out, rows.Err()
+}
+
+type imageScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanImage reads imageColumns followed by any extra destinations.
+func scanImage(row imageScanner, extra ...any) (Image, error) {
+	var img Image
+	dest := append([]any{&img.ImageID, &img.ProductID, &img.Position, &img.Alt, &img.AltTH, &img.Primary, &img.ContentType, &img.StorageKey}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return Image{}, err
+	}
+	img.URL = ImageURL(img.ProductID, img.ImageID)
+	return img, nil
+}
@@ -0,0 +1,125 @@
+package product
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
+	"github.com/wichananm65/pet-shop-backend/internal/storage"
+)
+
+// photo is an opaque 800x400 PNG upload.
+var photo = func() []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 800, 400))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.NRGBA{30, 120, 200, 255}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}()
+
+func TestProductGallery(t *testing.T) {
+	r := NewInMemoryRepository([]Product{{ID: 1, Name: "Cat Tree", Price: 1200}})
+	blobs := storage.NewMemory(nil)
+	h := NewHandler(NewService(r).WithStorage(blobs))
+	app := fiber.New()
+	app.Use(withRole(auth.RoleStaff))
+	h.RegisterPublicRoutes(app)
+	h.RegisterProtectedRoutes(app)
+
+	upload := func(productID string, data []byte, fields map[string]string) int {
+		var buf bytes.Buffer
+		w := multipart.NewWriter(&buf)
+		fw, _ := w.CreateFormFile("file", "photo.png")
+		fw.Write(data)
+		for k, v := range fields {
+			w.WriteField(k, v)
+		}
+		w.Close()
+		req := httptest.NewRequest("POST", "/api/v1/product/"+productID+"/images", &buf)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		res, _ := app.Test(req)
+		return res.StatusCode
+	}
+
+	if code := upload("1", photo, map[string]string{"alt": "front", "altTH": "ด้านหน้า"}); code != fiber.StatusCreated {
+		t.Fatalf("expected 201, got %d", code)
+	}
+	upload("1", photo, map[string]string{"alt": "side"})
+	upload("1", photo, map[string]string{"alt": "top", "primary": "true"})
+	if code := upload("1", []byte("<svg xmlns='http://www.w3.org/2000/svg'/>"), nil); code != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 for a non-image upload, got %d", code)
+	}
+	if code := upload("9", photo, nil); code != fiber.StatusNotFound {
+		t.Fatalf("expected 404 for unknown product, got %d", code)
+	}
+
+	req := httptest.NewRequest("PUT", "/api/v1/product/1/images/order", strings.NewReader(`{"imageIDs":[3,1]}`))
+	req.Header.Set("Content-Type", "application/json")
+	if res, _ := app.Test(req); res.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 for an incomplete order, got %d", res.StatusCode)
+	}
+	req = httptest.NewRequest("PUT", "/api/v1/product/1/images/order", strings.NewReader(`{"imageIDs":[3,1,2]}`))
+	req.Header.Set("Content-Type", "application/json")
+	if res, _ := app.Test(req); res.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200 reordering, got %d", res.StatusCode)
+	}
+
+	// deleting the primary image promotes the new first one
+	if res, _ := app.Test(httptest.NewRequest("DELETE", "/api/v1/product/1/images/3", nil)); res.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("expected 204, got %d", res.StatusCode)
+	}
+
+	res, _ := app.Test(httptest.NewRequest("GET", "/api/v1/product/1", nil))
+	var p ProductV1
+	if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Gallery) != 2 {
+		t.Fatalf("expected 2 images, got %+v", p.Gallery)
+	}
+	first, second := p.Gallery[0], p.Gallery[1]
+	if first.ImageID != 1 || first.Position != 0 || !first.Primary || first.AltTH != "ด้านหน้า" {
+		t.Fatalf("unexpected first image: %+v", first)
+	}
+	if second.ImageID != 2 || second.Position != 1 || second.Primary {
+		t.Fatalf("unexpected second image: %+v", second)
+	}
+
+	// uploads are re-encoded; opaque images become JPEG renditions
+	res, _ = app.Test(httptest.NewRequest("GET", first.URL+"?size=thumb", nil))
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != fiber.StatusOK || res.Header.Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("unexpected image response %d %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(body))
+	if err != nil || cfg.Width != 200 || cfg.Height != 100 {
+		t.Fatalf("expected a 200x100 thumbnail, got %+v (%v)", cfg, err)
+	}
+	if res, _ = app.Test(httptest.NewRequest("GET", first.URL+"?size=huge", nil)); res.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown size, got %d", res.StatusCode)
+	}
+
+	// identical uploads share one set of blobs, removed with the last image
+	if keys := blobs.Keys(); len(keys) != 3 {
+		t.Fatalf("expected one stored rendition set, got %v", keys)
+	}
+	app.Test(httptest.NewRequest("DELETE", "/api/v1/product/1/images/1", nil))
+	if keys := blobs.Keys(); len(keys) != 3 {
+		t.Fatalf("expected blobs to be kept while still in use, got %v", keys)
+	}
+	app.Test(httptest.NewRequest("DELETE", "/api/v1/product/1/images/2", nil))
+	if keys := blobs.Keys(); len(keys) != 0 {
+		t.Fatalf("expected blobs to be deleted, got %v", keys)
+	}
+}
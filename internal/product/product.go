@@ -1,7 +1,10 @@
 package product
 
-// Product represents a product in the system and maps to the `public.product` table.
-// JSON tags follow the camelCase convention used elsewhere in the project.
+// Product represents a product in the system and maps to a row of the
+// `products` table, the same row ProductV1 is read from. NameEn and
+// DescriptionEn are the English texts (productnameen, productdescen); the
+// Thai ones are only in ProductV1. JSON tags follow the camelCase convention
+// used elsewhere in the project.
 type Product struct {
 	ID            int     `json:"productId"`
 	Name          string  `json:"productName"`
@@ -11,6 +14,7 @@ type Product struct {
 	Description   string  `json:"productDesc"`
 	DescriptionEn *string `json:"productDescEn,omitempty"`
 	Category      *string `json:"category,omitempty"`
+	Stock         int     `json:"stock,omitempty"`
 	Pic           *string `json:"productPic,omitempty"`
 	PicSecond     *string `json:"productPicSecond,omitempty"`
 	CreatedAt     *string `json:"createdAt,omitempty"`
@@ -19,6 +23,7 @@ type Product struct {
 
 // ProductV1 is the API v1 product detail shape (used by `/api/v1/product/:id`).
 // Field names follow the `products`-style contract used by other v1 endpoints.
+// A product whose stock is not tracked reads as 0 units but in stock.
 type ProductV1 struct {
 	ProductID     int     `json:"productID"`
 	ProductName   *string `json:"productName,omitempty"`
@@ -29,6 +34,15 @@ type ProductV1 struct {
 	ProductDescTH *string `json:"productDescTH,omitempty"`
 	Score         *int    `json:"score,omitempty"`
 	Category      *string `json:"category,omitempty"`
+	// RatingAverage and RatingCount are cached from customer reviews; Score
+	// is the average rounded to a whole star.
+	RatingAverage float64   `json:"ratingAverage"`
+	RatingCount   int       `json:"ratingCount"`
+	Stock         int       `json:"stock"`
+	InStock       bool      `json:"inStock"`
+	Variants      []Variant `json:"variants,omitempty"`
+	// Gallery lists the product images in display order.
+	Gallery []Image `json:"gallery,omitempty"`
 }
 
 // AllowedCategories contains the supported product categories used across the app.
@@ -2,16 +2,26 @@ package product
 
 import (
 	"errors"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+
+	"github.com/wichananm65/pet-shop-backend/internal/imaging"
+	"github.com/wichananm65/pet-shop-backend/internal/listing"
 )
 
 var (
-	ErrNotFound = errors.New("product not found")
+	ErrNotFound     = errors.New("product not found")
+	ErrInvalidStock = errors.New("stock must be zero or more")
 )
 
 type Repository interface {
 	List() []Product
+	// ListPage returns the products matching the listing filters in listing
+	// order, starting after the query cursor. It returns up to q.Limit+1
+	// products so the caller can tell whether another page follows.
+	ListPage(q listing.Query) ([]Product, error)
 	GetByID(id int) (Product, error)
 	// ListByCategoryID returns all products belonging to the given category id.
 	// The implementation is free to interpret categories however makes sense
@@ -25,6 +35,32 @@ type Repository interface {
 	// ids is empty the implementation should return an empty slice without
 	// performing any database work.
 	ListV1ByIDs(ids []int) ([]ProductV1, error)
+	// SetStock sets the number of units available for sale.
+	SetStock(id int, stock int) error
+	// ListVariants returns the variants of a product ordered by id.
+	ListVariants(productID int) ([]Variant, error)
+	// ListVariantsByIDs returns the variants with the given ids; unknown ids
+	// are skipped.
+	ListVariantsByIDs(ids []int) ([]Variant, error)
+	CreateVariant(v Variant) (Variant, error)
+	UpdateVariant(id int, v Variant) (Variant, error)
+	DeleteVariant(id int) error
+	// ListImages returns a product's gallery ordered by position.
+	ListImages(productID int) ([]Image, error)
+	// CreateImage appends an image, whose renditions are already stored
+	// under img.StorageKey, to the gallery.
+	CreateImage(img Image) (Image, error)
+	// GetImage returns an image. Images stored before blob storage existed
+	// have no StorageKey and come with the bytes of the requested rendition.
+	GetImage(productID, imageID int, size imaging.Rendition) (Image, []byte, error)
+	UpdateImage(img Image) (Image, error)
+	ReorderImages(productID int, imageIDs []int) ([]Image, error)
+	// DeleteImage removes an image and returns it so its blobs can be
+	// deleted as well.
+	DeleteImage(productID, imageID int) (Image, error)
+	// Search returns one page of products matching a normalized query,
+	// best match first.
+	Search(q SearchQuery) (SearchResult, error)
 	Create(p Product) (Product, error)
 	Update(id int, p Product) (Product, error)
 	Delete(id int) error
@@ -35,9 +71,13 @@ type Repository interface {
 // InMemoryRepository is a simple in-memory implementation useful for tests and
 // seeding local data.
 type InMemoryRepository struct {
-	mu      sync.RWMutex
-	storage []Product
-	nextID  int
+	mu            sync.RWMutex
+	storage       []Product
+	nextID        int
+	variants      []Variant
+	nextVariantID int
+	images        []Image
+	nextImageID   int
 	// optional mapping used by ListByCategoryID; tests can populate this
 	// to provide human-readable names associated with numeric IDs.
 	CategoryNames map[int]string
@@ -45,8 +85,10 @@ type InMemoryRepository struct {
 
 func NewInMemoryRepository(seed []Product) *InMemoryRepository {
 	r := &InMemoryRepository{
-		storage: make([]Product, 0, len(seed)),
-		nextID:  1,
+		storage:       make([]Product, 0, len(seed)),
+		nextID:        1,
+		nextVariantID: 1,
+		nextImageID:   1,
 	}
 
 	maxID := 0
@@ -121,6 +163,16 @@ func (r *InMemoryRepository) GetV1ByID(id int) (ProductV1, error) {
 		ProductDesc:  &p.Description,
 		Score:        &p.Score,
 		Category:     p.Category,
+		Stock:        p.Stock,
+		InStock:      p.Stock > 0,
+	}
+	variants, _ := r.ListVariants(id)
+	if len(variants) > 0 {
+		res.Variants = variants
+	}
+	gallery, _ := r.ListImages(id)
+	if len(gallery) > 0 {
+		res.Gallery = gallery
 	}
 	// In-memory store doesn't have distinct TH fields — leave them nil.
 	return res, nil
@@ -141,6 +193,311 @@ func (r *InMemoryRepository) ListV1ByIDs(ids []int) ([]ProductV1, error) {
 	return out, nil
 }
 
+func (r *InMemoryRepository) SetStock(id int, stock int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.storage {
+		if r.storage[i].ID == id {
+			r.storage[i].Stock = stock
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *InMemoryRepository) ListVariants(productID int) ([]Variant, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Variant, 0)
+	for _, v := range r.variants {
+		if v.ProductID == productID {
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+func (r *InMemoryRepository) ListVariantsByIDs(ids []int) ([]Variant, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Variant, 0, len(ids))
+	for _, id := range ids {
+		for _, v := range r.variants {
+			if v.VariantID == id {
+				out = append(out, v)
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+func (r *InMemoryRepository) CreateVariant(v Variant) (Variant, error) {
+	if _, err := r.GetByID(v.ProductID); err != nil {
+		return Variant{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.skuTaken(v.SKU, 0) {
+		return Variant{}, ErrDuplicateSKU
+	}
+	v.VariantID = r.nextVariantID
+	r.nextVariantID++
+	v.InStock = v.Stock > 0
+	r.variants = append(r.variants, v)
+	return v, nil
+}
+
+func (r *InMemoryRepository) UpdateVariant(id int, v Variant) (Variant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.skuTaken(v.SKU, id) {
+		return Variant{}, ErrDuplicateSKU
+	}
+	for i := range r.variants {
+		if r.variants[i].VariantID == id {
+			v.VariantID = id
+			v.ProductID = r.variants[i].ProductID
+			v.InStock = v.Stock > 0
+			r.variants[i] = v
+			return v, nil
+		}
+	}
+	return Variant{}, ErrVariantNotFound
+}
+
+// skuTaken reports whether a variant other than exceptID uses sku; the
+// caller holds the lock.
+func (r *InMemoryRepository) skuTaken(sku string, exceptID int) bool {
+	for _, v := range r.variants {
+		if v.SKU == sku && v.VariantID != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *InMemoryRepository) DeleteVariant(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.variants {
+		if r.variants[i].VariantID == id {
+			r.variants = append(r.variants[:i], r.variants[i+1:]...)
+			return nil
+		}
+	}
+	return ErrVariantNotFound
+}
+
+// ListImages returns the gallery of a product. Images are kept in gallery
+// order, so no sorting is needed.
+func (r *InMemoryRepository) ListImages(productID int) ([]Image, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.galleryLocked(productID), nil
+}
+
+func (r *InMemoryRepository) CreateImage(img Image) (Image, error) {
+	if _, err := r.GetByID(img.ProductID); err != nil {
+		return Image{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	gallery := r.galleryLocked(img.ProductID)
+	img.ImageID = r.nextImageID
+	r.nextImageID++
+	img.Position = len(gallery)
+	img.Primary = img.Primary || len(gallery) == 0
+	img.URL = ImageURL(img.ProductID, img.ImageID)
+	if img.Primary {
+		r.setPrimaryLocked(img.ProductID, img.ImageID)
+	}
+	r.images = append(r.images, img)
+	return img, nil
+}
+
+func (r *InMemoryRepository) GetImage(productID, imageID int, size imaging.Rendition) (Image, []byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	i := r.imageIndexLocked(productID, imageID)
+	if i < 0 {
+		return Image{}, nil, ErrImageNotFound
+	}
+	return r.images[i], nil, nil
+}
+
+func (r *InMemoryRepository) UpdateImage(img Image) (Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := r.imageIndexLocked(img.ProductID, img.ImageID)
+	if i < 0 {
+		return Image{}, ErrImageNotFound
+	}
+	r.images[i].Alt = img.Alt
+	r.images[i].AltTH = img.AltTH
+	if img.Primary {
+		r.setPrimaryLocked(img.ProductID, img.ImageID)
+	}
+	return r.images[i], nil
+}
+
+func (r *InMemoryRepository) ReorderImages(productID int, imageIDs []int) ([]Image, error) {
+	if _, err := r.GetByID(productID); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !sameImageSet(r.galleryLocked(productID), imageIDs) {
+		return nil, ErrInvalidImageOrder
+	}
+	for pos, id := range imageIDs {
+		r.images[r.imageIndexLocked(productID, id)].Position = pos
+	}
+	r.sortImagesLocked()
+	return r.galleryLocked(productID), nil
+}
+
+func (r *InMemoryRepository) DeleteImage(productID, imageID int) (Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := r.imageIndexLocked(productID, imageID)
+	if i < 0 {
+		return Image{}, ErrImageNotFound
+	}
+	deleted := r.images[i]
+	r.images = append(r.images[:i], r.images[i+1:]...)
+	pos := 0
+	for j := range r.images {
+		if r.images[j].ProductID == productID {
+			r.images[j].Position = pos
+			if deleted.Primary && pos == 0 {
+				r.images[j].Primary = true
+			}
+			pos++
+		}
+	}
+	return deleted, nil
+}
+
+func (r *InMemoryRepository) galleryLocked(productID int) []Image {
+	out := make([]Image, 0)
+	for _, img := range r.images {
+		if img.ProductID == productID {
+			out = append(out, img)
+		}
+	}
+	return out
+}
+
+func (r *InMemoryRepository) imageIndexLocked(productID, imageID int) int {
+	for i, img := range r.images {
+		if img.ProductID == productID && img.ImageID == imageID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *InMemoryRepository) setPrimaryLocked(productID, imageID int) {
+	for i := range r.images {
+		if r.images[i].ProductID == productID {
+			r.images[i].Primary = r.images[i].ImageID == imageID
+		}
+	}
+}
+
+func (r *InMemoryRepository) sortImagesLocked() {
+	sort.SliceStable(r.images, func(i, j int) bool {
+		a, b := r.images[i], r.images[j]
+		if a.ProductID != b.ProductID {
+			return a.ProductID < b.ProductID
+		}
+		return a.Position < b.Position
+	})
+}
+
+func (r *InMemoryRepository) ListPage(q listing.Query) ([]Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	categories := map[string]bool{}
+	for _, id := range q.CategoryIDs {
+		if name, ok := r.CategoryNames[id]; ok {
+			categories[name] = true
+		}
+	}
+	out := make([]Product, 0)
+	for _, p := range r.storage {
+		switch {
+		case q.MinPrice != nil && p.Price < *q.MinPrice,
+			q.MaxPrice != nil && p.Price > *q.MaxPrice,
+			q.MinScore != nil && p.Score < *q.MinScore,
+			q.InStock && p.Stock <= 0,
+			len(q.CategoryIDs) > 0 && (p.Category == nil || !categories[*p.Category]),
+			!q.After(productKeys(p)):
+			continue
+		}
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return q.Less(productKeys(out[i]), productKeys(out[j])) })
+	if len(out) > q.Limit+1 {
+		out = out[:q.Limit+1]
+	}
+	return out, nil
+}
+
+func productKeys(p Product) listing.Keys {
+	return listing.Keys{ID: p.ID, Price: p.Price, Score: p.Score}
+}
+
+// Search matches query terms as case-insensitive substrings of the name and
+// description fields; name matches rank above description matches.
+func (r *InMemoryRepository) Search(q SearchQuery) (SearchResult, error) {
+	terms := searchTerms(strings.ToLower(q.Q))
+	r.mu.RLock()
+	hits := make([]SearchHit, 0)
+	for _, p := range r.storage {
+		var rank float64
+		for _, term := range terms {
+			if containsFold(&p.Name, term) || containsFold(p.NameEn, term) {
+				rank += 1
+			}
+			if containsFold(&p.Description, term) || containsFold(p.DescriptionEn, term) {
+				rank += 0.5
+			}
+		}
+		if rank > 0 {
+			hits = append(hits, SearchHit{ProductV1: ProductV1{
+				ProductID:    p.ID,
+				ProductName:  &p.Name,
+				ProductPrice: &p.Price,
+				ProductImg:   p.Pic,
+				ProductDesc:  &p.Description,
+				Score:        &p.Score,
+				Category:     p.Category,
+				Stock:        p.Stock,
+				InStock:      p.Stock > 0,
+			}, Rank: rank})
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Rank > hits[j].Rank })
+	res := SearchResult{Items: []SearchHit{}, Total: len(hits), Page: q.Page, PageSize: q.PageSize}
+	start := (q.Page - 1) * q.PageSize
+	if start < len(hits) {
+		end := start + q.PageSize
+		if end > len(hits) {
+			end = len(hits)
+		}
+		res.Items = hits[start:end]
+	}
+	return res, nil
+}
+
+func containsFold(s *string, term string) bool {
+	return s != nil && strings.Contains(strings.ToLower(*s), term)
+}
+
 func (r *InMemoryRepository) Create(p Product) (Product, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -148,6 +505,8 @@ func (r *InMemoryRepository) Create(p Product) (Product, error) {
 		p.ID = r.nextID
 		r.nextID++
 	}
+	// like the Postgres repository, score only comes from reviews
+	p.Score = 0
 	r.storage = append(r.storage, p)
 	return p, nil
 }
@@ -158,6 +517,7 @@ func (r *InMemoryRepository) Update(id int, p Product) (Product, error) {
 	for i := range r.storage {
 		if r.storage[i].ID == id {
 			p.ID = id
+			p.Score = r.storage[i].Score
 			r.storage[i] = p
 			return p, nil
 		}
@@ -4,41 +4,48 @@ import (
 	"database/sql"
 
 	"github.com/lib/pq"
+	"github.com/wichananm65/pet-shop-backend/internal/listing"
 )
 
 type PostgresRepository struct {
 	db *sql.DB
 }
 
+// productColumns is the column list read by scanProduct. Every product read
+// and write goes to the canonical `products` table.
+const productColumns = `p.productid, p.productname, p.productnameen, p.productprice, p.score, p.productdesc, p.productdescen,
+	       p.productimg, p.productimgsec, p.category, p.stock, p.created_at::text, p.updated_at::text`
+
 const (
-	listProductsQuery = `
-		SELECT productid, productname, productprice, productdesc, productimg, score, productnameth, productdescth, created_at, updated_at
-		FROM products
-		ORDER BY productid
-	`
-	getProductByIDQuery = `
-		SELECT productid, productname, productprice, productdesc, productimg, score, productnameth, productdescth, created_at, updated_at
-		FROM products
-		WHERE productid = $1
+	listProductsQuery   = `SELECT ` + productColumns + ` FROM products p ORDER BY p.productid`
+	getProductByIDQuery = `SELECT ` + productColumns + ` FROM products p WHERE p.productid = $1`
+	// listByCategoryIDQuery matches products by category name, like the
+	// category filter of ListPage.
+	listByCategoryIDQuery = `
+		SELECT ` + productColumns + `
+		FROM products p
+		JOIN category c ON c."categoryName" = p.category
+		WHERE c."categoryID" = $1
+		ORDER BY p.productid
 	`
 	insertProductQuery = `
-		INSERT INTO products (productname, productnameth, productprice, score, productdesc, productdescth, productimg, created_at, updated_at)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+		INSERT INTO products (productname, productnameen, productprice, productdesc, productdescen, productimg, productimgsec, category, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,now(),now())
 		RETURNING productid
 	`
+	// score is left to the review aggregate, see review.PostgresRepository
 	updateProductQuery = `
 		UPDATE products
-		SET product_name = $1,
-			product_name_en = $2,
-			category = $3,
-			product_price = $4,
-			score = $5,
-			product_desc = $6,
-			product_desc_en = $7,
-			product_pic = $8,
-			product_pic_second = $9,
-			updated_at = $10
-		WHERE product_id = $11
+		SET productname = $1,
+			productnameen = $2,
+			productprice = $3,
+			productdesc = $4,
+			productdescen = $5,
+			productimg = $6,
+			productimgsec = $7,
+			category = $8,
+			updated_at = now()
+		WHERE productid = $9
 	`
 	deleteProductQuery = `DELETE FROM products WHERE productid = $1`
 )
@@ -48,41 +55,38 @@ func NewPostgresRepository(db *sql.DB) *PostgresRepository {
 }
 
 func (r *PostgresRepository) List() []Product {
-	rows, err := r.db.Query(listProductsQuery)
+	products, err := r.queryProducts(listProductsQuery)
 	if err != nil {
-		// fallback to legacy `products` table if the modern `product` table is missing
-		return r.listLegacy()
+		return []Product{}
 	}
-	defer rows.Close()
+	return products
+}
 
-	out := make([]Product, 0)
-	for rows.Next() {
-		p, err := scanProduct(rows)
-		if err != nil {
-			continue
-		}
-		out = append(out, p)
+// ListByCategoryID returns the products of the category with the given
+// numeric ID.
+func (r *PostgresRepository) ListByCategoryID(catID int) []Product {
+	products, err := r.queryProducts(listByCategoryIDQuery, catID)
+	if err != nil {
+		return []Product{}
 	}
-	return out
+	return products
 }
 
-// ListByCategoryID returns products whose category matches the name stored in
-// the category table for the given numeric ID. The join avoids having to perform
-// two queries in the service layer.
-func (r *PostgresRepository) ListByCategoryID(catID int) []Product {
-	q := `
-		SELECT p.product_id, p.product_name, p.product_name_en, p.category,
-		       p.product_price, p.score, p.product_desc, p.product_desc_en,
-		       p.product_pic, p.product_pic_second, p.created_at, p.updated_at
-		FROM product p
-		JOIN category c ON p.category = c."categoryName"
-		WHERE c."categoryID" = $1
-		ORDER BY p.product_id
-	`
-	rows, err := r.db.Query(q, catID)
+func (r *PostgresRepository) GetByID(id int) (Product, error) {
+	p, err := scanProduct(r.db.QueryRow(getProductByIDQuery, id))
+	if err == sql.ErrNoRows {
+		return Product{}, ErrNotFound
+	}
+	if err != nil {
+		return Product{}, err
+	}
+	return p, nil
+}
+
+func (r *PostgresRepository) queryProducts(query string, args ...any) ([]Product, error) {
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
-		// try legacy table
-		return r.listByCategoryIDLegacy(catID)
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -90,85 +94,41 @@ func (r *PostgresRepository) ListByCategoryID(catID int) []Product {
 	for rows.Next() {
 		p, err := scanProduct(rows)
 		if err != nil {
-			continue
+			return nil, err
 		}
 		out = append(out, p)
 	}
-	return out
+	return out, rows.Err()
 }
 
-func (r *PostgresRepository) GetByID(id int) (Product, error) {
-	row := r.db.QueryRow(getProductByIDQuery, id)
-	p, err := scanProduct(row)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			// try legacy table as last resort
-			return r.getByIDLegacy(id)
-		}
-		// if the query itself failed (table missing) we get an error from Scan
-		return r.getByIDLegacy(id)
-	}
-	return p, nil
-}
+// v1Columns is the column list read by scanProductV1.
+const v1Columns = `p.productid, p.productname, p.productnameth, p.productprice, p.productimg, p.productdesc, p.productdescth,
+	       p.score, p.category, p.stock, p.rating_avg, p.rating_count`
 
 // GetV1ByID returns the `products`-style product detail used by the v1 API.
 func (r *PostgresRepository) GetV1ByID(id int) (ProductV1, error) {
-	q := `SELECT productid, productname, productnameth, productprice, productimg, productdesc, productdescth, score FROM products WHERE productid = $1`
-	row := r.db.QueryRow(q, id)
-	var (
-		pid    int
-		name   sql.NullString
-		nameTH sql.NullString
-		price  sql.NullInt64
-		img    sql.NullString
-		desc   sql.NullString
-		descTH sql.NullString
-		score  sql.NullInt64
-	)
-	if err := row.Scan(&pid, &name, &nameTH, &price, &img, &desc, &descTH, &score); err != nil {
-		if err == sql.ErrNoRows {
-			return ProductV1{}, ErrNotFound
-		}
-		return ProductV1{}, err
-	}
-	var (
-		pName, pNameTH, pImg, pDesc, pDescTH *string
-		pPrice, pScore                       *int
-	)
-	if name.Valid {
-		pName = &name.String
-	}
-	if nameTH.Valid {
-		pNameTH = &nameTH.String
+	p, err := scanProductV1(r.db.QueryRow(`SELECT `+v1Columns+` FROM products p WHERE p.productid = $1`, id))
+	if err == sql.ErrNoRows {
+		return ProductV1{}, ErrNotFound
 	}
-	if img.Valid {
-		pImg = &img.String
+	if err != nil {
+		return ProductV1{}, err
 	}
-	if desc.Valid {
-		pDesc = &desc.String
+	variants, err := r.ListVariants(p.ProductID)
+	if err != nil {
+		return ProductV1{}, err
 	}
-	if descTH.Valid {
-		pDescTH = &descTH.String
+	if len(variants) > 0 {
+		p.Variants = variants
 	}
-	if price.Valid {
-		v := int(price.Int64)
-		pPrice = &v
+	gallery, err := r.ListImages(p.ProductID)
+	if err != nil {
+		return ProductV1{}, err
 	}
-	if score.Valid {
-		v := int(score.Int64)
-		pScore = &v
+	if len(gallery) > 0 {
+		p.Gallery = gallery
 	}
-
-	return ProductV1{
-		ProductID:     pid,
-		ProductName:   pName,
-		ProductNameTH: pNameTH,
-		ProductPrice:  pPrice,
-		ProductImg:    pImg,
-		ProductDesc:   pDesc,
-		ProductDescTH: pDescTH,
-		Score:         pScore,
-	}, nil
+	return p, nil
 }
 
 // ListV1ByIDs retrieves the v1-style records for all product IDs in the
@@ -177,8 +137,7 @@ func (r *PostgresRepository) ListV1ByIDs(ids []int) ([]ProductV1, error) {
 	if len(ids) == 0 {
 		return []ProductV1{}, nil
 	}
-	q := `SELECT productid, productname, productnameth, productprice, productimg, productdesc, productdescth, score FROM products WHERE productid = ANY($1::int[])`
-	rows, err := r.db.Query(q, pq.Array(ids))
+	rows, err := r.db.Query(`SELECT `+v1Columns+` FROM products p WHERE p.productid = ANY($1::int[])`, pq.Array(ids))
 	if err != nil {
 		return nil, err
 	}
@@ -186,86 +145,70 @@ func (r *PostgresRepository) ListV1ByIDs(ids []int) ([]ProductV1, error) {
 
 	out := make([]ProductV1, 0)
 	for rows.Next() {
-		var (
-			pid    int
-			name   sql.NullString
-			nameTH sql.NullString
-			price  sql.NullInt64
-			img    sql.NullString
-			desc   sql.NullString
-			descTH sql.NullString
-			score  sql.NullInt64
-		)
-		if err := rows.Scan(&pid, &name, &nameTH, &price, &img, &desc, &descTH, &score); err != nil {
-			continue
-		}
-		p := ProductV1{ProductID: pid}
-		if name.Valid {
-			p.ProductName = &name.String
-		}
-		if nameTH.Valid {
-			p.ProductNameTH = &nameTH.String
-		}
-		if price.Valid {
-			val := int(price.Int64)
-			p.ProductPrice = &val
-		}
-		if img.Valid {
-			p.ProductImg = &img.String
-		}
-		if desc.Valid {
-			p.ProductDesc = &desc.String
-		}
-		if descTH.Valid {
-			p.ProductDescTH = &descTH.String
-		}
-		if score.Valid {
-			val := int(score.Int64)
-			p.Score = &val
+		p, err := scanProductV1(rows)
+		if err != nil {
+			return nil, err
 		}
 		out = append(out, p)
 	}
-	return out, nil
+	return out, rows.Err()
+}
+
+// scanProductV1 reads v1Columns. Variants and the gallery are left empty.
+func scanProductV1(scanner rowScanner) (ProductV1, error) {
+	var (
+		p                      ProductV1
+		name, nameTH, img      sql.NullString
+		desc, descTH, category sql.NullString
+		price, score, stock    sql.NullInt64
+	)
+	if err := scanner.Scan(&p.ProductID, &name, &nameTH, &price, &img, &desc, &descTH, &score, &category, &stock, &p.RatingAverage, &p.RatingCount); err != nil {
+		return ProductV1{}, err
+	}
+	p.ProductName = nullStringPtr(name)
+	p.ProductNameTH = nullStringPtr(nameTH)
+	p.ProductImg = nullStringPtr(img)
+	p.ProductDesc = nullStringPtr(desc)
+	p.ProductDescTH = nullStringPtr(descTH)
+	p.Category = nullStringPtr(category)
+	p.ProductPrice = nullIntPtr(price)
+	p.Score = nullIntPtr(score)
+	p.Stock, p.InStock = stockLevel(stock)
+	return p, nil
+}
+
+// stockLevel reads a stock column. A NULL stock is not tracked (see
+// migration 0003): it reads as 0 units but in stock.
+func stockLevel(stock sql.NullInt64) (int, bool) {
+	return int(stock.Int64), !stock.Valid || stock.Int64 > 0
+}
+
+// SetStock overwrites the available units of a product.
+func (r *PostgresRepository) SetStock(id int, stock int) error {
+	result, err := r.db.Exec(`UPDATE products SET stock = $1, updated_at = now() WHERE productid = $2`, stock, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
 func (r *PostgresRepository) Create(p Product) (Product, error) {
 	var id int
-	err := r.db.QueryRow(
-		insertProductQuery,
-		p.Name,
-		p.NameEn,
-		p.Category,
-		p.Price,
-		p.Score,
-		p.Description,
-		p.DescriptionEn,
-		p.Pic,
-		p.PicSecond,
-		p.CreatedAt,
-		p.UpdatedAt,
-	).Scan(&id)
-	if err != nil {
+	if err := r.db.QueryRow(insertProductQuery, productArgs(p)...).Scan(&id); err != nil {
 		return Product{}, err
 	}
-	p.ID = id
-	return p, nil
+	return r.GetByID(id)
 }
 
 func (r *PostgresRepository) Update(id int, p Product) (Product, error) {
-	result, err := r.db.Exec(
-		updateProductQuery,
-		p.Name,
-		p.NameEn,
-		p.Category,
-		p.Price,
-		p.Score,
-		p.Description,
-		p.DescriptionEn,
-		p.Pic,
-		p.PicSecond,
-		p.UpdatedAt,
-		id,
-	)
+	result, err := r.db.Exec(updateProductQuery, append(productArgs(p), id)...)
 	if err != nil {
 		return Product{}, err
 	}
@@ -295,8 +238,6 @@ func (r *PostgresRepository) Delete(id int) error {
 }
 
 // Reset deletes all products and inserts the provided list in a single transaction.
-// To support legacy deployments we clear both `product` and `products` tables so
-// today’s code can work regardless of which table is actually populated.
 func (r *PostgresRepository) Reset(products []Product) error {
 	tx, err := r.db.Begin()
 	if err != nil {
@@ -306,196 +247,72 @@ func (r *PostgresRepository) Reset(products []Product) error {
 		_ = tx.Rollback()
 	}()
 
-	// clear both tables; ignore errors for non-existent table
-	_, _ = tx.Exec(`DELETE FROM product`)
-	_, _ = tx.Exec(`DELETE FROM products`)
-
+	if _, err := tx.Exec(`DELETE FROM products`); err != nil {
+		return err
+	}
 	for _, p := range products {
 		var id int
-		err := tx.QueryRow(insertProductQuery,
-			p.Name,
-			p.NameEn,
-			p.Category,
-			p.Price,
-			p.Score,
-			p.Description,
-			p.DescriptionEn,
-			p.Pic,
-			p.PicSecond,
-			p.CreatedAt,
-			p.UpdatedAt,
-		).Scan(&id)
-		if err != nil {
+		if err := tx.QueryRow(insertProductQuery, productArgs(p)...).Scan(&id); err != nil {
 			return err
 		}
 	}
-
-	if err := tx.Commit(); err != nil {
-		return err
-	}
-	return nil
+	return tx.Commit()
 }
 
-// ---- legacy helpers -------------------------------------------------------
-
-// listLegacy retrieves rows from the older `products` table and converts them
-// into the v2 Product struct.
-func (r *PostgresRepository) listLegacy() []Product {
-	q := `SELECT productid,productname,productnameth,productprice,score,productdesc,productdescth,productimg,NULL::text,NULL::text,NULL::text,NULL::text FROM products ORDER BY productid`
-	rows, err := r.db.Query(q)
-	if err != nil {
-		return []Product{}
-	}
-	defer rows.Close()
-	out := make([]Product, 0)
-	for rows.Next() {
-		p, err := scanProductLegacy(rows)
-		if err != nil {
-			continue
-		}
-		out = append(out, p)
-	}
-	return out
-}
-
-func (r *PostgresRepository) listByCategoryIDLegacy(catID int) []Product {
-	q := `SELECT p.productid,p.productname,p.productnameth,p.productprice,p.score,p.productdesc,p.productdescth,p.productimg,NULL::text,NULL::text,NULL::text,NULL::text
-		FROM products p
-		JOIN category c ON p.productid = ANY(c.product_id)
-		WHERE c.categoryid = $1
-		ORDER BY p.productid`
-	rows, err := r.db.Query(q, catID)
-	if err != nil {
-		return []Product{}
-	}
-	defer rows.Close()
-	out := make([]Product, 0)
-	for rows.Next() {
-		p, err := scanProductLegacy(rows)
-		if err != nil {
-			continue
-		}
-		out = append(out, p)
-	}
-	return out
+// productArgs returns the values of insertProductQuery and the first
+// parameters of updateProductQuery. Score is not among them; it is derived
+// from reviews.
+func productArgs(p Product) []any {
+	return []any{p.Name, p.NameEn, p.Price, p.Description, p.DescriptionEn, p.Pic, p.PicSecond, p.Category}
 }
 
-func (r *PostgresRepository) getByIDLegacy(id int) (Product, error) {
-	q := `SELECT productid, productname, productnameth, productprice, score, productdesc, productdescth, productimg, NULL::text, NULL::text, NULL::text, NULL::text FROM products WHERE productid = $1`
-	row := r.db.QueryRow(q, id)
-	p, err := scanProductLegacy(row)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return Product{}, ErrNotFound
-		}
-		return Product{}, err
-	}
-	return p, nil
+type rowScanner interface {
+	Scan(dest ...any) error
 }
 
-func scanProductLegacy(scanner rowScanner) (Product, error) {
-	p := Product{}
+// scanProduct reads productColumns.
+func scanProduct(scanner rowScanner) (Product, error) {
 	var (
-		nameTH    sql.NullString
-		descTH    sql.NullString
-		img       sql.NullString
-		imgSec    sql.NullString
-		category  sql.NullString
-		createdAt sql.NullString
-		updatedAt sql.NullString
+		p                    Product
+		name, desc           sql.NullString
+		price, score, stock  sql.NullInt64
+		nameEn, descEn       sql.NullString
+		pic, picSecond       sql.NullString
+		category             sql.NullString
+		createdAt, updatedAt sql.NullString
 	)
-	if err := scanner.Scan(
-		&p.ID,
-		&p.Name,
-		&nameTH,
-		&p.Price,
-		&p.Score,
-		&p.Description,
-		&descTH,
-		&img,
-		&imgSec,
-		&createdAt,
-		&updatedAt,
-		&category,
-	); err != nil {
+	if err := scanner.Scan(&p.ID, &name, &nameEn, &price, &score, &desc, &descEn, &pic, &picSecond, &category, &stock, &createdAt, &updatedAt); err != nil {
 		return Product{}, err
 	}
-	if nameTH.Valid {
-		p.NameEn = &nameTH.String // repurpose Thai name for lack of English field
-	}
-	if descTH.Valid {
-		p.DescriptionEn = &descTH.String
-	}
-	if img.Valid {
-		p.Pic = &img.String
-	}
-	if imgSec.Valid {
-		p.PicSecond = &imgSec.String
-	}
-	if category.Valid {
-		p.Category = &category.String
-	}
-	if createdAt.Valid {
-		p.CreatedAt = &createdAt.String
-	}
-	if updatedAt.Valid {
-		p.UpdatedAt = &updatedAt.String
-	}
+	p.Name = name.String
+	p.Description = desc.String
+	p.Price = int(price.Int64)
+	p.Score = int(score.Int64)
+	p.Stock = int(stock.Int64)
+	p.NameEn = nullStringPtr(nameEn)
+	p.DescriptionEn = nullStringPtr(descEn)
+	p.Pic = nullStringPtr(pic)
+	p.PicSecond = nullStringPtr(picSecond)
+	p.Category = nullStringPtr(category)
+	p.CreatedAt = nullStringPtr(createdAt)
+	p.UpdatedAt = nullStringPtr(updatedAt)
 	return p, nil
 }
 
-type rowScanner interface {
-	Scan(dest ...any) error
-}
-
-func scanProduct(scanner rowScanner) (Product, error) {
-	p := Product{}
-	var createdAt sql.NullString
-	var updatedAt sql.NullString
-	var nameEn sql.NullString
-	var category sql.NullString
-	var descEn sql.NullString
-	var pic sql.NullString
-	var picSecond sql.NullString
-
-	if err := scanner.Scan(
-		&p.ID,
-		&p.Name,
-		&nameEn,
-		&category,
-		&p.Price,
-		&p.Score,
-		&p.Description,
-		&descEn,
-		&pic,
-		&picSecond,
-		&createdAt,
-		&updatedAt,
-	); err != nil {
-		return Product{}, err
-	}
+// listPageSelect reads productColumns; the listing clauses are appended by
+// listing.Query.Build.
+const listPageSelect = `SELECT ` + productColumns + ` FROM products p`
 
-	if nameEn.Valid {
-		p.NameEn = &nameEn.String
-	}
-	if category.Valid {
-		p.Category = &category.String
-	}
-	if descEn.Valid {
-		p.DescriptionEn = &descEn.String
-	}
-	if pic.Valid {
-		p.Pic = &pic.String
-	}
-	if picSecond.Valid {
-		p.PicSecond = &picSecond.String
-	}
-	if createdAt.Valid {
-		p.CreatedAt = &createdAt.String
-	}
-	if updatedAt.Valid {
-		p.UpdatedAt = &updatedAt.String
-	}
+var listPageColumns = listing.Columns{
+	ID:       "p.productid",
+	Price:    "p.productprice",
+	Score:    "p.score",
+	Stock:    "p.stock",
+	Category: `(SELECT c."categoryID" FROM category c WHERE c."categoryName" = p.category LIMIT 1)`,
+}
 
-	return p, nil
+// ListPage filters, sorts and paginates products in SQL.
+func (r *PostgresRepository) ListPage(q listing.Query) ([]Product, error) {
+	query, args := q.Build(listPageSelect, listPageColumns)
+	return r.queryProducts(query, args...)
 }
@@ -5,9 +5,12 @@ import (
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
-func TestListByCategoryID_Fallback(t *testing.T) {
+var productRowColumns = []string{"productid", "productname", "productnameen", "productprice", "score", "productdesc", "productdescen", "productimg", "productimgsec", "category", "stock", "created_at", "updated_at"}
+
+func TestListByCategoryID(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("failed to create sqlmock: %v", err)
@@ -15,20 +18,17 @@ func TestListByCategoryID_Fallback(t *testing.T) {
 	defer db.Close()
 	repo := NewPostgresRepository(db)
 
-	// simulate modern query failing (table missing)
-	mock.ExpectQuery("SELECT p.product_id").WithArgs(3).WillReturnError(errors.New("no such table"))
-
-	// legacy query should be attempted next and return a single product
-	rows := sqlmock.NewRows([]string{"productid", "productname", "productnameth", "productprice", "score", "productdesc", "productdescth", "productimg", "productimgsec", "created_at", "updated_at", "category"}).
-		AddRow(5, "Foo", "ไทย", 100, 1, "d", "dth", "img", "img2", "t", "u", "cat")
-	mock.ExpectQuery("FROM products p").WithArgs(3).WillReturnRows(rows)
+	rows := sqlmock.NewRows(productRowColumns).
+		AddRow(5, "Foo", "Foo (en)", 100, 1, "d", "d (en)", "img", nil, "Cat snacks", 3, "t", "u")
+	mock.ExpectQuery(`FROM products p\s+JOIN category c ON c\."categoryName" = p\.category\s+WHERE c\."categoryID" = \$1`).WithArgs(3).WillReturnRows(rows)
 
 	products := repo.ListByCategoryID(3)
 	if len(products) != 1 {
 		t.Fatalf("expected 1 product, got %d", len(products))
 	}
-	if products[0].Name != "Foo" {
-		t.Fatalf("unexpected product name %q", products[0].Name)
+	p := products[0]
+	if p.Name != "Foo" || p.NameEn == nil || *p.NameEn != "Foo (en)" || p.Category == nil || *p.Category != "Cat snacks" || p.PicSecond != nil || p.Stock != 3 {
+		t.Fatalf("unexpected product %+v", p)
 	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -36,7 +36,7 @@ func TestListByCategoryID_Fallback(t *testing.T) {
 	}
 }
 
-func TestList_Fallback(t *testing.T) {
+func TestList(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("sqlmock error: %v", err)
@@ -44,25 +44,25 @@ func TestList_Fallback(t *testing.T) {
 	defer db.Close()
 	repo := NewPostgresRepository(db)
 
-	// first query fails
-	mock.ExpectQuery("SELECT productid").WillReturnError(errors.New("no such table"))
-	// fallback returns two rows
-	rows := sqlmock.NewRows([]string{"productid", "productname", "productnameth", "productprice", "score", "productdesc", "productdescth", "productimg", "productimgsec", "created_at", "updated_at", "category"}).
-		AddRow(1, "A", "ไทยA", 10, 1, "d", "dth", "img", "img2", "t", "u", "cat").
-		AddRow(2, "B", "ไทยB", 20, 2, "d2", "dth2", "imgb", "img2b", "t2", "u2", "cat2")
-	mock.ExpectQuery("FROM products ORDER BY productid").WillReturnRows(rows)
+	rows := sqlmock.NewRows(productRowColumns).
+		AddRow(1, "A", "A (en)", 10, 1, "d", "d (en)", "img", "img2", "cat", 0, "t", "u").
+		AddRow(2, "B", nil, nil, nil, nil, nil, nil, nil, nil, 0, "t2", "u2")
+	mock.ExpectQuery("FROM products p ORDER BY p.productid").WillReturnRows(rows)
 
 	all := repo.List()
 	if len(all) != 2 {
 		t.Fatalf("expected 2 products, got %d", len(all))
 	}
+	if all[1].NameEn != nil || all[1].Price != 0 {
+		t.Fatalf("expected NULL columns to read as empty values, got %+v", all[1])
+	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("unmet expectations: %v", err)
 	}
 }
 
-func TestGetByID_Fallback(t *testing.T) {
+func TestGetByID_NotFound(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("sqlmock error: %v", err)
@@ -70,18 +70,42 @@ func TestGetByID_Fallback(t *testing.T) {
 	defer db.Close()
 	repo := NewPostgresRepository(db)
 
-	// simulate Scan error (table missing)
-	mock.ExpectQuery("SELECT .*FROM product").WithArgs(9).WillReturnError(errors.New("no such table"))
+	// there is no second table to fall back to
+	mock.ExpectQuery("FROM products p WHERE p.productid").WithArgs(9).WillReturnRows(sqlmock.NewRows(productRowColumns))
+	if _, err := repo.GetByID(9); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	mock.ExpectQuery("FROM products p WHERE p.productid").WithArgs(9).WillReturnError(errors.New("connection reset"))
+	if _, err := repo.GetByID(9); err == nil || err == ErrNotFound {
+		t.Fatalf("expected the query error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpdate_WritesProductsRow(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock error: %v", err)
+	}
+	defer db.Close()
+	repo := NewPostgresRepository(db)
 
-	rows := sqlmock.NewRows([]string{"productid", "productname", "productnameth", "productprice", "score", "productdesc", "productdescth", "productimg", "productimgsec", "created_at", "updated_at", "category"}).
-		AddRow(9, "Z", "ไทยZ", 99, 9, "d", "dth", "img", "img2", "t", "u", "cat")
-	mock.ExpectQuery("FROM products").WithArgs(9).WillReturnRows(rows)
+	nameEn, category := "Cat toy", "Cat exercise"
+	mock.ExpectExec("UPDATE products\\s+SET productname = \\$1").
+		WithArgs("Toy", &nameEn, 150, "desc", nil, nil, nil, &category, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM products p WHERE p.productid").WithArgs(7).WillReturnRows(sqlmock.NewRows(productRowColumns).
+		AddRow(7, "Toy", nameEn, 150, 4, "desc", nil, nil, nil, category, 0, "t", "u"))
 
-	p, err := repo.GetByID(9)
+	// the client's score is not written; the row keeps the review-derived one
+	p, err := repo.Update(7, Product{Name: "Toy", NameEn: &nameEn, Price: 150, Score: 1, Description: "desc", Category: &category})
 	if err != nil {
-		t.Fatalf("expected nil err, got %v", err)
+		t.Fatal(err)
 	}
-	if p.ID != 9 || p.Name != "Z" {
+	if p.ID != 7 || *p.Category != category || p.Score != 4 {
 		t.Fatalf("unexpected product %+v", p)
 	}
 
@@ -89,3 +113,51 @@ func TestGetByID_Fallback(t *testing.T) {
 		t.Errorf("unmet expectations: %v", err)
 	}
 }
+
+func TestReserveStock_SkipsUntrackedStock(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock error: %v", err)
+	}
+	defer db.Close()
+
+	// product 1 predates stock tracking (NULL); product 2 is tracked and short
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM products WHERE productid = ANY").WillReturnRows(
+		sqlmock.NewRows([]string{"productid", "variantid", "stock"}).AddRow(1, 0, nil).AddRow(2, 0, 1))
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	err = ReserveStock(tx, map[StockKey]int{{ProductID: 1}: 5, {ProductID: 2}: 2})
+	var short *InsufficientStockError
+	if !errors.As(err, &short) {
+		t.Fatalf("expected InsufficientStockError, got %v", err)
+	}
+	if len(short.Shortages) != 1 || short.Shortages[0].ProductID != 2 {
+		t.Fatalf("expected only product 2 to be short, got %+v", short.Shortages)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestCreateVariant_DuplicateSKU(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock error: %v", err)
+	}
+	defer db.Close()
+	repo := NewPostgresRepository(db)
+
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("INSERT INTO product_variants").WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: "product_variants_sku_key"})
+	if _, err := repo.CreateVariant(Variant{ProductID: 1, SKU: "DOG-1KG"}); err != ErrDuplicateSKU {
+		t.Fatalf("expected ErrDuplicateSKU, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package product
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Search limits. Queries longer than MaxSearchQueryLength are rejected and
+// page sizes are capped at MaxSearchPageSize.
+const (
+	DefaultSearchPageSize = 20
+	MaxSearchPageSize     = 50
+	MaxSearchQueryLength  = 100
+	snippetRadius         = 40
+)
+
+var ErrInvalidSearch = errors.New("search query must be 1-100 characters")
+
+// SearchQuery is a normalized product search request. Page is 1-based.
+type SearchQuery struct {
+	Q        string
+	Page     int
+	PageSize int
+}
+
+// SearchHit is one matching product. Highlights maps the matched field
+// (productName, productNameTH, productDesc, productDescTH) to an HTML-escaped
+// snippet with the matched text wrapped in <mark>.
+type SearchHit struct {
+	ProductV1
+	Rank       float64           `json:"rank"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+// SearchResult is one page of search hits, best match first.
+type SearchResult struct {
+	Items    []SearchHit `json:"items"`
+	Total    int         `json:"total"`
+	Page     int         `json:"page"`
+	PageSize int         `json:"pageSize"`
+}
+
+// normalize trims the query and fills in paging defaults.
+func (q SearchQuery) normalize() (SearchQuery, error) {
+	q.Q = strings.Join(strings.Fields(q.Q), " ")
+	if q.Q == "" || len([]rune(q.Q)) > MaxSearchQueryLength {
+		return q, ErrInvalidSearch
+	}
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.PageSize < 1 {
+		q.PageSize = DefaultSearchPageSize
+	}
+	if q.PageSize > MaxSearchPageSize {
+		q.PageSize = MaxSearchPageSize
+	}
+	return q, nil
+}
+
+// searchTerms splits the query on whitespace. Thai is written without spaces
+// between words, so a Thai query stays a single term that is matched as a
+// substring rather than relying on word boundaries.
+func searchTerms(q string) []string {
+	return strings.Fields(q)
+}
+
+// Highlight returns an HTML-escaped snippet of text around the first match of
+// any term with every match wrapped in <mark>…</mark>, or "" when no term
+// occurs. Matching is case-insensitive and works on runes, so it applies to
+// Thai text as well as English.
+func Highlight(text string, terms []string) string {
+	if text == "" || len(terms) == 0 {
+		return ""
+	}
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	// mark[i] is true for runes that belong to a match
+	mark := make([]bool, len(runes))
+	first := -1
+	for _, term := range terms {
+		t := []rune(strings.ToLower(term))
+		if len(t) == 0 {
+			continue
+		}
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if runesEqual(lower[i:i+len(t)], t) {
+				for j := i; j < i+len(t); j++ {
+					mark[j] = true
+				}
+				if first == -1 || i < first {
+					first = i
+				}
+			}
+		}
+	}
+	if first == -1 {
+		return ""
+	}
+
+	start := first - snippetRadius
+	if start < 0 {
+		start = 0
+	}
+	end := first + snippetRadius*2
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	inMark := false
+	for i := start; i < end; i++ {
+		if mark[i] && !inMark {
+			b.WriteString("<mark>")
+			inMark = true
+		} else if !mark[i] && inMark {
+			b.WriteString("</mark>")
+			inMark = false
+		}
+		b.WriteString(html.EscapeString(string(runes[i])))
+	}
+	if inMark {
+		b.WriteString("</mark>")
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+func runesEqual(a, b []rune) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// highlightHit fills in the highlighted snippets of a hit.
+func highlightHit(hit *SearchHit, terms []string) {
+	fields := map[string]*string{
+		"productName":   hit.ProductName,
+		"productNameTH": hit.ProductNameTH,
+		"productDesc":   hit.ProductDesc,
+		"productDescTH": hit.ProductDescTH,
+	}
+	for name, value := range fields {
+		if value == nil {
+			continue
+		}
+		if snippet := Highlight(*value, terms); snippet != "" {
+			if hit.Highlights == nil {
+				hit.Highlights = map[string]string{}
+			}
+			hit.Highlights[name] = snippet
+		}
+	}
+}
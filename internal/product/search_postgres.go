@@ -0,0 +1,89 @@
+package product
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// searchDocument is the text indexed for full-text search; the expression
+// must match the products_search_idx index created at startup.
+const searchDocument = `to_tsvector('simple', coalesce(p.productname, '') || ' ' || coalesce(p.productnameth, '') || ' ' || coalesce(p.productdesc, '') || ' ' || coalesce(p.productdescth, ''))`
+
+// searchProductsQuery combines three matchers: full-text search for
+// space-separated words, trigram-backed ILIKE substring matching (which is
+// what makes Thai work, as Thai has no spaces between words) and trigram
+// similarity on the names to tolerate typos. Name matches rank above
+// description matches.
+const searchProductsQuery = `
+	WITH q AS (SELECT $1::text AS term, plainto_tsquery('simple', $1) AS tsq)
+	SELECT p.productid, p.productname, p.productnameth, p.productprice, p.productimg,
+	       p.productdesc, p.productdescth, p.score, p.stock,
+	       ts_rank(` + searchDocument + `, q.tsq)
+	         + GREATEST(similarity(coalesce(p.productname, ''), q.term), similarity(coalesce(p.productnameth, ''), q.term))
+	         + CASE WHEN p.productname ILIKE $2 ESCAPE '\' OR p.productnameth ILIKE $2 ESCAPE '\' THEN 1 ELSE 0 END
+	         + CASE WHEN p.productdesc ILIKE $2 ESCAPE '\' OR p.productdescth ILIKE $2 ESCAPE '\' THEN 0.5 ELSE 0 END AS rank,
+	       count(*) OVER () AS total
+	FROM products p, q
+	WHERE ` + searchDocument + ` @@ q.tsq
+	   OR p.productname ILIKE $2 ESCAPE '\' OR p.productnameth ILIKE $2 ESCAPE '\'
+	   OR p.productdesc ILIKE $2 ESCAPE '\' OR p.productdescth ILIKE $2 ESCAPE '\'
+	   OR p.productname % q.term OR p.productnameth % q.term
+	ORDER BY rank DESC, p.productid
+	LIMIT $3 OFFSET $4
+`
+
+// likeEscaper escapes LIKE wildcards so user input is matched literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Search runs a ranked full-text/trigram search over the English and Thai
+// name and description columns.
+func (r *PostgresRepository) Search(q SearchQuery) (SearchResult, error) {
+	res := SearchResult{Items: []SearchHit{}, Page: q.Page, PageSize: q.PageSize}
+	rows, err := r.db.Query(searchProductsQuery, q.Q, "%"+likeEscaper.Replace(q.Q)+"%", q.PageSize, (q.Page-1)*q.PageSize)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			hit    SearchHit
+			name   sql.NullString
+			nameTH sql.NullString
+			price  sql.NullInt64
+			img    sql.NullString
+			desc   sql.NullString
+			descTH sql.NullString
+			score  sql.NullInt64
+			stock  sql.NullInt64
+		)
+		if err := rows.Scan(&hit.ProductID, &name, &nameTH, &price, &img, &desc, &descTH, &score, &stock, &hit.Rank, &res.Total); err != nil {
+			return res, err
+		}
+		hit.ProductName = nullStringPtr(name)
+		hit.ProductNameTH = nullStringPtr(nameTH)
+		hit.ProductImg = nullStringPtr(img)
+		hit.ProductDesc = nullStringPtr(desc)
+		hit.ProductDescTH = nullStringPtr(descTH)
+		hit.ProductPrice = nullIntPtr(price)
+		hit.Score = nullIntPtr(score)
+		hit.Stock, hit.InStock = stockLevel(stock)
+		res.Items = append(res.Items, hit)
+	}
+	return res, rows.Err()
+}
+
+func nullStringPtr(v sql.NullString) *string {
+	if !v.Valid {
+		return nil
+	}
+	return &v.String
+}
+
+func nullIntPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	i := int(v.Int64)
+	return &i
+}
@@ -0,0 +1,84 @@
+package product
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestHighlight(t *testing.T) {
+	cases := []struct {
+		name  string
+		text  string
+		terms []string
+		want  string
+	}{
+		{"case insensitive", "Premium Cat Food", []string{"cat"}, "Premium <mark>Cat</mark> Food"},
+		{"several terms", "Cat food for cats", []string{"cat", "food"}, "<mark>Cat</mark> <mark>food</mark> for <mark>cat</mark>s"},
+		{"thai without spaces", "อาหารแมวคุณภาพสูง", []string{"แมว"}, "อาหาร<mark>แมว</mark>คุณภาพสูง"},
+		{"escapes html", "<b>Cat</b> toy", []string{"toy"}, "&lt;b&gt;Cat&lt;/b&gt; <mark>toy</mark>"},
+		{"no match", "Dog bed", []string{"cat"}, ""},
+	}
+	for _, tc := range cases {
+		if got := Highlight(tc.text, tc.terms); got != tc.want {
+			t.Errorf("%s: got %q want %q", tc.name, got, tc.want)
+		}
+	}
+
+	long := strings.Repeat("x", 100) + "cat" + strings.Repeat("y", 100)
+	got := Highlight(long, []string{"cat"})
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") || !strings.Contains(got, "<mark>cat</mark>") {
+		t.Errorf("expected trimmed snippet around the match, got %q", got)
+	}
+}
+
+func TestSearchProducts(t *testing.T) {
+	seed := []Product{
+		{ID: 1, Name: "Happy Cat Food", NameEn: ptrString("อาหารแมว"), Description: "Premium dry food"},
+		{ID: 2, Name: "Dog Bed", Description: "Soft bed, loved by cats too"},
+		{ID: 3, Name: "Fish Tank", Description: "Glass tank"},
+	}
+	h := NewHandler(NewService(NewInMemoryRepository(seed)))
+	app := fiber.New()
+	h.RegisterPublicRoutes(app)
+
+	search := func(query string) (int, SearchResult) {
+		res, err := app.Test(httptest.NewRequest("GET", "/api/v1/product/search?"+query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out SearchResult
+		json.NewDecoder(res.Body).Decode(&out)
+		return res.StatusCode, out
+	}
+
+	status, out := search("q=cat")
+	if status != 200 || out.Total != 2 || len(out.Items) != 2 {
+		t.Fatalf("expected 2 hits, got %d %+v", status, out)
+	}
+	if out.Items[0].ProductID != 1 {
+		t.Errorf("name match should rank first, got product %d", out.Items[0].ProductID)
+	}
+	if out.Items[0].Highlights["productName"] != "Happy <mark>Cat</mark> Food" {
+		t.Errorf("unexpected highlight %+v", out.Items[0].Highlights)
+	}
+
+	// Thai query matched as a substring
+	status, out = search("q=" + url.QueryEscape("แมว"))
+	if status != 200 || out.Total != 1 || out.Items[0].ProductID != 1 {
+		t.Fatalf("expected Thai match on product 1, got %d %+v", status, out)
+	}
+
+	status, out = search("q=cat&page=2&pageSize=1")
+	if status != 200 || out.Total != 2 || len(out.Items) != 1 || out.Items[0].ProductID != 2 || out.Page != 2 {
+		t.Fatalf("unexpected second page %+v", out)
+	}
+
+	if status, _ = search("q=+"); status != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 for blank query, got %d", status)
+	}
+}
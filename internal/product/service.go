@@ -1,5 +1,11 @@
 package product
 
+import (
+	"github.com/wichananm65/pet-shop-backend/internal/imaging"
+	"github.com/wichananm65/pet-shop-backend/internal/listing"
+	"github.com/wichananm65/pet-shop-backend/internal/storage"
+)
+
 // ServiceInterface defines the subset of functionality used by external
 // packages.  It exists primarily to make testing easier and avoid
 // depending directly on the concrete Service type.
@@ -8,6 +14,7 @@ type ServiceInterface interface {
 	GetByID(id int) (Product, error)
 	GetV1ByID(id int) (ProductV1, error)
 	ListV1ByIDs(ids []int) ([]ProductV1, error)
+	ListVariantsByIDs(ids []int) ([]Variant, error)
 	Create(p Product) (Product, error)
 	Update(id int, p Product) (Product, error)
 	Delete(id int) error
@@ -18,17 +25,39 @@ type ServiceInterface interface {
 var _ ServiceInterface = (*Service)(nil)
 
 type Service struct {
-	repo Repository
+	repo  Repository
+	blobs storage.Blob
 }
 
+// NewService keeps gallery images in memory until WithStorage provides a
+// real backend.
 func NewService(repo Repository) *Service {
-	return &Service{repo: repo}
+	return &Service{repo: repo, blobs: storage.NewMemory(nil)}
+}
+
+// WithStorage sets where gallery images are stored.
+func (s *Service) WithStorage(b storage.Blob) *Service {
+	s.blobs = b
+	return s
 }
 
 func (s *Service) List() []Product {
 	return s.repo.List()
 }
 
+// ListPage returns one page of products filtered and sorted by q.
+func (s *Service) ListPage(q listing.Query) (listing.Page[Product], error) {
+	q, err := q.Normalize()
+	if err != nil {
+		return listing.Page[Product]{}, err
+	}
+	products, err := s.repo.ListPage(q)
+	if err != nil {
+		return listing.Page[Product]{}, err
+	}
+	return listing.Paginate(products, q, productKeys), nil
+}
+
 func (s *Service) GetByID(id int) (Product, error) {
 	return s.repo.GetByID(id)
 }
@@ -42,6 +71,148 @@ func (s *Service) ListV1ByIDs(ids []int) ([]ProductV1, error) {
 	return s.repo.ListV1ByIDs(ids)
 }
 
+// SetStock sets the available units of a product. Stock cannot be negative.
+func (s *Service) SetStock(id int, stock int) (ProductV1, error) {
+	if stock < 0 {
+		return ProductV1{}, ErrInvalidStock
+	}
+	if err := s.repo.SetStock(id, stock); err != nil {
+		return ProductV1{}, err
+	}
+	return s.repo.GetV1ByID(id)
+}
+
+// ListVariantsByIDs returns the variants with the given ids.
+func (s *Service) ListVariantsByIDs(ids []int) ([]Variant, error) {
+	return s.repo.ListVariantsByIDs(ids)
+}
+
+// CreateVariant adds a variant to the product identified by v.ProductID.
+func (s *Service) CreateVariant(v Variant) (Variant, error) {
+	if err := validateVariant(v); err != nil {
+		return Variant{}, err
+	}
+	return s.repo.CreateVariant(v)
+}
+
+// UpdateVariant replaces the SKU, options, price, stock and image of a variant.
+func (s *Service) UpdateVariant(id int, v Variant) (Variant, error) {
+	if err := validateVariant(v); err != nil {
+		return Variant{}, err
+	}
+	return s.repo.UpdateVariant(id, v)
+}
+
+func (s *Service) DeleteVariant(id int) error {
+	return s.repo.DeleteVariant(id)
+}
+
+// ListImages returns a product's gallery in display order.
+func (s *Service) ListImages(productID int) ([]Image, error) {
+	return s.repo.ListImages(productID)
+}
+
+// CreateImage adds an image to the end of a product's gallery. The upload is
+// decoded and stored as re-encoded renditions; the original bytes, including
+// any EXIF metadata, are discarded.
+func (s *Service) CreateImage(img Image, data []byte) (Image, error) {
+	if err := validateImageAlt(img); err != nil {
+		return Image{}, err
+	}
+	renditions, err := imaging.Process(data)
+	if err != nil {
+		return Image{}, err
+	}
+	img.ContentType = renditions.ContentType()
+	img.StorageKey = imageKey(img.ProductID, renditions.Large)
+	if err := storage.PutRenditions(s.blobs, img.StorageKey, renditions); err != nil {
+		return Image{}, err
+	}
+	created, err := s.repo.CreateImage(img)
+	if err != nil {
+		s.releaseImage(img)
+		return Image{}, err
+	}
+	return created, nil
+}
+
+// GetImage returns an image of a product together with the bytes of the
+// requested rendition.
+func (s *Service) GetImage(productID, imageID int, size imaging.Rendition) (Image, []byte, error) {
+	img, data, err := s.repo.GetImage(productID, imageID, size)
+	if err != nil || img.StorageKey == "" {
+		return img, data, err
+	}
+	obj, err := s.blobs.Get(imaging.RenditionPath(img.StorageKey, size))
+	if err == storage.ErrNotFound {
+		return Image{}, nil, ErrImageNotFound
+	}
+	if err != nil {
+		return Image{}, nil, err
+	}
+	return img, obj.Data, nil
+}
+
+// UpdateImage sets the alt texts of an image and optionally makes it the
+// primary image.
+func (s *Service) UpdateImage(img Image) (Image, error) {
+	if err := validateImageAlt(img); err != nil {
+		return Image{}, err
+	}
+	return s.repo.UpdateImage(img)
+}
+
+// ReorderImages puts a product's gallery in the order given by imageIDs.
+func (s *Service) ReorderImages(productID int, imageIDs []int) ([]Image, error) {
+	return s.repo.ReorderImages(productID, imageIDs)
+}
+
+func (s *Service) DeleteImage(productID, imageID int) error {
+	img, err := s.repo.DeleteImage(productID, imageID)
+	if err != nil {
+		return err
+	}
+	s.releaseImage(img)
+	return nil
+}
+
+// releaseImage deletes the blobs of an image no longer in the gallery unless
+// another image of the product shares them. Failures only leave orphaned
+// blobs behind, so they are not reported.
+func (s *Service) releaseImage(img Image) {
+	if img.StorageKey == "" {
+		return
+	}
+	gallery, err := s.repo.ListImages(img.ProductID)
+	if err != nil {
+		return
+	}
+	for _, other := range gallery {
+		if other.StorageKey == img.StorageKey {
+			return
+		}
+	}
+	_ = storage.DeleteRenditions(s.blobs, img.StorageKey)
+}
+
+// Search normalizes the query, runs it against the repository and adds
+// highlighted snippets of the matched fields to every hit.
+func (s *Service) Search(q SearchQuery) (SearchResult, error) {
+	q, err := q.normalize()
+	if err != nil {
+		return SearchResult{}, err
+	}
+	res, err := s.repo.Search(q)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	terms := searchTerms(q.Q)
+	for i := range res.Items {
+		highlightHit(&res.Items[i], terms)
+	}
+	return res, nil
+}
+
 func (s *Service) Create(p Product) (Product, error) {
 	return s.repo.Create(p)
 }
@@ -0,0 +1,40 @@
+package product
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StockKey identifies a stocked line: a product, or one of its variants when
+// VariantID is non-zero.
+type StockKey struct {
+	ProductID int
+	VariantID int
+}
+
+// StockShortage describes one product or variant that cannot cover the
+// requested quantity.
+type StockShortage struct {
+	ProductID int `json:"productID"`
+	VariantID int `json:"variantID,omitempty"`
+	Requested int `json:"requested"`
+	Available int `json:"available"`
+}
+
+// InsufficientStockError is returned when a cart or order asks for more
+// units than are available.
+type InsufficientStockError struct {
+	Shortages []StockShortage `json:"shortages"`
+}
+
+func (e *InsufficientStockError) Error() string {
+	parts := make([]string, 0, len(e.Shortages))
+	for _, s := range e.Shortages {
+		name := fmt.Sprintf("product %d", s.ProductID)
+		if s.VariantID != 0 {
+			name += fmt.Sprintf(" variant %d", s.VariantID)
+		}
+		parts = append(parts, fmt.Sprintf("%s (requested %d, available %d)", name, s.Requested, s.Available))
+	}
+	return "insufficient stock: " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,117 @@
+package product
+
+import (
+	"database/sql"
+	"sort"
+
+	"github.com/lib/pq"
+)
+
+// ReserveStock takes the given quantities out of stock inside the caller's
+// transaction. Product rows and then variant rows are locked in id order with
+// SELECT ... FOR UPDATE so concurrent checkouts serialize per line and cannot
+// oversell; nothing is changed if any line is short. Products with a NULL
+// stock are not tracked and never short.
+func ReserveStock(tx *sql.Tx, quantities map[StockKey]int) error {
+	if len(quantities) == 0 {
+		return nil
+	}
+	productIDs, variantIDs := splitStockKeys(quantities)
+
+	available := make(map[StockKey]int, len(quantities))
+	untracked := make(map[StockKey]bool)
+	if len(productIDs) > 0 {
+		if err := lockStock(tx, `SELECT productid, 0, stock FROM products WHERE productid = ANY($1::int[]) ORDER BY productid FOR UPDATE`, productIDs, available, untracked); err != nil {
+			return err
+		}
+	}
+	if len(variantIDs) > 0 {
+		if err := lockStock(tx, `SELECT productid, variantid, stock FROM product_variants WHERE variantid = ANY($1::int[]) ORDER BY variantid FOR UPDATE`, variantIDs, available, untracked); err != nil {
+			return err
+		}
+	}
+
+	keys := sortedStockKeys(quantities)
+	shortages := make([]StockShortage, 0)
+	for _, k := range keys {
+		if !untracked[k] && available[k] < quantities[k] {
+			shortages = append(shortages, StockShortage{ProductID: k.ProductID, VariantID: k.VariantID, Requested: quantities[k], Available: available[k]})
+		}
+	}
+	if len(shortages) > 0 {
+		return &InsufficientStockError{Shortages: shortages}
+	}
+	return adjustStock(tx, keys, quantities, -1)
+}
+
+// ReleaseStock puts previously reserved quantities back into stock inside
+// the caller's transaction. An untracked stock stays NULL.
+func ReleaseStock(tx *sql.Tx, quantities map[StockKey]int) error {
+	return adjustStock(tx, sortedStockKeys(quantities), quantities, 1)
+}
+
+func lockStock(tx *sql.Tx, query string, ids []int, available map[StockKey]int, untracked map[StockKey]bool) error {
+	rows, err := tx.Query(query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k StockKey
+		var stock sql.NullInt64
+		if err := rows.Scan(&k.ProductID, &k.VariantID, &stock); err != nil {
+			return err
+		}
+		available[k] = int(stock.Int64)
+		untracked[k] = !stock.Valid
+	}
+	return rows.Err()
+}
+
+func adjustStock(tx *sql.Tx, keys []StockKey, quantities map[StockKey]int, sign int) error {
+	for _, k := range keys {
+		var err error
+		if k.VariantID != 0 {
+			_, err = tx.Exec(`UPDATE product_variants SET stock = stock + $1 WHERE variantid = $2`, sign*quantities[k], k.VariantID)
+		} else {
+			_, err = tx.Exec(`UPDATE products SET stock = stock + $1 WHERE productid = $2`, sign*quantities[k], k.ProductID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitStockKeys(quantities map[StockKey]int) (productIDs, variantIDs []int) {
+	for k := range quantities {
+		if k.VariantID != 0 {
+			variantIDs = append(variantIDs, k.VariantID)
+		} else {
+			productIDs = append(productIDs, k.ProductID)
+		}
+	}
+	sort.Ints(productIDs)
+	sort.Ints(variantIDs)
+	return productIDs, variantIDs
+}
+
+// sortedStockKeys orders product lines before variant lines, each by id, so
+// every transaction touches rows in the same order.
+func sortedStockKeys(quantities map[StockKey]int) []StockKey {
+	keys := make([]StockKey, 0, len(quantities))
+	for k := range quantities {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if (a.VariantID == 0) != (b.VariantID == 0) {
+			return a.VariantID == 0
+		}
+		if a.VariantID != b.VariantID {
+			return a.VariantID < b.VariantID
+		}
+		return a.ProductID < b.ProductID
+	})
+	return keys
+}
@@ -0,0 +1,30 @@
+package product
+
+import "errors"
+
+var (
+	ErrVariantNotFound = errors.New("variant not found")
+	ErrInvalidVariant  = errors.New("variant needs a sku, a non-negative price and non-negative stock")
+	ErrDuplicateSKU    = errors.New("another variant already uses this sku")
+)
+
+// Variant is a purchasable option of a product such as a size, flavor or
+// weight. Each variant carries its own SKU, price and stock; Options holds
+// the option values that distinguish it, e.g. {"size": "M", "flavor": "tuna"}.
+type Variant struct {
+	VariantID int               `json:"variantID"`
+	ProductID int               `json:"productID"`
+	SKU       string            `json:"sku"`
+	Options   map[string]string `json:"options"`
+	Price     int               `json:"price"`
+	Stock     int               `json:"stock"`
+	InStock   bool              `json:"inStock"`
+	Img       *string           `json:"img,omitempty"`
+}
+
+func validateVariant(v Variant) error {
+	if v.SKU == "" || v.Price < 0 || v.Stock < 0 {
+		return ErrInvalidVariant
+	}
+	return nil
+}
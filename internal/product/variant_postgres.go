@@ -0,0 +1,136 @@
+package product
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+// uniqueViolation is the SQLSTATE of a unique constraint violation.
+const uniqueViolation = "23505"
+
+const (
+	variantColumns     = `variantid, productid, sku, options, price, stock, img`
+	listVariantsQuery  = `SELECT ` + variantColumns + ` FROM product_variants WHERE productid = $1 ORDER BY variantid`
+	variantsByIDsQuery = `SELECT ` + variantColumns + ` FROM product_variants WHERE variantid = ANY($1::int[]) ORDER BY variantid`
+	insertVariantQuery = `
+		INSERT INTO product_variants (productid, sku, options, price, stock, img, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,now(),now())
+		RETURNING variantid
+	`
+	updateVariantQuery = `
+		UPDATE product_variants
+		SET sku = $1, options = $2, price = $3, stock = $4, img = $5, updated_at = now()
+		WHERE variantid = $6
+		RETURNING productid
+	`
+	deleteVariantQuery = `DELETE FROM product_variants WHERE variantid = $1`
+)
+
+func (r *PostgresRepository) ListVariants(productID int) ([]Variant, error) {
+	rows, err := r.db.Query(listVariantsQuery, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanVariants(rows)
+}
+
+func (r *PostgresRepository) ListVariantsByIDs(ids []int) ([]Variant, error) {
+	if len(ids) == 0 {
+		return []Variant{}, nil
+	}
+	rows, err := r.db.Query(variantsByIDsQuery, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanVariants(rows)
+}
+
+func (r *PostgresRepository) CreateVariant(v Variant) (Variant, error) {
+	options, err := json.Marshal(v.Options)
+	if err != nil {
+		return Variant{}, err
+	}
+	var exists bool
+	if err := r.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM products WHERE productid = $1)`, v.ProductID).Scan(&exists); err != nil {
+		return Variant{}, err
+	}
+	if !exists {
+		return Variant{}, ErrNotFound
+	}
+	if err := r.db.QueryRow(insertVariantQuery, v.ProductID, v.SKU, options, v.Price, v.Stock, v.Img).Scan(&v.VariantID); err != nil {
+		return Variant{}, skuError(err)
+	}
+	v.InStock = v.Stock > 0
+	return v, nil
+}
+
+func (r *PostgresRepository) UpdateVariant(id int, v Variant) (Variant, error) {
+	options, err := json.Marshal(v.Options)
+	if err != nil {
+		return Variant{}, err
+	}
+	err = r.db.QueryRow(updateVariantQuery, v.SKU, options, v.Price, v.Stock, v.Img, id).Scan(&v.ProductID)
+	if err == sql.ErrNoRows {
+		return Variant{}, ErrVariantNotFound
+	}
+	if err != nil {
+		return Variant{}, skuError(err)
+	}
+	v.VariantID = id
+	v.InStock = v.Stock > 0
+	return v, nil
+}
+
+func (r *PostgresRepository) DeleteVariant(id int) error {
+	result, err := r.db.Exec(deleteVariantQuery, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrVariantNotFound
+	}
+	return nil
+}
+
+// skuError turns a violation of the unique sku constraint into
+// ErrDuplicateSKU.
+func skuError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return ErrDuplicateSKU
+	}
+	return err
+}
+
+func scanVariants(rows *sql.Rows) ([]Variant, error) {
+	out := make([]Variant, 0)
+	for rows.Next() {
+		var (
+			v       Variant
+			options []byte
+			img     sql.NullString
+		)
+		if err := rows.Scan(&v.VariantID, &v.ProductID, &v.SKU, &options, &v.Price, &v.Stock, &img); err != nil {
+			return nil, err
+		}
+		if len(options) > 0 {
+			_ = json.Unmarshal(options, &v.Options)
+		}
+		if img.Valid {
+			v.Img = &img.String
+		}
+		v.InStock = v.Stock > 0
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
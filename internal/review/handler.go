@@ -0,0 +1,161 @@
+package review
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/wichananm65/pet-shop-backend/internal/imaging"
+	"github.com/wichananm65/pet-shop-backend/internal/storage"
+	"github.com/wichananm65/pet-shop-backend/internal/upload"
+	"github.com/wichananm65/pet-shop-backend/internal/user"
+)
+
+type Handler struct {
+	service *Service
+	blobs   storage.Blob
+}
+
+func NewHandler(s *Service) *Handler {
+	return &Handler{service: s}
+}
+
+// WithStorage enables review photo uploads.
+func (h *Handler) WithStorage(blobs storage.Blob) *Handler {
+	h.blobs = blobs
+	return h
+}
+
+// RegisterPublicRoutes registers the review listing, which anonymous
+// shoppers can read.
+func (h *Handler) RegisterPublicRoutes(app *fiber.App) {
+	app.Get("/api/v1/product/:id<[0-9]+>/reviews", h.listReviews)
+}
+
+func (h *Handler) RegisterProtectedRoutes(app *fiber.App) {
+	app.Post("/api/v1/product/:id<[0-9]+>/reviews", h.createReview)
+	app.Post("/api/v1/reviews/:reviewId<[0-9]+>/helpful", h.markHelpful)
+	app.Post("/api/v1/reviews/photos", h.uploadPhoto)
+}
+
+type reviewRequest struct {
+	Rating int      `json:"rating"`
+	Text   string   `json:"text"`
+	Photos []string `json:"photos"`
+}
+
+// listReviews handles GET /api/v1/product/:id/reviews?sort=&page=&pageSize=.
+func (h *Handler) listReviews(c *fiber.Ctx) error {
+	productID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid product id"})
+	}
+	page, err := h.service.List(ListQuery{
+		ProductID: productID,
+		Sort:      c.Query("sort"),
+		Page:      c.QueryInt("page", 1),
+		PageSize:  c.QueryInt("pageSize", DefaultPageSize),
+	})
+	if err != nil {
+		return writeReviewError(c, err)
+	}
+	return c.JSON(page)
+}
+
+func (h *Handler) createReview(c *fiber.Ctx) error {
+	userID, err := user.GetUserIDFromCtx(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
+	}
+	productID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid product id"})
+	}
+	var req reviewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid request body"})
+	}
+	rev, err := h.service.Create(userID, Review{
+		ProductID: productID,
+		Rating:    req.Rating,
+		Text:      req.Text,
+		Photos:    req.Photos,
+	})
+	if err != nil {
+		return writeReviewError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(rev)
+}
+
+// uploadPhoto stores an image as renditions under the uploader's
+// PhotoPrefix and returns the URL to cite in a review.
+func (h *Handler) uploadPhoto(c *fiber.Ctx) error {
+	userID, err := user.GetUserIDFromCtx(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
+	}
+	if h.blobs == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"message": "photo uploads are not configured"})
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "file is required"})
+	}
+	f, err := upload.Read(file, upload.Images)
+	if err != nil {
+		return writeUploadError(c, err)
+	}
+	set, err := imaging.Process(f.Data)
+	if err != nil {
+		return writeUploadError(c, err)
+	}
+	key := PhotoPrefix(userID) + upload.Name(set.Large.Data, set.Large.Ext())
+	if err := storage.PutRenditions(h.blobs, key, set); err != nil {
+		return writeUploadError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"photo": "/uploads/" + key})
+}
+
+func (h *Handler) markHelpful(c *fiber.Ctx) error {
+	userID, err := user.GetUserIDFromCtx(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
+	}
+	reviewID, err := strconv.Atoi(c.Params("reviewId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid review id"})
+	}
+	rev, err := h.service.MarkHelpful(reviewID, userID)
+	if err != nil {
+		return writeReviewError(c, err)
+	}
+	return c.JSON(rev)
+}
+
+func writeReviewError(c *fiber.Ctx, err error) error {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"errors": verr.Fields})
+	}
+	switch err {
+	case ErrInvalidSort, ErrOwnReview:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	case ErrNotEligible:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"message": err.Error()})
+	case ErrNotFound, ErrProductNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": err.Error()})
+	case ErrAlreadyReviewed:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+}
+
+func writeUploadError(c *fiber.Ctx, err error) error {
+	switch err {
+	case imaging.ErrUnsupportedFormat, upload.ErrUnsupportedType, upload.ErrArchive, upload.ErrScript:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	case imaging.ErrTooLarge, upload.ErrTooLarge:
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"message": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+}
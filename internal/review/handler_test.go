@@ -0,0 +1,158 @@
+package review
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/wichananm65/pet-shop-backend/internal/storage"
+)
+
+func makeAppWithReviewHandler(h *Handler) *fiber.App {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		if v := c.Get("X-User-ID"); v != "" {
+			id, err := strconv.Atoi(v)
+			if err == nil {
+				claims := jwt.MapClaims{"user_id": id}
+				tok := &jwt.Token{Claims: claims}
+				c.Locals("user", tok)
+			}
+		}
+		return c.Next()
+	})
+	h.RegisterPublicRoutes(app)
+	h.RegisterProtectedRoutes(app)
+	return app
+}
+
+func postReview(t *testing.T, app *fiber.App, userID int, body string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/api/v1/product/7/reviews", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", strconv.Itoa(userID))
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(res.Body)
+	return res.StatusCode, string(b)
+}
+
+func TestCreateReview_RequiresDeliveredOrder(t *testing.T) {
+	repo := NewInMemoryRepository(map[int][]int{1: {7}})
+	app := makeAppWithReviewHandler(NewHandler(NewService(repo)))
+
+	if code, _ := postReview(t, app, 2, `{"rating":5,"text":"great"}`); code != fiber.StatusForbidden {
+		t.Fatalf("expected 403 for a user without a delivered order, got %d", code)
+	}
+	if code, _ := postReview(t, app, 1, `{"rating":6}`); code != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 for rating out of range, got %d", code)
+	}
+	for _, photo := range []string{"https://tracker.example/p.jpg", "/uploads/files/ab12.jpg", "/uploads/reviews/2/ab12.jpg"} {
+		if code, _ := postReview(t, app, 1, `{"rating":4,"photos":["`+photo+`"]}`); code != fiber.StatusBadRequest {
+			t.Fatalf("expected 400 citing %s, got %d", photo, code)
+		}
+	}
+	if code, body := postReview(t, app, 1, `{"rating":4,"text":"my cat loves it","photos":["/uploads/reviews/1/ab12.jpg"]}`); code != fiber.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", code, body)
+	}
+	if code, _ := postReview(t, app, 1, `{"rating":3}`); code != fiber.StatusConflict {
+		t.Fatalf("expected 409 for a second review, got %d", code)
+	}
+}
+
+func TestListReviews_SummaryAndHelpfulSort(t *testing.T) {
+	repo := NewInMemoryRepository(map[int][]int{1: {7}, 2: {7}, 3: {7}})
+	app := makeAppWithReviewHandler(NewHandler(NewService(repo)))
+
+	postReview(t, app, 1, `{"rating":5}`)
+	postReview(t, app, 2, `{"rating":2}`)
+
+	vote := func(reviewID, userID int) int {
+		req := httptest.NewRequest("POST", "/api/v1/reviews/"+strconv.Itoa(reviewID)+"/helpful", nil)
+		req.Header.Set("X-User-ID", strconv.Itoa(userID))
+		res, _ := app.Test(req)
+		return res.StatusCode
+	}
+	if code := vote(1, 1); code != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 voting on own review, got %d", code)
+	}
+	if code := vote(1, 3); code != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	// a repeated vote does not count twice
+	vote(1, 3)
+	if code := vote(99, 3); code != fiber.StatusNotFound {
+		t.Fatalf("expected 404 for unknown review, got %d", code)
+	}
+
+	res, _ := app.Test(httptest.NewRequest("GET", "/api/v1/product/7/reviews", nil))
+	if res.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d", res.StatusCode)
+	}
+	var page Page
+	if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || page.Summary.Count != 2 || page.Summary.Average != 3.5 {
+		t.Fatalf("unexpected summary: total=%d %+v", page.Total, page.Summary)
+	}
+	if len(page.Items) != 2 || page.Items[0].ReviewID != 1 || page.Items[0].HelpfulCount != 1 {
+		t.Fatalf("expected the helpful review first, got %+v", page.Items)
+	}
+
+	res, _ = app.Test(httptest.NewRequest("GET", "/api/v1/product/7/reviews?sort=rating_desc&pageSize=1&page=2", nil))
+	page = Page{}
+	_ = json.NewDecoder(res.Body).Decode(&page)
+	if len(page.Items) != 1 || page.Items[0].Rating != 2 {
+		t.Fatalf("expected the lower rating on page 2, got %+v", page.Items)
+	}
+
+	res, _ = app.Test(httptest.NewRequest("GET", "/api/v1/product/7/reviews?sort=random", nil))
+	if res.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown sort, got %d", res.StatusCode)
+	}
+}
+
+func TestUploadPhoto_StoresUnderUploaderPrefix(t *testing.T) {
+	blobs := storage.NewMemory(nil)
+	app := makeAppWithReviewHandler(NewHandler(NewService(NewInMemoryRepository(map[int][]int{1: {7}}))).WithStorage(blobs))
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewNRGBA(image.Rect(0, 0, 64, 64)))
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, _ := w.CreateFormFile("file", "cat.png")
+	part.Write(img.Bytes())
+	w.Close()
+	req := httptest.NewRequest("POST", "/api/v1/reviews/photos", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set("X-User-ID", "1")
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Photo string `json:"photo"`
+	}
+	json.NewDecoder(res.Body).Decode(&out)
+	if res.StatusCode != fiber.StatusCreated || !strings.HasPrefix(out.Photo, "/uploads/reviews/1/") {
+		t.Fatalf("expected 201 with a photo under reviews/1/, got %d %q", res.StatusCode, out.Photo)
+	}
+	if _, err := blobs.Get(strings.TrimPrefix(out.Photo, "/uploads/")); err != nil {
+		t.Fatalf("expected the photo to be stored, got %v", err)
+	}
+	if code, body := postReview(t, app, 1, `{"rating":5,"photos":["`+out.Photo+`"]}`); code != fiber.StatusCreated {
+		t.Fatalf("expected the uploaded photo to be accepted, got %d: %s", code, body)
+	}
+}
@@ -0,0 +1,159 @@
+package review
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Repository persists reviews and keeps each product's cached rating
+// aggregate in step with them.
+type Repository interface {
+	// Eligible reports whether the user has a delivered order containing
+	// the product.
+	Eligible(userID, productID int) (bool, error)
+	// Create stores a review and refreshes the product's rating aggregate
+	// in the same transaction. A second review by the same user for the
+	// same product returns ErrAlreadyReviewed.
+	Create(r Review) (Review, error)
+	GetByID(id int) (Review, error)
+	// List returns one page of a product's reviews in the requested order
+	// together with the product's rating summary.
+	List(q ListQuery) (Page, error)
+	// MarkHelpful records the user's helpful vote on a review. Voting twice
+	// is a no-op.
+	MarkHelpful(reviewID, userID int) (Review, error)
+}
+
+// InMemoryRepository is a simple in-memory implementation useful for tests.
+type InMemoryRepository struct {
+	mu        sync.RWMutex
+	reviews   []Review
+	votes     map[int]map[int]bool
+	nextID    int
+	delivered map[int]map[int]bool
+}
+
+// NewInMemoryRepository creates a repository where delivered maps a user id
+// to the product ids that user has received.
+func NewInMemoryRepository(delivered map[int][]int) *InMemoryRepository {
+	r := &InMemoryRepository{
+		votes:     make(map[int]map[int]bool),
+		nextID:    1,
+		delivered: make(map[int]map[int]bool),
+	}
+	for userID, productIDs := range delivered {
+		r.delivered[userID] = make(map[int]bool)
+		for _, pid := range productIDs {
+			r.delivered[userID][pid] = true
+		}
+	}
+	return r
+}
+
+func (r *InMemoryRepository) Eligible(userID, productID int) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.delivered[userID][productID], nil
+}
+
+func (r *InMemoryRepository) Create(rev Review) (Review, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.reviews {
+		if existing.ProductID == rev.ProductID && existing.UserID == rev.UserID {
+			return Review{}, ErrAlreadyReviewed
+		}
+	}
+	rev.ReviewID = r.nextID
+	r.nextID++
+	rev.HelpfulCount = 0
+	if rev.Photos == nil {
+		rev.Photos = []string{}
+	}
+	if rev.CreatedAt == "" {
+		rev.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	r.reviews = append(r.reviews, rev)
+	return rev, nil
+}
+
+func (r *InMemoryRepository) GetByID(id int) (Review, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, rev := range r.reviews {
+		if rev.ReviewID == id {
+			return rev, nil
+		}
+	}
+	return Review{}, ErrNotFound
+}
+
+func (r *InMemoryRepository) List(q ListQuery) (Page, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	matched := make([]Review, 0)
+	ratings := make([]int, 0)
+	for _, rev := range r.reviews {
+		if rev.ProductID == q.ProductID {
+			matched = append(matched, rev)
+			ratings = append(ratings, rev.Rating)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return less(q.Sort, matched[i], matched[j])
+	})
+
+	page := Page{Items: []Review{}, Total: len(matched), Page: q.Page, PageSize: q.PageSize, Summary: summarize(ratings)}
+	start := (q.Page - 1) * q.PageSize
+	if start < len(matched) {
+		end := start + q.PageSize
+		if end > len(matched) {
+			end = len(matched)
+		}
+		page.Items = matched[start:end]
+	}
+	return page, nil
+}
+
+func (r *InMemoryRepository) MarkHelpful(reviewID, userID int) (Review, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.reviews {
+		if r.reviews[i].ReviewID != reviewID {
+			continue
+		}
+		if r.votes[reviewID] == nil {
+			r.votes[reviewID] = make(map[int]bool)
+		}
+		if !r.votes[reviewID][userID] {
+			r.votes[reviewID][userID] = true
+			r.reviews[i].HelpfulCount++
+		}
+		return r.reviews[i], nil
+	}
+	return Review{}, ErrNotFound
+}
+
+// less orders reviews for the given sort, falling back to newest first. It
+// mirrors the ORDER BY clauses of the Postgres repository.
+func less(sortBy string, a, b Review) bool {
+	switch sortBy {
+	case SortHelpful:
+		if a.HelpfulCount != b.HelpfulCount {
+			return a.HelpfulCount > b.HelpfulCount
+		}
+	case SortHighest:
+		if a.Rating != b.Rating {
+			return a.Rating > b.Rating
+		}
+	case SortLowest:
+		if a.Rating != b.Rating {
+			return a.Rating < b.Rating
+		}
+	}
+	if a.CreatedAt != b.CreatedAt {
+		return a.CreatedAt > b.CreatedAt
+	}
+	return a.ReviewID > b.ReviewID
+}
@@ -0,0 +1,205 @@
+package review
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/wichananm65/pet-shop-backend/internal/order"
+)
+
+type PostgresRepository struct {
+	db *sql.DB
+}
+
+const (
+	eligibleQuery = `
+		SELECT EXISTS (
+			SELECT 1
+			FROM orders o
+			JOIN order_items i ON i."orderID" = o."orderID"
+			WHERE o."userID" = $1 AND i."productID" = $2 AND o.status = $3
+		)
+	`
+	insertReviewQuery = `
+		INSERT INTO reviews (product_id, user_id, rating, body, photos)
+		VALUES ($1,$2,$3,$4,$5)
+		ON CONFLICT (product_id, user_id) DO NOTHING
+		RETURNING review_id, created_at
+	`
+	// refreshRatingQuery recomputes the cached aggregate from the reviews
+	// table; score follows the average rounded to a whole star.
+	refreshRatingQuery = `
+		UPDATE products p
+		SET rating_avg = s.avg, rating_count = s.cnt, score = ROUND(s.avg)::int, updated_at = now()
+		FROM (
+			SELECT COALESCE(ROUND(AVG(rating), 2), 0) AS avg, COUNT(*) AS cnt
+			FROM reviews
+			WHERE product_id = $1
+		) s
+		WHERE p.productid = $1
+	`
+	selectReviewColumns = `review_id, product_id, user_id, rating, body, photos, helpful_count, created_at`
+)
+
+// listOrders maps each sort to its ORDER BY clause; newest first breaks ties.
+var listOrders = map[string]string{
+	SortHelpful: `helpful_count DESC, created_at DESC, review_id DESC`,
+	SortNewest:  `created_at DESC, review_id DESC`,
+	SortHighest: `rating DESC, created_at DESC, review_id DESC`,
+	SortLowest:  `rating ASC, created_at DESC, review_id DESC`,
+}
+
+func NewPostgresRepository(db *sql.DB) *PostgresRepository {
+	return &PostgresRepository{db: db}
+}
+
+func (r *PostgresRepository) Eligible(userID, productID int) (bool, error) {
+	var ok bool
+	err := r.db.QueryRow(eligibleQuery, userID, productID, order.StatusDelivered).Scan(&ok)
+	return ok, err
+}
+
+// Create inserts the review and refreshes the product aggregate in one
+// transaction. The product row is locked first so concurrent reviews of the
+// same product cannot compute the aggregate from a stale set of ratings.
+func (r *PostgresRepository) Create(rev Review) (Review, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return Review{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var locked int
+	if err := tx.QueryRow(`SELECT productid FROM products WHERE productid = $1 FOR UPDATE`, rev.ProductID).Scan(&locked); err != nil {
+		if err == sql.ErrNoRows {
+			return Review{}, ErrProductNotFound
+		}
+		return Review{}, err
+	}
+	if rev.Photos == nil {
+		rev.Photos = []string{}
+	}
+	var createdAt time.Time
+	err = tx.QueryRow(insertReviewQuery, rev.ProductID, rev.UserID, rev.Rating, rev.Text, pq.Array(rev.Photos)).Scan(&rev.ReviewID, &createdAt)
+	if err == sql.ErrNoRows {
+		return Review{}, ErrAlreadyReviewed
+	}
+	if err != nil {
+		return Review{}, err
+	}
+	if _, err := tx.Exec(refreshRatingQuery, rev.ProductID); err != nil {
+		return Review{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Review{}, err
+	}
+	rev.HelpfulCount = 0
+	rev.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	return rev, nil
+}
+
+func (r *PostgresRepository) GetByID(id int) (Review, error) {
+	rev, err := scanReview(r.db.QueryRow(`SELECT `+selectReviewColumns+` FROM reviews WHERE review_id = $1`, id))
+	if err == sql.ErrNoRows {
+		return Review{}, ErrNotFound
+	}
+	return rev, err
+}
+
+// List reads the summary from the cached aggregate on the product, whose
+// count doubles as the total number of reviews.
+func (r *PostgresRepository) List(q ListQuery) (Page, error) {
+	page := Page{Items: []Review{}, Page: q.Page, PageSize: q.PageSize}
+	err := r.db.QueryRow(`SELECT rating_avg, rating_count FROM products WHERE productid = $1`, q.ProductID).
+		Scan(&page.Summary.Average, &page.Summary.Count)
+	if err == sql.ErrNoRows {
+		return Page{}, ErrProductNotFound
+	}
+	if err != nil {
+		return Page{}, err
+	}
+	page.Total = page.Summary.Count
+	if page.Total == 0 {
+		return page, nil
+	}
+
+	orderBy, ok := listOrders[q.Sort]
+	if !ok {
+		return Page{}, ErrInvalidSort
+	}
+	rows, err := r.db.Query(`SELECT `+selectReviewColumns+` FROM reviews WHERE product_id = $1 ORDER BY `+orderBy+` LIMIT $2 OFFSET $3`,
+		q.ProductID, q.PageSize, (q.Page-1)*q.PageSize)
+	if err != nil {
+		return Page{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rev, err := scanReview(rows)
+		if err != nil {
+			return Page{}, err
+		}
+		page.Items = append(page.Items, rev)
+	}
+	return page, rows.Err()
+}
+
+// MarkHelpful records the vote and bumps the cached helpful count only when
+// the vote is new.
+func (r *PostgresRepository) MarkHelpful(reviewID, userID int) (Review, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return Review{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var locked int
+	if err := tx.QueryRow(`SELECT review_id FROM reviews WHERE review_id = $1 FOR UPDATE`, reviewID).Scan(&locked); err != nil {
+		if err == sql.ErrNoRows {
+			return Review{}, ErrNotFound
+		}
+		return Review{}, err
+	}
+	res, err := tx.Exec(`INSERT INTO review_helpful_votes (review_id, user_id) VALUES ($1,$2) ON CONFLICT DO NOTHING`, reviewID, userID)
+	if err != nil {
+		return Review{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		if _, err := tx.Exec(`UPDATE reviews SET helpful_count = helpful_count + 1 WHERE review_id = $1`, reviewID); err != nil {
+			return Review{}, err
+		}
+	}
+	rev, err := scanReview(tx.QueryRow(`SELECT `+selectReviewColumns+` FROM reviews WHERE review_id = $1`, reviewID))
+	if err != nil {
+		return Review{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Review{}, err
+	}
+	return rev, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanReview(row rowScanner) (Review, error) {
+	var (
+		rev       Review
+		photos    pq.StringArray
+		createdAt time.Time
+	)
+	if err := row.Scan(&rev.ReviewID, &rev.ProductID, &rev.UserID, &rev.Rating, &rev.Text, &photos, &rev.HelpfulCount, &createdAt); err != nil {
+		return Review{}, err
+	}
+	rev.Photos = []string(photos)
+	if rev.Photos == nil {
+		rev.Photos = []string{}
+	}
+	rev.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	return rev, nil
+}
@@ -0,0 +1,148 @@
+package review
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wichananm65/pet-shop-backend/internal/storage"
+)
+
+// Review limits.
+const (
+	MinRating       = 1
+	MaxRating       = 5
+	MaxTextLength   = 2000
+	MaxPhotos       = 5
+	DefaultPageSize = 10
+	MaxPageSize     = 50
+)
+
+// Sort orders accepted by the review listing. SortHelpful is the default.
+const (
+	SortHelpful = "helpful"
+	SortNewest  = "newest"
+	SortHighest = "rating_desc"
+	SortLowest  = "rating_asc"
+)
+
+var (
+	ErrNotFound        = errors.New("review not found")
+	ErrProductNotFound = errors.New("product not found")
+	ErrNotEligible     = errors.New("only customers with a delivered order containing this product can review it")
+	ErrAlreadyReviewed = errors.New("you have already reviewed this product")
+	ErrInvalidSort     = errors.New("sort must be one of helpful, newest, rating_desc, rating_asc")
+	ErrOwnReview       = errors.New("you cannot vote on your own review")
+)
+
+// PhotoPrefix is the storage key prefix of the review photos uploaded by
+// userID. A review may only cite photos under its author's prefix, so it
+// cannot point at third-party hosts or at other people's files.
+func PhotoPrefix(userID int) string {
+	return fmt.Sprintf("reviews/%d/", userID)
+}
+
+// Review is a customer's rating of a product. Photos holds /uploads URLs
+// under PhotoPrefix(UserID).
+type Review struct {
+	ReviewID     int      `json:"reviewID"`
+	ProductID    int      `json:"productID"`
+	UserID       int      `json:"userID"`
+	Rating       int      `json:"rating"`
+	Text         string   `json:"text"`
+	Photos       []string `json:"photos"`
+	HelpfulCount int      `json:"helpfulCount"`
+	CreatedAt    string   `json:"createdAt"`
+}
+
+// Summary is the aggregate rating of a product as cached on the product row.
+type Summary struct {
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
+}
+
+// ListQuery is a normalized review listing request. Page is 1-based.
+type ListQuery struct {
+	ProductID int
+	Sort      string
+	Page      int
+	PageSize  int
+}
+
+// Page is one page of a product's reviews together with its rating summary.
+type Page struct {
+	Items    []Review `json:"items"`
+	Total    int      `json:"total"`
+	Page     int      `json:"page"`
+	PageSize int      `json:"pageSize"`
+	Summary  Summary  `json:"summary"`
+}
+
+// ValidationError lists the invalid fields of a review keyed by JSON name.
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid review"
+}
+
+func validateReview(r *Review) error {
+	r.Text = strings.TrimSpace(r.Text)
+	errs := map[string]string{}
+	if r.Rating < MinRating || r.Rating > MaxRating {
+		errs["rating"] = "rating must be between 1 and 5"
+	}
+	if utf8.RuneCountInString(r.Text) > MaxTextLength {
+		errs["text"] = "text must be at most 2000 characters"
+	}
+	if len(r.Photos) > MaxPhotos {
+		errs["photos"] = "at most 5 photos are allowed"
+	}
+	prefix := "/uploads/" + PhotoPrefix(r.UserID)
+	for _, p := range r.Photos {
+		if !strings.HasPrefix(p, prefix) || !storage.ValidKey(strings.TrimPrefix(p, "/uploads/")) {
+			errs["photos"] = "photos must be uploaded through /api/v1/reviews/photos"
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+	return nil
+}
+
+func (q *ListQuery) normalize() error {
+	switch q.Sort {
+	case "":
+		q.Sort = SortHelpful
+	case SortHelpful, SortNewest, SortHighest, SortLowest:
+	default:
+		return ErrInvalidSort
+	}
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.PageSize < 1 {
+		q.PageSize = DefaultPageSize
+	}
+	if q.PageSize > MaxPageSize {
+		q.PageSize = MaxPageSize
+	}
+	return nil
+}
+
+// summarize computes the aggregate of the given ratings, with the average
+// rounded to two decimals as stored on the product.
+func summarize(ratings []int) Summary {
+	if len(ratings) == 0 {
+		return Summary{}
+	}
+	sum := 0
+	for _, r := range ratings {
+		sum += r
+	}
+	avg := float64(sum) / float64(len(ratings))
+	return Summary{Average: float64(int(avg*100+0.5)) / 100, Count: len(ratings)}
+}
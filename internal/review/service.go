@@ -0,0 +1,49 @@
+package review
+
+// Service provides business logic for product reviews.
+type Service struct {
+	repo Repository
+}
+
+func NewService(r Repository) *Service {
+	return &Service{repo: r}
+}
+
+// Create posts a review on behalf of userID, who must have received the
+// product in a delivered order.
+func (s *Service) Create(userID int, rev Review) (Review, error) {
+	rev.UserID = userID
+	if err := validateReview(&rev); err != nil {
+		return Review{}, err
+	}
+	ok, err := s.repo.Eligible(userID, rev.ProductID)
+	if err != nil {
+		return Review{}, err
+	}
+	if !ok {
+		return Review{}, ErrNotEligible
+	}
+	return s.repo.Create(rev)
+}
+
+// List returns one page of a product's reviews, most helpful first unless
+// another sort is requested.
+func (s *Service) List(q ListQuery) (Page, error) {
+	if err := q.normalize(); err != nil {
+		return Page{}, err
+	}
+	return s.repo.List(q)
+}
+
+// MarkHelpful records that userID found the review helpful. Authors cannot
+// vote on their own reviews.
+func (s *Service) MarkHelpful(reviewID, userID int) (Review, error) {
+	rev, err := s.repo.GetByID(reviewID)
+	if err != nil {
+		return Review{}, err
+	}
+	if rev.UserID == userID {
+		return Review{}, ErrOwnReview
+	}
+	return s.repo.MarkHelpful(reviewID, userID)
+}
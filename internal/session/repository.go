@@ -0,0 +1,97 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+type Repository interface {
+	Create(t RefreshToken) error
+	// GetByHash returns ErrNotFound for unknown hashes.
+	GetByHash(hash string) (RefreshToken, error)
+	// Rotate marks token oldID as used and stores next. It fails with
+	// ErrTokenReused when oldID was used or revoked in the meantime, so two
+	// concurrent refreshes cannot both succeed.
+	Rotate(oldID int64, next RefreshToken) error
+	RevokeFamily(familyID string) error
+	RevokeUser(userID int) error
+}
+
+type InMemoryRepository struct {
+	mu     sync.RWMutex
+	tokens []RefreshToken
+	nextID int64
+}
+
+func NewInMemoryRepository() *InMemoryRepository {
+	return &InMemoryRepository{nextID: 1}
+}
+
+func (r *InMemoryRepository) Create(t RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.create(t)
+	return nil
+}
+
+func (r *InMemoryRepository) create(t RefreshToken) {
+	t.ID = r.nextID
+	r.nextID++
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now().UTC()
+	}
+	r.tokens = append(r.tokens, t)
+}
+
+func (r *InMemoryRepository) GetByHash(hash string) (RefreshToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, t := range r.tokens {
+		if t.TokenHash == hash {
+			return t, nil
+		}
+	}
+	return RefreshToken{}, ErrNotFound
+}
+
+func (r *InMemoryRepository) Rotate(oldID int64, next RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.tokens {
+		if r.tokens[i].ID != oldID {
+			continue
+		}
+		if r.tokens[i].UsedAt != nil || r.tokens[i].RevokedAt != nil {
+			return ErrTokenReused
+		}
+		now := time.Now().UTC()
+		r.tokens[i].UsedAt = &now
+		r.create(next)
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (r *InMemoryRepository) RevokeFamily(familyID string) error {
+	return r.revoke(func(t RefreshToken) bool { return t.FamilyID == familyID })
+}
+
+func (r *InMemoryRepository) RevokeUser(userID int) error {
+	return r.revoke(func(t RefreshToken) bool { return t.UserID == userID })
+}
+
+func (r *InMemoryRepository) revoke(match func(RefreshToken) bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	for i := range r.tokens {
+		if match(r.tokens[i]) && r.tokens[i].RevokedAt == nil {
+			r.tokens[i].RevokedAt = &now
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package session
+
+import (
+	"database/sql"
+)
+
+// PostgresRepository stores refresh tokens in the refresh_tokens table.
+type PostgresRepository struct {
+	db *sql.DB
+}
+
+const (
+	insertTokenQuery = `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`
+	getTokenByHashQuery = `
+		SELECT id, user_id, family_id, token_hash, expires_at, created_at, used_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+	`
+	markTokenUsedQuery = `
+		UPDATE refresh_tokens SET used_at = now()
+		WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL
+	`
+	revokeFamilyQuery = `UPDATE refresh_tokens SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL`
+	revokeUserQuery   = `UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`
+)
+
+func NewPostgresRepository(db *sql.DB) *PostgresRepository {
+	return &PostgresRepository{db: db}
+}
+
+func (r *PostgresRepository) Create(t RefreshToken) error {
+	_, err := r.db.Exec(insertTokenQuery, t.UserID, t.FamilyID, t.TokenHash, t.ExpiresAt)
+	return err
+}
+
+func (r *PostgresRepository) GetByHash(hash string) (RefreshToken, error) {
+	var t RefreshToken
+	err := r.db.QueryRow(getTokenByHashQuery, hash).
+		Scan(&t.ID, &t.UserID, &t.FamilyID, &t.TokenHash, &t.ExpiresAt, &t.CreatedAt, &t.UsedAt, &t.RevokedAt)
+	if err == sql.ErrNoRows {
+		return RefreshToken{}, ErrNotFound
+	}
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	return t, nil
+}
+
+// Rotate relies on the conditional update to let only one of several
+// concurrent refreshes with the same token win.
+func (r *PostgresRepository) Rotate(oldID int64, next RefreshToken) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	result, err := tx.Exec(markTokenUsedQuery, oldID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrTokenReused
+	}
+	if _, err := tx.Exec(insertTokenQuery, next.UserID, next.FamilyID, next.TokenHash, next.ExpiresAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresRepository) RevokeFamily(familyID string) error {
+	_, err := r.db.Exec(revokeFamilyQuery, familyID)
+	return err
+}
+
+func (r *PostgresRepository) RevokeUser(userID int) error {
+	_, err := r.db.Exec(revokeUserQuery, userID)
+	return err
+}
@@ -0,0 +1,164 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
+)
+
+type Service struct {
+	repo       Repository
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	now        func() time.Time
+}
+
+// NewService signs access tokens with secret, the key the JWT middleware
+// verifies them with.
+func NewService(repo Repository, secret string) *Service {
+	return &Service{
+		repo:       repo,
+		secret:     []byte(secret),
+		accessTTL:  DefaultAccessTTL,
+		refreshTTL: DefaultRefreshTTL,
+		now:        time.Now,
+	}
+}
+
+// WithTTLs overrides the token lifetimes.
+func (s *Service) WithTTLs(access, refresh time.Duration) *Service {
+	s.accessTTL = access
+	s.refreshTTL = refresh
+	return s
+}
+
+// Issue starts a new token family for a login.
+func (s *Service) Issue(c Claims) (Tokens, error) {
+	family, err := randomToken()
+	if err != nil {
+		return Tokens{}, err
+	}
+	raw, next, err := s.newRefreshToken(c.UserID, family)
+	if err != nil {
+		return Tokens{}, err
+	}
+	if err := s.repo.Create(next); err != nil {
+		return Tokens{}, err
+	}
+	return s.tokens(c, raw)
+}
+
+// Refresh exchanges a refresh token for a new pair. reload returns the
+// current claims of the user, so role changes apply from the next refresh.
+// Replaying a token that was already rotated revokes its family and
+// returns ErrTokenReused.
+func (s *Service) Refresh(raw string, reload func(userID int) (Claims, error)) (Tokens, error) {
+	old, err := s.repo.GetByHash(hashToken(raw))
+	if err == ErrNotFound {
+		return Tokens{}, ErrInvalidToken
+	}
+	if err != nil {
+		return Tokens{}, err
+	}
+	if old.UsedAt != nil {
+		return Tokens{}, s.reused(old)
+	}
+	if old.RevokedAt != nil || !s.now().Before(old.ExpiresAt) {
+		return Tokens{}, ErrInvalidToken
+	}
+
+	c, err := reload(old.UserID)
+	if err != nil {
+		// the user is gone; nothing should refresh for them any more
+		_ = s.repo.RevokeFamily(old.FamilyID)
+		return Tokens{}, ErrInvalidToken
+	}
+	nextRaw, next, err := s.newRefreshToken(old.UserID, old.FamilyID)
+	if err != nil {
+		return Tokens{}, err
+	}
+	if err := s.repo.Rotate(old.ID, next); err != nil {
+		if errors.Is(err, ErrTokenReused) {
+			return Tokens{}, s.reused(old)
+		}
+		return Tokens{}, err
+	}
+	return s.tokens(c, nextRaw)
+}
+
+// Revoke signs out the login raw belongs to. Unknown tokens are reported
+// as ErrInvalidToken.
+func (s *Service) Revoke(raw string) error {
+	t, err := s.repo.GetByHash(hashToken(raw))
+	if err == ErrNotFound {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+	return s.repo.RevokeFamily(t.FamilyID)
+}
+
+// RevokeAll signs a user out of every device. Access tokens already handed
+// out stay valid until they expire.
+func (s *Service) RevokeAll(userID int) error {
+	return s.repo.RevokeUser(userID)
+}
+
+func (s *Service) reused(t RefreshToken) error {
+	if err := s.repo.RevokeFamily(t.FamilyID); err != nil {
+		return err
+	}
+	return ErrTokenReused
+}
+
+func (s *Service) newRefreshToken(userID int, family string) (string, RefreshToken, error) {
+	raw, err := randomToken()
+	if err != nil {
+		return "", RefreshToken{}, err
+	}
+	return raw, RefreshToken{
+		UserID:    userID,
+		FamilyID:  family,
+		TokenHash: hashToken(raw),
+		ExpiresAt: s.now().Add(s.refreshTTL).UTC(),
+	}, nil
+}
+
+func (s *Service) tokens(c Claims, refresh string) (Tokens, error) {
+	if len(s.secret) == 0 {
+		return Tokens{}, errors.New("no JWT secret configured")
+	}
+	claims := jwt.MapClaims{
+		"user_id":      c.UserID,
+		"email":        c.Email,
+		auth.RoleClaim: c.Role,
+		"exp":          s.now().Add(s.accessTTL).Unix(),
+	}
+	access, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	if err != nil {
+		return Tokens{}, err
+	}
+	return Tokens{AccessToken: access, RefreshToken: refresh, ExpiresIn: int(s.accessTTL / time.Second)}, nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is what gets stored, so a leaked table cannot be replayed.
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,84 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
+)
+
+func claimsFor(userID int) func(int) (Claims, error) {
+	return func(id int) (Claims, error) {
+		if id != userID {
+			return Claims{}, errors.New("user not found")
+		}
+		return Claims{UserID: id, Email: "a@example.com", Role: auth.RoleCustomer}, nil
+	}
+}
+
+func TestRefresh_RotatesAndRevokesFamilyOnReuse(t *testing.T) {
+	s := NewService(NewInMemoryRepository(), "secret")
+	first, err := s.Issue(Claims{UserID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := s.Issue(Claims{UserID: 1})
+
+	second, err := s.Refresh(first.RefreshToken, claimsFor(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == "" {
+		t.Fatalf("expected a rotated token pair, got %+v", second)
+	}
+
+	if _, err := s.Refresh(first.RefreshToken, claimsFor(1)); err != ErrTokenReused {
+		t.Fatalf("expected ErrTokenReused replaying a rotated token, got %v", err)
+	}
+	if _, err := s.Refresh(second.RefreshToken, claimsFor(1)); err != ErrInvalidToken {
+		t.Fatalf("expected the family to be revoked, got %v", err)
+	}
+	if _, err := s.Refresh(other.RefreshToken, claimsFor(1)); err != nil {
+		t.Fatalf("expected the other login to survive, got %v", err)
+	}
+}
+
+func TestRefresh_RejectsExpiredAndUnknown(t *testing.T) {
+	s := NewService(NewInMemoryRepository(), "secret").WithTTLs(time.Minute, time.Hour)
+	tokens, _ := s.Issue(Claims{UserID: 1})
+	if tokens.ExpiresIn != 60 {
+		t.Fatalf("expected expiresIn 60, got %d", tokens.ExpiresIn)
+	}
+
+	s.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := s.Refresh(tokens.RefreshToken, claimsFor(1)); err != ErrInvalidToken {
+		t.Fatalf("expected ErrInvalidToken for an expired token, got %v", err)
+	}
+	if _, err := s.Refresh("nope", claimsFor(1)); err != ErrInvalidToken {
+		t.Fatalf("expected ErrInvalidToken for an unknown token, got %v", err)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	s := NewService(NewInMemoryRepository(), "secret")
+	a, _ := s.Issue(Claims{UserID: 1})
+	b, _ := s.Issue(Claims{UserID: 1})
+	c, _ := s.Issue(Claims{UserID: 2})
+
+	if err := s.Revoke(a.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Refresh(a.RefreshToken, claimsFor(1)); err != ErrInvalidToken {
+		t.Fatalf("expected a signed out token to be rejected, got %v", err)
+	}
+	if err := s.RevokeAll(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Refresh(b.RefreshToken, claimsFor(1)); err != ErrInvalidToken {
+		t.Fatalf("expected sign out everywhere to revoke every login, got %v", err)
+	}
+	if _, err := s.Refresh(c.RefreshToken, claimsFor(2)); err != nil {
+		t.Fatalf("expected other users to stay signed in, got %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+// Package session issues the tokens a login hands out: a short-lived JWT
+// access token and an opaque refresh token. Refresh tokens are stored as
+// SHA-256 hashes and rotate on every use; presenting one that was already
+// rotated revokes its whole family, i.e. every token descended from the
+// same login.
+package session
+
+import (
+	"errors"
+	"time"
+
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
+)
+
+// Default lifetimes of the two token kinds.
+const (
+	DefaultAccessTTL  = 15 * time.Minute
+	DefaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrNotFound     = errors.New("refresh token not found")
+	ErrInvalidToken = errors.New("invalid or expired refresh token")
+	ErrTokenReused  = errors.New("refresh token was already used; all sessions of this login were signed out")
+)
+
+// Claims is what an access token says about its user.
+type Claims struct {
+	UserID int
+	Email  string
+	Role   auth.Role
+}
+
+// Tokens is the response of a login or refresh. ExpiresIn is the lifetime
+// of the access token in seconds.
+type Tokens struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int    `json:"expiresIn"`
+}
+
+// RefreshToken is a stored refresh token. UsedAt is set once it has been
+// rotated, RevokedAt when it was signed out.
+type RefreshToken struct {
+	ID        int64
+	UserID    int
+	FamilyID  string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
@@ -4,6 +4,7 @@ import (
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/wichananm65/pet-shop-backend/internal/listing"
 )
 
 type Handler struct {
@@ -17,15 +18,18 @@ func (h *Handler) RegisterPublicRoutes(app *fiber.App) {
 	app.Get("/api/v1/product/shopping-mall", h.getProductShoppingMall)
 }
 
+// getShoppingMall lists products with optional filters (minPrice, maxPrice,
+// category, minScore, inStock), sort and cursor pagination.
 func (h *Handler) getShoppingMall(c *fiber.Ctx) error {
-	limit := 100
-	if l := c.Query("limit"); l != "" {
-		if v, err := strconv.Atoi(l); err == nil && v > 0 {
-			limit = v
-		}
+	q, err := listing.FromRequest(c, listing.MaxLimit)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
 	}
-	items := h.service.List(limit)
-	return c.JSON(items)
+	page, err := h.service.List(q)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	return c.JSON(page)
 }
 
 func (h *Handler) getProductShoppingMall(c *fiber.Ctx) error {
@@ -2,11 +2,14 @@ package shoppingmall
 
 import (
 	"database/sql"
+
+	"github.com/wichananm65/pet-shop-backend/internal/listing"
 )
 
 // Repository provides access to shopping-mall rows.
 type Repository interface {
-	List(limit int) ([]ShoppingMallItem, error)
+	// List returns up to q.Limit+1 items matching the listing query.
+	List(q listing.Query) ([]ShoppingMallItem, error)
 	ListLite(limit int) ([]LiteItem, error)
 }
 
@@ -19,8 +22,17 @@ func NewPostgresRepository(db *sql.DB) *PostgresRepository {
 	return &PostgresRepository{db: db}
 }
 
-func (r *PostgresRepository) List(limit int) ([]ShoppingMallItem, error) {
-	rows, err := r.db.Query(`SELECT productid, productimg, productprice, score, productname, productnameth FROM products ORDER BY productid LIMIT $1`, limit)
+var listColumns = listing.Columns{
+	ID:       "p.productid",
+	Price:    "p.productprice",
+	Score:    "p.score",
+	Stock:    "p.stock",
+	Category: `(SELECT c."categoryID" FROM category c WHERE c."categoryName" = p.category LIMIT 1)`,
+}
+
+func (r *PostgresRepository) List(q listing.Query) ([]ShoppingMallItem, error) {
+	query, args := q.Build(`SELECT p.productid, p.productimg, p.productprice, p.score, p.productname, p.productnameth, p.stock FROM products p`, listColumns)
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return []ShoppingMallItem{}, nil
 	}
@@ -35,11 +47,13 @@ func (r *PostgresRepository) List(limit int) ([]ShoppingMallItem, error) {
 			score  sql.NullInt64
 			name   sql.NullString
 			nameTH sql.NullString
+			stock  sql.NullInt64
 		)
-		if err := rows.Scan(&id, &img, &price, &score, &name, &nameTH); err != nil {
+		if err := rows.Scan(&id, &img, &price, &score, &name, &nameTH, &stock); err != nil {
 			continue
 		}
-		it := ShoppingMallItem{ProductID: id}
+		// a NULL stock is not tracked and counts as in stock
+		it := ShoppingMallItem{ProductID: id, Stock: int(stock.Int64), InStock: !stock.Valid || stock.Int64 > 0}
 		if img.Valid {
 			s := img.String
 			it.ProductImg = &s
@@ -1,5 +1,7 @@
 package shoppingmall
 
+import "github.com/wichananm65/pet-shop-backend/internal/listing"
+
 // Service provides business logic for shopping-mall endpoints.
 type Service struct {
 	repo Repository
@@ -9,12 +11,28 @@ func NewService(r Repository) *Service {
 	return &Service{repo: r}
 }
 
-func (s *Service) List(limit int) []ShoppingMallItem {
-	items, err := s.repo.List(limit)
+// List returns one page of shopping-mall items filtered and sorted by q.
+func (s *Service) List(q listing.Query) (listing.Page[ShoppingMallItem], error) {
+	q, err := q.Normalize()
 	if err != nil {
-		return []ShoppingMallItem{}
+		return listing.Page[ShoppingMallItem]{}, err
 	}
-	return items
+	items, err := s.repo.List(q)
+	if err != nil {
+		items = []ShoppingMallItem{}
+	}
+	return listing.Paginate(items, q, itemKeys), nil
+}
+
+func itemKeys(it ShoppingMallItem) listing.Keys {
+	k := listing.Keys{ID: it.ProductID}
+	if it.Price != nil {
+		k.Price = *it.Price
+	}
+	if it.Score != nil {
+		k.Score = *it.Score
+	}
+	return k
 }
 
 func (s *Service) ListLite(limit int) []LiteItem {
@@ -9,6 +9,8 @@ type ShoppingMallItem struct {
 	Score         *int    `json:"score,omitempty"`
 	ProductName   *string `json:"productName,omitempty"`
 	ProductNameTH *string `json:"productNameTH,omitempty"`
+	Stock         int     `json:"stock"`
+	InStock       bool    `json:"inStock"`
 }
 
 // LiteItem is the lightweight DTO returned by GET /api/v1/product/shopping-mall
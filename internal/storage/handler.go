@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/wichananm65/pet-shop-backend/internal/imaging"
+)
+
+// Handler serves stored blobs over HTTP: public uploads under /uploads and
+// signed downloads under SignedRoutePrefix.
+type Handler struct {
+	blob   Blob
+	signer *URLSigner
+}
+
+func NewHandler(blob Blob, signer *URLSigner) *Handler {
+	return &Handler{blob: blob, signer: signer}
+}
+
+// RegisterPublicRoutes must run before the JWT middleware; signed downloads
+// are authorized by their signature instead.
+func (h *Handler) RegisterPublicRoutes(app *fiber.App) {
+	app.Get("/uploads/*", h.serveUpload)
+	app.Get(SignedRoutePrefix+"/*", h.serveSigned)
+}
+
+// serveUpload serves a public upload. ?size=thumb|medium serves the matching
+// rendition stored next to processed uploads such as avatars, falling back
+// to the object itself for uploads that predate renditions.
+func (h *Handler) serveUpload(c *fiber.Ctx) error {
+	key, ok := wildcardKey(c)
+	if !ok || strings.HasPrefix(key, PrivatePrefix) {
+		return c.Status(fiber.StatusNotFound).SendString("not found")
+	}
+	size, err := imaging.ParseRendition(c.Query("size"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	obj, err := h.blob.Get(imaging.RenditionPath(key, size))
+	if err == ErrNotFound && size != imaging.Large {
+		obj, err = h.blob.Get(key)
+	}
+	return h.send(c, obj, err)
+}
+
+func (h *Handler) serveSigned(c *fiber.Ctx) error {
+	key, ok := wildcardKey(c)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).SendString("not found")
+	}
+	if err := h.signer.Verify(key, c.Query("expires"), c.Query("signature")); err != nil {
+		return c.Status(fiber.StatusForbidden).SendString(err.Error())
+	}
+	obj, err := h.blob.Get(key)
+	if err == nil {
+		c.Set(fiber.HeaderCacheControl, "private, no-store")
+	}
+	return h.send(c, obj, err)
+}
+
+func (h *Handler) send(c *fiber.Ctx, obj Object, err error) error {
+	if err == ErrNotFound {
+		return c.Status(fiber.StatusNotFound).SendString("not found")
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	if obj.ContentType != "" {
+		c.Set(fiber.HeaderContentType, obj.ContentType)
+	}
+	// browsers must not second-guess the stored type and run an upload as
+	// HTML or script
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	return c.Send(obj.Data)
+}
+
+func wildcardKey(c *fiber.Ctx) (string, bool) {
+	key, err := url.PathUnescape(c.Params("*"))
+	if err != nil || !ValidKey(key) {
+		return "", false
+	}
+	return key, true
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Local stores blobs as files below a root directory. The content type is
+// derived from the file extension when read back.
+type Local struct {
+	root   string
+	signer *URLSigner
+}
+
+// NewLocal stores below root. Without a signer SignedURL fails with
+// ErrNoSigningKey.
+func NewLocal(root string, signer *URLSigner) *Local {
+	if signer == nil {
+		signer = NewURLSigner(SignedRoutePrefix, nil)
+	}
+	return &Local{root: root, signer: signer}
+}
+
+func (l *Local) path(key string) string {
+	return filepath.Join(l.root, filepath.FromSlash(key))
+}
+
+// Put writes to a temporary file first and renames it into place so readers
+// never see a partially written file.
+func (l *Local) Put(key string, data []byte, contentType string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	dest := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+func (l *Local) Get(key string) (Object, error) {
+	if !ValidKey(key) {
+		return Object{}, ErrInvalidKey
+	}
+	data, err := os.ReadFile(l.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Object{}, ErrNotFound
+	}
+	if err != nil {
+		return Object{}, err
+	}
+	ct := mime.TypeByExtension(path.Ext(key))
+	if ct == "" {
+		ct = http.DetectContentType(data)
+	}
+	return Object{Data: data, ContentType: ct}, nil
+}
+
+func (l *Local) Delete(key string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	err := os.Remove(l.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (l *Local) SignedURL(key string, ttl time.Duration) (string, error) {
+	return l.signer.Sign(key, ttl)
+}
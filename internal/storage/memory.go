@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory keeps blobs in a map. It is used by tests and as the default of
+// services that have not been given a real backend.
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string]Object
+	signer  *URLSigner
+}
+
+// NewMemory returns an empty store. Without a signer SignedURL fails with
+// ErrNoSigningKey.
+func NewMemory(signer *URLSigner) *Memory {
+	if signer == nil {
+		signer = NewURLSigner(SignedRoutePrefix, nil)
+	}
+	return &Memory{objects: make(map[string]Object), signer: signer}
+}
+
+func (m *Memory) Put(key string, data []byte, contentType string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = Object{Data: append([]byte(nil), data...), ContentType: contentType}
+	return nil
+}
+
+func (m *Memory) Get(key string) (Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	obj, ok := m.objects[key]
+	if !ok {
+		return Object{}, ErrNotFound
+	}
+	return obj, nil
+}
+
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *Memory) SignedURL(key string, ttl time.Duration) (string, error) {
+	return m.signer.Sign(key, ttl)
+}
+
+// Keys returns the stored keys in sorted order; handy in tests.
+func (m *Memory) Keys() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]string, 0, len(m.objects))
+	for k := range m.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Postgres stores blobs in the storage_blobs table.
+type Postgres struct {
+	db     *sql.DB
+	signer *URLSigner
+}
+
+const (
+	putBlobQuery = `
+		INSERT INTO storage_blobs (key, content_type, data)
+		VALUES ($1,$2,$3)
+		ON CONFLICT (key) DO UPDATE SET content_type = EXCLUDED.content_type, data = EXCLUDED.data, created_at = now()
+	`
+	getBlobQuery    = `SELECT content_type, data FROM storage_blobs WHERE key = $1`
+	deleteBlobQuery = `DELETE FROM storage_blobs WHERE key = $1`
+)
+
+func NewPostgres(db *sql.DB, signer *URLSigner) *Postgres {
+	return &Postgres{db: db, signer: signer}
+}
+
+func (p *Postgres) Put(key string, data []byte, contentType string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	_, err := p.db.Exec(putBlobQuery, key, contentType, data)
+	return err
+}
+
+func (p *Postgres) Get(key string) (Object, error) {
+	var obj Object
+	err := p.db.QueryRow(getBlobQuery, key).Scan(&obj.ContentType, &obj.Data)
+	if err == sql.ErrNoRows {
+		return Object{}, ErrNotFound
+	}
+	return obj, err
+}
+
+func (p *Postgres) Delete(key string) error {
+	_, err := p.db.Exec(deleteBlobQuery, key)
+	return err
+}
+
+func (p *Postgres) SignedURL(key string, ttl time.Duration) (string, error) {
+	return p.signer.Sign(key, ttl)
+}
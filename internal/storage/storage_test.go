@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestValidKey(t *testing.T) {
+	for key, want := range map[string]bool{
+		"avatars/1_ab.jpg": true,
+		"a.png":            true,
+		"":                 false,
+		"/etc/passwd":      false,
+		"../secret":        false,
+		"a/./b":            false,
+		"a//b":             false,
+		`a\b`:              false,
+	} {
+		if got := ValidKey(key); got != want {
+			t.Errorf("ValidKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestLocal(t *testing.T) {
+	root := t.TempDir()
+	l := NewLocal(root, nil)
+
+	if err := l.Put("avatars/1.png", []byte("png"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(root, "avatars", "1.png")); err != nil || string(b) != "png" {
+		t.Fatalf("expected file on disk, got %q (%v)", b, err)
+	}
+	obj, err := l.Get("avatars/1.png")
+	if err != nil || string(obj.Data) != "png" || obj.ContentType != "image/png" {
+		t.Fatalf("unexpected object %+v (%v)", obj, err)
+	}
+	if err := l.Put("../escape.png", nil, ""); err != ErrInvalidKey {
+		t.Fatalf("expected ErrInvalidKey, got %v", err)
+	}
+	if err := l.Delete("avatars/1.png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Delete("avatars/1.png"); err != nil {
+		t.Fatalf("deleting a missing key should succeed, got %v", err)
+	}
+	if _, err := l.Get("avatars/1.png"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := l.SignedURL("avatars/1.png", time.Minute); err != ErrNoSigningKey {
+		t.Fatalf("expected ErrNoSigningKey without a signer, got %v", err)
+	}
+}
+
+func TestURLSigner(t *testing.T) {
+	s := NewURLSigner(SignedRoutePrefix, []byte("secret"))
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+
+	raw, err := s.Sign("private/exports/1.json", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Path != "/files/private/exports/1.json" {
+		t.Fatalf("unexpected signed URL %q (%v)", raw, err)
+	}
+	q := u.Query()
+	if err := s.Verify("private/exports/1.json", q.Get("expires"), q.Get("signature")); err != nil {
+		t.Fatalf("expected a valid signature, got %v", err)
+	}
+	if err := s.Verify("private/exports/2.json", q.Get("expires"), q.Get("signature")); err != ErrInvalidSignature {
+		t.Fatalf("signature must be bound to the key, got %v", err)
+	}
+	now = now.Add(2 * time.Hour)
+	if err := s.Verify("private/exports/1.json", q.Get("expires"), q.Get("signature")); err != ErrInvalidSignature {
+		t.Fatalf("expected expired signature to be rejected, got %v", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	signer := NewURLSigner(SignedRoutePrefix, []byte("secret"))
+	m := NewMemory(signer)
+	m.Put("avatars/1.jpg", []byte("large"), "image/jpeg")
+	m.Put("avatars/1_thumb.jpg", []byte("thumb"), "image/jpeg")
+	m.Put("legacy.png", []byte("legacy"), "image/png")
+	m.Put("private/exports/1.json", []byte(`{}`), "application/json")
+
+	app := fiber.New()
+	NewHandler(m, signer).RegisterPublicRoutes(app)
+	get := func(target string) (int, string) {
+		res, err := app.Test(httptest.NewRequest("GET", target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+
+	if code, body := get("/uploads/avatars/1.jpg?size=thumb"); code != fiber.StatusOK || body != "thumb" {
+		t.Fatalf("expected thumb rendition, got %d %q", code, body)
+	}
+	// uploads without renditions fall back to the original
+	if code, body := get("/uploads/legacy.png?size=medium"); code != fiber.StatusOK || body != "legacy" {
+		t.Fatalf("expected original, got %d %q", code, body)
+	}
+	if code, _ := get("/uploads/avatars/1.jpg?size=huge"); code != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown size, got %d", code)
+	}
+	if code, _ := get("/uploads/private/exports/1.json"); code != fiber.StatusNotFound {
+		t.Fatalf("private blobs must not be public, got %d", code)
+	}
+	if code, _ := get("/uploads/missing.png"); code != fiber.StatusNotFound {
+		t.Fatalf("expected 404, got %d", code)
+	}
+
+	signed, err := m.SignedURL("private/exports/1.json", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, body := get(signed); code != fiber.StatusOK || body != `{}` {
+		t.Fatalf("expected signed download, got %d %q", code, body)
+	}
+	if code, _ := get("/files/private/exports/1.json?expires=9999999999&signature=00"); code != fiber.StatusForbidden {
+		t.Fatalf("expected 403 for a forged signature, got %d", code)
+	}
+}
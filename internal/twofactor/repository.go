@@ -0,0 +1,131 @@
+package twofactor
+
+import (
+	"sync"
+	"time"
+)
+
+// TOTP is the second factor of a user. EnabledAt is nil until the
+// enrollment is confirmed; LastStep is the time step of the last code used.
+type TOTP struct {
+	UserID    int
+	Secret    string
+	EnabledAt *time.Time
+	LastStep  int64
+}
+
+type Repository interface {
+	// Get returns ErrNotFound for users without a second factor.
+	Get(userID int) (TOTP, error)
+	// SavePending stores an unconfirmed secret, replacing an earlier one.
+	// It fails with ErrAlreadyEnabled once the user has confirmed.
+	SavePending(userID int, secret string) error
+	// Enable confirms the enrollment and stores the recovery code hashes.
+	Enable(userID int, step int64, recoveryHashes []string) error
+	// UseStep records the time step of a used code. It fails with
+	// ErrInvalidCode when a code of that or a later step was used already.
+	UseStep(userID int, step int64) error
+	// UseRecoveryCode uses up the unused recovery code with hash, or fails
+	// with ErrInvalidCode.
+	UseRecoveryCode(userID int, hash string) error
+	ReplaceRecoveryCodes(userID int, hashes []string) error
+	CountRecoveryCodes(userID int) (int, error)
+	// Delete removes the second factor and recovery codes of a user.
+	Delete(userID int) error
+}
+
+type InMemoryRepository struct {
+	mu       sync.Mutex
+	totp     map[int]TOTP
+	recovery map[int]map[string]bool
+}
+
+func NewInMemoryRepository() *InMemoryRepository {
+	return &InMemoryRepository{totp: map[int]TOTP{}, recovery: map[int]map[string]bool{}}
+}
+
+func (r *InMemoryRepository) Get(userID int) (TOTP, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.totp[userID]
+	if !ok {
+		return TOTP{}, ErrNotFound
+	}
+	return t, nil
+}
+
+func (r *InMemoryRepository) SavePending(userID int, secret string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.totp[userID]; ok && t.EnabledAt != nil {
+		return ErrAlreadyEnabled
+	}
+	r.totp[userID] = TOTP{UserID: userID, Secret: secret}
+	return nil
+}
+
+func (r *InMemoryRepository) Enable(userID int, step int64, recoveryHashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.totp[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	now := time.Now().UTC()
+	t.EnabledAt = &now
+	t.LastStep = step
+	r.totp[userID] = t
+	r.replaceRecoveryCodes(userID, recoveryHashes)
+	return nil
+}
+
+func (r *InMemoryRepository) UseStep(userID int, step int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.totp[userID]
+	if !ok || step <= t.LastStep {
+		return ErrInvalidCode
+	}
+	t.LastStep = step
+	r.totp[userID] = t
+	return nil
+}
+
+func (r *InMemoryRepository) UseRecoveryCode(userID int, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.recovery[userID][hash] {
+		return ErrInvalidCode
+	}
+	delete(r.recovery[userID], hash)
+	return nil
+}
+
+func (r *InMemoryRepository) ReplaceRecoveryCodes(userID int, hashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.replaceRecoveryCodes(userID, hashes)
+	return nil
+}
+
+func (r *InMemoryRepository) replaceRecoveryCodes(userID int, hashes []string) {
+	codes := map[string]bool{}
+	for _, h := range hashes {
+		codes[h] = true
+	}
+	r.recovery[userID] = codes
+}
+
+func (r *InMemoryRepository) CountRecoveryCodes(userID int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.recovery[userID]), nil
+}
+
+func (r *InMemoryRepository) Delete(userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.totp, userID)
+	delete(r.recovery, userID)
+	return nil
+}
@@ -0,0 +1,154 @@
+package twofactor
+
+import (
+	"database/sql"
+)
+
+// PostgresRepository stores secrets in user_totp and recovery code hashes
+// in user_recovery_codes.
+type PostgresRepository struct {
+	db *sql.DB
+}
+
+const (
+	getTOTPQuery         = `SELECT user_id, secret, enabled_at, last_step FROM user_totp WHERE user_id = $1`
+	savePendingTOTPQuery = `
+		INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = $2, last_step = 0
+		WHERE user_totp.enabled_at IS NULL
+	`
+	enableTOTPQuery = `UPDATE user_totp SET enabled_at = now(), last_step = $2 WHERE user_id = $1 AND enabled_at IS NULL`
+	// the condition refuses a code whose step is not newer, even when two
+	// requests race with the same code
+	useStepQuery             = `UPDATE user_totp SET last_step = $2 WHERE user_id = $1 AND last_step < $2`
+	useRecoveryCodeQuery     = `UPDATE user_recovery_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	deleteRecoveryCodesQuery = `DELETE FROM user_recovery_codes WHERE user_id = $1`
+	insertRecoveryCodeQuery  = `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+	countRecoveryCodesQuery  = `SELECT count(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+	deleteTOTPQuery          = `DELETE FROM user_totp WHERE user_id = $1`
+)
+
+func NewPostgresRepository(db *sql.DB) *PostgresRepository {
+	return &PostgresRepository{db: db}
+}
+
+func (r *PostgresRepository) Get(userID int) (TOTP, error) {
+	var t TOTP
+	var enabled sql.NullTime
+	err := r.db.QueryRow(getTOTPQuery, userID).Scan(&t.UserID, &t.Secret, &enabled, &t.LastStep)
+	if err == sql.ErrNoRows {
+		return TOTP{}, ErrNotFound
+	}
+	if err != nil {
+		return TOTP{}, err
+	}
+	if enabled.Valid {
+		t.EnabledAt = &enabled.Time
+	}
+	return t, nil
+}
+
+func (r *PostgresRepository) SavePending(userID int, secret string) error {
+	result, err := r.db.Exec(savePendingTOTPQuery, userID, secret)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAlreadyEnabled
+	}
+	return nil
+}
+
+func (r *PostgresRepository) Enable(userID int, step int64, recoveryHashes []string) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(enableTOTPQuery, userID, step)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrAlreadyEnabled
+		}
+		return replaceRecoveryCodes(tx, userID, recoveryHashes)
+	})
+}
+
+func (r *PostgresRepository) UseStep(userID int, step int64) error {
+	return r.execOne(useStepQuery, userID, step)
+}
+
+func (r *PostgresRepository) UseRecoveryCode(userID int, hash string) error {
+	return r.execOne(useRecoveryCodeQuery, userID, hash)
+}
+
+func (r *PostgresRepository) ReplaceRecoveryCodes(userID int, hashes []string) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		return replaceRecoveryCodes(tx, userID, hashes)
+	})
+}
+
+func (r *PostgresRepository) CountRecoveryCodes(userID int) (int, error) {
+	var n int
+	err := r.db.QueryRow(countRecoveryCodesQuery, userID).Scan(&n)
+	return n, err
+}
+
+func (r *PostgresRepository) Delete(userID int) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(deleteRecoveryCodesQuery, userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(deleteTOTPQuery, userID)
+		return err
+	})
+}
+
+// execOne runs an update that has to change a row, or fails with
+// ErrInvalidCode.
+func (r *PostgresRepository) execOne(query string, args ...any) error {
+	result, err := r.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+func (r *PostgresRepository) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int, hashes []string) error {
+	if _, err := tx.Exec(deleteRecoveryCodesQuery, userID); err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		if _, err := tx.Exec(insertRecoveryCodeQuery, userID, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+// Package twofactor adds TOTP (RFC 6238) as a second sign-in factor. Users
+// enroll by importing an otpauth URI into an authenticator app and
+// confirming a first code, which also hands out single-use recovery codes.
+// Sign-in then happens in two steps: the password yields a short-lived
+// challenge token, which is exchanged for the session together with a code.
+package twofactor
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
+)
+
+// ChallengeTTL is how long the second sign-in step may take.
+const ChallengeTTL = 5 * time.Minute
+
+// RecoveryCodeCount is how many recovery codes a user gets at a time.
+const RecoveryCodeCount = 10
+
+var (
+	ErrNotFound         = errors.New("two-factor authentication is not set up")
+	ErrAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrInvalidCode      = errors.New("invalid authentication code")
+	ErrInvalidChallenge = errors.New("invalid or expired sign-in challenge")
+	ErrRequired         = errors.New("two-factor authentication is required for this account")
+)
+
+// Enrollment is what a user needs to add the account to an authenticator
+// app.
+type Enrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauthUri"`
+}
+
+// Status describes the second factor of a user.
+type Status struct {
+	Enabled           bool `json:"enabled"`
+	Required          bool `json:"required"`
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+}
+
+type Service struct {
+	repo     Repository
+	issuer   string
+	key      []byte
+	required map[auth.Role]bool
+	now      func() time.Time
+}
+
+// NewService shows issuer as the account's provider in authenticator apps.
+// Challenge tokens are signed with a key derived from secret, so they are
+// never accepted where access tokens are.
+func NewService(repo Repository, issuer, secret string) *Service {
+	key := sha256.Sum256([]byte("2fa-challenge:" + secret))
+	return &Service{repo: repo, issuer: issuer, key: key[:], required: map[auth.Role]bool{}, now: time.Now}
+}
+
+// WithRequiredRoles makes two-factor authentication mandatory for users
+// with one of roles.
+func (s *Service) WithRequiredRoles(roles ...auth.Role) *Service {
+	for _, r := range roles {
+		s.required[r] = true
+	}
+	return s
+}
+
+// Required reports whether users with role must use a second factor.
+func (s *Service) Required(role auth.Role) bool {
+	return s.required[role]
+}
+
+// Enabled reports whether the user has confirmed an enrollment.
+func (s *Service) Enabled(userID int) (bool, error) {
+	t, err := s.repo.Get(userID)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return t.EnabledAt != nil, nil
+}
+
+func (s *Service) Status(userID int, role auth.Role) (Status, error) {
+	enabled, err := s.Enabled(userID)
+	if err != nil {
+		return Status{}, err
+	}
+	st := Status{Enabled: enabled, Required: s.Required(role)}
+	if enabled {
+		if st.RecoveryCodesLeft, err = s.repo.CountRecoveryCodes(userID); err != nil {
+			return Status{}, err
+		}
+	}
+	return st, nil
+}
+
+// Begin starts an enrollment with a new secret, replacing an unconfirmed
+// one. account names the user in the authenticator app.
+func (s *Service) Begin(userID int, account string) (Enrollment, error) {
+	if enabled, err := s.Enabled(userID); err != nil {
+		return Enrollment{}, err
+	} else if enabled {
+		return Enrollment{}, ErrAlreadyEnabled
+	}
+	secret, err := GenerateSecret()
+	if err != nil {
+		return Enrollment{}, err
+	}
+	if err := s.repo.SavePending(userID, secret); err != nil {
+		return Enrollment{}, err
+	}
+	return Enrollment{Secret: secret, URI: URI(s.issuer, account, secret)}, nil
+}
+
+// Confirm enables the enrollment started by Begin once code proves the app
+// has the secret, and returns the recovery codes. They are only shown now.
+func (s *Service) Confirm(userID int, code string) ([]string, error) {
+	t, err := s.repo.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+	if t.EnabledAt != nil {
+		return nil, ErrAlreadyEnabled
+	}
+	step, ok := Match(t.Secret, code, s.now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.Enable(userID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify checks a code from the authenticator app or, failing that, uses
+// up a recovery code. Each TOTP code works once.
+func (s *Service) Verify(userID int, code string) error {
+	t, err := s.repo.Get(userID)
+	if err == ErrNotFound {
+		return ErrInvalidCode
+	}
+	if err != nil {
+		return err
+	}
+	if t.EnabledAt == nil {
+		return ErrInvalidCode
+	}
+	if step, ok := Match(t.Secret, code, s.now()); ok {
+		if step <= t.LastStep {
+			return ErrInvalidCode
+		}
+		return s.repo.UseStep(userID, step)
+	}
+	return s.repo.UseRecoveryCode(userID, hashRecoveryCode(code))
+}
+
+// Disable removes the second factor after verifying code. Users whose role
+// requires two-factor authentication get ErrRequired.
+func (s *Service) Disable(userID int, role auth.Role, code string) error {
+	if s.Required(role) {
+		return ErrRequired
+	}
+	if err := s.Verify(userID, code); err != nil {
+		return err
+	}
+	return s.repo.Delete(userID)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after verifying code.
+func (s *Service) RegenerateRecoveryCodes(userID int, code string) ([]string, error) {
+	if err := s.Verify(userID, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+const challengePurpose = "2fa"
+
+// NewChallenge returns the token that carries a user from the password step
+// to the code step of signing in.
+func (s *Service) NewChallenge(userID int) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id": userID,
+		"purpose": challengePurpose,
+		"exp":     s.now().Add(ChallengeTTL).Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.key)
+}
+
+// ParseChallenge returns the user a challenge token was issued to.
+func (s *Service) ParseChallenge(token string) (int, error) {
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, ErrInvalidChallenge
+		}
+		return s.key, nil
+	})
+	if err != nil || !parsed.Valid || claims["purpose"] != challengePurpose {
+		return 0, ErrInvalidChallenge
+	}
+	id, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, ErrInvalidChallenge
+	}
+	return int(id), nil
+}
+
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// newRecoveryCodes returns codes such as "k7pqa-x3mzr" and their hashes.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < RecoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		var code strings.Builder
+		for j, v := range b {
+			if j == 5 {
+				code.WriteByte('-')
+			}
+			// 256 is not a multiple of the alphabet size; the bias is
+			// negligible for a code of about 50 bits
+			code.WriteByte(recoveryAlphabet[int(v)%len(recoveryAlphabet)])
+		}
+		codes = append(codes, code.String())
+		hashes = append(hashes, hashRecoveryCode(code.String()))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case, spaces and dashes, which users tend to get
+// wrong when typing a code.
+func hashRecoveryCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
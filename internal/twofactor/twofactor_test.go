@@ -0,0 +1,165 @@
+package twofactor
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
+)
+
+func TestCode_RFC6238Vectors(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	for _, tc := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	} {
+		got, err := Code(secret, Step(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Fatalf("at %d: expected %s, got %s", tc.unix, tc.want, got)
+		}
+	}
+}
+
+func TestMatch_AllowsOneStepOfSkew(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	prev, _ := Code(secret, Step(now)-1)
+	if step, ok := Match(secret, prev, now); !ok || step != Step(now)-1 {
+		t.Fatalf("expected the previous code to match its step, got %d %v", step, ok)
+	}
+	old, _ := Code(secret, Step(now)-2)
+	if _, ok := Match(secret, old, now); ok {
+		t.Fatal("expected a code two steps old to be refused")
+	}
+}
+
+func newService() (*Service, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	s := NewService(NewInMemoryRepository(), "Pet Shop", "test-secret")
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func enroll(t *testing.T, s *Service, userID int) (secret string, codes []string) {
+	t.Helper()
+	e, err := s.Begin(userID, "a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(e.URI, "otpauth://totp/Pet%20Shop:a@example.com?") || !strings.Contains(e.URI, "secret="+e.Secret) {
+		t.Fatalf("unexpected otpauth URI %q", e.URI)
+	}
+	code, _ := Code(e.Secret, Step(s.now()))
+	if codes, err = s.Confirm(userID, code); err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %d", RecoveryCodeCount, len(codes))
+	}
+	return e.Secret, codes
+}
+
+func TestService_VerifyRefusesReplayedCodes(t *testing.T) {
+	s, now := newService()
+	secret, _ := enroll(t, s, 1)
+
+	// the confirming code is used up
+	code, _ := Code(secret, Step(*now))
+	if err := s.Verify(1, code); err != ErrInvalidCode {
+		t.Fatalf("expected the confirming code to be refused, got %v", err)
+	}
+	*now = now.Add(Period)
+	code, _ = Code(secret, Step(*now))
+	if err := s.Verify(1, code); err != nil {
+		t.Fatalf("expected the next code to verify, got %v", err)
+	}
+	if err := s.Verify(1, code); err != ErrInvalidCode {
+		t.Fatalf("expected a replayed code to be refused, got %v", err)
+	}
+	if _, err := s.Begin(1, "a@example.com"); err != ErrAlreadyEnabled {
+		t.Fatalf("expected a second enrollment to be refused, got %v", err)
+	}
+}
+
+func TestService_RecoveryCodesWorkOnce(t *testing.T) {
+	s, _ := newService()
+	_, codes := enroll(t, s, 1)
+
+	if err := s.Verify(1, " "+strings.ToUpper(codes[0])+" "); err != nil {
+		t.Fatalf("expected a recovery code to verify regardless of case, got %v", err)
+	}
+	if err := s.Verify(1, codes[0]); err != ErrInvalidCode {
+		t.Fatalf("expected a used recovery code to be refused, got %v", err)
+	}
+	st, err := s.Status(1, auth.RoleCustomer)
+	if err != nil || !st.Enabled || st.RecoveryCodesLeft != RecoveryCodeCount-1 {
+		t.Fatalf("unexpected status %+v, %v", st, err)
+	}
+
+	fresh, err := s.RegenerateRecoveryCodes(1, codes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Verify(1, codes[2]); err != ErrInvalidCode {
+		t.Fatalf("expected old recovery codes to be replaced, got %v", err)
+	}
+	if err := s.Verify(1, fresh[0]); err != nil {
+		t.Fatalf("expected a new recovery code to verify, got %v", err)
+	}
+}
+
+func TestService_Disable(t *testing.T) {
+	s, _ := newService()
+	s.WithRequiredRoles(auth.RoleStaff)
+	_, codes := enroll(t, s, 1)
+
+	if err := s.Disable(1, auth.RoleStaff, codes[0]); err != ErrRequired {
+		t.Fatalf("expected staff not to be able to disable, got %v", err)
+	}
+	if err := s.Disable(1, auth.RoleCustomer, "000000"); err != ErrInvalidCode {
+		t.Fatalf("expected a wrong code to be refused, got %v", err)
+	}
+	if err := s.Disable(1, auth.RoleCustomer, codes[0]); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, _ := s.Enabled(1); enabled {
+		t.Fatal("expected two-factor authentication to be off")
+	}
+}
+
+func TestService_Challenge(t *testing.T) {
+	s, now := newService()
+	// expiry is checked against the wall clock
+	*now = time.Now()
+	token, err := s.NewChallenge(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := s.ParseChallenge(token); err != nil || id != 42 {
+		t.Fatalf("expected the challenge to name user 42, got %d, %v", id, err)
+	}
+
+	other := NewService(NewInMemoryRepository(), "Pet Shop", "other-secret")
+	if _, err := other.ParseChallenge(token); err != ErrInvalidChallenge {
+		t.Fatalf("expected a challenge signed with another key to be refused, got %v", err)
+	}
+
+	*now = time.Now().Add(-ChallengeTTL - time.Minute)
+	expired, _ := s.NewChallenge(42)
+	if _, err := s.ParseChallenge(expired); err != ErrInvalidChallenge {
+		t.Fatalf("expected an expired challenge to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+// Package upload reads multipart uploads safely. The client's filename and
+// Content-Type are ignored: the type is sniffed from the content and checked
+// against an allow-list, archives and SVG or script documents are refused,
+// and stored files are named after a hash of their content.
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/wichananm65/pet-shop-backend/internal/imaging"
+)
+
+// MaxBytes caps uploads accepted by the Files policy.
+const MaxBytes = 10 << 20
+
+// FormOverheadBytes is the room a request body needs besides the file for
+// its multipart framing and form fields.
+const FormOverheadBytes = 1 << 20
+
+// MaxBodyBytes is the request body limit the app needs so the largest
+// allowed upload still fits together with its multipart framing and form
+// fields.
+const MaxBodyBytes = MaxBytes + FormOverheadBytes
+
+var (
+	ErrTooLarge        = errors.New("file is too large")
+	ErrUnsupportedType = errors.New("file type is not allowed")
+	ErrArchive         = errors.New("archives are not allowed")
+	ErrScript          = errors.New("SVG and script files are not allowed")
+)
+
+// Policy limits the size and sniffed content types of an upload. Types maps
+// every allowed content type to the extension stored files get.
+type Policy struct {
+	MaxBytes int64
+	Types    map[string]string
+}
+
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// Images accepts the raster formats imaging can decode.
+var Images = Policy{MaxBytes: imaging.MaxUploadBytes, Types: imageTypes}
+
+// Files accepts images and PDF documents.
+var Files = Policy{MaxBytes: MaxBytes, Types: merge(imageTypes, map[string]string{"application/pdf": ".pdf"})}
+
+// File is an upload that passed a Policy.
+type File struct {
+	Data        []byte
+	ContentType string
+	Ext         string
+}
+
+// Name returns the content-addressed file name of f.
+func (f File) Name() string {
+	return Name(f.Data, f.Ext)
+}
+
+// Name returns the hex SHA-256 of data followed by ext. Equal content always
+// gets the same name, so a client can neither pick nor overwrite another
+// file's name.
+func Name(data []byte, ext string) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) + ext
+}
+
+// Read reads a multipart file and checks it against p.
+func Read(fh *multipart.FileHeader, p Policy) (File, error) {
+	if fh.Size > p.MaxBytes {
+		return File{}, ErrTooLarge
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+	// the header size comes from the client, so cap the read as well
+	data, err := io.ReadAll(io.LimitReader(f, p.MaxBytes+1))
+	if err != nil {
+		return File{}, err
+	}
+	if int64(len(data)) > p.MaxBytes {
+		return File{}, ErrTooLarge
+	}
+	return Check(data, p)
+}
+
+// Check sniffs the content type of data and checks it against p.
+func Check(data []byte, p Policy) (File, error) {
+	if int64(len(data)) > p.MaxBytes {
+		return File{}, ErrTooLarge
+	}
+	if isArchive(data) {
+		return File{}, ErrArchive
+	}
+	ct := http.DetectContentType(data)
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = ct[:i]
+	}
+	if strings.HasPrefix(ct, "text/") && isMarkup(data) {
+		return File{}, ErrScript
+	}
+	ext, ok := p.Types[ct]
+	if !ok {
+		return File{}, ErrUnsupportedType
+	}
+	return File{Data: data, ContentType: ct, Ext: ext}, nil
+}
+
+var archiveMagic = [][]byte{
+	[]byte("PK\x03\x04"), []byte("PK\x05\x06"), []byte("PK\x07\x08"), // zip and zip-based formats
+	[]byte("\x1f\x8b"),                     // gzip
+	[]byte("Rar!\x1a\x07"),                 // rar
+	[]byte("7z\xbc\xaf\x27\x1c"),           // 7z
+	[]byte("BZh"),                          // bzip2
+	[]byte("\xfd7zXZ\x00"),                 // xz
+	[]byte("\x28\xb5\x2f\xfd"),             // zstd
+	[]byte("MSCF"),                         // cab
+	[]byte("!<arch>\n"),                    // ar and deb
+	[]byte("\xed\xab\xee\xdb"),             // rpm
+	[]byte("\x1f\x9d"), []byte("\x1f\xa0"), // compress
+}
+
+// zipEOCD ends every zip archive. Zip readers search for it from the end of
+// the file, so an image with an archive appended is still a valid zip.
+var zipEOCD = []byte("PK\x05\x06")
+
+// isArchive reports whether data starts like an archive, is a tar file or
+// carries a zip archive at its end.
+func isArchive(data []byte) bool {
+	for _, magic := range archiveMagic {
+		if bytes.HasPrefix(data, magic) {
+			return true
+		}
+	}
+	if len(data) >= 262 && bytes.Equal(data[257:262], []byte("ustar")) {
+		return true
+	}
+	// the end of central directory record is 22 bytes plus a comment of
+	// at most 64 KiB
+	tail := data
+	if n := 22 + 0xffff; len(tail) > n {
+		tail = tail[len(tail)-n:]
+	}
+	return bytes.Contains(tail, zipEOCD)
+}
+
+// isMarkup reports whether a text document is SVG or contains HTML that a
+// browser would run scripts from.
+func isMarkup(data []byte) bool {
+	head := data
+	if len(head) > 4096 {
+		head = head[:4096]
+	}
+	lower := bytes.ToLower(head)
+	for _, tag := range []string{"<svg", "<script", "<html", "<!doctype html", "<?xml"} {
+		if bytes.Contains(lower, []byte(tag)) {
+			return true
+		}
+	}
+	return false
+}
+
+func merge(maps ...map[string]string) map[string]string {
+	out := make(map[string]string)
+	for _, m := range maps {
+		for k, v := range m {
+			out[k] = v
+		}
+	}
+	return out
+}
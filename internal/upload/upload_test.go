@@ -0,0 +1,92 @@
+package upload
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func pngData(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipData(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, _ := w.Create("payload.html")
+	f.Write([]byte("<script>alert(1)</script>"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCheck(t *testing.T) {
+	img := pngData(t)
+	cases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"png", img, nil},
+		{"pdf", []byte("%PDF-1.7\n1 0 obj\n"), nil},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"/>`), ErrScript},
+		{"svg with prolog", []byte("<?xml version=\"1.0\"?>\n<svg/>"), ErrScript},
+		{"html", []byte("<!DOCTYPE html><script>alert(1)</script>"), ErrScript},
+		{"zip", zipData(t), ErrArchive},
+		{"gzip", []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00"), ErrArchive},
+		{"image with appended zip", append(append([]byte(nil), img...), zipData(t)...), ErrArchive},
+		{"plain text", []byte("hello"), ErrUnsupportedType},
+		{"executable", []byte("MZ\x90\x00\x03\x00\x00\x00"), ErrUnsupportedType},
+	}
+	for _, tc := range cases {
+		f, err := Check(tc.data, Files)
+		if err != tc.err {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.err, err)
+		}
+		if err == nil && f.Ext == "" {
+			t.Errorf("%s: expected an extension, got %+v", tc.name, f)
+		}
+	}
+	if _, err := Check([]byte("%PDF-1.7\n"), Images); err != ErrUnsupportedType {
+		t.Fatalf("expected the image policy to refuse a PDF, got %v", err)
+	}
+	if _, err := Check(img, Policy{MaxBytes: 10, Types: imageTypes}); err != ErrTooLarge {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestReadIgnoresClientMetadata(t *testing.T) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, _ := w.CreateFormFile("file", "../../etc/passwd.pdf")
+	part.Write(pngData(t))
+	w.Close()
+	req := httptest.NewRequest("POST", "/", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	if err := req.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Read(req.MultipartForm.File["file"][0], Images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.ContentType != "image/png" || !strings.HasSuffix(f.Name(), ".png") || strings.Contains(f.Name(), "/") {
+		t.Fatalf("expected a sniffed, content-addressed PNG name, got %q (%s)", f.Name(), f.ContentType)
+	}
+	if f.Name() != Name(pngData(t), ".png") {
+		t.Fatalf("expected the name to depend on the content only, got %q", f.Name())
+	}
+}
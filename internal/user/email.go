@@ -0,0 +1,26 @@
+package user
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// NormalizeEmail trims and lowercases email and checks that it is a bare
+// address such as "jane@example.com", without a display name. Emails are
+// stored normalized so "Jane@Example.com" cannot register a second account.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if len(email) > 254 {
+		return "", ErrInvalidEmail
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", ErrInvalidEmail
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	// a dotless domain is almost always a typo, not an intranet host
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
@@ -1,18 +1,35 @@
 package user
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
 	"mime/multipart"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
+	"github.com/wichananm65/pet-shop-backend/internal/imaging"
+	"github.com/wichananm65/pet-shop-backend/internal/loginguard"
+	"github.com/wichananm65/pet-shop-backend/internal/session"
+	"github.com/wichananm65/pet-shop-backend/internal/storage"
+	"github.com/wichananm65/pet-shop-backend/internal/twofactor"
+	"github.com/wichananm65/pet-shop-backend/internal/upload"
 )
 
 type Handler struct {
-	service *Service
+	service   *Service
+	blobs     storage.Blob
+	sessions  *session.Service
+	guard     *loginguard.Guard
+	twoFactor *twofactor.Service
+	// erase, when set, deletes users for the admin route instead of
+	// Service.Delete, so their data goes with them
+	erase func(userID int) error
 }
 
 type loginRequest struct {
@@ -30,26 +47,78 @@ type registerRequest struct {
 }
 
 func NewHandler(service *Service) *Handler {
-	return &Handler{service: service}
+	return &Handler{service: service, blobs: storage.NewLocal("./uploads", nil)}
+}
+
+// WithStorage sets where avatars are stored; uploads are served from
+// /uploads/<key> by storage.Handler.
+func (h *Handler) WithStorage(b storage.Blob) *Handler {
+	h.blobs = b
+	return h
+}
+
+// WithEraser makes the admin route delete users through erase, such as
+// privacy.Service.Erase.
+func (h *Handler) WithEraser(erase func(userID int) error) *Handler {
+	h.erase = erase
+	return h
+}
+
+// WithLoginGuard rate limits sign-in attempts per email and IP.
+func (h *Handler) WithLoginGuard(g *loginguard.Guard) *Handler {
+	h.guard = g
+	return h
+}
+
+// WithTwoFactor turns on two-step sign-in for users with a second factor
+// and the routes that manage it.
+func (h *Handler) WithTwoFactor(s *twofactor.Service) *Handler {
+	h.twoFactor = s
+	return h
+}
+
+// WithSessions sets the service that issues login tokens; without it login
+// and token refresh fail.
+func (h *Handler) WithSessions(s *session.Service) *Handler {
+	h.sessions = s
+	return h
 }
 
 func (h *Handler) RegisterPublicRoutes(app *fiber.App) {
 	app.Post("/api/v1/sign-in", h.login)
 	app.Post("/api/v1/sign-up", h.register)
+	// both are authorized by the refresh token in the body, so they work
+	// after the access token has expired
+	app.Post("/api/v1/token/refresh", h.refreshToken)
+	app.Post("/api/v1/sign-out", h.signOut)
+	app.Post("/api/v1/password/forgot", h.forgotPassword)
+	app.Post("/api/v1/password/reset", h.resetPassword)
+	app.Post("/api/v1/email/verify", h.verifyEmail)
+	app.Post("/api/v1/sign-in/2fa", h.loginTwoFactor)
+	app.Post("/api/v1/sign-in/2fa/setup", h.loginTwoFactorSetup)
 }
 
 func (h *Handler) RegisterProtectedRoutes(app *fiber.App) {
-	app.Get("/users", h.getUsers)
-	app.Get("/user/:id", h.getUser)
-	app.Post("/users", h.createUser)
-	app.Put("/user/:id", h.updateUser)
-	app.Delete("/user/:id", h.deleteUser)
+	admin := auth.Require(auth.ManageUsers)
+	app.Get("/users", admin, h.getUsers)
+	app.Get("/user/:id", admin, h.getUser)
+	app.Post("/users", admin, h.createUser)
+	app.Put("/user/:id", admin, h.updateUser)
+	app.Put("/user/:id/role", admin, h.setRole)
+	app.Delete("/user/:id", admin, h.deleteUser)
 	app.Get("/api/v1/profile", h.getProfile)
 	app.Put("/api/v1/profile", h.updateProfile)
 	app.Patch("/api/v1/profile", h.updateProfile)
 	app.Post("/api/v1/profile/avatar", h.uploadAvatar)
 	app.Delete("/api/v1/profile/avatar", h.removeAvatar)
-
+	app.Post("/api/v1/sign-out/all", h.signOutAll)
+	app.Put("/api/v1/password", h.changePassword)
+	app.Post("/api/v1/email/verify/resend", h.resendVerification)
+	app.Get("/api/v1/2fa", h.getTwoFactor)
+	app.Post("/api/v1/2fa/enroll", h.enrollTwoFactor)
+	app.Post("/api/v1/2fa/confirm", h.confirmTwoFactor)
+	app.Post("/api/v1/2fa/disable", h.disableTwoFactor)
+	app.Post("/api/v1/2fa/recovery-codes", h.regenerateRecoveryCodes)
 }
 
 func (h *Handler) login(c *fiber.Ctx) error {
@@ -58,28 +127,554 @@ func (h *Handler) login(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
 	}
 
+	if h.guard != nil {
+		if err := h.guard.Check(payload.Email, c.IP()); err != nil {
+			return writeLimitError(c, err)
+		}
+	}
 	user, err := h.service.Authenticate(payload.Email, payload.Password)
-	if err != nil {
+	if err == ErrInvalidCredentials {
+		if h.guard != nil {
+			if err := h.guard.Failure(payload.Email, c.IP()); err != nil {
+				log.Printf("could not record failed sign-in: %v", err)
+			}
+		}
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Invalid email or password"})
 	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+	}
+
+	if h.twoFactor != nil {
+		enabled, err := h.twoFactor.Enabled(user.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+		}
+		if enabled || h.twoFactor.Required(user.Role) {
+			// failures stay counted until the second step succeeds
+			challenge, err := h.twoFactor.NewChallenge(user.ID)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+			}
+			return c.JSON(fiber.Map{
+				"message":           "Two-factor authentication required",
+				"twoFactorRequired": true,
+				"setupRequired":     !enabled,
+				"challengeToken":    challenge,
+			})
+		}
+	}
+
+	if h.guard != nil {
+		if err := h.guard.Success(payload.Email); err != nil {
+			log.Printf("could not clear failed sign-ins: %v", err)
+		}
+	}
+	return h.completeLogin(c, user, nil)
+}
+
+// completeLogin starts a session for user and answers with its tokens and
+// any extra fields.
+func (h *Handler) completeLogin(c *fiber.Ctx, user User, extra fiber.Map) error {
+	if h.sessions == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "failed to generate token"})
+	}
+	tokens, err := h.sessions.Issue(sessionClaims(user))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "failed to generate token"})
+	}
+
+	res := fiber.Map{
+		"message":      "Login successful",
+		"user":         sanitizeUser(user),
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+	}
+	for k, v := range extra {
+		res[k] = v
+	}
+	return c.JSON(res)
+}
+
+type twoFactorLoginRequest struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+}
+
+// loginTwoFactor is the second sign-in step. code is a TOTP or recovery
+// code; for users who had to set up two-factor authentication it confirms
+// the enrollment, and the response then carries their recovery codes.
+func (h *Handler) loginTwoFactor(c *fiber.Ctx) error {
+	payload := new(twoFactorLoginRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	u, err := h.challengeUser(payload.ChallengeToken)
+	if err != nil {
+		return writeTwoFactorError(c, err)
+	}
+	enabled, err := h.twoFactor.Enabled(u.ID)
+	if err != nil {
+		return writeTwoFactorError(c, err)
+	}
+
+	var recoveryCodes []string
+	err = h.guardCode(c, u.Email, func() error {
+		if enabled {
+			return h.twoFactor.Verify(u.ID, payload.Code)
+		}
+		var err error
+		recoveryCodes, err = h.twoFactor.Confirm(u.ID, payload.Code)
+		return err
+	})
+	if err == twofactor.ErrInvalidCode {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": err.Error()})
+	}
+	if err != nil {
+		return writeTwoFactorError(c, err)
+	}
+
+	var extra fiber.Map
+	if recoveryCodes != nil {
+		extra = fiber.Map{"recoveryCodes": recoveryCodes}
+	}
+	return h.completeLogin(c, u, extra)
+}
+
+// loginTwoFactorSetup starts the enrollment of a user whose role requires
+// two-factor authentication before their first sign-in with it.
+func (h *Handler) loginTwoFactorSetup(c *fiber.Ctx) error {
+	payload := new(twoFactorLoginRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	u, err := h.challengeUser(payload.ChallengeToken)
+	if err != nil {
+		return writeTwoFactorError(c, err)
+	}
+	enrollment, err := h.twoFactor.Begin(u.ID, u.Email)
+	if err != nil {
+		return writeTwoFactorError(c, err)
+	}
+	return c.JSON(enrollment)
+}
+
+func (h *Handler) challengeUser(token string) (User, error) {
+	if h.twoFactor == nil {
+		return User{}, twofactor.ErrInvalidChallenge
+	}
+	id, err := h.twoFactor.ParseChallenge(token)
+	if err != nil {
+		return User{}, err
+	}
+	u, err := h.service.GetByID(id)
+	if err == ErrNotFound {
+		return User{}, twofactor.ErrInvalidChallenge
+	}
+	return u, err
+}
+
+// guardCode runs verify, a check of a second-factor code, under the
+// sign-in limits of email so codes cannot be guessed either.
+func (h *Handler) guardCode(c *fiber.Ctx, email string, verify func() error) error {
+	if h.guard != nil {
+		if err := h.guard.Check(email, c.IP()); err != nil {
+			return err
+		}
+	}
+	err := verify()
+	if h.guard == nil {
+		return err
+	}
+	switch err {
+	case nil:
+		if err := h.guard.Success(email); err != nil {
+			log.Printf("could not clear failed sign-ins: %v", err)
+		}
+	case twofactor.ErrInvalidCode:
+		if err := h.guard.Failure(email, c.IP()); err != nil {
+			log.Printf("could not record failed sign-in: %v", err)
+		}
+	}
+	return err
+}
+
+func (h *Handler) getTwoFactor(c *fiber.Ctx) error {
+	u, err := h.twoFactorUser(c)
+	if err != nil {
+		return writeTwoFactorError(c, err)
+	}
+	status, err := h.twoFactor.Status(u.ID, u.Role)
+	if err != nil {
+		return writeTwoFactorError(c, err)
+	}
+	return c.JSON(status)
+}
+
+func (h *Handler) enrollTwoFactor(c *fiber.Ctx) error {
+	u, err := h.twoFactorUser(c)
+	if err != nil {
+		return writeTwoFactorError(c, err)
+	}
+	enrollment, err := h.twoFactor.Begin(u.ID, u.Email)
+	if err != nil {
+		return writeTwoFactorError(c, err)
+	}
+	return c.JSON(enrollment)
+}
 
-	claims := jwt.MapClaims{
-		"user_id": user.ID,
-		"email":   user.Email,
-		"exp":     time.Now().Add(72 * time.Hour).Unix(),
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+func (h *Handler) confirmTwoFactor(c *fiber.Ctx) error {
+	u, err := h.twoFactorUser(c)
+	if err != nil {
+		return writeTwoFactorError(c, err)
+	}
+	payload := new(twoFactorCodeRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	var codes []string
+	err = h.guardCode(c, u.Email, func() error {
+		var err error
+		codes, err = h.twoFactor.Confirm(u.ID, payload.Code)
+		return err
+	})
+	if err != nil {
+		return writeTwoFactorError(c, err)
 	}
+	return c.JSON(fiber.Map{"message": "Two-factor authentication enabled", "recoveryCodes": codes})
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signed, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+func (h *Handler) disableTwoFactor(c *fiber.Ctx) error {
+	u, err := h.twoFactorUser(c)
 	if err != nil {
+		return writeTwoFactorError(c, err)
+	}
+	payload := new(twoFactorCodeRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	err = h.guardCode(c, u.Email, func() error {
+		return h.twoFactor.Disable(u.ID, u.Role, payload.Code)
+	})
+	if err != nil {
+		return writeTwoFactorError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *Handler) regenerateRecoveryCodes(c *fiber.Ctx) error {
+	u, err := h.twoFactorUser(c)
+	if err != nil {
+		return writeTwoFactorError(c, err)
+	}
+	payload := new(twoFactorCodeRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	var codes []string
+	err = h.guardCode(c, u.Email, func() error {
+		var err error
+		codes, err = h.twoFactor.RegenerateRecoveryCodes(u.ID, payload.Code)
+		return err
+	})
+	if err != nil {
+		return writeTwoFactorError(c, err)
+	}
+	return c.JSON(fiber.Map{"recoveryCodes": codes})
+}
+
+// twoFactorUser loads the signed-in user for the two-factor routes.
+func (h *Handler) twoFactorUser(c *fiber.Ctx) (User, error) {
+	if h.twoFactor == nil {
+		return User{}, errTwoFactorDisabled
+	}
+	userID, err := GetUserIDFromCtx(c)
+	if err != nil {
+		return User{}, err
+	}
+	return h.service.GetByID(userID)
+}
+
+var errTwoFactorDisabled = errors.New("two-factor authentication is not available")
+
+func writeTwoFactorError(c *fiber.Ctx, err error) error {
+	var limit *loginguard.LimitError
+	switch {
+	case errors.As(err, &limit):
+		return writeLimitError(c, err)
+	case err == fiber.ErrUnauthorized, err == twofactor.ErrInvalidChallenge:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": err.Error()})
+	case err == twofactor.ErrInvalidCode, err == twofactor.ErrNotFound:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	case err == twofactor.ErrAlreadyEnabled:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": err.Error()})
+	case err == twofactor.ErrRequired:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"message": err.Error()})
+	case err == ErrNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "user not found"})
+	case err == errTwoFactorDisabled:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+	}
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// refreshToken rotates a refresh token into a new token pair. The access
+// token is minted from the current user record.
+func (h *Handler) refreshToken(c *fiber.Ctx) error {
+	payload := new(refreshRequest)
+	if err := c.BodyParser(payload); err != nil || payload.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "refreshToken is required"})
+	}
+	if h.sessions == nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "failed to generate token"})
 	}
 
-	return c.JSON(fiber.Map{
-		"message": "Login successful",
-		"user":    sanitizeUser(user),
-		"token":   signed,
+	tokens, err := h.sessions.Refresh(payload.RefreshToken, func(userID int) (session.Claims, error) {
+		u, err := h.service.GetByID(userID)
+		if err != nil {
+			return session.Claims{}, err
+		}
+		// a role that now requires two-factor authentication ends sessions
+		// started without it, so the next sign-in sets it up
+		if h.twoFactor != nil && h.twoFactor.Required(u.Role) {
+			if enabled, err := h.twoFactor.Enabled(u.ID); err != nil || !enabled {
+				return session.Claims{}, twofactor.ErrRequired
+			}
+		}
+		return sessionClaims(u), nil
 	})
+	if err != nil {
+		return writeSessionError(c, err)
+	}
+	return c.JSON(tokens)
+}
+
+// signOut revokes the login the refresh token belongs to.
+func (h *Handler) signOut(c *fiber.Ctx) error {
+	payload := new(refreshRequest)
+	if err := c.BodyParser(payload); err != nil || payload.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "refreshToken is required"})
+	}
+	if h.sessions == nil {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	if err := h.sessions.Revoke(payload.RefreshToken); err != nil && err != session.ErrInvalidToken {
+		return writeSessionError(c, err)
+	}
+	// signing out twice is not an error
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// signOutAll revokes every refresh token of the current user, signing them
+// out on all devices once their access tokens expire.
+func (h *Handler) signOutAll(c *fiber.Ctx) error {
+	userID, err := GetUserIDFromCtx(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
+	}
+	if h.sessions != nil {
+		if err := h.sessions.RevokeAll(userID); err != nil {
+			return writeSessionError(c, err)
+		}
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// forgotPassword answers the same whether or not the email has an account,
+// so it cannot be used to find out who is registered. For the same reason
+// an email over its mail limit is answered like any other; only the IP
+// limit is reported.
+func (h *Handler) forgotPassword(c *fiber.Ctx) error {
+	payload := new(forgotPasswordRequest)
+	if err := c.BodyParser(payload); err != nil || payload.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "email is required"})
+	}
+	if h.guard != nil {
+		if err := h.guard.CheckIP(c.IP()); err != nil {
+			return writeLimitError(c, err)
+		}
+	}
+	if err := h.service.RequestPasswordReset(payload.Email); err != nil && err != ErrResetThrottled {
+		log.Printf("password reset request failed: %v", err)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "If the email is registered, a reset link has been sent"})
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// resetPassword also signs the user out everywhere, since whoever held the
+// old password may still hold a session.
+func (h *Handler) resetPassword(c *fiber.Ctx) error {
+	payload := new(resetPasswordRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	userID, err := h.service.ResetPassword(payload.Token, payload.Password)
+	if err != nil {
+		return writePasswordError(c, err)
+	}
+	if h.sessions != nil {
+		if err := h.sessions.RevokeAll(userID); err != nil {
+			return writeSessionError(c, err)
+		}
+	}
+	return c.JSON(fiber.Map{"message": "Password has been reset"})
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// changePassword checks the current password under the sign-in limits of
+// the user's email. Afterwards every session of the user is revoked and
+// the caller gets a new token pair, so only this device stays signed in.
+func (h *Handler) changePassword(c *fiber.Ctx) error {
+	userID, err := GetUserIDFromCtx(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
+	}
+	payload := new(changePasswordRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	u, err := h.service.GetByID(userID)
+	if err != nil {
+		return writePasswordError(c, err)
+	}
+	if h.guard != nil {
+		if err := h.guard.Check(u.Email, c.IP()); err != nil {
+			return writeLimitError(c, err)
+		}
+	}
+	err = h.service.ChangePassword(userID, payload.CurrentPassword, payload.NewPassword)
+	if h.guard != nil {
+		switch err {
+		case nil:
+			if err := h.guard.Success(u.Email); err != nil {
+				log.Printf("could not clear failed sign-ins: %v", err)
+			}
+		case ErrWrongPassword:
+			if err := h.guard.Failure(u.Email, c.IP()); err != nil {
+				log.Printf("could not record failed sign-in: %v", err)
+			}
+		}
+	}
+	if err != nil {
+		return writePasswordError(c, err)
+	}
+
+	res := fiber.Map{"message": "Password has been changed"}
+	if h.sessions != nil {
+		if err := h.sessions.RevokeAll(userID); err != nil {
+			return writeSessionError(c, err)
+		}
+		tokens, err := h.sessions.Issue(sessionClaims(u))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "failed to generate token"})
+		}
+		res["token"] = tokens.AccessToken
+		res["refreshToken"] = tokens.RefreshToken
+		res["expiresIn"] = tokens.ExpiresIn
+	}
+	return c.JSON(res)
+}
+
+type verifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+func (h *Handler) verifyEmail(c *fiber.Ctx) error {
+	payload := new(verifyEmailRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	u, err := h.service.VerifyEmail(payload.Token)
+	if err != nil {
+		return writeVerificationError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Email verified", "user": sanitizeUser(u)})
+}
+
+func (h *Handler) resendVerification(c *fiber.Ctx) error {
+	userID, err := GetUserIDFromCtx(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
+	}
+	if err := h.service.SendVerification(userID); err != nil {
+		return writeVerificationError(c, err)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "Verification email sent"})
+}
+
+func writeVerificationError(c *fiber.Ctx, err error) error {
+	switch err {
+	case ErrInvalidVerificationToken:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	case ErrAlreadyVerified:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": err.Error()})
+	case ErrVerificationThrottled:
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"message": err.Error()})
+	case ErrNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "user not found"})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+	}
+}
+
+func writePasswordError(c *fiber.Ctx, err error) error {
+	switch err {
+	case ErrInvalidResetToken, ErrWeakPassword:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	case ErrWrongPassword:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"message": err.Error()})
+	case ErrNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "user not found"})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+	}
+}
+
+// writeLimitError answers refused sign-ins with 429 and a Retry-After
+// header.
+func writeLimitError(c *fiber.Ctx, err error) error {
+	var limit *loginguard.LimitError
+	if !errors.As(err, &limit) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+	}
+	seconds := int(math.Ceil(limit.RetryAfter.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"message": limit.Reason, "retryAfter": seconds})
+}
+
+func writeSessionError(c *fiber.Ctx, err error) error {
+	switch err {
+	case session.ErrInvalidToken, session.ErrTokenReused:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+	}
+}
+
+func sessionClaims(u User) session.Claims {
+	return session.Claims{UserID: u.ID, Email: u.Email, Role: u.Role}
 }
 
 func (h *Handler) register(c *fiber.Ctx) error {
@@ -107,6 +702,9 @@ func (h *Handler) register(c *fiber.Ctx) error {
 		if err == ErrEmailExists {
 			return c.Status(fiber.StatusConflict).SendString("Email already exists")
 		}
+		if err == ErrInvalidEmail {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid email address")
+		}
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
 
@@ -242,18 +840,9 @@ func (h *Handler) updateProfile(c *fiber.Ctx) error {
 			file = f
 		}
 		if file != nil {
-			f, err := file.Open()
+			path, err := h.saveAvatar(userID, file)
 			if err != nil {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
-			}
-			defer f.Close()
-			path := "/uploads/avatars/" + strconv.Itoa(userID) + "_" + file.Filename
-			dest := "." + path
-			if err := os.MkdirAll("./uploads/avatars", 0755); err != nil {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
-			}
-			if err := c.SaveFile(file, dest); err != nil {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+				return writeAvatarError(c, err)
 			}
 			existing.AvatarPic = &path
 		}
@@ -286,21 +875,9 @@ func (h *Handler) uploadAvatar(c *fiber.Ctx) error {
 	if file == nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "file is required"})
 	}
-	f, err := file.Open()
+	path, err := h.saveAvatar(userID, file)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
-	}
-	defer f.Close()
-
-	// save under uploads/avatars/userID_filename
-	path := "/uploads/avatars/" + strconv.Itoa(userID) + "_" + file.Filename
-	dest := "." + path
-	// ensure directory exists
-	if err := os.MkdirAll("./uploads/avatars", 0755); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
-	}
-	if err := c.SaveFile(file, dest); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+		return writeAvatarError(c, err)
 	}
 
 	// update user record
@@ -316,6 +893,38 @@ func (h *Handler) uploadAvatar(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{"avatarPic": path, "user": sanitizeUser(updated)})
 }
 
+// saveAvatar processes an uploaded avatar into renditions and stores them
+// under avatars/, named after the user and the content hash so a new upload
+// never overwrites a cached URL. It returns the public path of the large
+// rendition; the smaller ones are stored next to it (see
+// imaging.RenditionPath).
+func (h *Handler) saveAvatar(userID int, file *multipart.FileHeader) (string, error) {
+	f, err := upload.Read(file, upload.Images)
+	if err != nil {
+		return "", err
+	}
+	set, err := imaging.Process(f.Data)
+	if err != nil {
+		return "", err
+	}
+
+	key := fmt.Sprintf("avatars/%d_%s", userID, upload.Name(set.Large.Data, set.Large.Ext()))
+	if err := storage.PutRenditions(h.blobs, key, set); err != nil {
+		return "", err
+	}
+	return "/uploads/" + key, nil
+}
+
+func writeAvatarError(c *fiber.Ctx, err error) error {
+	switch err {
+	case imaging.ErrUnsupportedFormat, upload.ErrUnsupportedType, upload.ErrArchive, upload.ErrScript:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	case imaging.ErrTooLarge, upload.ErrTooLarge:
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"message": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+}
+
 func (h *Handler) removeAvatar(c *fiber.Ctx) error {
 	userID, err := GetUserIDFromCtx(c)
 	if err != nil {
@@ -341,6 +950,9 @@ func (h *Handler) createUser(c *fiber.Ctx) error {
 	}
 
 	created, err := h.service.Create(*user)
+	if err == auth.ErrUnknownRole || err == ErrInvalidEmail {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
@@ -360,21 +972,62 @@ func (h *Handler) updateUser(c *fiber.Ctx) error {
 	}
 
 	updated, err := h.service.Update(userID, *userUpdate)
-	if err != nil {
+	switch err {
+	case nil:
+	case ErrInvalidEmail:
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	case ErrEmailExists:
+		return c.Status(fiber.StatusConflict).SendString("Email already exists")
+	default:
 		return c.Status(fiber.StatusNotFound).SendString("User not found")
 	}
 
 	return c.JSON(sanitizeUser(updated))
 }
 
+type setRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// setRole changes the role of a user; the user gets it with their next
+// access token.
+func (h *Handler) setRole(c *fiber.Ctx) error {
+	userID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	payload := new(setRoleRequest)
+	if err := c.BodyParser(payload); err != nil || payload.Role == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "role is required"})
+	}
+
+	updated, err := h.service.SetRole(userID, payload.Role)
+	switch err {
+	case nil:
+		return c.JSON(sanitizeUser(updated))
+	case auth.ErrUnknownRole:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	case ErrNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "user not found"})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
+	}
+}
+
 func (h *Handler) deleteUser(c *fiber.Ctx) error {
 	userID, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
 	}
 
-	if err := h.service.Delete(userID); err != nil {
+	erase := h.service.Delete
+	if h.erase != nil {
+		erase = h.erase
+	}
+	if err := erase(userID); err == ErrNotFound {
 		return c.Status(fiber.StatusNotFound).SendString("User not found")
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
 
 	return c.SendString("User deleted")
@@ -1,19 +1,37 @@
 package user
 
 import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
 	"io"
 	"mime/multipart"
+	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
+	"github.com/wichananm65/pet-shop-backend/internal/imaging"
+	"github.com/wichananm65/pet-shop-backend/internal/loginguard"
+	"github.com/wichananm65/pet-shop-backend/internal/mailer"
+	"github.com/wichananm65/pet-shop-backend/internal/session"
+	"github.com/wichananm65/pet-shop-backend/internal/storage"
+	"github.com/wichananm65/pet-shop-backend/internal/twofactor"
 )
 
 // helper to build an app with a simple "bootstrap" middleware that injects a
-// jwt.Token into locals when the X-User-ID header is provided. This avoids
+// jwt.Token into locals when the X-User-ID header is provided; X-User-Role
+// sets its role claim. This avoids
 // pulling in the full jwtware middleware and keeps tests lightweight.
 func makeAppWithUserHandler(uHandler *Handler) *fiber.App {
 	app := fiber.New()
@@ -21,7 +39,7 @@ func makeAppWithUserHandler(uHandler *Handler) *fiber.App {
 		if v := c.Get("X-User-ID"); v != "" {
 			id, err := strconv.Atoi(v)
 			if err == nil {
-				claims := jwt.MapClaims{"user_id": id}
+				claims := jwt.MapClaims{"user_id": id, auth.RoleClaim: c.Get("X-User-Role")}
 				tok := &jwt.Token{Claims: claims}
 				c.Locals("user", tok)
 			}
@@ -85,11 +103,21 @@ func TestProfileRoute_RegistrationAndAuth(t *testing.T) {
 	}
 }
 
+// avatarPNG is a small opaque image accepted by the avatar upload.
+var avatarPNG = func() []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 640, 480))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.NRGBA{240, 180, 60, 255}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}()
+
 func TestProfileUpdateAndAvatar(t *testing.T) {
 	seed := []User{{ID: 15, Email: "u15@example.com", FirstName: "Old", LastName: "Name", Phone: "000", Gender: "male"}}
 	repo := NewInMemoryRepository(seed)
 	service := NewService(repo)
-	handler := NewHandler(service)
+	blobs := storage.NewMemory(nil)
+	handler := NewHandler(service).WithStorage(blobs)
 	app := makeAppWithUserHandler(handler)
 
 	// update profile fields using both PUT and PATCH to ensure both
@@ -141,7 +169,7 @@ func TestProfileUpdateAndAvatar(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to create form file: %v", err)
 	}
-	part.Write([]byte("PNGDATA"))
+	part.Write(avatarPNG)
 	writer.Close()
 
 	req2 := httptest.NewRequest("POST", "/api/v1/profile/avatar", strings.NewReader(body.String()))
@@ -163,6 +191,30 @@ func TestProfileUpdateAndAvatar(t *testing.T) {
 	if u.AvatarPic == nil || *u.AvatarPic == "" {
 		t.Fatalf("avatar pic not set in repo")
 	}
+	// the upload is re-encoded into renditions stored next to each other
+	if !strings.HasSuffix(*u.AvatarPic, ".jpg") {
+		t.Fatalf("expected opaque avatar re-encoded as JPEG, got %s", *u.AvatarPic)
+	}
+	thumb, err := blobs.Get(imaging.RenditionPath(strings.TrimPrefix(*u.AvatarPic, "/uploads/"), imaging.Thumb))
+	if err != nil {
+		t.Fatalf("thumbnail not stored: %v", err)
+	}
+	if cfg, err := jpeg.DecodeConfig(bytes.NewReader(thumb.Data)); err != nil || cfg.Width != 200 || cfg.Height != 150 {
+		t.Fatalf("expected a 200x150 thumbnail, got %+v (%v)", cfg, err)
+	}
+
+	// anything that does not decode as an image is rejected
+	bad := &strings.Builder{}
+	badWriter := multipart.NewWriter(bad)
+	badPart, _ := badWriter.CreateFormFile("file", "avatar.png")
+	badPart.Write([]byte("PNGDATA"))
+	badWriter.Close()
+	badReq := httptest.NewRequest("POST", "/api/v1/profile/avatar", strings.NewReader(bad.String()))
+	badReq.Header.Set("X-User-ID", "15")
+	badReq.Header.Set("Content-Type", badWriter.FormDataContentType())
+	if res, _ := app.Test(badReq); res.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 for a non-image avatar, got %d", res.StatusCode)
+	}
 
 	// now exercise the new combined update route twice: once using the generic
 	// "file" key and once using the preferred "avatarPic" key, verifying both
@@ -179,7 +231,7 @@ func TestProfileUpdateAndAvatar(t *testing.T) {
 		if err != nil {
 			t.Fatalf("failed to create form file: %v", err)
 		}
-		part3.Write([]byte("PNGDATA2"))
+		part3.Write(avatarPNG)
 		writer3.Close()
 
 		req3 := httptest.NewRequest("PUT", "/api/v1/profile", strings.NewReader(body3.String()))
@@ -254,3 +306,461 @@ func TestProfileUpdateAndAvatar(t *testing.T) {
 		t.Fatalf("avatar was not cleared by removal request: %+v", u4)
 	}
 }
+
+func TestAdminRoutes_RequireAdminRole(t *testing.T) {
+	repo := NewInMemoryRepository([]User{{ID: 1, Email: "admin@example.com", Role: auth.RoleAdmin}, {ID: 2, Email: "c@example.com", Role: auth.RoleCustomer}})
+	app := makeAppWithUserHandler(NewHandler(NewService(repo)))
+
+	send := func(method, path, role, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User-ID", "2")
+		req.Header.Set("X-User-Role", role)
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode
+	}
+
+	for _, role := range []string{"customer", "staff", ""} {
+		if code := send("GET", "/users", role, ""); code != fiber.StatusForbidden {
+			t.Errorf("%q: expected 403 listing users, got %d", role, code)
+		}
+		if code := send("PUT", "/user/2/role", role, `{"role":"admin"}`); code != fiber.StatusForbidden {
+			t.Errorf("%q: expected 403 changing a role, got %d", role, code)
+		}
+	}
+	if code := send("GET", "/users", "admin", ""); code != fiber.StatusOK {
+		t.Fatalf("expected 200 for an admin, got %d", code)
+	}
+	if code := send("PUT", "/user/2/role", "admin", `{"role":"root"}`); code != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown role, got %d", code)
+	}
+	if code := send("PUT", "/user/2/role", "admin", `{"role":"staff"}`); code != fiber.StatusOK {
+		t.Fatalf("expected 200 granting staff, got %d", code)
+	}
+	if u, _ := repo.GetByID(2); u.Role != auth.RoleStaff {
+		t.Fatalf("expected user 2 to be staff, got %q", u.Role)
+	}
+}
+
+func TestLogin_TokenCarriesRole(t *testing.T) {
+	service := NewService(NewInMemoryRepository(nil))
+	created, err := service.Register(User{Email: "s@example.com", Password: "secret123", Role: auth.RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Role != auth.RoleCustomer {
+		t.Fatalf("expected registration to ignore the requested role, got %q", created.Role)
+	}
+	if _, err := service.SetRole(created.ID, "staff"); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	NewHandler(service).WithSessions(session.NewService(session.NewInMemoryRepository(), "test-secret")).RegisterPublicRoutes(app)
+	req := httptest.NewRequest("POST", "/api/v1/sign-in", strings.NewReader(`{"email":"s@example.com","password":"secret123"}`))
+	req.Header.Set("Content-Type", "application/json")
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	json.NewDecoder(res.Body).Decode(&body)
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(body.Token, claims, func(*jwt.Token) (any, error) { return []byte("test-secret"), nil }); err != nil {
+		t.Fatalf("expected a valid token, got %v (status %d)", err, res.StatusCode)
+	}
+	if claims[auth.RoleClaim] != "staff" {
+		t.Fatalf("expected the staff role claim, got %v", claims[auth.RoleClaim])
+	}
+}
+
+func TestRefreshToken_RotatesAndDetectsReuse(t *testing.T) {
+	service := NewService(NewInMemoryRepository(nil))
+	if _, err := service.Register(User{Email: "r@example.com", Password: "secret123"}); err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	NewHandler(service).WithSessions(session.NewService(session.NewInMemoryRepository(), "test-secret")).RegisterPublicRoutes(app)
+
+	post := func(path, body string) (int, session.Tokens) {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tokens session.Tokens
+		json.NewDecoder(res.Body).Decode(&tokens)
+		return res.StatusCode, tokens
+	}
+
+	_, login := post("/api/v1/sign-in", `{"email":"r@example.com","password":"secret123"}`)
+	if login.RefreshToken == "" {
+		t.Fatal("expected login to return a refresh token")
+	}
+	refreshBody := `{"refreshToken":"` + login.RefreshToken + `"}`
+	code, rotated := post("/api/v1/token/refresh", refreshBody)
+	if code != fiber.StatusOK || rotated.AccessToken == "" || rotated.RefreshToken == login.RefreshToken {
+		t.Fatalf("expected a new token pair, got %d %+v", code, rotated)
+	}
+	if code, _ := post("/api/v1/token/refresh", refreshBody); code != fiber.StatusUnauthorized {
+		t.Fatalf("expected 401 replaying a rotated token, got %d", code)
+	}
+	// the replay revoked the family, so the stolen pair's sibling is dead too
+	if code, _ := post("/api/v1/token/refresh", `{"refreshToken":"`+rotated.RefreshToken+`"}`); code != fiber.StatusUnauthorized {
+		t.Fatalf("expected 401 after reuse revoked the family, got %d", code)
+	}
+	if code, _ := post("/api/v1/sign-out", refreshBody); code != fiber.StatusNoContent {
+		t.Fatalf("expected 204 signing out, got %d", code)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	var outbox bytes.Buffer
+	service := NewService(NewInMemoryRepository(nil)).
+		WithMailer(mailer.NewLog(&outbox, "shop@example.com")).
+		WithPasswordReset("https://shop.example/reset", time.Hour)
+	if _, err := service.Register(User{Email: "p@example.com", Password: "old-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	sessions := session.NewService(session.NewInMemoryRepository(), "test-secret")
+	policy := loginguard.DefaultPolicy()
+	policy.IPLimit = 3
+	app := fiber.New()
+	NewHandler(service).WithSessions(sessions).
+		WithLoginGuard(loginguard.New(loginguard.NewInMemoryRepository(), policy)).
+		RegisterPublicRoutes(app)
+
+	post := func(path, body string) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode
+	}
+
+	if code := post("/api/v1/password/forgot", `{"email":"nobody@example.com"}`); code != fiber.StatusAccepted || outbox.Len() != 0 {
+		t.Fatalf("expected 202 and no mail for an unknown email, got %d", code)
+	}
+	if code := post("/api/v1/password/forgot", `{"email":"p@example.com"}`); code != fiber.StatusAccepted {
+		t.Fatalf("expected 202, got %d", code)
+	}
+	token := regexp.MustCompile(`https://shop\.example/reset\?token=([\w-]+)`).FindStringSubmatch(outbox.String())
+	if token == nil {
+		t.Fatalf("expected a reset link in %q", outbox.String())
+	}
+	// asking again right away is answered the same but mails nothing
+	sent := outbox.Len()
+	if code := post("/api/v1/password/forgot", `{"email":"p@example.com"}`); code != fiber.StatusAccepted || outbox.Len() != sent {
+		t.Fatalf("expected 202 and no second mail, got %d", code)
+	}
+	if code := post("/api/v1/password/forgot", `{"email":"other@example.com"}`); code != fiber.StatusTooManyRequests {
+		t.Fatalf("expected 429 once the IP is over its limit, got %d", code)
+	}
+	signedIn, _ := sessions.Issue(session.Claims{UserID: 1})
+
+	if code := post("/api/v1/password/reset", `{"token":"`+token[1]+`","password":"short"}`); code != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 for a short password, got %d", code)
+	}
+	if code := post("/api/v1/password/reset", `{"token":"`+token[1]+`","password":"new-secret"}`); code != fiber.StatusOK {
+		t.Fatalf("expected 200 resetting the password, got %d", code)
+	}
+	if code := post("/api/v1/password/reset", `{"token":"`+token[1]+`","password":"other-secret"}`); code != fiber.StatusBadRequest {
+		t.Fatalf("expected a used token to be rejected, got %d", code)
+	}
+	if _, err := service.Authenticate("p@example.com", "new-secret"); err != nil {
+		t.Fatalf("expected the new password to work, got %v", err)
+	}
+	if _, err := sessions.Refresh(signedIn.RefreshToken, func(int) (session.Claims, error) { return session.Claims{}, nil }); err != session.ErrInvalidToken {
+		t.Fatalf("expected the reset to sign out existing sessions, got %v", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	service := NewService(NewInMemoryRepository(nil))
+	created, err := service.Register(User{Email: "c@example.com", Password: "old-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := session.NewService(session.NewInMemoryRepository(), "test-secret")
+	policy := loginguard.DefaultPolicy()
+	policy.BackoffAfter = 2
+	guard := loginguard.New(loginguard.NewInMemoryRepository(), policy)
+	app := makeAppWithUserHandler(NewHandler(service).WithSessions(sessions).WithLoginGuard(guard))
+	change := func(body string) (int, session.Tokens) {
+		req := httptest.NewRequest("PUT", "/api/v1/password", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User-ID", strconv.Itoa(created.ID))
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out session.Tokens
+		json.NewDecoder(res.Body).Decode(&out)
+		return res.StatusCode, out
+	}
+
+	if code, _ := change(`{"currentPassword":"wrong","newPassword":"new-secret"}`); code != fiber.StatusForbidden {
+		t.Fatalf("expected 403 for a wrong current password, got %d", code)
+	}
+	change(`{"currentPassword":"wrong","newPassword":"new-secret"}`)
+	if code, _ := change(`{"currentPassword":"old-secret","newPassword":"new-secret"}`); code != fiber.StatusTooManyRequests {
+		t.Fatalf("expected wrong current passwords to back off like sign-ins, got %d", code)
+	}
+	guard.Success("c@example.com")
+
+	other, _ := sessions.Issue(session.Claims{UserID: created.ID})
+	code, res := change(`{"currentPassword":"old-secret","newPassword":"new-secret"}`)
+	if code != fiber.StatusOK || res.RefreshToken == "" {
+		t.Fatalf("expected 200 with a new token pair, got %d %+v", code, res)
+	}
+	if _, err := service.Authenticate("c@example.com", "new-secret"); err != nil {
+		t.Fatalf("expected the new password to work, got %v", err)
+	}
+	reload := func(int) (session.Claims, error) { return session.Claims{UserID: created.ID}, nil }
+	if _, err := sessions.Refresh(other.RefreshToken, reload); err != session.ErrInvalidToken {
+		t.Fatalf("expected other sessions to be signed out, got %v", err)
+	}
+	if _, err := sessions.Refresh(res.RefreshToken, reload); err != nil {
+		t.Fatalf("expected the returned session to work, got %v", err)
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	for in, want := range map[string]string{
+		" Jane.Doe@Example.COM ": "jane.doe@example.com",
+		"a+tag@shop.co.th":       "a+tag@shop.co.th",
+	} {
+		if got, err := NormalizeEmail(in); err != nil || got != want {
+			t.Errorf("NormalizeEmail(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "jane", "jane@", "@example.com", "jane@localhost", "Jane <jane@example.com>", "a@b.com, c@d.com", "jane@example.com."} {
+		if _, err := NormalizeEmail(in); err != ErrInvalidEmail {
+			t.Errorf("NormalizeEmail(%q): expected ErrInvalidEmail, got %v", in, err)
+		}
+	}
+}
+
+func TestRegister_NormalizesEmail(t *testing.T) {
+	service := NewService(NewInMemoryRepository(nil))
+	created, err := service.Register(User{Email: " Mixed@Example.com", Password: "secret123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Email != "mixed@example.com" {
+		t.Fatalf("expected a normalized email, got %q", created.Email)
+	}
+	if _, err := service.Register(User{Email: "MIXED@example.com", Password: "secret123"}); err != ErrEmailExists {
+		t.Fatalf("expected ErrEmailExists for the same email in another case, got %v", err)
+	}
+	if _, err := service.Register(User{Email: "not-an-email", Password: "secret123"}); err != ErrInvalidEmail {
+		t.Fatalf("expected ErrInvalidEmail, got %v", err)
+	}
+	if _, err := service.Authenticate("Mixed@EXAMPLE.com", "secret123"); err != nil {
+		t.Fatalf("expected sign-in to ignore case, got %v", err)
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	var outbox bytes.Buffer
+	service := NewService(NewInMemoryRepository(nil)).
+		WithMailer(mailer.NewLog(&outbox, "shop@example.com")).
+		WithEmailVerification("https://shop.example/verify", time.Hour)
+	created, err := service.Register(User{Email: "v@example.com", Password: "secret123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.RequireVerifiedEmail(created.ID) != ErrEmailNotVerified {
+		t.Fatal("expected a new account to be unverified")
+	}
+	link := regexp.MustCompile(`https://shop\.example/verify\?token=([\w-]+)`)
+	token := link.FindStringSubmatch(outbox.String())
+	if token == nil {
+		t.Fatalf("expected registration to mail a verification link, got %q", outbox.String())
+	}
+
+	app := makeAppWithUserHandler(NewHandler(service))
+	NewHandler(service).RegisterPublicRoutes(app)
+	send := func(path, body string) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User-ID", strconv.Itoa(created.ID))
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode
+	}
+
+	// the registration mail counts towards the resend throttle
+	if code := send("/api/v1/email/verify/resend", ""); code != fiber.StatusTooManyRequests {
+		t.Fatalf("expected 429 resending right after sign-up, got %d", code)
+	}
+	if code := send("/api/v1/email/verify", `{"token":"nope"}`); code != fiber.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown token, got %d", code)
+	}
+	if code := send("/api/v1/email/verify", `{"token":"`+token[1]+`"}`); code != fiber.StatusOK {
+		t.Fatalf("expected 200 verifying, got %d", code)
+	}
+	if err := service.RequireVerifiedEmail(created.ID); err != nil {
+		t.Fatalf("expected the email to be verified, got %v", err)
+	}
+	if code := send("/api/v1/email/verify/resend", ""); code != fiber.StatusConflict {
+		t.Fatalf("expected 409 resending for a verified email, got %d", code)
+	}
+
+	// changing the email requires verifying the new one
+	created.Email = "new@example.com"
+	if _, err := service.Update(created.ID, created); err != nil {
+		t.Fatal(err)
+	}
+	if service.RequireVerifiedEmail(created.ID) != ErrEmailNotVerified {
+		t.Fatal("expected a changed email to be unverified")
+	}
+}
+
+func TestLogin_LocksOutAfterRepeatedFailures(t *testing.T) {
+	service := NewService(NewInMemoryRepository(nil))
+	if _, err := service.Register(User{Email: "l@example.com", Password: "secret123"}); err != nil {
+		t.Fatal(err)
+	}
+	policy := loginguard.DefaultPolicy()
+	policy.MaxFailures = 2
+	policy.BackoffAfter = 0
+	guard := loginguard.New(loginguard.NewInMemoryRepository(), policy)
+	app := fiber.New()
+	NewHandler(service).WithSessions(session.NewService(session.NewInMemoryRepository(), "test-secret")).
+		WithLoginGuard(guard).RegisterPublicRoutes(app)
+
+	signIn := func(password string) *http.Response {
+		req := httptest.NewRequest("POST", "/api/v1/sign-in", strings.NewReader(`{"email":"l@example.com","password":"`+password+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	for i := 0; i < 2; i++ {
+		if res := signIn("wrong"); res.StatusCode != fiber.StatusUnauthorized {
+			t.Fatalf("expected 401 for a wrong password, got %d", res.StatusCode)
+		}
+	}
+	res := signIn("secret123")
+	if res.StatusCode != fiber.StatusTooManyRequests || res.Header.Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After once locked, got %d %q", res.StatusCode, res.Header.Get("Retry-After"))
+	}
+}
+
+func TestLogin_TwoFactor(t *testing.T) {
+	service := NewService(NewInMemoryRepository(nil))
+	created, err := service.Register(User{Email: "t@example.com", Password: "secret123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.SetRole(created.ID, "staff"); err != nil {
+		t.Fatal(err)
+	}
+	twoFactor := twofactor.NewService(twofactor.NewInMemoryRepository(), "Pet Shop", "test-secret").
+		WithRequiredRoles(auth.RoleStaff)
+	app := fiber.New()
+	NewHandler(service).WithSessions(session.NewService(session.NewInMemoryRepository(), "test-secret")).
+		WithTwoFactor(twoFactor).RegisterPublicRoutes(app)
+
+	post := func(path, body string) (int, map[string]any) {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := map[string]any{}
+		json.NewDecoder(res.Body).Decode(&out)
+		return res.StatusCode, out
+	}
+	signIn := `{"email":"t@example.com","password":"secret123"}`
+
+	// staff must set up a second factor before their first sign-in
+	code, res := post("/api/v1/sign-in", signIn)
+	if code != fiber.StatusOK || res["token"] != nil || res["twoFactorRequired"] != true || res["setupRequired"] != true {
+		t.Fatalf("expected a setup challenge instead of a token, got %d %v", code, res)
+	}
+	challenge, _ := res["challengeToken"].(string)
+	if _, err := twoFactor.ParseChallenge(challenge); err != nil {
+		t.Fatalf("expected a challenge token, got %v", err)
+	}
+	// the challenge is not an access token
+	if _, err := jwt.Parse(challenge, func(*jwt.Token) (any, error) { return []byte("test-secret"), nil }); err == nil {
+		t.Fatal("expected the challenge not to verify as an access token")
+	}
+
+	code, res = post("/api/v1/sign-in/2fa/setup", `{"challengeToken":"`+challenge+`"}`)
+	secret, _ := res["secret"].(string)
+	if code != fiber.StatusOK || secret == "" {
+		t.Fatalf("expected an enrollment, got %d %v", code, res)
+	}
+	if code, _ := post("/api/v1/sign-in/2fa", `{"challengeToken":"`+challenge+`","code":"000000"}`); code != fiber.StatusUnauthorized {
+		t.Fatalf("expected 401 for a wrong code, got %d", code)
+	}
+	totp, _ := twofactor.Code(secret, twofactor.Step(time.Now()))
+	code, res = post("/api/v1/sign-in/2fa", `{"challengeToken":"`+challenge+`","code":"`+totp+`"}`)
+	recovery, _ := res["recoveryCodes"].([]any)
+	if code != fiber.StatusOK || res["token"] == nil || len(recovery) != twofactor.RecoveryCodeCount {
+		t.Fatalf("expected tokens and recovery codes, got %d %v", code, res)
+	}
+
+	// afterwards the second step takes a code, here a recovery code
+	code, res = post("/api/v1/sign-in", signIn)
+	if code != fiber.StatusOK || res["token"] != nil || res["setupRequired"] != false {
+		t.Fatalf("expected a challenge, got %d %v", code, res)
+	}
+	challenge, _ = res["challengeToken"].(string)
+	code, res = post("/api/v1/sign-in/2fa", `{"challengeToken":"`+challenge+`","code":"`+recovery[0].(string)+`"}`)
+	if code != fiber.StatusOK || res["token"] == nil || res["recoveryCodes"] != nil {
+		t.Fatalf("expected tokens, got %d %v", code, res)
+	}
+	if code, _ := post("/api/v1/sign-in/2fa", `{"challengeToken":"not-a-token","code":"`+totp+`"}`); code != fiber.StatusUnauthorized {
+		t.Fatalf("expected 401 for a bad challenge, got %d", code)
+	}
+}
+
+func TestDeleteUser_UsesEraser(t *testing.T) {
+	repo := NewInMemoryRepository([]User{{ID: 5, Email: "e@example.com"}})
+	var erased []int
+	handler := NewHandler(NewService(repo)).WithEraser(func(id int) error {
+		if id != 5 {
+			return ErrNotFound
+		}
+		erased = append(erased, id)
+		return nil
+	})
+	app := makeAppWithUserHandler(handler)
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{{"/user/5", fiber.StatusOK}, {"/user/6", fiber.StatusNotFound}} {
+		req := httptest.NewRequest("DELETE", tc.path, nil)
+		req.Header.Set("X-User-ID", "1")
+		req.Header.Set("X-User-Role", "admin")
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tc.want {
+			t.Fatalf("DELETE %s: expected %d, got %d", tc.path, tc.want, res.StatusCode)
+		}
+	}
+	if len(erased) != 1 {
+		t.Fatalf("expected the eraser to delete user 5, got %v", erased)
+	}
+}
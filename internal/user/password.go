@@ -0,0 +1,181 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/wichananm65/pet-shop-backend/internal/mailer"
+)
+
+// DefaultResetTTL is how long a password reset link stays valid.
+const DefaultResetTTL = time.Hour
+
+// MinPasswordLength applies to passwords set through reset or change.
+const MinPasswordLength = 8
+
+// Reset mails are throttled per user like verification mails: one per
+// resetResendInterval and at most resetHourlyLimit an hour.
+const (
+	resetResendInterval = time.Minute
+	resetHourlyLimit    = 5
+)
+
+// WithMailer sets the mailer that sends password reset and verification
+// links.
+func (s *Service) WithMailer(m mailer.Mailer) *Service {
+	s.mailer = m
+	return s
+}
+
+// WithPasswordReset enables RequestPasswordReset. Reset links point at
+// resetURL with the token in the "token" query parameter; the frontend
+// page there posts it back to the reset endpoint.
+func (s *Service) WithPasswordReset(resetURL string, ttl time.Duration) *Service {
+	s.resetURL = resetURL
+	s.resetTTL = ttl
+	return s
+}
+
+// RequestPasswordReset mails a reset link to email. An unknown email is not
+// an error so callers cannot tell which addresses have accounts. It fails
+// with ErrResetThrottled when links were sent to the user too recently.
+func (s *Service) RequestPasswordReset(email string) error {
+	if s.mailer == nil || s.resetURL == "" {
+		return errors.New("password reset is not configured")
+	}
+	u, err := s.repo.GetByEmail(strings.TrimSpace(email))
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	recent, err := s.repo.CountResetTokensSince(u.ID, now.Add(-resetResendInterval))
+	if err != nil {
+		return err
+	}
+	hourly, err := s.repo.CountResetTokensSince(u.ID, now.Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+	if recent > 0 || hourly >= resetHourlyLimit {
+		return ErrResetThrottled
+	}
+
+	raw, err := newToken()
+	if err != nil {
+		return err
+	}
+	ttl := s.resetTTL
+	if ttl <= 0 {
+		ttl = DefaultResetTTL
+	}
+	if err := s.repo.CreateResetToken(u.ID, hashToken(raw), now.Add(ttl).UTC()); err != nil {
+		return err
+	}
+
+	link, err := tokenLink(s.resetURL, raw)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(mailer.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %s and works once.\n\n%s\n\n"+
+			"If you did not ask for this, you can ignore this email.\n", u.FirstName, ttl, link),
+	})
+}
+
+// ResetPassword sets a new password with a token from a reset link and
+// returns the ID of its user.
+func (s *Service) ResetPassword(token, password string) (int, error) {
+	if len(password) < MinPasswordLength {
+		return 0, ErrWeakPassword
+	}
+	if token == "" {
+		return 0, ErrInvalidResetToken
+	}
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+	return s.repo.ResetPassword(hashToken(token), hashed)
+}
+
+// ChangePassword replaces the password of a signed-in user, who has to
+// confirm the current one.
+func (s *Service) ChangePassword(id int, current, next string) error {
+	u, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if !passwordMatches(u.Password, current) {
+		return ErrWrongPassword
+	}
+	if len(next) < MinPasswordLength {
+		return ErrWeakPassword
+	}
+	hashed, err := hashPassword(next)
+	if err != nil {
+		return err
+	}
+	return s.repo.SetPassword(id, hashed)
+}
+
+// CheckPassword returns ErrWrongPassword unless password is the user's,
+// for actions that ask to re-enter it.
+func (s *Service) CheckPassword(id int, password string) error {
+	u, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if !passwordMatches(u.Password, password) {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// passwordMatches also accepts legacy plaintext passwords, like
+// Authenticate.
+func passwordMatches(stored, password string) bool {
+	if looksLikeBcrypt(stored) {
+		return passwordMatchesHash(stored, password)
+	}
+	return stored != "" && stored == password
+}
+
+// tokenLink adds token to base as the "token" query parameter.
+func tokenLink(base, token string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is what gets stored for reset and verification tokens, so the
+// tables alone cannot be used to take over an account.
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
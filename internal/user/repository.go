@@ -2,7 +2,11 @@ package user
 
 import (
 	"errors"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
 )
 
 var (
@@ -11,23 +15,72 @@ var (
 	ErrEmailExists        = errors.New("email already exists")
 	ErrAlreadyFavorite    = errors.New("product already in favorites")
 	ErrNotFavorite        = errors.New("product not in favorites")
+	ErrInvalidResetToken  = errors.New("invalid or expired reset token")
+	ErrWrongPassword      = errors.New("current password is incorrect")
+	ErrWeakPassword       = errors.New("password must be at least 8 characters")
+	ErrInvalidEmail       = errors.New("invalid email address")
+
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+	ErrAlreadyVerified          = errors.New("email is already verified")
+	ErrEmailNotVerified         = errors.New("email address is not verified")
+	ErrVerificationThrottled    = errors.New("a verification email was sent recently, try again later")
+	ErrResetThrottled           = errors.New("a reset email was sent recently, try again later")
 )
 
 type Repository interface {
 	List() []User
 	GetByID(id int) (User, error)
+	// GetByEmail matches email case-insensitively.
 	GetByEmail(email string) (User, error)
 	Create(user User) (User, error)
 	Update(id int, user User) (User, error)
 	Delete(id int) error
+	SetRole(id int, role auth.Role) error
+	// SetPassword stores an already hashed password.
+	SetPassword(id int, hash string) error
+	// CreateResetToken stores the hash of a password reset token.
+	CreateResetToken(userID int, tokenHash string, expiresAt time.Time) error
+	CountResetTokensSince(userID int, since time.Time) (int, error)
+	// ResetPassword uses up the reset token with tokenHash and every other
+	// open token of its user, then sets the password. It returns the user's
+	// ID, or ErrInvalidResetToken for unknown, used or expired tokens.
+	ResetPassword(tokenHash, passwordHash string) (int, error)
+	// CreateVerificationToken stores the hash of a token that verifies email
+	// for the user.
+	CreateVerificationToken(userID int, email, tokenHash string, expiresAt time.Time) error
+	CountVerificationTokensSince(userID int, since time.Time) (int, error)
+	// VerifyEmail uses up the verification token with tokenHash and marks
+	// its user verified. It returns the user's ID, or
+	// ErrInvalidVerificationToken for unknown, used or expired tokens and
+	// for tokens sent to an email the user no longer has.
+	VerifyEmail(tokenHash string) (int, error)
 	// CreateCartWithID creates a cart row with the given id
 	CreateCartWithID(cartID int) error
 }
 
 type InMemoryRepository struct {
-	mu     sync.RWMutex
-	users  []User
-	nextID int
+	mu           sync.RWMutex
+	users        []User
+	nextID       int
+	resetTokens  []resetToken
+	verifyTokens []verificationToken
+}
+
+type verificationToken struct {
+	userID    int
+	email     string
+	hash      string
+	expiresAt time.Time
+	createdAt time.Time
+	used      bool
+}
+
+type resetToken struct {
+	userID    int
+	hash      string
+	expiresAt time.Time
+	createdAt time.Time
+	used      bool
 }
 
 func NewInMemoryRepository(seed []User) *InMemoryRepository {
@@ -75,7 +128,7 @@ func (r *InMemoryRepository) GetByEmail(email string) (User, error) {
 	defer r.mu.RUnlock()
 
 	for _, user := range r.users {
-		if user.Email == email {
+		if strings.EqualFold(user.Email, email) {
 			return user, nil
 		}
 	}
@@ -113,6 +166,9 @@ func (r *InMemoryRepository) Update(id int, userUpdate User) (User, error) {
 
 	for i, user := range r.users {
 		if user.ID == id {
+			if !strings.EqualFold(user.Email, userUpdate.Email) {
+				user.EmailVerifiedAt = nil
+			}
 			user.Email = userUpdate.Email
 			user.FirstName = userUpdate.FirstName
 			user.LastName = userUpdate.LastName
@@ -140,6 +196,128 @@ func (r *InMemoryRepository) Update(id int, userUpdate User) (User, error) {
 	return User{}, ErrNotFound
 }
 
+func (r *InMemoryRepository) SetRole(id int, role auth.Role) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.users {
+		if r.users[i].ID == id {
+			r.users[i].Role = role
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+func (r *InMemoryRepository) SetPassword(id int, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.setPassword(id, hash)
+}
+
+func (r *InMemoryRepository) setPassword(id int, hash string) error {
+	for i := range r.users {
+		if r.users[i].ID == id {
+			r.users[i].Password = hash
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+func (r *InMemoryRepository) CreateResetToken(userID int, tokenHash string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.resetTokens = append(r.resetTokens, resetToken{userID: userID, hash: tokenHash, expiresAt: expiresAt, createdAt: time.Now()})
+	return nil
+}
+
+func (r *InMemoryRepository) CountResetTokensSince(userID int, since time.Time) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	n := 0
+	for _, t := range r.resetTokens {
+		if t.userID == userID && !t.createdAt.Before(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *InMemoryRepository) ResetPassword(tokenHash, passwordHash string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	userID := 0
+	for _, t := range r.resetTokens {
+		if t.hash == tokenHash && !t.used && time.Now().Before(t.expiresAt) {
+			userID = t.userID
+		}
+	}
+	if userID == 0 {
+		return 0, ErrInvalidResetToken
+	}
+	for i := range r.resetTokens {
+		if r.resetTokens[i].userID == userID {
+			r.resetTokens[i].used = true
+		}
+	}
+	return userID, r.setPassword(userID, passwordHash)
+}
+
+func (r *InMemoryRepository) CreateVerificationToken(userID int, email, tokenHash string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.verifyTokens = append(r.verifyTokens, verificationToken{
+		userID:    userID,
+		email:     email,
+		hash:      tokenHash,
+		expiresAt: expiresAt,
+		createdAt: time.Now(),
+	})
+	return nil
+}
+
+func (r *InMemoryRepository) CountVerificationTokensSince(userID int, since time.Time) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	n := 0
+	for _, t := range r.verifyTokens {
+		if t.userID == userID && !t.createdAt.Before(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *InMemoryRepository) VerifyEmail(tokenHash string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, t := range r.verifyTokens {
+		if t.hash != tokenHash || t.used || !time.Now().Before(t.expiresAt) {
+			continue
+		}
+		r.verifyTokens[i].used = true
+		for j := range r.users {
+			if r.users[j].ID == t.userID && strings.EqualFold(r.users[j].Email, t.email) {
+				now := time.Now().UTC()
+				r.users[j].EmailVerifiedAt = &now
+				return t.userID, nil
+			}
+		}
+		break
+	}
+	return 0, ErrInvalidVerificationToken
+}
+
 func (r *InMemoryRepository) Delete(id int) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -2,6 +2,9 @@ package user
 
 import (
 	"database/sql"
+	"time"
+
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
 )
 
 type PostgresRepository struct {
@@ -15,26 +18,28 @@ type rowScanner interface {
 const (
 	listUsersQuery = `
 		SELECT userid, email, password_hash, firstname, lastname, phone, gender, mainaddressid, avatarpic,
-		createdat, updatedat
+		createdat, updatedat, role, email_verified_at
 		FROM users
 		ORDER BY userid
 	`
 	getUserByIDQuery = `
 		SELECT userid, email, password_hash, firstname, lastname, phone, gender, mainaddressid, avatarpic,
-		createdat, updatedat
+		createdat, updatedat, role, email_verified_at
 		FROM users
 		WHERE userid = $1
 	`
 	getUserByEmailQuery = `
 		SELECT userid, email, password_hash, firstname, lastname, phone, gender, mainaddressid, avatarpic,
-		createdat, updatedat
+		createdat, updatedat, role, email_verified_at
 		FROM users
-		WHERE email = $1
+		WHERE lower(email) = lower($1)
+		ORDER BY userid
+		LIMIT 1
 	`
 
 	insertUserQuery = `
-		INSERT INTO users (email, password_hash, firstname, lastname, phone, gender, createdat, updatedat, avatarpic)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		INSERT INTO users (email, password_hash, firstname, lastname, phone, gender, createdat, updatedat, avatarpic, role)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		RETURNING userid
 	`
 	updateUserQuery = `
@@ -46,10 +51,38 @@ const (
 			gender = $5,
 			mainaddressid = $9,
 			avatarpic = $8,
-			updatedat = $6
+			updatedat = $6,
+			email_verified_at = CASE WHEN lower(email) = lower($1) THEN email_verified_at END
 		WHERE userid = $7
 	`
-	deleteUserQuery = `DELETE FROM users WHERE userid = $1`
+	deleteUserQuery  = `DELETE FROM users WHERE userid = $1`
+	setUserRoleQuery = `UPDATE users SET role = $1 WHERE userid = $2`
+
+	setPasswordQuery      = `UPDATE users SET password_hash = $1, updatedat = $2 WHERE userid = $3`
+	insertResetTokenQuery = `
+		INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+	`
+	countResetTokensQuery = `SELECT count(*) FROM password_reset_tokens WHERE user_id = $1 AND created_at >= $2`
+	useResetTokenQuery    = `
+		UPDATE password_reset_tokens SET used_at = now()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id
+	`
+	useOpenResetTokensQuery = `UPDATE password_reset_tokens SET used_at = now() WHERE user_id = $1 AND used_at IS NULL`
+
+	insertVerificationTokenQuery = `
+		INSERT INTO email_verification_tokens (user_id, email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`
+	countVerificationTokensQuery = `SELECT count(*) FROM email_verification_tokens WHERE user_id = $1 AND created_at >= $2`
+	useVerificationTokenQuery    = `
+		UPDATE email_verification_tokens SET used_at = now()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id, email
+	`
+	// only verifies the address the link was sent to
+	markEmailVerifiedQuery = `UPDATE users SET email_verified_at = now() WHERE userid = $1 AND lower(email) = lower($2)`
 )
 
 func NewPostgresRepository(db *sql.DB) *PostgresRepository {
@@ -119,6 +152,7 @@ func (r *PostgresRepository) Create(user User) (User, error) {
 		user.CreatedAt,
 		user.UpdatedAt,
 		avatarVal,
+		user.Role,
 	).Scan(&id)
 	if err != nil {
 		return User{}, err
@@ -181,6 +215,131 @@ func (r *PostgresRepository) Delete(id int) error {
 
 	return nil
 }
+func (r *PostgresRepository) SetRole(id int, role auth.Role) error {
+	result, err := r.db.Exec(setUserRoleQuery, role, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *PostgresRepository) SetPassword(id int, hash string) error {
+	return setPassword(r.db, id, hash)
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func setPassword(db execer, id int, hash string) error {
+	result, err := db.Exec(setPasswordQuery, hash, time.Now().UTC().Format(time.RFC3339), id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *PostgresRepository) CreateResetToken(userID int, tokenHash string, expiresAt time.Time) error {
+	_, err := r.db.Exec(insertResetTokenQuery, userID, tokenHash, expiresAt)
+	return err
+}
+
+func (r *PostgresRepository) CountResetTokensSince(userID int, since time.Time) (int, error) {
+	var n int
+	err := r.db.QueryRow(countResetTokensQuery, userID, since).Scan(&n)
+	return n, err
+}
+
+// ResetPassword relies on the conditional update so a token can only be
+// used once, even by concurrent requests.
+func (r *PostgresRepository) ResetPassword(tokenHash, passwordHash string) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var userID int
+	err = tx.QueryRow(useResetTokenQuery, tokenHash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidResetToken
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(useOpenResetTokensQuery, userID); err != nil {
+		return 0, err
+	}
+	if err := setPassword(tx, userID, passwordHash); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+func (r *PostgresRepository) CreateVerificationToken(userID int, email, tokenHash string, expiresAt time.Time) error {
+	_, err := r.db.Exec(insertVerificationTokenQuery, userID, email, tokenHash, expiresAt)
+	return err
+}
+
+func (r *PostgresRepository) CountVerificationTokensSince(userID int, since time.Time) (int, error) {
+	var n int
+	err := r.db.QueryRow(countVerificationTokensQuery, userID, since).Scan(&n)
+	return n, err
+}
+
+func (r *PostgresRepository) VerifyEmail(tokenHash string) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var userID int
+	var email string
+	err = tx.QueryRow(useVerificationTokenQuery, tokenHash).Scan(&userID, &email)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec(markEmailVerifiedQuery, userID, email)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		// the email changed after the link was sent
+		return 0, ErrInvalidVerificationToken
+	}
+	return userID, tx.Commit()
+}
+
 func (r *PostgresRepository) CreateCartWithID(cartID int) error {
 	// cart table removed — no operation required
 	return nil
@@ -198,6 +357,7 @@ func scanUser(scanner rowScanner) (User, error) {
 	var mainAddr sql.NullInt64
 	var createdAt sql.NullString
 	var updatedAt sql.NullString
+	var verifiedAt sql.NullTime
 
 	if err := scanner.Scan(
 		&user.ID,
@@ -211,9 +371,14 @@ func scanUser(scanner rowScanner) (User, error) {
 		&avatar,
 		&createdAt,
 		&updatedAt,
+		&user.Role,
+		&verifiedAt,
 	); err != nil {
 		return User{}, err
 	}
+	if verifiedAt.Valid {
+		user.EmailVerifiedAt = &verifiedAt.Time
+	}
 	if mainAddr.Valid {
 		v := int(mainAddr.Int64)
 		user.MainAddressID = &v
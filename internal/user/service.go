@@ -2,8 +2,12 @@ package user
 
 import (
 	"log"
+	"strings"
 	"time"
 
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
+	"github.com/wichananm65/pet-shop-backend/internal/mailer"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -22,7 +26,12 @@ type ServiceInterface interface {
 var _ ServiceInterface = (*Service)(nil) // Ensure *Service implements ServiceInterface
 
 type Service struct {
-	repo Repository
+	repo      Repository
+	mailer    mailer.Mailer
+	resetURL  string
+	resetTTL  time.Duration
+	verifyURL string
+	verifyTTL time.Duration
 }
 
 func NewService(repo Repository) *Service {
@@ -38,6 +47,15 @@ func (s *Service) GetByID(id int) (User, error) {
 }
 
 func (s *Service) Create(user User) (User, error) {
+	role, err := auth.ParseRole(string(user.Role))
+	if err != nil {
+		return User{}, err
+	}
+	user.Role = role
+	if user.Email, err = NormalizeEmail(user.Email); err != nil {
+		return User{}, err
+	}
+	user.EmailVerifiedAt = nil
 	if user.Password != "" && !looksLikeBcrypt(user.Password) {
 		hashed, err := hashPassword(user.Password)
 		if err != nil {
@@ -49,7 +67,25 @@ func (s *Service) Create(user User) (User, error) {
 	return s.repo.Create(user)
 }
 
+// Update stores user. A changed email is normalized, has to be free and
+// needs to be verified again; an unchanged one is kept as stored.
 func (s *Service) Update(id int, user User) (User, error) {
+	existing, err := s.repo.GetByID(id)
+	if err != nil {
+		return User{}, err
+	}
+	if !strings.EqualFold(strings.TrimSpace(user.Email), existing.Email) {
+		if user.Email, err = NormalizeEmail(user.Email); err != nil {
+			return User{}, err
+		}
+		if other, err := s.repo.GetByEmail(user.Email); err == nil && other.ID != id {
+			return User{}, ErrEmailExists
+		} else if err != nil && err != ErrNotFound {
+			return User{}, err
+		}
+	} else {
+		user.Email = existing.Email
+	}
 	return s.repo.Update(id, user)
 }
 
@@ -57,6 +93,19 @@ func (s *Service) Delete(id int) error {
 	return s.repo.Delete(id)
 }
 
+// SetRole changes the role of a user. The new role reaches the user's
+// access token at their next token refresh.
+func (s *Service) SetRole(id int, role string) (User, error) {
+	r, err := auth.ParseRole(role)
+	if err != nil {
+		return User{}, err
+	}
+	if err := s.repo.SetRole(id, r); err != nil {
+		return User{}, err
+	}
+	return s.repo.GetByID(id)
+}
+
 // AppendOrderID adds an order ID to the user's order list and returns updated user
 func (s *Service) AppendOrderID(userID int, orderID int) (User, error) {
 	// Orders are now in a separate table, no need to maintain order IDs in user table
@@ -68,7 +117,15 @@ func (s *Service) AppendOrderID(userID int, orderID int) (User, error) {
 	return u, nil
 }
 
+// Register creates a customer account. With email verification enabled it
+// also mails the verification link; a failure to send does not fail the
+// registration, the user can ask for the link again.
 func (s *Service) Register(user User) (User, error) {
+	email, err := NormalizeEmail(user.Email)
+	if err != nil {
+		return User{}, err
+	}
+	user.Email = email
 	if _, err := s.repo.GetByEmail(user.Email); err == nil {
 		return User{}, ErrEmailExists
 	} else if err != ErrNotFound {
@@ -80,25 +137,35 @@ func (s *Service) Register(user User) (User, error) {
 		return User{}, err
 	}
 	user.Password = hashed
+	// self-registration always yields a customer
+	user.Role = auth.RoleCustomer
+	user.EmailVerifiedAt = nil
 	created, err := s.repo.Create(user)
 	if err != nil {
 		return User{}, err
 	}
+	if s.verifyURL != "" {
+		if err := s.sendVerification(created); err != nil {
+			log.Printf("could not send verification email to user %d: %v", created.ID, err)
+		}
+	}
 
 	// cart table removed — no cart row needs to be created during registration
 	return created, nil
 }
 
 func (s *Service) Authenticate(email, password string) (User, error) {
-	user, err := s.repo.GetByEmail(email)
-	if err != nil {
+	// lookups ignore case; no format check so accounts created before
+	// validation can still sign in
+	user, err := s.repo.GetByEmail(strings.TrimSpace(email))
+	if err == ErrNotFound {
+		// spend as long as a wrong password would, so response times do not
+		// tell which emails have accounts
+		passwordMatchesHash(dummyHash, password)
 		return User{}, ErrInvalidCredentials
 	}
-	// debug: log minimal password info when favorite array present (temporary)
-	// NOTE: this is debug-only; will not log full hash
-	if len(user.Password) > 0 {
-		// log minimal debug info to help diagnose sign-in issues (temporary)
-		log.Printf("[DEBUG] Authenticate: email=%s passwordLen=%d favoriteCount=%d", email, len(user.Password), len(user.FavoriteProductIDs))
+	if err != nil {
+		return User{}, err
 	}
 	// If stored password looks like a bcrypt hash, validate via bcrypt
 	if looksLikeBcrypt(user.Password) {
@@ -112,11 +179,9 @@ func (s *Service) Authenticate(email, password string) (User, error) {
 	if user.Password == password {
 		// attempt to upgrade stored password to bcrypt
 		if hashed, err := hashPassword(password); err == nil {
-			// update user record with hashed password and updatedAt timestamp
 			user.Password = hashed
-			user.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
 			// best-effort update; ignore update error to avoid blocking login
-			_, _ = s.repo.Update(user.ID, user)
+			_ = s.repo.SetPassword(user.ID, hashed)
 		}
 		return user, nil
 	}
@@ -124,6 +189,15 @@ func (s *Service) Authenticate(email, password string) (User, error) {
 	return User{}, ErrInvalidCredentials
 }
 
+// dummyHash is compared against for unknown emails; it matches no password.
+var dummyHash = func() string {
+	hashed, err := hashPassword("no account has this password")
+	if err != nil {
+		panic(err)
+	}
+	return hashed
+}()
+
 func looksLikeBcrypt(value string) bool {
 	return len(value) > 4 && value[0:2] == "$2"
 }
@@ -1,5 +1,11 @@
 package user
 
+import (
+	"time"
+
+	"github.com/wichananm65/pet-shop-backend/internal/auth"
+)
+
 type FavoriteProduct struct {
 	ProductID     int     `json:"productID"`
 	ProductName   *string `json:"productName,omitempty"`
@@ -12,15 +18,20 @@ type FavoriteProduct struct {
 }
 
 type User struct {
-	ID            int    `json:"userId"`
-	Email         string `json:"email"`
-	Password      string `json:"password,omitempty"`
-	FirstName     string `json:"firstName"`
-	LastName      string `json:"lastName"`
-	Phone         string `json:"phone"`
-	Gender        string `json:"gender"`
-	MainAddressID *int   `json:"mainAddressId,omitempty"`
-	AddressIDs    []int  `json:"addressId,omitempty"`
+	ID        int    `json:"userId"`
+	Email     string `json:"email"`
+	Password  string `json:"password,omitempty"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Phone     string `json:"phone"`
+	Gender    string `json:"gender"`
+	// Role is only changed through SetRole; Update leaves it alone.
+	Role auth.Role `json:"role,omitempty"`
+	// EmailVerifiedAt is set once the user follows a verification link and
+	// cleared when the email changes.
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt,omitempty"`
+	MainAddressID   *int       `json:"mainAddressId,omitempty"`
+	AddressIDs      []int      `json:"addressId,omitempty"`
 
 	OrderIDs           []int       `json:"orderId,omitempty"`
 	FavoriteProductIDs []int       `json:"favoriteProductId,omitempty"`